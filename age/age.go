@@ -0,0 +1,273 @@
+// Package age implements the native X25519 recipient type of the age file
+// encryption format (age-encryption.org/v1), backed by this package's X25519
+// KEM, HKDF-SHA256, and ChaCha20Poly1305 implementations.  Files encrypted to
+// an X25519Recipient can be decrypted by age tooling, and files that age
+// tooling encrypts to an X25519 recipient can be decrypted with an
+// X25519Identity.
+//
+// The Wrap and Unwrap methods mirror the age.Recipient and age.Identity
+// interfaces, with Stanza in place of age.Stanza, which has the same fields.
+// An adapter for filippo.io/age only needs to convert between the two stanza
+// types.
+package age
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	hpke "github.com/cisco/go-hpke"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	x25519StanzaType = "X25519"
+	x25519Label      = "age-encryption.org/v1/X25519"
+
+	recipientHRP = "age"
+	identityHRP  = "AGE-SECRET-KEY-"
+
+	fileKeySize = 16
+)
+
+// ErrIncorrectIdentity is returned by Unwrap when none of the stanzas are
+// addressed to the identity.  Callers should try their other identities.
+var ErrIncorrectIdentity = errors.New("Incorrect identity for recipient block")
+
+var b64 = base64.RawStdEncoding.Strict()
+
+// Stanza is a recipient stanza of an age header.
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+func x25519Suite() hpke.CipherSuite {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305)
+	if err != nil {
+		panic(err)
+	}
+	return suite
+}
+
+// X25519Recipient is the public key of an age X25519 identity.
+type X25519Recipient struct {
+	suite hpke.CipherSuite
+	pkR   hpke.KEMPublicKey
+}
+
+// NewX25519Recipient returns a recipient for an HPKE X25519 public key.
+func NewX25519Recipient(pkR hpke.KEMPublicKey) (*X25519Recipient, error) {
+	suite := x25519Suite()
+	if _, err := suite.KEM.DeserializePublicKey(suite.KEM.SerializePublicKey(pkR)); err != nil {
+		return nil, err
+	}
+
+	return &X25519Recipient{suite: suite, pkR: pkR}, nil
+}
+
+// ParseX25519Recipient parses a recipient in its "age1..." encoding.
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if hrp != recipientHRP {
+		return nil, fmt.Errorf("Malformed age recipient: unexpected type %q", hrp)
+	}
+
+	suite := x25519Suite()
+	pkR, err := suite.KEM.DeserializePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &X25519Recipient{suite: suite, pkR: pkR}, nil
+}
+
+// PublicKey returns the HPKE X25519 public key of the recipient.
+func (r *X25519Recipient) PublicKey() hpke.KEMPublicKey {
+	return r.pkR
+}
+
+// String returns the "age1..." encoding of the recipient.
+func (r *X25519Recipient) String() string {
+	s, _ := bech32Encode(recipientHRP, r.suite.KEM.SerializePublicKey(r.pkR))
+	return s
+}
+
+// Wrap encrypts the file key to the recipient.
+func (r *X25519Recipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephemeral); err != nil {
+		return nil, err
+	}
+
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	pkRm := r.suite.KEM.SerializePublicKey(r.pkR)
+	sharedSecret, err := curve25519.X25519(ephemeral, pkRm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", hpke.ErrInvalidPublicKey, err)
+	}
+
+	aead, err := wrapAEAD(r.suite, sharedSecret, share, pkRm)
+	if err != nil {
+		return nil, err
+	}
+
+	stanza := &Stanza{
+		Type: x25519StanzaType,
+		Args: []string{b64.EncodeToString(share)},
+		Body: aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil),
+	}
+	return []*Stanza{stanza}, nil
+}
+
+// X25519Identity is the private key of an age X25519 identity.
+type X25519Identity struct {
+	suite hpke.CipherSuite
+	skR   hpke.KEMPrivateKey
+}
+
+// GenerateX25519Identity generates a new identity using rand.
+func GenerateX25519Identity(rand io.Reader) (*X25519Identity, error) {
+	suite := x25519Suite()
+	skRm := make([]byte, suite.KEM.PrivateKeySize())
+	if _, err := io.ReadFull(rand, skRm); err != nil {
+		return nil, err
+	}
+
+	skR, err := suite.KEM.DeserializePrivateKey(skRm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &X25519Identity{suite: suite, skR: skR}, nil
+}
+
+// NewX25519Identity returns an identity for an HPKE X25519 private key.
+func NewX25519Identity(skR hpke.KEMPrivateKey) (*X25519Identity, error) {
+	suite := x25519Suite()
+	if _, err := suite.KEM.DeserializePrivateKey(suite.KEM.SerializePrivateKey(skR)); err != nil {
+		return nil, err
+	}
+
+	return &X25519Identity{suite: suite, skR: skR}, nil
+}
+
+// ParseX25519Identity parses an identity in its "AGE-SECRET-KEY-1..."
+// encoding.
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if hrp != strings.ToLower(identityHRP) {
+		return nil, fmt.Errorf("Malformed age identity: unexpected type %q", hrp)
+	}
+
+	suite := x25519Suite()
+	skR, err := suite.KEM.DeserializePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &X25519Identity{suite: suite, skR: skR}, nil
+}
+
+// PrivateKey returns the HPKE X25519 private key of the identity.
+func (i *X25519Identity) PrivateKey() hpke.KEMPrivateKey {
+	return i.skR
+}
+
+// Recipient returns the recipient corresponding to the identity.
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{suite: i.suite, pkR: i.skR.PublicKey()}
+}
+
+// String returns the "AGE-SECRET-KEY-1..." encoding of the identity.
+func (i *X25519Identity) String() string {
+	s, _ := bech32Encode(identityHRP, i.suite.KEM.SerializePrivateKey(i.skR))
+	return strings.ToUpper(s)
+}
+
+// Unwrap returns the file key from the first X25519 stanza addressed to the
+// identity, or ErrIncorrectIdentity if there is none.
+func (i *X25519Identity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		fileKey, err := i.unwrap(s)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+		return fileKey, err
+	}
+
+	return nil, ErrIncorrectIdentity
+}
+
+func (i *X25519Identity) unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != x25519StanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+
+	if len(s.Args) != 1 {
+		return nil, fmt.Errorf("Invalid X25519 recipient block")
+	}
+
+	share, err := b64.DecodeString(s.Args[0])
+	if err != nil || len(share) != curve25519.PointSize {
+		return nil, fmt.Errorf("Invalid X25519 recipient block")
+	}
+
+	skRm := i.suite.KEM.SerializePrivateKey(i.skR)
+	sharedSecret, err := curve25519.X25519(skRm, share)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", hpke.ErrInvalidPublicKey, err)
+	}
+
+	pkRm := i.suite.KEM.SerializePublicKey(i.skR.PublicKey())
+	aead, err := wrapAEAD(i.suite, sharedSecret, share, pkRm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Body) != fileKeySize+aead.Overhead() {
+		return nil, fmt.Errorf("Invalid X25519 recipient block")
+	}
+
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), s.Body, nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+
+	return fileKey, nil
+}
+
+// wrapAEAD derives the key that wraps the file key:
+//
+//	wrap_key = HKDF-SHA256(ikm = shared_secret, salt = share || pkR,
+//	                       info = "age-encryption.org/v1/X25519")
+func wrapAEAD(suite hpke.CipherSuite, sharedSecret, share, pkRm []byte) (cipher.AEAD, error) {
+	// curve25519.X25519 rejects low-order points, but check explicitly as
+	// required by the age specification.
+	if subtle.ConstantTimeCompare(sharedSecret, make([]byte, len(sharedSecret))) == 1 {
+		return nil, hpke.ErrInvalidPublicKey
+	}
+
+	salt := append(append([]byte{}, share...), pkRm...)
+	prk := suite.KDF.Extract(salt, sharedSecret)
+	key := suite.KDF.Expand(prk, []byte(x25519Label), suite.AEAD.KeySize())
+	return suite.AEAD.New(key)
+}
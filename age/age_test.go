@@ -0,0 +1,112 @@
+package age
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBech32(t *testing.T) {
+	// BIP 173 valid test vectors
+	for _, s := range []string{
+		"A12UEL5L",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		hrp, values := s[:strings.LastIndexByte(s, '1')], s[strings.LastIndexByte(s, '1')+1:]
+		hrp = strings.ToLower(hrp)
+		data := make([]byte, len(values))
+		for i := range values {
+			data[i] = byte(strings.IndexByte(bech32Charset, strings.ToLower(values)[i]))
+		}
+		require.Equal(t, uint32(1), bech32Polymod(append(bech32HRPExpand(hrp), data...)), "Invalid checksum for %s", s)
+	}
+
+	for _, s := range []string{
+		"A12UEL5l",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx",
+		"1pzry9x0s0muk",
+	} {
+		_, _, err := bech32Decode(s)
+		require.NotNil(t, err, "Invalid bech32 string accepted: %s", s)
+	}
+
+	data := []byte{0x00, 0x01, 0x02, 0xfd, 0xfe, 0xff}
+	s, err := bech32Encode("test", data)
+	require.Nil(t, err, "Error in bech32Encode")
+
+	hrp, decoded, err := bech32Decode(strings.ToUpper(s))
+	require.Nil(t, err, "Error in bech32Decode")
+	require.Equal(t, "test", hrp, "Incorrect human-readable part")
+	require.Equal(t, data, decoded, "Incorrect data")
+}
+
+func TestX25519RoundTrip(t *testing.T) {
+	identity, err := GenerateX25519Identity(rand.Reader)
+	require.Nil(t, err, "Error generating identity")
+
+	require.True(t, strings.HasPrefix(identity.String(), "AGE-SECRET-KEY-1"), "Incorrect identity encoding")
+	require.True(t, strings.HasPrefix(identity.Recipient().String(), "age1"), "Incorrect recipient encoding")
+
+	parsedIdentity, err := ParseX25519Identity(identity.String())
+	require.Nil(t, err, "Error parsing identity")
+	require.Equal(t, identity.String(), parsedIdentity.String(), "Identity did not round-trip")
+
+	recipient, err := ParseX25519Recipient(identity.Recipient().String())
+	require.Nil(t, err, "Error parsing recipient")
+
+	_, err = ParseX25519Recipient(identity.String())
+	require.NotNil(t, err, "Identity accepted as recipient")
+
+	fileKey := make([]byte, fileKeySize)
+	rand.Read(fileKey)
+
+	stanzas, err := recipient.Wrap(fileKey)
+	require.Nil(t, err, "Error in Wrap")
+	require.Equal(t, 1, len(stanzas), "Incorrect number of stanzas")
+	require.Equal(t, "X25519", stanzas[0].Type, "Incorrect stanza type")
+
+	other, err := GenerateX25519Identity(rand.Reader)
+	require.Nil(t, err, "Error generating identity")
+
+	_, err = other.Unwrap(stanzas)
+	require.Equal(t, ErrIncorrectIdentity, err, "Stanza unwrapped by wrong identity")
+
+	unknown := &Stanza{Type: "scrypt", Args: []string{"salt", "18"}}
+	unwrapped, err := parsedIdentity.Unwrap([]*Stanza{unknown, stanzas[0]})
+	require.Nil(t, err, "Error in Unwrap")
+	require.Equal(t, fileKey, unwrapped, "Incorrect file key")
+
+	stanzas[0].Body = stanzas[0].Body[1:]
+	_, err = identity.Unwrap(stanzas)
+	require.NotNil(t, err, "Truncated stanza accepted")
+	require.NotEqual(t, ErrIncorrectIdentity, err, "Truncated stanza not reported as malformed")
+}
+
+func TestHPKEKeys(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	identity, err := NewX25519Identity(skR)
+	require.Nil(t, err, "Error in NewX25519Identity")
+	recipient, err := NewX25519Recipient(pkR)
+	require.Nil(t, err, "Error in NewX25519Recipient")
+	require.Equal(t, identity.Recipient().String(), recipient.String(), "Recipient does not match identity")
+
+	fileKey := make([]byte, fileKeySize)
+	rand.Read(fileKey)
+
+	stanzas, err := recipient.Wrap(fileKey)
+	require.Nil(t, err, "Error in Wrap")
+	unwrapped, err := identity.Unwrap(stanzas)
+	require.Nil(t, err, "Error in Unwrap")
+	require.Equal(t, fileKey, unwrapped, "Incorrect file key")
+}
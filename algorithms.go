@@ -0,0 +1,130 @@
+package hpke
+
+import (
+	"sort"
+
+	"golang.org/x/sys/cpu"
+)
+
+// KEMInfo describes one of the supported KEMs.
+type KEMInfo struct {
+	ID             KEMID
+	Name           string
+	PublicKeySize  int
+	PrivateKeySize int
+
+	// Auth is true if the KEM supports the Auth and AuthPSK modes.
+	Auth bool
+}
+
+// KDFInfo describes one of the supported KDFs.
+type KDFInfo struct {
+	ID         KDFID
+	Name       string
+	OutputSize int
+}
+
+// AEADInfo describes one of the supported AEADs.
+type AEADInfo struct {
+	ID        AEADID
+	Name      string
+	KeySize   int
+	NonceSize int
+
+	// ExportOnly is true for the export-only AEAD, which supports only the
+	// secret export interface.  KeySize and NonceSize are zero.
+	ExportOnly bool
+}
+
+// SupportedKEMs lists the KEMs supported by this package, ordered by ID.
+func SupportedKEMs() []KEMInfo {
+	out := make([]KEMInfo, 0, len(kems))
+	for id, kem := range kems {
+		_, auth := kem.(AuthKEMScheme)
+		out = append(out, KEMInfo{
+			ID:             id,
+			Name:           id.String(),
+			PublicKeySize:  kem.PublicKeySize(),
+			PrivateKeySize: kem.PrivateKeySize(),
+			Auth:           auth,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// SupportedKDFs lists the KDFs supported by this package, ordered by ID.
+func SupportedKDFs() []KDFInfo {
+	out := make([]KDFInfo, 0, len(kdfs))
+	for id, kdf := range kdfs {
+		out = append(out, KDFInfo{
+			ID:         id,
+			Name:       id.String(),
+			OutputSize: kdf.OutputSize(),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// SupportedAEADs lists the AEADs supported by this package, ordered by ID.
+func SupportedAEADs() []AEADInfo {
+	out := make([]AEADInfo, 0, len(aeads))
+	for id, aead := range aeads {
+		info := AEADInfo{
+			ID:         id,
+			Name:       id.String(),
+			ExportOnly: id == AEAD_EXPORT_ONLY,
+		}
+
+		if !info.ExportOnly {
+			info.KeySize = aead.KeySize()
+			info.NonceSize = aead.NonceSize()
+		}
+
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// hasAESGCMHardwareSupport reports whether the CPU accelerates both AES and
+// the GHASH multiplication, as crypto/tls determines it.
+var hasAESGCMHardwareSupport = cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ ||
+	cpu.ARM64.HasAES && cpu.ARM64.HasPMULL ||
+	cpu.S390X.HasAES && cpu.S390X.HasAESGCM
+
+// PreferredAEAD recommends the fastest AEAD for this host: AES-GCM where the
+// CPU has AES and carry-less multiplication instructions (AES-NI and
+// PCLMULQDQ, or the ARMv8 AES and PMULL extensions), and ChaCha20Poly1305
+// otherwise, where AES-GCM would be both slower and harder to implement in
+// constant time.  AEADs that are not registered in this build, or that FIPS
+// mode or the installed Policy forbid, are passed over, with AES-256-GCM as
+// the fallback when AES-128-GCM is not strong enough.
+func PreferredAEAD() AEADID {
+	candidates := []AEADID{AEAD_CHACHA20POLY1305, AEAD_AESGCM128, AEAD_AESGCM256}
+	if hasAESGCMHardwareSupport {
+		candidates = []AEADID{AEAD_AESGCM128, AEAD_AESGCM256, AEAD_CHACHA20POLY1305}
+	}
+
+	for _, id := range candidates {
+		if _, ok := aeads[id]; !ok {
+			continue
+		}
+
+		if FIPSMode() && !fipsApprovedAEAD(id) {
+			continue
+		}
+
+		if p := CurrentPolicy(); p != nil && !p.allowsAEAD(id) {
+			continue
+		}
+
+		return id
+	}
+
+	return candidates[0]
+}
@@ -0,0 +1,68 @@
+package hpke
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedAlgorithms(t *testing.T) {
+	kemInfos := SupportedKEMs()
+	require.Len(t, kemInfos, len(kems), "Incorrect number of KEMs")
+	for i, info := range kemInfos {
+		if i > 0 {
+			require.True(t, kemInfos[i-1].ID < info.ID, "KEMs not sorted")
+		}
+
+		suite, err := AssembleCipherSuite(info.ID, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+		require.Nil(t, err, "Error assembling suite for %s", info.Name)
+		require.Equal(t, suite.KEM.PublicKeySize(), info.PublicKeySize, "Incorrect public key size for %s", info.Name)
+
+		_, err = authKEMScheme(suite)
+		require.Equal(t, err == nil, info.Auth, "Incorrect auth capability for %s", info.Name)
+	}
+
+	kdfInfos := SupportedKDFs()
+	require.Len(t, kdfInfos, len(kdfs), "Incorrect number of KDFs")
+	require.Equal(t, KDFInfo{KDF_HKDF_SHA256, "HKDF-SHA256", 32}, kdfInfos[0], "Incorrect KDF info")
+
+	aeadInfos := SupportedAEADs()
+	require.Len(t, aeadInfos, len(aeads), "Incorrect number of AEADs")
+	if minimalProfile {
+		require.Equal(t, AEADInfo{AEAD_CHACHA20POLY1305, "ChaCha20Poly1305", 32, 12, false}, aeadInfos[0], "Incorrect AEAD info")
+	} else {
+		require.Equal(t, AEADInfo{AEAD_AESGCM128, "AES-128-GCM", 16, 12, false}, aeadInfos[0], "Incorrect AEAD info")
+	}
+	require.Equal(t, AEADInfo{ID: AEAD_EXPORT_ONLY, Name: "Export-only", ExportOnly: true}, aeadInfos[len(aeadInfos)-1], "Incorrect export-only AEAD info")
+}
+
+func TestPreferredAEAD(t *testing.T) {
+	defer func(hw bool) { hasAESGCMHardwareSupport = hw }(hasAESGCMHardwareSupport)
+	defer SetPolicy(CurrentPolicy())
+	defer SetFIPSMode(FIPSMode())
+	SetFIPSMode(false)
+	SetPolicy(nil)
+
+	_, hasAES := aeads[AEAD_AESGCM128]
+
+	hasAESGCMHardwareSupport = false
+	require.Equal(t, AEAD_CHACHA20POLY1305, PreferredAEAD(), "AES-GCM preferred without hardware support")
+
+	if !hasAES {
+		t.Skip("AES-GCM not registered")
+	}
+
+	hasAESGCMHardwareSupport = true
+	require.Equal(t, AEAD_AESGCM128, PreferredAEAD(), "AES-GCM not preferred with hardware support")
+
+	SetPolicy(&Policy{MinSecurityLevel: 256})
+	require.Equal(t, AEAD_AESGCM256, PreferredAEAD(), "Policy ignored")
+
+	hasAESGCMHardwareSupport = false
+	SetPolicy(&Policy{AEADs: []AEADID{AEAD_AESGCM128}})
+	require.Equal(t, AEAD_AESGCM128, PreferredAEAD(), "Policy ignored")
+
+	SetPolicy(nil)
+	SetFIPSMode(true)
+	require.Equal(t, AEAD_AESGCM128, PreferredAEAD(), "FIPS mode ignored")
+}
@@ -0,0 +1,115 @@
+package hpke
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Block types for ASCII-armored data.
+const (
+	ArmorMessage    = "HPKE MESSAGE"
+	ArmorEnvelope   = "HPKE ENVELOPE"
+	ArmorPublicKey  = "HPKE PUBLIC KEY"
+	ArmorPrivateKey = "HPKE PRIVATE KEY"
+)
+
+const (
+	armorBegin     = "-----BEGIN "
+	armorEnd       = "-----END "
+	armorDashes    = "-----"
+	armorLineWidth = 64
+
+	crc24Init = 0xB704CE
+	crc24Poly = 0x1864CFB
+)
+
+// crc24 computes the OpenPGP CRC-24 checksum (RFC 4880, Section 6.1).
+func crc24(data []byte) uint32 {
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+func armorChecksum(data []byte) string {
+	crc := crc24(data)
+	return "=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)})
+}
+
+// Armor encodes data as Base64 between BEGIN and END markers for the given
+// block type, followed by a CRC-24 checksum line, in the style of OpenPGP
+// armor.  The result is suitable for email, configuration files, and
+// copy-paste.
+func Armor(blockType string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(armorBegin + blockType + armorDashes + "\n")
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > armorLineWidth {
+		buf.WriteString(encoded[:armorLineWidth] + "\n")
+		encoded = encoded[armorLineWidth:]
+	}
+	if len(encoded) > 0 {
+		buf.WriteString(encoded + "\n")
+	}
+
+	buf.WriteString(armorChecksum(data) + "\n")
+	buf.WriteString(armorEnd + blockType + armorDashes + "\n")
+	return buf.Bytes()
+}
+
+// Dearmor decodes data produced by Armor, returning the block type and the
+// decoded data.  Whitespace surrounding the armored block is ignored.
+func Dearmor(armored []byte) (string, []byte, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(armored))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+
+	if len(lines) < 3 {
+		return "", nil, fmt.Errorf("Truncated armor")
+	}
+
+	begin, end := lines[0], lines[len(lines)-1]
+	if !strings.HasPrefix(begin, armorBegin) || !strings.HasSuffix(begin, armorDashes) {
+		return "", nil, fmt.Errorf("Missing armor BEGIN marker")
+	}
+
+	blockType := strings.TrimSuffix(strings.TrimPrefix(begin, armorBegin), armorDashes)
+	if end != armorEnd+blockType+armorDashes {
+		return "", nil, fmt.Errorf("Missing or mismatched armor END marker")
+	}
+
+	checksum := lines[len(lines)-2]
+	if !strings.HasPrefix(checksum, "=") {
+		return "", nil, fmt.Errorf("Missing armor checksum")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-2], ""))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if checksum != armorChecksum(data) {
+		return "", nil, fmt.Errorf("Armor checksum mismatch")
+	}
+
+	return blockType, data, nil
+}
@@ -0,0 +1,52 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCRC24(t *testing.T) {
+	// Check value for the OpenPGP CRC-24
+	if crc := crc24([]byte("123456789")); crc != 0x21CF02 {
+		t.Fatalf("Incorrect CRC-24: %06x", crc)
+	}
+}
+
+func TestArmorRoundTrip(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	env, err := SealEnvelope(suite, rand.Reader, []Recipient{{KeyID: []byte("key"), PublicKey: pkR}}, info, aad, original)
+	assertNotError(t, suite, "Error in SealEnvelope", err)
+
+	inputs := map[string][]byte{
+		ArmorEnvelope:  env,
+		ArmorPublicKey: suite.KEM.SerializePublicKey(pkR),
+		ArmorMessage:   {},
+	}
+
+	for blockType, data := range inputs {
+		armored := Armor(blockType, data)
+
+		decodedType, decoded, err := Dearmor(append([]byte("\n  "), armored...))
+		assertNotError(t, suite, "Error in Dearmor", err)
+		assert(t, suite, "Incorrect block type", decodedType == blockType)
+		assertBytesEqual(t, suite, "Incorrect dearmored data", decoded, data)
+	}
+
+	armored := Armor(ArmorEnvelope, env)
+
+	corrupted := append([]byte{}, armored...)
+	i := bytes.IndexByte(corrupted, '\n') + 1
+	corrupted[i] ^= 0x01
+	_, _, err = Dearmor(corrupted)
+	assert(t, suite, "Dearmor accepted corrupted data", err != nil)
+
+	mismatched := bytes.Replace(armored, []byte("END "+ArmorEnvelope), []byte("END "+ArmorMessage), 1)
+	_, _, err = Dearmor(mismatched)
+	assert(t, suite, "Dearmor accepted mismatched markers", err != nil)
+
+	_, _, err = Dearmor(armored[:len(armored)/2])
+	assert(t, suite, "Dearmor accepted truncated armor", err != nil)
+}
@@ -0,0 +1,154 @@
+package hpke
+
+import (
+	"sync/atomic"
+)
+
+// AuditOperation identifies the kind of key operation reported to an
+// Auditor.
+type AuditOperation uint8
+
+const (
+	// AuditKeyGeneration reports a KEM key pair derived by DeriveKeyPair.
+	// Ephemeral key pairs generated during encapsulation are not reported.
+	AuditKeyGeneration AuditOperation = iota + 1
+
+	// AuditEncap reports the encapsulation performed by sender setup.
+	AuditEncap
+
+	// AuditDecap reports the decapsulation performed by receiver setup.
+	AuditDecap
+
+	// AuditExport reports a secret exported from a context by Export or
+	// ExportKeyingMaterial.
+	AuditExport
+)
+
+func (op AuditOperation) String() string {
+	switch op {
+	case AuditKeyGeneration:
+		return "KeyGeneration"
+	case AuditEncap:
+		return "Encap"
+	case AuditDecap:
+		return "Decap"
+	case AuditExport:
+		return "Export"
+	default:
+		return "UnknownOperation"
+	}
+}
+
+// AuditEvent describes a key operation.  It carries only non-secret metadata:
+// never private keys, shared secrets, PSKs, or exported values.
+type AuditEvent struct {
+	Operation AuditOperation
+
+	// KEMID identifies the KEM.  KDFID and AEADID identify the rest of the
+	// suite, and are zero for key generation, which involves only the KEM.
+	KEMID  KEMID
+	KDFID  KDFID
+	AEADID AEADID
+
+	// Mode is the mode of an encapsulation or decapsulation.
+	Mode Mode
+
+	// PublicKey is the serialized public key of a generated key pair.
+	PublicKey []byte
+
+	// Enc is the encapsulated key produced by an encapsulation or presented
+	// to a decapsulation.
+	Enc []byte
+
+	// Length is the length of an exported secret.
+	Length int
+
+	// Err is the error with which the operation failed, if any.  Failed
+	// decapsulations are reported, so that they can feed anomaly detection.
+	Err error
+}
+
+// Auditor receives audit events for key operations.  Audit is called
+// synchronously, on the goroutine performing the operation, so it should
+// return quickly and must be safe for concurrent use.  Exports are reported
+// while the context is locked, so Audit must not call back into it.
+type Auditor interface {
+	Audit(event AuditEvent)
+}
+
+// AuditorFunc adapts an ordinary function to the Auditor interface.
+type AuditorFunc func(event AuditEvent)
+
+// Audit calls f(event).
+func (f AuditorFunc) Audit(event AuditEvent) {
+	f(event)
+}
+
+// auditorHolder wraps the installed Auditor, so that atomic.Value always
+// stores the same concrete type.
+type auditorHolder struct {
+	auditor Auditor
+}
+
+var auditor atomic.Value
+
+// SetAuditor installs a package-wide Auditor, replacing any previous one.  A
+// nil auditor disables auditing.
+func SetAuditor(a Auditor) {
+	auditor.Store(auditorHolder{a})
+}
+
+// CurrentAuditor returns the installed Auditor, or nil if there is none.
+func CurrentAuditor() Auditor {
+	holder, _ := auditor.Load().(auditorHolder)
+	return holder.auditor
+}
+
+func audit(event AuditEvent) {
+	if a := CurrentAuditor(); a != nil {
+		a.Audit(event)
+	}
+}
+
+func auditSuite(op AuditOperation, suite CipherSuite) AuditEvent {
+	return AuditEvent{
+		Operation: op,
+		KEMID:     suite.KEM.ID(),
+		KDFID:     suite.KDF.ID(),
+		AEADID:    suite.AEAD.ID(),
+	}
+}
+
+func auditKeyGeneration(kem KEMScheme, pk KEMPublicKey, err error) {
+	if CurrentAuditor() == nil {
+		return
+	}
+
+	event := AuditEvent{Operation: AuditKeyGeneration, KEMID: kem.ID(), Err: err}
+	if err == nil {
+		event.PublicKey = kem.SerializePublicKey(pk)
+	}
+	audit(event)
+}
+
+func auditSetup(op AuditOperation, suite CipherSuite, mode Mode, enc []byte, err error) {
+	if CurrentAuditor() == nil {
+		return
+	}
+
+	event := auditSuite(op, suite)
+	event.Mode = mode
+	event.Enc = append([]byte(nil), enc...)
+	event.Err = err
+	audit(event)
+}
+
+func auditExport(suite CipherSuite, length int) {
+	if CurrentAuditor() == nil {
+		return
+	}
+
+	event := auditSuite(AuditExport, suite)
+	event.Length = length
+	audit(event)
+}
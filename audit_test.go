@@ -0,0 +1,61 @@
+package hpke
+
+import (
+	"sync"
+	"testing"
+)
+
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recordingAuditor) Audit(event AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func TestAuditor(t *testing.T) {
+	defer SetAuditor(CurrentAuditor())
+
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	recorder := &recordingAuditor{}
+	SetAuditor(recorder)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info))
+	assertNotError(t, suite, "Error in NewSender", err)
+	_, err = NewReceiver(suite, skR, enc, WithInfo(info))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+	_, err = NewReceiver(suite, skR, enc[:1], WithInfo(info))
+	assert(t, suite, "Truncated enc accepted", err != nil)
+	ctxS.Export(exportContext, 32)
+
+	ops := []AuditOperation{AuditKeyGeneration, AuditEncap, AuditDecap, AuditDecap, AuditExport}
+	assert(t, suite, "Incorrect number of events", len(recorder.events) == len(ops))
+	for i, event := range recorder.events {
+		assert(t, suite, "Incorrect operation "+event.Operation.String(), event.Operation == ops[i])
+		assert(t, suite, "Incorrect KEM", event.KEMID == DHKEM_X25519)
+	}
+
+	keygen := recorder.events[0]
+	assertBytesEqual(t, suite, "Incorrect public key", keygen.PublicKey, suite.KEM.SerializePublicKey(pkR))
+	assert(t, suite, "Suite reported for key generation", keygen.KDFID == 0 && keygen.AEADID == 0)
+
+	encap := recorder.events[1]
+	assertBytesEqual(t, suite, "Incorrect enc", encap.Enc, enc)
+	assert(t, suite, "Incorrect suite", encap.KDFID == KDF_HKDF_SHA256 && encap.AEADID == AEAD_CHACHA20POLY1305)
+	assert(t, suite, "Incorrect mode", encap.Mode == ModeBase)
+	assert(t, suite, "Error reported for encap", encap.Err == nil)
+
+	assert(t, suite, "Failed decap not reported", recorder.events[2].Err == nil && recorder.events[3].Err != nil)
+	assert(t, suite, "Incorrect export length", recorder.events[4].Length == 32)
+
+	SetAuditor(nil)
+	count := len(recorder.events)
+	ctxS.Export(exportContext, 32)
+	assert(t, suite, "Event after auditor removed", len(recorder.events) == count)
+}
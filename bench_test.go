@@ -0,0 +1,259 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+var benchmarkSizes = []int{64, 1024, 16384}
+
+// benchmarkSuites runs f as a sub-benchmark for every suite that can be
+// assembled from the registered algorithms.
+func benchmarkSuites(b *testing.B, f func(b *testing.B, suite CipherSuite)) {
+	for _, kem := range SupportedKEMs() {
+		for _, kdf := range SupportedKDFs() {
+			for _, aead := range SupportedAEADs() {
+				suite, err := AssembleCipherSuite(kem.ID, kdf.ID, aead.ID)
+				if err != nil {
+					continue
+				}
+
+				b.Run(suite.String(), func(b *testing.B) {
+					b.ReportAllocs()
+					f(b, suite)
+				})
+			}
+		}
+	}
+}
+
+func benchmarkKeyPair(b *testing.B, suite CipherSuite) (KEMPrivateKey, KEMPublicKey) {
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Reader.Read(ikm)
+	sk, pk, err := suite.KEM.DeriveKeyPair(ikm)
+	if err != nil {
+		b.Fatalf("Error generating key pair: %v", err)
+	}
+	return sk, pk
+}
+
+func benchmarkContexts(b *testing.B, suite CipherSuite) (*SenderContext, *ReceiverContext) {
+	skR, pkR := benchmarkKeyPair(b, suite)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info))
+	if err != nil {
+		b.Fatalf("Error in NewSender: %v", err)
+	}
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info))
+	if err != nil {
+		b.Fatalf("Error in NewReceiver: %v", err)
+	}
+
+	return ctxS, ctxR
+}
+
+func BenchmarkSetupSender(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		_, pkR := benchmarkKeyPair(b, suite)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := NewSender(suite, pkR, WithInfo(info)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSetupReceiver(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		skR, pkR := benchmarkKeyPair(b, suite)
+		enc, _, err := NewSender(suite, pkR, WithInfo(info))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := NewReceiver(suite, skR, enc, WithInfo(info)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSeal(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		if suite.AEAD.ID() == AEAD_EXPORT_ONLY {
+			b.Skip("Export-only AEAD")
+		}
+
+		for _, size := range benchmarkSizes {
+			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+				ctxS, _ := benchmarkContexts(b, suite)
+				pt := make([]byte, size)
+				dst := make([]byte, 0, size+ctxS.aead.Overhead())
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := ctxS.SealTo(dst[:0], aad, pt); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkOpen(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		if suite.AEAD.ID() == AEAD_EXPORT_ONLY {
+			b.Skip("Export-only AEAD")
+		}
+
+		for _, size := range benchmarkSizes {
+			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+				ctxS, ctxR := benchmarkContexts(b, suite)
+				ct, err := ctxS.Seal(aad, make([]byte, size))
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				// Opening the same message by sequence number repeats the
+				// work of Open without needing b.N distinct ciphertexts.
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := ctxR.OpenWithSeq(0, aad, ct); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkExport(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		ctxS, _ := benchmarkContexts(b, suite)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ctxS.Export(exportContext, 32)
+		}
+	})
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		ctxS, _ := benchmarkContexts(b, suite)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ctxS.Marshal(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	benchmarkSuites(b, func(b *testing.B, suite CipherSuite) {
+		ctxS, _ := benchmarkContexts(b, suite)
+		opaque, err := ctxS.Marshal()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := UnmarshalSenderContext(opaque); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+var benchmarkStreamChunkSizes = []int{4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// BenchmarkStreamWriter measures the throughput of the streaming mode at each
+// chunk size, which StreamChunkSize's recommendations are based on.
+func BenchmarkStreamWriter(b *testing.B) {
+	const streamSize = 4 << 20
+	pt := make([]byte, streamSize)
+
+	for _, aead := range SupportedAEADs() {
+		if aead.ExportOnly {
+			continue
+		}
+
+		suite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, aead.ID)
+		if err != nil {
+			continue
+		}
+
+		for _, chunkSize := range benchmarkStreamChunkSizes {
+			b.Run(fmt.Sprintf("%s/%d", aead.Name, chunkSize), func(b *testing.B) {
+				ctxS, _ := benchmarkContexts(b, suite)
+
+				b.ReportAllocs()
+				b.SetBytes(streamSize)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					sw := NewStreamWriter(ctxS, ioutil.Discard, aad, chunkSize)
+					if _, err := sw.Write(pt); err != nil {
+						b.Fatal(err)
+					}
+					if err := sw.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// unpooledAEAD hides the AEAD scheme's Release method from contexts.
+type unpooledAEAD struct {
+	AEADScheme
+}
+
+// BenchmarkContextChurn sets up and closes a receiver context per iteration,
+// with and without the AEAD scheme recycling the contexts' AEAD instances.
+func BenchmarkContextChurn(b *testing.B) {
+	base, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	suites := map[string]CipherSuite{
+		"pooled":   base,
+		"unpooled": {KEM: base.KEM, KDF: base.KDF, AEAD: unpooledAEAD{base.AEAD}},
+	}
+
+	for _, name := range []string{"pooled", "unpooled"} {
+		suite := suites[name]
+		b.Run(name, func(b *testing.B) {
+			skR, pkR := benchmarkKeyPair(b, suite)
+			enc, _, err := NewSender(suite, pkR, WithInfo(info))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info))
+				if err != nil {
+					b.Fatal(err)
+				}
+				ctxR.Close()
+			}
+		})
+	}
+}
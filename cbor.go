@@ -0,0 +1,169 @@
+package hpke
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// This file implements the small subset of CBOR (RFC 8949) needed to encode
+// HPKE structures: unsigned integers, byte strings, and maps.  Only the
+// deterministic encoding of Section 4.2 is produced or accepted, i.e.,
+// shortest-form heads, definite lengths, and map keys in ascending order.
+
+const (
+	cborMajorUint  = 0
+	cborMajorBytes = 2
+	cborMajorMap   = 5
+)
+
+type cborWriter struct {
+	buf []byte
+}
+
+func (w *cborWriter) head(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major|byte(n))
+	case n <= 0xFF:
+		w.buf = append(w.buf, major|24, byte(n))
+	case n <= 0xFFFF:
+		w.buf = append(w.buf, major|25, byte(n>>8), byte(n))
+	case n <= 0xFFFFFFFF:
+		w.buf = append(w.buf, major|26)
+		w.buf = append(w.buf, make([]byte, 4)...)
+		binary.BigEndian.PutUint32(w.buf[len(w.buf)-4:], uint32(n))
+	default:
+		w.buf = append(w.buf, major|27)
+		w.buf = append(w.buf, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], n)
+	}
+}
+
+func (w *cborWriter) uint(n uint64) {
+	w.head(cborMajorUint, n)
+}
+
+func (w *cborWriter) bytes(b []byte) {
+	w.head(cborMajorBytes, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *cborWriter) mapHeader(n int) {
+	w.head(cborMajorMap, uint64(n))
+}
+
+type cborReader struct {
+	data []byte
+}
+
+func (r *cborReader) head(major byte) (uint64, error) {
+	if len(r.data) == 0 {
+		return 0, fmt.Errorf("Truncated CBOR data")
+	}
+
+	if r.data[0]>>5 != major {
+		return 0, fmt.Errorf("Unexpected CBOR major type [%d]", r.data[0]>>5)
+	}
+
+	info := r.data[0] & 0x1F
+	r.data = r.data[1:]
+
+	var size int
+	var min uint64
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size, min = 1, 24
+	case info == 25:
+		size, min = 2, 0x100
+	case info == 26:
+		size, min = 4, 0x10000
+	case info == 27:
+		size, min = 8, 0x100000000
+	default:
+		return 0, fmt.Errorf("Unsupported CBOR additional information [%d]", info)
+	}
+
+	if len(r.data) < size {
+		return 0, fmt.Errorf("Truncated CBOR data")
+	}
+
+	var n uint64
+	for _, b := range r.data[:size] {
+		n = n<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+
+	if n < min {
+		return 0, fmt.Errorf("Non-deterministic CBOR integer encoding")
+	}
+
+	return n, nil
+}
+
+func (r *cborReader) uint(max uint64) (uint64, error) {
+	n, err := r.head(cborMajorUint)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > max {
+		return 0, fmt.Errorf("CBOR integer out of range [%d]", n)
+	}
+
+	return n, nil
+}
+
+func (r *cborReader) bytes() ([]byte, error) {
+	n, err := r.head(cborMajorBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(len(r.data)) {
+		return nil, fmt.Errorf("Truncated CBOR data")
+	}
+
+	out := append([]byte{}, r.data[:n]...)
+	r.data = r.data[n:]
+	return out, nil
+}
+
+func (r *cborReader) mapHeader() (uint64, error) {
+	return r.head(cborMajorMap)
+}
+
+// fields reads a map whose keys are exactly the integers 1 through n, in
+// ascending order, calling field to read the value for each key.  The number
+// of entries n must be between min and max, so that trailing keys can be
+// optional.
+func (r *cborReader) fields(min, max uint64, field func(key uint64) error) error {
+	n, err := r.mapHeader()
+	if err != nil {
+		return err
+	}
+
+	if n < min || n > max {
+		return fmt.Errorf("Incorrect number of CBOR map entries [%d]", n)
+	}
+
+	for key := uint64(1); key <= n; key++ {
+		readKey, err := r.uint(math.MaxUint64)
+		if err != nil {
+			return err
+		}
+
+		if readKey != key {
+			return fmt.Errorf("Unexpected CBOR map key [%d]", readKey)
+		}
+
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package hpke
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// PublicKeyFromCertificate extracts the DHKEM public key from an X.509
+// certificate, whose SubjectPublicKeyInfo must be in one of the forms that
+// ParsePKIXPublicKey accepts.  If the certificate restricts its key usage,
+// it must allow key agreement.
+func PublicKeyFromCertificate(cert *x509.Certificate) (KEMID, KEMPublicKey, error) {
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageKeyAgreement == 0 {
+		return 0, nil, fmt.Errorf("Certificate key usage does not allow key agreement")
+	}
+
+	return ParsePKIXPublicKey(cert.RawSubjectPublicKeyInfo)
+}
+
+// VerifyCertificateKey verifies the certificate's chain with the given
+// options, which name the trusted roots and any intermediates, and then
+// extracts its public key.  The key must be for the suite's KEM.
+func VerifyCertificateKey(suite CipherSuite, cert *x509.Certificate, opts x509.VerifyOptions) (KEMPublicKey, error) {
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, err
+	}
+
+	kemID, pk, err := PublicKeyFromCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if kemID != suite.KEM.ID() {
+		return nil, fmt.Errorf("%w: Certificate key is for a different KEM [%s]", ErrInvalidPublicKey, kemID)
+	}
+
+	return pk, nil
+}
+
+// SetupAuthRWithCertificate sets up a receiver context in Auth mode for a
+// sender identified by an X.509 certificate.  The certificate is verified
+// as with VerifyCertificateKey, and the context is pinned to its public key,
+// so it can be opened only if the sender holds the certificate's private key.
+func SetupAuthRWithCertificate(suite CipherSuite, skR KEMPrivateKey, cert *x509.Certificate, opts x509.VerifyOptions, enc, info []byte) (*ReceiverContext, error) {
+	pkS, err := VerifyCertificateKey(suite, cert, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return SetupAuthR(suite, skR, pkS, enc, info)
+}
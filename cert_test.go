@@ -0,0 +1,104 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
+package hpke
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustCreateCertificate(t *testing.T, template, parent *x509.Certificate, pub interface{}, priv *ecdsa.PrivateKey) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+	require.Nil(t, err, "Error creating certificate")
+
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err, "Error parsing certificate")
+	return cert
+}
+
+func TestCertificateAuth(t *testing.T) {
+	suite, err := AssembleCipherSuite(DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "Error generating CA key")
+
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca := mustCreateCertificate(t, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	rawS := pkS.(*ecdhPublicKey)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "sender"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	leaf := mustCreateCertificate(t, leafTemplate, ca, ecdsaPublicKey(rawS), caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+
+	enc, ctxS, err := SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	require.Nil(t, err, "Error in SetupAuthS")
+	ct, err := ctxS.Seal(aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err := SetupAuthRWithCertificate(suite, skR, leaf, opts, enc, info)
+	require.Nil(t, err, "Error in SetupAuthRWithCertificate")
+	opened, err := ctxR.Open(aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// Certificates that do not chain to a trusted root are rejected
+	_, err = SetupAuthRWithCertificate(suite, skR, leaf, x509.VerifyOptions{Roots: x509.NewCertPool()}, enc, info)
+	require.NotNil(t, err, "Untrusted certificate accepted")
+
+	// A certificate for a different key does not authenticate the sender
+	_, pkOther, _ := mustGenerateKeyPair(t, suite)
+	rawOther := pkOther.(*ecdhPublicKey)
+	leafTemplate.SerialNumber = big.NewInt(3)
+	other := mustCreateCertificate(t, leafTemplate, ca, ecdsaPublicKey(rawOther), caKey)
+
+	ctxR, err = SetupAuthRWithCertificate(suite, skR, other, opts, enc, info)
+	require.Nil(t, err, "Error in SetupAuthRWithCertificate")
+	_, err = ctxR.Open(aad, ct)
+	require.NotNil(t, err, "Context opened with another certificate's key")
+
+	// The key must be for the suite's KEM and allow key agreement
+	x25519, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+	_, err = VerifyCertificateKey(x25519, leaf, opts)
+	require.True(t, errors.Is(err, ErrInvalidPublicKey), "Certificate for another KEM accepted")
+
+	leafTemplate.SerialNumber = big.NewInt(4)
+	leafTemplate.KeyUsage = x509.KeyUsageDigitalSignature
+	signing := mustCreateCertificate(t, leafTemplate, ca, ecdsaPublicKey(rawS), caKey)
+	_, _, err = PublicKeyFromCertificate(signing)
+	require.NotNil(t, err, "Signing-only certificate accepted")
+}
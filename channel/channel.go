@@ -0,0 +1,262 @@
+// Package channel establishes a bidirectional secure channel in one round
+// trip, using only HPKE Setup and Export.  The handshake resembles the Noise
+// IK pattern: the initiator knows the responder's static public key in
+// advance and may authenticate with a static key of its own, and the session
+// keys are forward secret once the responder has replied.
+//
+// The handshake consists of two messages:
+//
+//  1. The initiator sets up an HPKE context to the responder's static key, in
+//     Auth mode if it has a static key and Base mode otherwise.  Under that
+//     context it sends a fresh ephemeral public key, followed by an optional
+//     payload.
+//
+//  2. The responder sets up a second HPKE context to the initiator's
+//     ephemeral key.  Its info string is exported from the first context, so
+//     it binds the whole handshake.  Under the second context the responder
+//     sends an optional payload.
+//
+// The second context protects the responder's messages, and the response
+// context derived from it (see hpke.ReceiverContext.ResponseSender) protects
+// the initiator's.  Only the responder can derive the second context's info
+// string, so the initiator knows whom it is talking to once it has processed
+// the reply.  The responder authenticates the initiator only if it is given
+// the initiator's static public key.
+//
+// Each handshake message is the encapsulated key, prefixed with its 16-bit
+// length, followed by an AEAD ciphertext.
+package channel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+const (
+	labelInitiatorInfo = "hpke channel initiator"
+	labelResponderInfo = "hpke channel responder"
+)
+
+// Session is an established channel.  Messages must be opened in the order
+// they were sealed; a Session is not safe for concurrent use.
+type Session struct {
+	send     *hpke.SenderContext
+	recv     *hpke.ReceiverContext
+	exporter interface {
+		ExportSecret(context []byte, L int) ([]byte, error)
+	}
+}
+
+// Seal encrypts a message to the peer.
+func (s *Session) Seal(aad, pt []byte) ([]byte, error) {
+	return s.send.Seal(aad, pt)
+}
+
+// Open decrypts the next message from the peer.
+func (s *Session) Open(aad, ct []byte) ([]byte, error) {
+	return s.recv.Open(aad, ct)
+}
+
+// Export derives a secret that both ends of the session share.  It fails once
+// the session has been closed.
+func (s *Session) Export(context []byte, L int) ([]byte, error) {
+	return s.exporter.ExportSecret(context, L)
+}
+
+// Close zeroizes the session's keys.
+func (s *Session) Close() error {
+	s.send.Zeroize()
+	s.recv.Zeroize()
+	return nil
+}
+
+func initiatorInfo(prologue []byte) []byte {
+	return append([]byte(labelInitiatorInfo), prologue...)
+}
+
+// marshalMessage encodes a handshake message, enc || ct.
+func marshalMessage(enc, ct []byte) ([]byte, error) {
+	if len(enc) > 0xFFFF {
+		return nil, fmt.Errorf("Encapsulated key too long [%d]", len(enc))
+	}
+
+	msg := make([]byte, 2, 2+len(enc)+len(ct))
+	binary.BigEndian.PutUint16(msg, uint16(len(enc)))
+	msg = append(msg, enc...)
+	return append(msg, ct...), nil
+}
+
+func parseMessage(msg []byte) ([]byte, []byte, error) {
+	if len(msg) < 2 {
+		return nil, nil, fmt.Errorf("Truncated handshake message")
+	}
+
+	encLen := int(binary.BigEndian.Uint16(msg))
+	if len(msg) < 2+encLen {
+		return nil, nil, fmt.Errorf("Truncated handshake message")
+	}
+
+	return msg[2 : 2+encLen], msg[2+encLen:], nil
+}
+
+// Initiator is the state of the initiator between sending the first
+// handshake message and receiving the reply.
+type Initiator struct {
+	suite        hpke.CipherSuite
+	skE          hpke.KEMPrivateKey
+	responseInfo []byte
+}
+
+// Initiate starts a handshake with the responder whose static public key is
+// pkR.  If skI is not nil, the initiator authenticates with it, and the
+// suite's KEM must support Auth mode.  The prologue is bound into the
+// handshake, and both ends must agree on it.  The returned message carries
+// the payload, encrypted to the responder.
+func Initiate(suite hpke.CipherSuite, rand io.Reader, pkR hpke.KEMPublicKey, skI hpke.KEMPrivateKey, prologue, payload []byte) ([]byte, *Initiator, error) {
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	if _, err := io.ReadFull(rand, ikm); err != nil {
+		return nil, nil, err
+	}
+
+	skE, pkE, err := suite.KEM.DeriveKeyPair(ikm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var enc []byte
+	var ctx *hpke.SenderContext
+	if skI != nil {
+		enc, ctx, err = hpke.SetupAuthS(suite, rand, pkR, skI, initiatorInfo(prologue))
+	} else {
+		enc, ctx, err = hpke.SetupBaseS(suite, rand, pkR, initiatorInfo(prologue))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ctx.Zeroize()
+
+	pkEm := suite.KEM.SerializePublicKey(pkE)
+	ct, err := ctx.Seal(nil, append(pkEm, payload...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := marshalMessage(enc, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initiator := &Initiator{
+		suite:        suite,
+		skE:          skE,
+		responseInfo: ctx.Export([]byte(labelResponderInfo), suite.KDF.OutputSize()),
+	}
+	return msg, initiator, nil
+}
+
+// Finish processes the responder's reply, returning the established session
+// and the responder's payload.
+func (i *Initiator) Finish(msg []byte) (*Session, []byte, error) {
+	enc, ct, err := parseMessage(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recv, err := hpke.SetupBaseR(i.suite, i.skE, enc, i.responseInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payload, err := recv.Open(nil, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	send, err := recv.ResponseSender()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &Session{send: send, recv: recv, exporter: recv}, payload, nil
+}
+
+// Responder is the state of the responder between receiving the first
+// handshake message and replying.
+type Responder struct {
+	suite        hpke.CipherSuite
+	pkE          hpke.KEMPublicKey
+	responseInfo []byte
+}
+
+// Accept processes the initiator's first handshake message with the
+// responder's static private key skR, returning the initiator's payload.  If
+// pkI is not nil, the initiator must have authenticated with the
+// corresponding private key.
+func Accept(suite hpke.CipherSuite, skR hpke.KEMPrivateKey, pkI hpke.KEMPublicKey, prologue, msg []byte) (*Responder, []byte, error) {
+	enc, ct, err := parseMessage(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ctx *hpke.ReceiverContext
+	if pkI != nil {
+		ctx, err = hpke.SetupAuthR(suite, skR, pkI, enc, initiatorInfo(prologue))
+	} else {
+		ctx, err = hpke.SetupBaseR(suite, skR, enc, initiatorInfo(prologue))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ctx.Zeroize()
+
+	pt, err := ctx.Open(nil, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	Npk := suite.KEM.PublicKeySize()
+	if len(pt) < Npk {
+		return nil, nil, fmt.Errorf("Truncated handshake payload")
+	}
+
+	pkE, err := suite.KEM.DeserializePublicKey(pt[:Npk])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	responder := &Responder{
+		suite:        suite,
+		pkE:          pkE,
+		responseInfo: ctx.Export([]byte(labelResponderInfo), suite.KDF.OutputSize()),
+	}
+	return responder, pt[Npk:], nil
+}
+
+// Reply completes the handshake, returning the message to send to the
+// initiator, which carries the payload, and the established session.
+func (r *Responder) Reply(rand io.Reader, payload []byte) ([]byte, *Session, error) {
+	enc, send, err := hpke.SetupBaseS(r.suite, rand, r.pkE, r.responseInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := send.Seal(nil, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := marshalMessage(enc, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recv, err := send.ResponseReceiver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return msg, &Session{send: send, recv: recv, exporter: send}, nil
+}
@@ -0,0 +1,121 @@
+package channel
+
+import (
+	"crypto/rand"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKeyPair(t *testing.T, suite hpke.CipherSuite) (hpke.KEMPrivateKey, hpke.KEMPublicKey) {
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	sk, pk, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+	return sk, pk
+}
+
+func handshake(t *testing.T, suite hpke.CipherSuite, skI hpke.KEMPrivateKey, pkI hpke.KEMPublicKey) (*Session, *Session) {
+	skR, pkR := generateKeyPair(t, suite)
+	prologue := []byte("channel test")
+
+	msg1, initiator, err := Initiate(suite, rand.Reader, pkR, skI, prologue, []byte("hello"))
+	require.Nil(t, err, "Error in Initiate")
+
+	responder, payload, err := Accept(suite, skR, pkI, prologue, msg1)
+	require.Nil(t, err, "Error in Accept")
+	require.Equal(t, []byte("hello"), payload, "Incorrect initiator payload")
+
+	msg2, rs, err := responder.Reply(rand.Reader, []byte("welcome"))
+	require.Nil(t, err, "Error in Reply")
+
+	is, payload, err := initiator.Finish(msg2)
+	require.Nil(t, err, "Error in Finish")
+	require.Equal(t, []byte("welcome"), payload, "Incorrect responder payload")
+
+	// The handshake is bound to the prologue and the responder's key
+	_, _, err = Accept(suite, skR, pkI, []byte("other"), msg1)
+	require.NotNil(t, err, "Handshake accepted with a different prologue")
+
+	skOther, _ := generateKeyPair(t, suite)
+	_, _, err = Accept(suite, skOther, pkI, prologue, msg1)
+	require.NotNil(t, err, "Handshake accepted by the wrong responder")
+
+	return is, rs
+}
+
+func exchange(t *testing.T, is, rs *Session) {
+	for i := 0; i < 3; i++ {
+		ct, err := is.Seal([]byte("aad"), []byte("ping"))
+		require.Nil(t, err, "Error sealing to responder")
+		pt, err := rs.Open([]byte("aad"), ct)
+		require.Nil(t, err, "Error opening at responder")
+		require.Equal(t, []byte("ping"), pt, "Incorrect message to responder")
+
+		ct, err = rs.Seal(nil, []byte("pong"))
+		require.Nil(t, err, "Error sealing to initiator")
+		pt, err = is.Open(nil, ct)
+		require.Nil(t, err, "Error opening at initiator")
+		require.Equal(t, []byte("pong"), pt, "Incorrect message to initiator")
+
+		// A message cannot be opened twice
+		_, err = is.Open(nil, ct)
+		require.NotNil(t, err, "Replayed message accepted")
+	}
+
+	exportedI, err := is.Export([]byte("test"), 32)
+	require.Nil(t, err, "Error exporting at initiator")
+	exportedR, err := rs.Export([]byte("test"), 32)
+	require.Nil(t, err, "Error exporting at responder")
+	require.Equal(t, exportedI, exportedR, "Exported secrets differ")
+
+	require.Nil(t, is.Close(), "Error closing session")
+	_, err = is.Export([]byte("test"), 32)
+	require.NotNil(t, err, "Export succeeded on a closed session")
+}
+
+func TestChannel(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	// Anonymous initiator
+	is, rs := handshake(t, suite, nil, nil)
+	exchange(t, is, rs)
+
+	// Authenticated initiator
+	skI, pkI := generateKeyPair(t, suite)
+	is, rs = handshake(t, suite, skI, pkI)
+	exchange(t, is, rs)
+	require.Nil(t, is.Close(), "Error closing session")
+
+	// The responder rejects initiators that do not hold the expected key
+	skR, pkR := generateKeyPair(t, suite)
+	msg1, _, err := Initiate(suite, rand.Reader, pkR, nil, nil, nil)
+	require.Nil(t, err, "Error in Initiate")
+	_, _, err = Accept(suite, skR, pkI, nil, msg1)
+	require.NotNil(t, err, "Unauthenticated initiator accepted")
+
+	skOther, _ := generateKeyPair(t, suite)
+	msg1, _, err = Initiate(suite, rand.Reader, pkR, skOther, nil, nil)
+	require.Nil(t, err, "Error in Initiate")
+	_, _, err = Accept(suite, skR, pkI, nil, msg1)
+	require.NotNil(t, err, "Initiator with the wrong key accepted")
+
+	// The initiator rejects replies that are not bound to its handshake
+	msg1, initiator, err := Initiate(suite, rand.Reader, pkR, nil, nil, nil)
+	require.Nil(t, err, "Error in Initiate")
+	other, _, err := Initiate(suite, rand.Reader, pkR, nil, nil, nil)
+	require.Nil(t, err, "Error in Initiate")
+
+	responder, _, err := Accept(suite, skR, nil, nil, other)
+	require.Nil(t, err, "Error in Accept")
+	msg2, _, err := responder.Reply(rand.Reader, nil)
+	require.Nil(t, err, "Error in Reply")
+
+	_, _, err = initiator.Finish(msg2)
+	require.NotNil(t, err, "Reply to another handshake accepted")
+
+	_, _, err = initiator.Finish(msg1[:1])
+	require.NotNil(t, err, "Truncated reply accepted")
+}
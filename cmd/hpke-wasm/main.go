@@ -0,0 +1,155 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command hpke-wasm exposes single-shot HPKE to JavaScript when compiled to
+// WebAssembly, so that browser applications can interoperate with Go
+// backends that use this package:
+//
+//	GOOS=js GOARCH=wasm go build -o hpke.wasm ./cmd/hpke-wasm
+//
+// Once the module is running (see wasm_exec.js in the Go distribution), the
+// global object `hpke` has the following methods.  Byte strings are passed
+// and returned as Uint8Arrays, and algorithms are given by their HPKE IDs.
+//
+//	hpke.generateKeyPair(kemID) -> {privateKey, publicKey}
+//	hpke.seal(kemID, kdfID, aeadID, publicKey, info, aad, pt) -> {enc, ct}
+//	hpke.open(kemID, kdfID, aeadID, privateKey, enc, info, aad, ct) -> pt
+//
+// On failure, a method returns an Error object instead of its result.
+// Randomness comes from crypto.getRandomValues, via crypto/rand.
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"syscall/js"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+func assembleSuite(args []js.Value) (hpke.CipherSuite, error) {
+	return hpke.AssembleCipherSuite(hpke.KEMID(args[0].Int()), hpke.KDFID(args[1].Int()), hpke.AEADID(args[2].Int()))
+}
+
+func generateKeyPair(kemID hpke.KEMID) ([]byte, []byte, error) {
+	suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	if _, err := rand.Read(ikm); err != nil {
+		return nil, nil, err
+	}
+
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return suite.KEM.SerializePrivateKey(skR), suite.KEM.SerializePublicKey(pkR), nil
+}
+
+func seal(suite hpke.CipherSuite, pkRm, info, aad, pt []byte) ([]byte, []byte, error) {
+	pkR, err := suite.KEM.DeserializePublicKey(pkRm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+}
+
+func open(suite hpke.CipherSuite, skRm, enc, info, aad, ct []byte) ([]byte, error) {
+	skR, err := suite.KEM.DeserializePrivateKey(skRm)
+	if err != nil {
+		return nil, err
+	}
+
+	return hpke.Open(suite, skR, enc, info, aad, ct)
+}
+
+// JavaScript bindings
+
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
+
+func bytesFromJS(v js.Value) []byte {
+	if v.IsUndefined() || v.IsNull() {
+		return nil
+	}
+
+	out := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(out, v)
+	return out
+}
+
+func bytesToJS(data []byte) js.Value {
+	out := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(out, data)
+	return out
+}
+
+// checkArgs converts panics from malformed arguments, e.g., a number where a
+// Uint8Array is expected, into an Error result.
+func checkArgs(count int, f func(args []js.Value) interface{}) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		if len(args) != count {
+			return jsError(fmt.Errorf("Expected %d arguments, got %d", count, len(args)))
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				result = jsError(fmt.Errorf("Invalid argument: %v", r))
+			}
+		}()
+
+		return f(args)
+	})
+}
+
+func main() {
+	api := map[string]interface{}{
+		"generateKeyPair": checkArgs(1, func(args []js.Value) interface{} {
+			skRm, pkRm, err := generateKeyPair(hpke.KEMID(args[0].Int()))
+			if err != nil {
+				return jsError(err)
+			}
+
+			return map[string]interface{}{"privateKey": bytesToJS(skRm), "publicKey": bytesToJS(pkRm)}
+		}),
+
+		"seal": checkArgs(7, func(args []js.Value) interface{} {
+			suite, err := assembleSuite(args)
+			if err != nil {
+				return jsError(err)
+			}
+
+			enc, ct, err := seal(suite, bytesFromJS(args[3]), bytesFromJS(args[4]), bytesFromJS(args[5]), bytesFromJS(args[6]))
+			if err != nil {
+				return jsError(err)
+			}
+
+			return map[string]interface{}{"enc": bytesToJS(enc), "ct": bytesToJS(ct)}
+		}),
+
+		"open": checkArgs(8, func(args []js.Value) interface{} {
+			suite, err := assembleSuite(args)
+			if err != nil {
+				return jsError(err)
+			}
+
+			pt, err := open(suite, bytesFromJS(args[3]), bytesFromJS(args[4]), bytesFromJS(args[5]), bytesFromJS(args[6]), bytesFromJS(args[7]))
+			if err != nil {
+				return jsError(err)
+			}
+
+			return bytesToJS(pt)
+		}),
+	}
+
+	js.Global().Set("hpke", js.ValueOf(api))
+
+	// Keep the exported functions alive
+	select {}
+}
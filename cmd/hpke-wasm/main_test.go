@@ -0,0 +1,32 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	for _, kemID := range []hpke.KEMID{hpke.DHKEM_X25519, hpke.DHKEM_P256} {
+		skRm, pkRm, err := generateKeyPair(kemID)
+		require.Nil(t, err, "Error in generateKeyPair")
+
+		suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305)
+		require.Nil(t, err, "Error looking up ciphersuite")
+
+		info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+		enc, ct, err := seal(suite, pkRm, info, aad, pt)
+		require.Nil(t, err, "Error in seal")
+
+		opened, err := open(suite, skRm, enc, info, aad, ct)
+		require.Nil(t, err, "Error in open")
+		require.Equal(t, pt, opened, "Incorrect plaintext")
+
+		_, err = open(suite, skRm, enc, info, nil, ct)
+		require.NotNil(t, err, "Open succeeded with wrong AAD")
+	}
+}
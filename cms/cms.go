@@ -0,0 +1,274 @@
+// Package cms builds and parses the KEMRecipientInfo structure (RFC 9629)
+// used to convey a content-encryption key to a recipient in CMS and S/MIME
+// with one of the HPKE KEMs, as described in draft-ietf-lamps-cms-hpke.
+//
+// The content-encryption key is protected as follows:
+//
+//	(ss, kemct) = Encap(pkR)
+//	KEK = HKDF(ss, info = DER(CMSORIforKEMOtherInfo), L = kekLength)
+//	encryptedKey = AES-KeyWrap(KEK, CEK)
+//
+// The KEM algorithm identifier is supplied by the caller, since it depends on
+// how the recipient's certificate identifies its key.
+package cms
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+var (
+	// oidKEMRecipientInfo is id-ori-kem (RFC 9629).
+	oidKEMRecipientInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 13, 3}
+
+	// HKDF algorithm identifiers (RFC 8619)
+	oidHKDFSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 28}
+	oidHKDFSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 29}
+	oidHKDFSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 30}
+
+	// AES Key Wrap algorithm identifiers (RFC 3565)
+	oidAES128Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	oidAES192Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	oidAES256Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+)
+
+var kdfOIDs = map[hpke.KDFID]asn1.ObjectIdentifier{
+	hpke.KDF_HKDF_SHA256: oidHKDFSHA256,
+	hpke.KDF_HKDF_SHA384: oidHKDFSHA384,
+	hpke.KDF_HKDF_SHA512: oidHKDFSHA512,
+}
+
+var wrapOIDs = map[int]asn1.ObjectIdentifier{
+	16: oidAES128Wrap,
+	24: oidAES192Wrap,
+	32: oidAES256Wrap,
+}
+
+// RecipientInfo is a KEMRecipientInfo.  The recipient is identified by
+// subject key identifier; IssuerAndSerialNumber is not supported.
+type RecipientInfo struct {
+	SubjectKeyID  []byte
+	KEM           pkix.AlgorithmIdentifier
+	KEMCiphertext []byte
+	KDF           hpke.KDFID
+	KEKLength     int
+	UKM           []byte
+	EncryptedKey  []byte
+}
+
+// kemRecipientInfo is the ASN.1 form of KEMRecipientInfo.
+type kemRecipientInfo struct {
+	Version      int
+	RID          []byte `asn1:"tag:0"`
+	KEM          pkix.AlgorithmIdentifier
+	KEMCT        []byte
+	KDF          pkix.AlgorithmIdentifier
+	KEKLength    int
+	UKM          []byte `asn1:"optional,explicit,tag:0"`
+	Wrap         pkix.AlgorithmIdentifier
+	EncryptedKey []byte
+}
+
+// otherRecipientInfo is the ASN.1 form of OtherRecipientInfo, which carries
+// a KEMRecipientInfo as the ori alternative of RecipientInfo.
+type otherRecipientInfo struct {
+	OriType  asn1.ObjectIdentifier
+	OriValue asn1.RawValue
+}
+
+// kemOtherInfo is CMSORIforKEMOtherInfo, the KDF info input.
+type kemOtherInfo struct {
+	Wrap      pkix.AlgorithmIdentifier
+	KEKLength int
+	UKM       []byte `asn1:"optional,explicit,tag:0"`
+}
+
+func wrapAlgorithm(kekLength int) (pkix.AlgorithmIdentifier, error) {
+	oid, ok := wrapOIDs[kekLength]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("Unsupported KEK length [%d]", kekLength)
+	}
+
+	return pkix.AlgorithmIdentifier{Algorithm: oid}, nil
+}
+
+// deriveKEK computes the key-encryption key from the KEM shared secret.
+func (ri *RecipientInfo) deriveKEK(sharedSecret []byte) ([]byte, error) {
+	wrap, err := wrapAlgorithm(ri.KEKLength)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(kemOtherInfo{Wrap: wrap, KEKLength: ri.KEKLength, UKM: ri.UKM})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := kdfOIDs[ri.KDF]; !ok {
+		return nil, fmt.Errorf("%w: KDF not supported in CMS [%s]", hpke.ErrUnsupportedSuite, ri.KDF)
+	}
+
+	// The KDF is independent of the KEM and AEAD, so any supported
+	// combination serves to look it up.
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, ri.KDF, hpke.AEAD_AESGCM128)
+	if err != nil {
+		return nil, err
+	}
+
+	prk := suite.KDF.Extract(nil, sharedSecret)
+	return suite.KDF.Expand(prk, info, ri.KEKLength), nil
+}
+
+// NewRecipientInfo wraps the content-encryption key cek to the public key
+// pkR, identified by subjectKeyID.  The key-encryption key is derived with
+// the given KDF and wraps the CEK with AES Key Wrap; kekLength selects AES-128,
+// AES-192, or AES-256.  The user keying material ukm is optional.
+func NewRecipientInfo(rand io.Reader, kem hpke.KEMScheme, kemAlg pkix.AlgorithmIdentifier, pkR hpke.KEMPublicKey, subjectKeyID []byte, kdf hpke.KDFID, kekLength int, ukm, cek []byte) (*RecipientInfo, error) {
+	sharedSecret, kemct, err := kem.Encap(rand, pkR)
+	if err != nil {
+		return nil, err
+	}
+
+	ri := &RecipientInfo{
+		SubjectKeyID:  subjectKeyID,
+		KEM:           kemAlg,
+		KEMCiphertext: kemct,
+		KDF:           kdf,
+		KEKLength:     kekLength,
+		UKM:           ukm,
+	}
+
+	kek, err := ri.deriveKEK(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	ri.EncryptedKey, err = wrapKey(kek, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	return ri, nil
+}
+
+// Open recovers the content-encryption key using the recipient's private key.
+// The caller selects the KEM, e.g., based on ri.KEM or on the type of skR.
+func (ri *RecipientInfo) Open(kem hpke.KEMScheme, skR hpke.KEMPrivateKey) ([]byte, error) {
+	sharedSecret, err := kem.Decap(ri.KEMCiphertext, skR)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := ri.deriveKEK(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := unwrapKey(kek, ri.EncryptedKey)
+	if err != nil {
+		return nil, hpke.ErrOpenFailed
+	}
+
+	return cek, nil
+}
+
+// Marshal returns the DER encoding of the RecipientInfo, as the
+// [4] IMPLICIT OtherRecipientInfo alternative of the RecipientInfo CHOICE.
+func (ri *RecipientInfo) Marshal() ([]byte, error) {
+	kdfOID, ok := kdfOIDs[ri.KDF]
+	if !ok {
+		return nil, fmt.Errorf("%w: KDF not supported in CMS [%s]", hpke.ErrUnsupportedSuite, ri.KDF)
+	}
+
+	wrap, err := wrapAlgorithm(ri.KEKLength)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := asn1.Marshal(kemRecipientInfo{
+		Version:      0,
+		RID:          ri.SubjectKeyID,
+		KEM:          ri.KEM,
+		KEMCT:        ri.KEMCiphertext,
+		KDF:          pkix.AlgorithmIdentifier{Algorithm: kdfOID},
+		KEKLength:    ri.KEKLength,
+		UKM:          ri.UKM,
+		Wrap:         wrap,
+		EncryptedKey: ri.EncryptedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ori := otherRecipientInfo{
+		OriType:  oidKEMRecipientInfo,
+		OriValue: asn1.RawValue{FullBytes: value},
+	}
+	return asn1.MarshalWithParams(ori, "tag:4")
+}
+
+// ParseRecipientInfo parses a RecipientInfo produced by Marshal.
+func ParseRecipientInfo(der []byte) (*RecipientInfo, error) {
+	var ori otherRecipientInfo
+	rest, err := asn1.UnmarshalWithParams(der, &ori, "tag:4")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("Trailing data after RecipientInfo")
+	}
+
+	if !ori.OriType.Equal(oidKEMRecipientInfo) {
+		return nil, fmt.Errorf("Unsupported OtherRecipientInfo type [%s]", ori.OriType)
+	}
+
+	var kri kemRecipientInfo
+	rest, err = asn1.Unmarshal(ori.OriValue.FullBytes, &kri)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("Trailing data after KEMRecipientInfo")
+	}
+
+	if kri.Version != 0 {
+		return nil, fmt.Errorf("Unsupported KEMRecipientInfo version [%d]", kri.Version)
+	}
+
+	ri := &RecipientInfo{
+		SubjectKeyID:  kri.RID,
+		KEM:           kri.KEM,
+		KEMCiphertext: kri.KEMCT,
+		KEKLength:     kri.KEKLength,
+		UKM:           kri.UKM,
+		EncryptedKey:  kri.EncryptedKey,
+	}
+
+	found := false
+	for kdf, oid := range kdfOIDs {
+		if oid.Equal(kri.KDF.Algorithm) {
+			ri.KDF, found = kdf, true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: Unknown KDF [%s]", hpke.ErrUnsupportedSuite, kri.KDF.Algorithm)
+	}
+
+	wrap, err := wrapAlgorithm(kri.KEKLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if !wrap.Algorithm.Equal(kri.Wrap.Algorithm) {
+		return nil, fmt.Errorf("Key wrap algorithm does not match KEK length [%s]", kri.Wrap.Algorithm)
+	}
+
+	return ri, nil
+}
@@ -0,0 +1,106 @@
+package cms
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	subjectKeyID = []byte{0x01, 0x02, 0x03, 0x04}
+	ukm          = []byte("user keying material")
+
+	// An arbitrary KEM algorithm identifier; the package does not interpret it
+	testKEMAlg = pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}}
+)
+
+func TestKeyWrap(t *testing.T) {
+	// RFC 3394, Section 4.1
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	expected, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped, err := wrapKey(kek, key)
+	require.Nil(t, err, "Error in wrapKey")
+	require.Equal(t, expected, wrapped, "Incorrect wrapped key")
+
+	unwrapped, err := unwrapKey(kek, wrapped)
+	require.Nil(t, err, "Error in unwrapKey")
+	require.Equal(t, key, unwrapped, "Incorrect unwrapped key")
+
+	wrapped[0] ^= 0x01
+	_, err = unwrapKey(kek, wrapped)
+	require.NotNil(t, err, "Modified wrapped key accepted")
+}
+
+func TestRecipientInfo(t *testing.T) {
+	for _, kemID := range []hpke.KEMID{hpke.DHKEM_X25519, hpke.DHKEM_P256} {
+		suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+		require.Nil(t, err, "Error looking up ciphersuite")
+
+		ikm := make([]byte, suite.KEM.PrivateKeySize())
+		rand.Read(ikm)
+		skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error deriving key pair")
+
+		cek := make([]byte, 32)
+		rand.Read(cek)
+
+		ri, err := NewRecipientInfo(rand.Reader, suite.KEM, testKEMAlg, pkR, subjectKeyID, hpke.KDF_HKDF_SHA256, 16, ukm, cek)
+		require.Nil(t, err, "Error in NewRecipientInfo")
+
+		der, err := ri.Marshal()
+		require.Nil(t, err, "Error in Marshal")
+		require.Equal(t, byte(0xa4), der[0], "RecipientInfo not tagged as ori")
+
+		parsed, err := ParseRecipientInfo(der)
+		require.Nil(t, err, "Error in ParseRecipientInfo")
+		require.Equal(t, ri.SubjectKeyID, parsed.SubjectKeyID, "Incorrect subject key ID")
+		require.True(t, ri.KEM.Algorithm.Equal(parsed.KEM.Algorithm), "Incorrect KEM algorithm")
+		require.Equal(t, ri.KDF, parsed.KDF, "Incorrect KDF")
+		require.Equal(t, ri.KEKLength, parsed.KEKLength, "Incorrect KEK length")
+		require.Equal(t, ri.UKM, parsed.UKM, "Incorrect UKM")
+
+		opened, err := parsed.Open(suite.KEM, skR)
+		require.Nil(t, err, "Error in Open")
+		require.Equal(t, cek, opened, "Incorrect content-encryption key")
+
+		// The UKM is bound into the key derivation
+		parsed.UKM = nil
+		_, err = parsed.Open(suite.KEM, skR)
+		require.NotNil(t, err, "Open succeeded with modified UKM")
+
+		_, err = ParseRecipientInfo(append(der, 0x00))
+		require.NotNil(t, err, "RecipientInfo with trailing data accepted")
+	}
+}
+
+func TestRecipientInfoParameters(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA512, hpke.AEAD_AESGCM256)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	_, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	cek := make([]byte, 16)
+	_, err = NewRecipientInfo(rand.Reader, suite.KEM, testKEMAlg, pkR, subjectKeyID, hpke.KDF_HKDF_SHA512, 20, nil, cek)
+	require.NotNil(t, err, "Unsupported KEK length accepted")
+
+	ri, err := NewRecipientInfo(rand.Reader, suite.KEM, testKEMAlg, pkR, subjectKeyID, hpke.KDF_HKDF_SHA512, 32, nil, cek)
+	require.Nil(t, err, "Error in NewRecipientInfo")
+
+	der, err := ri.Marshal()
+	require.Nil(t, err, "Error in Marshal")
+
+	parsed, err := ParseRecipientInfo(der)
+	require.Nil(t, err, "Error in ParseRecipientInfo")
+	require.Equal(t, hpke.KDF_HKDF_SHA512, parsed.KDF, "Incorrect KDF")
+	require.Nil(t, parsed.UKM, "UKM present when not provided")
+}
@@ -0,0 +1,78 @@
+package cms
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// defaultIV is the initial value of the AES Key Wrap algorithm (RFC 3394).
+var defaultIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// wrapKey wraps key under kek with the AES Key Wrap algorithm (RFC 3394).
+func wrapKey(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, fmt.Errorf("Invalid length for key wrap [%d]", len(key))
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, defaultIV)
+	copy(out[8:], key)
+
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, out[:8])
+			copy(buf[8:], out[8*i:8*i+8])
+			block.Encrypt(buf, buf)
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(out[8*i:], buf[8:])
+		}
+	}
+
+	return out, nil
+}
+
+// unwrapKey reverses wrapKey, verifying the integrity of the wrapped key.
+func unwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("Invalid length for key unwrap [%d]", len(wrapped))
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(buf[8:], out[8*i:8*i+8])
+			block.Decrypt(buf, buf)
+
+			copy(out[:8], buf[:8])
+			copy(out[8*i:], buf[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(out[:8], defaultIV) != 1 {
+		return nil, fmt.Errorf("Key unwrap integrity check failed")
+	}
+
+	return out[8:], nil
+}
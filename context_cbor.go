@@ -0,0 +1,160 @@
+package hpke
+
+import (
+	"fmt"
+	"math"
+)
+
+// Map keys for the CBOR encoding of a context
+const (
+	contextKeyRole = iota + 1
+	contextKeyKEMID
+	contextKeyKDFID
+	contextKeyAEADID
+	contextKeyExporterSecret
+	contextKeyKey
+	contextKeyBaseNonce
+	contextKeySeq
+	contextKeyEpoch
+	contextKeyExpiry
+	contextKeyRequired = contextKeyEpoch
+	contextKeyCount    = contextKeyExpiry
+)
+
+// MarshalCBOR serializes the context as a deterministically-encoded CBOR map
+// with integer keys, as an alternative to Marshal for exchanging contexts
+// with other implementations:
+//
+//	{
+//	  1: role,             ; 0 = sender, 1 = receiver
+//	  2: kem_id,
+//	  3: kdf_id,
+//	  4: aead_id,
+//	  5: bstr exporter_secret,
+//	  6: bstr key,
+//	  7: bstr base_nonce,
+//	  8: seq,
+//	  9: epoch,
+//	  ? 10: expiry         ; Unix time in seconds
+//	}
+//
+// The expiry is only present if the context has one.  Unlike Marshal, the
+// encoding carries no integrity check, and it cannot represent suite
+// binding, so contexts with suite binding enabled are refused.
+func (ctx *context) MarshalCBOR() ([]byte, error) {
+	defer ctx.lock()()
+
+	if ctx.closed {
+		return nil, ErrContextClosed
+	}
+
+	if ctx.binding != nil {
+		return nil, fmt.Errorf("Suite binding cannot be encoded in CBOR")
+	}
+
+	count := contextKeyRequired
+	if !ctx.expiry.IsZero() {
+		count = contextKeyCount
+	}
+
+	w := cborWriter{}
+	w.mapHeader(count)
+	w.uint(contextKeyRole)
+	w.uint(uint64(ctx.Role))
+	w.uint(contextKeyKEMID)
+	w.uint(uint64(ctx.KEMID))
+	w.uint(contextKeyKDFID)
+	w.uint(uint64(ctx.KDFID))
+	w.uint(contextKeyAEADID)
+	w.uint(uint64(ctx.AEADID))
+	w.uint(contextKeyExporterSecret)
+	w.bytes(ctx.ExporterSecret)
+	w.uint(contextKeyKey)
+	w.bytes(ctx.Key)
+	w.uint(contextKeyBaseNonce)
+	w.bytes(ctx.BaseNonce)
+	w.uint(contextKeySeq)
+	w.uint(ctx.Seq)
+	w.uint(contextKeyEpoch)
+	w.uint(ctx.Epoch)
+	if !ctx.expiry.IsZero() {
+		w.uint(contextKeyExpiry)
+		w.uint(expiryToUnix(ctx.expiry))
+	}
+	return w.buf, nil
+}
+
+func unmarshalContextCBOR(role contextRole, data []byte) (context, error) {
+	var ctx context
+	r := cborReader{data: data}
+	err := r.fields(contextKeyRequired, contextKeyCount, func(key uint64) error {
+		var err error
+		var n uint64
+		switch key {
+		case contextKeyRole:
+			n, err = r.uint(math.MaxUint8)
+			ctx.Role = contextRole(n)
+		case contextKeyKEMID:
+			n, err = r.uint(math.MaxUint16)
+			ctx.KEMID = KEMID(n)
+		case contextKeyKDFID:
+			n, err = r.uint(math.MaxUint16)
+			ctx.KDFID = KDFID(n)
+		case contextKeyAEADID:
+			n, err = r.uint(math.MaxUint16)
+			ctx.AEADID = AEADID(n)
+		case contextKeyExporterSecret:
+			ctx.ExporterSecret, err = r.bytes()
+		case contextKeyKey:
+			ctx.Key, err = r.bytes()
+		case contextKeyBaseNonce:
+			ctx.BaseNonce, err = r.bytes()
+		case contextKeySeq:
+			ctx.Seq, err = r.uint(math.MaxUint64)
+		case contextKeyEpoch:
+			ctx.Epoch, err = r.uint(math.MaxUint64)
+		case contextKeyExpiry:
+			n, err = r.uint(math.MaxUint64)
+			if err == nil && n == 0 {
+				err = fmt.Errorf("Zero context expiry")
+			}
+			ctx.expiry = expiryFromUnix(n)
+		}
+		return err
+	})
+	if err != nil {
+		return context{}, err
+	}
+
+	if len(r.data) != 0 {
+		return context{}, fmt.Errorf("Trailing data after context")
+	}
+
+	if err := ctx.restore(role); err != nil {
+		return context{}, err
+	}
+
+	return ctx, nil
+}
+
+// UnmarshalSenderContextCBOR deserializes a sender context produced by
+// MarshalCBOR.
+func UnmarshalSenderContextCBOR(data []byte) (*SenderContext, error) {
+	ctx, err := unmarshalContextCBOR(contextRoleSender, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SenderContext{ctx}, nil
+}
+
+// UnmarshalReceiverContextCBOR deserializes a receiver context produced by
+// MarshalCBOR.
+func UnmarshalReceiverContextCBOR(data []byte) (*ReceiverContext, error) {
+	ctx, err := unmarshalContextCBOR(contextRoleReceiver, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceiverContext{context: ctx}, nil
+}
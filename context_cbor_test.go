@@ -0,0 +1,51 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestContextCBOR(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_P256, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	ct, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	encodedS, err := ctxS.MarshalCBOR()
+	assertNotError(t, suite, "Error in MarshalCBOR", err)
+
+	encodedR, err := ctxR.MarshalCBOR()
+	assertNotError(t, suite, "Error in MarshalCBOR", err)
+
+	// map(9), 1: 0, 2: 0x10, 3: 1, 4: 3
+	expectedPrefix := []byte{0xa9, 0x01, 0x00, 0x02, 0x10, 0x03, 0x01, 0x04, 0x03}
+	assertBytesEqual(t, suite, "Incorrect CBOR encoding", encodedS[:len(expectedPrefix)], expectedPrefix)
+
+	restoredS, err := UnmarshalSenderContextCBOR(encodedS)
+	assertNotError(t, suite, "Error in UnmarshalSenderContextCBOR", err)
+	assertCipherContextEqual(t, suite, "CBOR sender context mismatch", ctxS.context, restoredS.context)
+
+	restoredR, err := UnmarshalReceiverContextCBOR(encodedR)
+	assertNotError(t, suite, "Error in UnmarshalReceiverContextCBOR", err)
+	assertCipherContextEqual(t, suite, "CBOR receiver context mismatch", ctxR.context, restoredR.context)
+
+	pt, err := restoredR.Open(aad, ct)
+	assertNotError(t, suite, "Error in Open after CBOR round trip", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+	_, err = UnmarshalReceiverContextCBOR(encodedS)
+	assert(t, suite, "Context accepted with the wrong role", err != nil)
+
+	_, err = UnmarshalSenderContextCBOR(append(encodedS, 0x00))
+	assert(t, suite, "Context with trailing data accepted", err != nil)
+
+	_, err = UnmarshalSenderContextCBOR(encodedS[:len(encodedS)-1])
+	assert(t, suite, "Truncated context accepted", err != nil)
+}
@@ -1,25 +1,19 @@
 package hpke
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/elliptic"
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/subtle"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"math/big"
-	mrand "math/rand"
+	"sync"
 
 	_ "crypto/sha256"
-	_ "crypto/sha512"
 
-	"git.schwanenlied.me/yawning/x448.git"
-	"github.com/cloudflare/circl/dh/sidh"
 	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
 )
@@ -44,7 +38,6 @@ type dhScheme interface {
 
 type dhkemScheme struct {
 	group dhScheme
-	skE   KEMPrivateKey
 }
 
 func (s dhkemScheme) ID() KEMID {
@@ -52,7 +45,9 @@ func (s dhkemScheme) ID() KEMID {
 }
 
 func (s dhkemScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
-	return s.group.DeriveKeyPair(ikm)
+	sk, pk, err := s.group.DeriveKeyPair(ikm)
+	auditKeyGeneration(s, pk, err)
+	return sk, pk, err
 }
 
 func (s dhkemScheme) SerializePublicKey(pk KEMPublicKey) []byte {
@@ -71,21 +66,23 @@ func (s dhkemScheme) DeserializePrivateKey(enc []byte) (KEMPrivateKey, error) {
 	return s.group.DeserializePrivateKey(enc)
 }
 
-func (s *dhkemScheme) setEphemeralKeyPair(skE KEMPrivateKey) {
-	s.skE = skE
-}
-
 func (s dhkemScheme) getEphemeralKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	if s.skE != nil {
-		return s.skE, s.skE.PublicKey(), nil
-	}
-
 	ikm := make([]byte, s.PrivateKeySize())
 	rand.Read(ikm)
 
 	return s.group.DeriveKeyPair(ikm)
 }
 
+// dh performs the DH operation with a private key, delegating it to the key
+// if it is a DHPrivateKey.
+func (s dhkemScheme) dh(sk KEMPrivateKey, pk KEMPublicKey) ([]byte, error) {
+	if external, ok := sk.(DHPrivateKey); ok {
+		return external.DH(s.group.SerializePublicKey(pk))
+	}
+
+	return s.group.DH(sk, pk)
+}
+
 func (s dhkemScheme) extractAndExpand(dh []byte, kemContext []byte, Nsecret int) []byte {
 	suiteID := kemSuiteFromID(s.ID())
 	eae_prk := s.group.internalKDF().LabeledExtract(nil, suiteID, "eae_prk", dh)
@@ -98,6 +95,19 @@ func (s dhkemScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, er
 		return nil, nil, err
 	}
 
+	return s.encap(skE, pkE, pkR)
+}
+
+func (s dhkemScheme) DeriveEncap(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	skE, pkE, err := s.group.DeriveKeyPair(ikmE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.encap(skE, pkE, pkR)
+}
+
+func (s dhkemScheme) encap(skE KEMPrivateKey, pkE, pkR KEMPublicKey) ([]byte, []byte, error) {
 	dh, err := s.group.DH(skE, pkR)
 	if err != nil {
 		return nil, nil, err
@@ -122,7 +132,7 @@ func (s dhkemScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
 		return nil, err
 	}
 
-	dh, err := s.group.DH(skR, pkE)
+	dh, err := s.dh(skR, pkE)
 	if err != nil {
 		return nil, err
 	}
@@ -145,12 +155,25 @@ func (s dhkemScheme) AuthEncap(rand io.Reader, pkR KEMPublicKey, skS KEMPrivateK
 		return nil, nil, err
 	}
 
+	return s.authEncap(skE, pkE, pkR, skS)
+}
+
+func (s dhkemScheme) DeriveAuthEncap(ikmE []byte, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error) {
+	skE, pkE, err := s.group.DeriveKeyPair(ikmE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.authEncap(skE, pkE, pkR, skS)
+}
+
+func (s dhkemScheme) authEncap(skE KEMPrivateKey, pkE, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error) {
 	dhER, err := s.group.DH(skE, pkR)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	dhIR, err := s.group.DH(skS, pkR)
+	dhIR, err := s.dh(skS, pkR)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -181,12 +204,12 @@ func (s dhkemScheme) AuthDecap(enc []byte, skR KEMPrivateKey, pkS KEMPublicKey)
 		return nil, err
 	}
 
-	dhER, err := s.group.DH(skR, pkE)
+	dhER, err := s.dh(skR, pkE)
 	if err != nil {
 		return nil, err
 	}
 
-	dhIR, err := s.group.DH(skR, pkS)
+	dhIR, err := s.dh(skR, pkS)
 	if err != nil {
 		return nil, err
 	}
@@ -221,25 +244,105 @@ func (s dhkemScheme) PrivateKeySize() int {
 ////////////////////////
 // ECDH with NIST curves
 
+// ecdhPrivateKey holds the scalar alongside the key used for DH, which is
+// computed once, when the key is created.
 type ecdhPrivateKey struct {
 	curve elliptic.Curve
 	d     []byte
-	x, y  *big.Int
+	key   nistecPrivateKey
+	pub   *ecdhPublicKey
 }
 
 func (priv ecdhPrivateKey) PublicKey() KEMPublicKey {
-	return &ecdhPublicKey{priv.curve, priv.x, priv.y}
+	return priv.pub
+}
+
+// Zeroize wipes the scalar and drops the crypto/ecdh key, whose copy of the
+// scalar cannot be wiped.  The key cannot be used afterwards.
+func (priv *ecdhPrivateKey) Zeroize() {
+	wipe(priv.d)
+
+	var zero nistecPrivateKey
+	priv.key = zero
+}
+
+// Equal reports whether other is the same private key, in constant time.
+func (priv *ecdhPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*ecdhPrivateKey)
+	if !ok || priv.curve.Params().Name != o.curve.Params().Name {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(priv.d, o.d) == 1
 }
 
+// ecdhPublicKey holds the uncompressed encoding of the point alongside the
+// key used for DH.
 type ecdhPublicKey struct {
 	curve elliptic.Curve
-	x, y  *big.Int
+	point []byte
+	key   nistecPublicKey
+}
+
+// Equal reports whether other is the same public key, in constant time.
+func (pub *ecdhPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*ecdhPublicKey)
+	if !ok || pub.curve.Params().Name != o.curve.Params().Name {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(pub.point, o.point) == 1
+}
+
+func (priv *ecdhPrivateKey) kemID() KEMID {
+	return ecdhScheme{curve: priv.curve}.ID()
+}
+
+func (priv *ecdhPrivateKey) MarshalBinary() ([]byte, error) {
+	return marshalPrivateKey(priv)
+}
+
+func (priv *ecdhPrivateKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPrivateKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*ecdhPrivateKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*priv = *decoded
+	return nil
+}
+
+func (pub *ecdhPublicKey) kemID() KEMID {
+	return ecdhScheme{curve: pub.curve}.ID()
+}
+
+func (pub *ecdhPublicKey) MarshalBinary() ([]byte, error) {
+	return marshalPublicKey(pub)
+}
+
+func (pub *ecdhPublicKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPublicKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*ecdhPublicKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*pub = *decoded
+	return nil
 }
 
 type ecdhScheme struct {
 	curve elliptic.Curve
 	KDF   KDFScheme
-	skE   KEMPrivateKey
 }
 
 func (s ecdhScheme) internalKDF() KDFScheme {
@@ -278,7 +381,10 @@ func (s ecdhScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, erro
 		bytes := s.KDF.LabeledExpand(dkp_prk, suiteID, "candidate", []byte{uint8(counter)}, s.PrivateKeySize())
 		bytes[0] = bytes[0] & s.privateKeyBitmask()
 
+		// Candidates are checked in constant time; only whether one was
+		// rejected, which RFC 9180 makes observable, depends on the secret.
 		sk, err := s.DeserializePrivateKey(bytes)
+		wipe(bytes)
 		if err == nil {
 			return sk, sk.PublicKey(), nil
 		}
@@ -294,7 +400,7 @@ func (s ecdhScheme) SerializePublicKey(pk KEMPublicKey) []byte {
 		return nil
 	}
 	raw := pk.(*ecdhPublicKey)
-	return elliptic.Marshal(raw.curve, raw.x, raw.y)
+	return append([]byte(nil), raw.point...)
 }
 
 func (s ecdhScheme) SerializePrivateKey(sk KEMPrivateKey) []byte {
@@ -303,27 +409,59 @@ func (s ecdhScheme) SerializePrivateKey(sk KEMPrivateKey) []byte {
 	}
 
 	raw := sk.(*ecdhPrivateKey)
-	copied := make([]byte, len(raw.d))
-	copy(copied, raw.d)
-	return copied
+	return append([]byte(nil), raw.d...)
 }
 
 func (s ecdhScheme) DeserializePublicKey(enc []byte) (KEMPublicKey, error) {
-	x, y := elliptic.Unmarshal(s.curve, enc)
-	if x == nil {
-		return nil, fmt.Errorf("Error deserializing public key")
-	}
-
-	return &ecdhPublicKey{s.curve, x, y}, nil
+	return newECDHPublicKey(s.curve, enc)
 }
 
+// DeserializePrivateKey accepts a big-endian scalar of at most Nsk bytes,
+// which it copies into a buffer of exactly Nsk bytes, so that the key's
+// serialization has a fixed length.  The scalar is range checked in constant
+// time.
 func (s ecdhScheme) DeserializePrivateKey(enc []byte) (KEMPrivateKey, error) {
 	if enc == nil {
 		return nil, fmt.Errorf("Invalid input")
 	}
 
-	x, y := s.curve.Params().ScalarBaseMult(enc)
-	return &ecdhPrivateKey{s.curve, enc, x, y}, nil
+	size := s.PrivateKeySize()
+	if len(enc) > size {
+		return nil, fmt.Errorf("Error deserializing %s private key", s.curve.Params().Name)
+	}
+
+	d := make([]byte, size)
+	copy(d[size-len(enc):], enc)
+	if !scalarInRange(d, s.curve.Params().N) {
+		wipe(d)
+		return nil, fmt.Errorf("Error deserializing %s private key", s.curve.Params().Name)
+	}
+
+	sk, err := newECDHPrivateKey(s.curve, d)
+	if err != nil {
+		wipe(d)
+		return nil, err
+	}
+
+	return sk, nil
+}
+
+// scalarInRange reports whether the big-endian scalar d lies in [1, n-1].  It
+// runs in time that depends only on the length of d.
+func scalarInRange(d []byte, n *big.Int) bool {
+	nBytes := n.Bytes()
+	order := make([]byte, len(d))
+	copy(order[len(d)-len(nBytes):], nBytes)
+
+	// d < n if and only if d - n borrows
+	var borrow, nonzero int
+	for i := len(d) - 1; i >= 0; i-- {
+		diff := int(d[i]) - int(order[i]) - borrow
+		borrow = (diff >> 8) & 1
+		nonzero |= int(d[i])
+	}
+
+	return borrow&(1-subtle.ConstantTimeByteEq(uint8(nonzero), 0)) == 1
 }
 
 func (s ecdhScheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
@@ -337,14 +475,7 @@ func (s ecdhScheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
 		return nil, fmt.Errorf("Public key not suitable for ECDH")
 	}
 
-	x, _ := s.curve.Params().ScalarMult(ecdhPub.x, ecdhPub.y, ecdhPriv.d)
-	xx := x.Bytes()
-
-	size := (s.curve.Params().BitSize + 7) >> 3
-	pad := make([]byte, size-len(xx))
-	dh := append(pad, xx...)
-
-	return dh, nil
+	return ecdhSharedSecret(ecdhPriv, ecdhPub)
 }
 
 func (s ecdhScheme) PublicKeySize() int {
@@ -369,14 +500,74 @@ func (priv x25519PrivateKey) PublicKey() KEMPublicKey {
 	return pub
 }
 
+func (priv *x25519PrivateKey) Zeroize() {
+	wipe(priv.val[:])
+}
+
+// Equal reports whether other is the same private key, in constant time.
+func (priv *x25519PrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*x25519PrivateKey)
+	return ok && subtle.ConstantTimeCompare(priv.val[:], o.val[:]) == 1
+}
+
 type x25519PublicKey struct {
 	val [32]byte
 }
 
-type x25519Scheme struct {
-	skE KEMPrivateKey
+// Equal reports whether other is the same public key, in constant time.
+func (pub *x25519PublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*x25519PublicKey)
+	return ok && subtle.ConstantTimeCompare(pub.val[:], o.val[:]) == 1
+}
+
+func (priv *x25519PrivateKey) kemID() KEMID {
+	return DHKEM_X25519
+}
+
+func (priv *x25519PrivateKey) MarshalBinary() ([]byte, error) {
+	return marshalPrivateKey(priv)
+}
+
+func (priv *x25519PrivateKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPrivateKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*x25519PrivateKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*priv = *decoded
+	return nil
+}
+
+func (pub *x25519PublicKey) kemID() KEMID {
+	return DHKEM_X25519
+}
+
+func (pub *x25519PublicKey) MarshalBinary() ([]byte, error) {
+	return marshalPublicKey(pub)
+}
+
+func (pub *x25519PublicKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPublicKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*x25519PublicKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*pub = *decoded
+	return nil
 }
 
+type x25519Scheme struct{}
+
 func (s x25519Scheme) internalKDF() KDFScheme {
 	return hkdfScheme{hash: crypto.SHA256}
 }
@@ -410,12 +601,12 @@ func (s x25519Scheme) SerializePrivateKey(sk KEMPrivateKey) []byte {
 		return nil
 	}
 	raw := sk.(*x25519PrivateKey)
-	return raw.val[:]
+	return append([]byte(nil), raw.val[:]...)
 }
 
 func (s x25519Scheme) DeserializePublicKey(enc []byte) (KEMPublicKey, error) {
 	if len(enc) != 32 {
-		return nil, fmt.Errorf("Error deserializing X25519 public key")
+		return nil, fmt.Errorf("%w: X25519 public key length [%d]", ErrInvalidPublicKey, len(enc))
 	}
 
 	pub := &x25519PublicKey{}
@@ -449,7 +640,11 @@ func (s x25519Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
 	}
 
 	sharedSecret, err := curve25519.X25519(xPriv.val[:], xPub.val[:])
-	return sharedSecret, err
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+
+	return sharedSecret, nil
 }
 
 func (s x25519Scheme) PublicKeySize() int {
@@ -469,18 +664,78 @@ type x448PrivateKey struct {
 
 func (priv x448PrivateKey) PublicKey() KEMPublicKey {
 	pub := &x448PublicKey{}
-	x448.ScalarBaseMult(&pub.val, &priv.val)
+	x448ScalarBaseMult(&pub.val, &priv.val)
 	return pub
 }
 
+func (priv *x448PrivateKey) Zeroize() {
+	wipe(priv.val[:])
+}
+
+// Equal reports whether other is the same private key, in constant time.
+func (priv *x448PrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*x448PrivateKey)
+	return ok && subtle.ConstantTimeCompare(priv.val[:], o.val[:]) == 1
+}
+
 type x448PublicKey struct {
 	val [56]byte
 }
 
-type x448Scheme struct {
-	skE KEMPrivateKey
+// Equal reports whether other is the same public key, in constant time.
+func (pub *x448PublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*x448PublicKey)
+	return ok && subtle.ConstantTimeCompare(pub.val[:], o.val[:]) == 1
+}
+
+func (priv *x448PrivateKey) kemID() KEMID {
+	return DHKEM_X448
+}
+
+func (priv *x448PrivateKey) MarshalBinary() ([]byte, error) {
+	return marshalPrivateKey(priv)
+}
+
+func (priv *x448PrivateKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPrivateKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*x448PrivateKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*priv = *decoded
+	return nil
+}
+
+func (pub *x448PublicKey) kemID() KEMID {
+	return DHKEM_X448
+}
+
+func (pub *x448PublicKey) MarshalBinary() ([]byte, error) {
+	return marshalPublicKey(pub)
 }
 
+func (pub *x448PublicKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPublicKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*x448PublicKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*pub = *decoded
+	return nil
+}
+
+type x448Scheme struct{}
+
 func (s x448Scheme) internalKDF() KDFScheme {
 	return hkdfScheme{hash: crypto.SHA512}
 }
@@ -514,12 +769,12 @@ func (s x448Scheme) SerializePrivateKey(sk KEMPrivateKey) []byte {
 		return nil
 	}
 	raw := sk.(*x448PrivateKey)
-	return raw.val[:]
+	return append([]byte(nil), raw.val[:]...)
 }
 
 func (s x448Scheme) DeserializePublicKey(enc []byte) (KEMPublicKey, error) {
 	if len(enc) != 56 {
-		return nil, fmt.Errorf("Error deserializing X448 public key")
+		return nil, fmt.Errorf("%w: X448 public key length [%d]", ErrInvalidPublicKey, len(enc))
 	}
 
 	pub := &x448PublicKey{}
@@ -553,9 +808,9 @@ func (s x448Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
 	}
 
 	var sharedSecret, zero [56]byte
-	x448.ScalarMult(&sharedSecret, &xPriv.val, &xPub.val)
+	x448ScalarMult(&sharedSecret, &xPriv.val, &xPub.val)
 	if subtle.ConstantTimeCompare(sharedSecret[:], zero[:]) == 1 {
-		return nil, fmt.Errorf("bad input point: low order point")
+		return nil, fmt.Errorf("%w: low order point", ErrInvalidPublicKey)
 	}
 
 	return sharedSecret[:], nil
@@ -569,175 +824,6 @@ func (s x448Scheme) PrivateKeySize() int {
 	return 56
 }
 
-///////
-// SIKE
-
-type sikePublicKey struct {
-	field uint8
-	pub   *sidh.PublicKey
-}
-
-type sikePrivateKey struct {
-	field uint8
-	priv  *sidh.PrivateKey
-	pub   *sidh.PublicKey
-}
-
-func (priv sikePrivateKey) PublicKey() KEMPublicKey {
-	return &sikePublicKey{priv.field, priv.pub}
-}
-
-type sikeScheme struct {
-	field uint8
-	KDF   KDFScheme
-}
-
-func (s sikeScheme) internalKDF() KDFScheme {
-	return s.KDF
-}
-
-func (s sikeScheme) ID() KEMID {
-	switch s.field {
-	case sidh.Fp503:
-		return KEM_SIKE503
-	case sidh.Fp751:
-		return KEM_SIKE751
-	}
-	panic(fmt.Sprintf("Unsupported field: %d", s.field))
-}
-
-func (s sikeScheme) generateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
-	err := rawPriv.Generate(rand)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
-	rawPriv.GeneratePublicKey(rawPub)
-
-	priv := &sikePrivateKey{s.field, rawPriv, rawPub}
-	return priv, priv.PublicKey(), nil
-}
-
-func (s sikeScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
-	// Note: DeriveKeyPair is not specified for SIKE, so we just use IKM to
-	// seed a DRBG, and then re-use the other APIs for generating key pairs
-	// from randomness.
-	var seed int64
-	ikmReader := bytes.NewReader(ikm)
-	if err := binary.Read(ikmReader, binary.BigEndian, &seed); err != nil {
-		return nil, nil, fmt.Errorf("Error deriving key pair")
-	}
-
-	source := mrand.NewSource(seed)
-	return s.generateKeyPair(mrand.New(source))
-}
-
-func (s sikeScheme) SerializePublicKey(pk KEMPublicKey) []byte {
-	if pk == nil {
-		return nil
-	}
-	raw := pk.(*sikePublicKey)
-	out := make([]byte, raw.pub.Size())
-	raw.pub.Export(out)
-	return out
-}
-
-func (s sikeScheme) SerializePrivateKey(sk KEMPrivateKey) []byte {
-	panic("Not implemented")
-	return nil
-}
-
-func (s sikeScheme) DeserializePublicKey(enc []byte) (KEMPublicKey, error) {
-	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
-	if len(enc) != rawPub.Size() {
-		return nil, fmt.Errorf("Invalid public key size: got %d, expected %d", len(enc), rawPub.Size())
-	}
-
-	err := rawPub.Import(enc)
-	if err != nil {
-		return nil, err
-	}
-
-	return &sikePublicKey{s.field, rawPub}, nil
-}
-
-func (s sikeScheme) DeserializePrivateKey(enc []byte) (KEMPrivateKey, error) {
-	panic("Not implemented")
-	return nil, nil
-}
-
-func (s sikeScheme) newKEM(rand io.Reader) (*sidh.KEM, error) {
-	switch s.field {
-	case sidh.Fp503:
-		return sidh.NewSike503(rand), nil
-	case sidh.Fp751:
-		return sidh.NewSike751(rand), nil
-	}
-	return nil, fmt.Errorf("Invalid field")
-}
-
-func (s sikeScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
-	raw := pkR.(*sikePublicKey)
-
-	kem, err := s.newKEM(rand)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	enc := make([]byte, kem.CiphertextSize())
-	sharedSecret := make([]byte, s.KDF.OutputSize())
-	err = kem.Encapsulate(enc, sharedSecret, raw.pub)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	return sharedSecret, enc, nil
-}
-
-type panicReader struct{}
-
-func (p panicReader) Read(unused []byte) (int, error) {
-	panic("Should not read")
-}
-
-func (s sikeScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
-	raw := skR.(*sikePrivateKey)
-
-	kem, err := s.newKEM(panicReader{})
-	if err != nil {
-		return nil, err
-	}
-
-	sharedSecret := make([]byte, s.KDF.OutputSize())
-	err = kem.Decapsulate(sharedSecret, raw.priv, raw.pub, enc)
-	if err != nil {
-		return nil, err
-	}
-
-	return sharedSecret, nil
-}
-
-func (s sikeScheme) PublicKeySize() int {
-	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
-	return rawPub.Size()
-}
-
-func (s sikeScheme) PrivateKeySize() int {
-	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
-	err := rawPriv.Generate(rand.Reader)
-	if err != nil {
-		panic("PrivateKeySize failed")
-	}
-
-	return rawPriv.Size()
-}
-
-func (s sikeScheme) setEphemeralKeyPair(skE KEMPrivateKey) {
-	panic("SIKE cannot use a pre-set ephemeral key pair")
-}
-
 //////////
 // AES-GCM
 
@@ -787,8 +873,28 @@ func (s chachaPolyScheme) ID() AEADID {
 	return AEAD_CHACHA20POLY1305
 }
 
+// chachaPolyPool holds the instances released by contexts, which New re-keys
+// instead of allocating.
+var chachaPolyPool = sync.Pool{
+	New: func() interface{} { return new(chachaPolyAEAD) },
+}
+
 func (s chachaPolyScheme) New(key []byte) (cipher.AEAD, error) {
-	return chacha20poly1305.New(key)
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("Incorrect key size %d != %d", len(key), chacha20poly1305.KeySize)
+	}
+
+	aead := chachaPolyPool.Get().(*chachaPolyAEAD)
+	copy(aead.key[:], key)
+	return aead, nil
+}
+
+// Release wipes the key of an instance returned by New and pools it.
+func (s chachaPolyScheme) Release(aead cipher.AEAD) {
+	if c, ok := aead.(*chachaPolyAEAD); ok {
+		wipe(c.key[:])
+		chachaPolyPool.Put(c)
+	}
 }
 
 func (s chachaPolyScheme) KeySize() int {
@@ -799,6 +905,31 @@ func (s chachaPolyScheme) NonceSize() int {
 	return chacha20poly1305.NonceSize
 }
 
+// chachaPolyAEAD is a ChaCha20-Poly1305 instance that can be re-keyed.  The
+// key is its only state, and chacha20poly1305.New merely copies it, so Seal
+// and Open construct the underlying instance on the stack for each call.
+type chachaPolyAEAD struct {
+	key [chacha20poly1305.KeySize]byte
+}
+
+func (c *chachaPolyAEAD) NonceSize() int {
+	return chacha20poly1305.NonceSize
+}
+
+func (c *chachaPolyAEAD) Overhead() int {
+	return 16
+}
+
+func (c *chachaPolyAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	aead, _ := chacha20poly1305.New(c.key[:])
+	return aead.Seal(dst, nonce, plaintext, additionalData)
+}
+
+func (c *chachaPolyAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(c.key[:])
+	return aead.Open(dst, nonce, ciphertext, additionalData)
+}
+
 //////////
 // Export-only AEAD scheme
 
@@ -821,6 +952,106 @@ func (s exportOnlyScheme) NonceSize() int {
 	panic("Not supported")
 }
 
+//////////
+// Key-committing AEAD wrapper
+//
+// The committing wrapper splits the AEAD key into an encryption key and a
+// commitment value, and prefixes each ciphertext with the commitment.  A
+// ciphertext will thus only open under the key that produced it, which
+// prevents key-commitment attacks in multi-recipient settings.
+
+const (
+	commitmentSize    = 32
+	commitEncLabel    = "HPKE-commit enc"
+	commitCommitLabel = "HPKE-commit key"
+)
+
+type committingScheme struct {
+	id    AEADID
+	inner AEADScheme
+}
+
+func (s committingScheme) ID() AEADID {
+	return s.id
+}
+
+func (s committingScheme) New(key []byte) (cipher.AEAD, error) {
+	if len(key) != s.KeySize() {
+		return nil, fmt.Errorf("Incorrect key size %d != %d", len(key), s.KeySize())
+	}
+
+	kdf := hkdfScheme{hash: crypto.SHA256}
+	encKey := kdf.Expand(key, []byte(commitEncLabel), s.inner.KeySize())
+	commitment := kdf.Expand(key, []byte(commitCommitLabel), commitmentSize)
+
+	aead, err := s.inner.New(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &committingAEAD{aead: aead, commitment: commitment}, nil
+}
+
+func (s committingScheme) KeySize() int {
+	return s.inner.KeySize()
+}
+
+func (s committingScheme) NonceSize() int {
+	return s.inner.NonceSize()
+}
+
+type committingAEAD struct {
+	aead       cipher.AEAD
+	commitment []byte
+}
+
+func (c *committingAEAD) NonceSize() int {
+	return c.aead.NonceSize()
+}
+
+func (c *committingAEAD) Overhead() int {
+	return commitmentSize + c.aead.Overhead()
+}
+
+// Seal writes the commitment ahead of the ciphertext.  So that sealing in
+// place (with dst equal to plaintext[:0]) works as cipher.AEAD requires, the
+// plaintext is first moved up past the commitment and then sealed in place.
+func (c *committingAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	var ret []byte
+	if n := len(dst) + commitmentSize + len(plaintext); cap(dst) >= n {
+		ret = dst[:n]
+	} else {
+		ret = append(make([]byte, 0, n+c.aead.Overhead()), dst...)[:n]
+	}
+
+	out := ret[len(dst):]
+	copy(out[commitmentSize:], plaintext)
+	copy(out, c.commitment)
+
+	inner := out[commitmentSize:]
+	return c.aead.Seal(ret[:len(dst)+commitmentSize], nonce, inner, additionalData)
+}
+
+func (c *committingAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < c.Overhead() {
+		return nil, fmt.Errorf("Ciphertext too short")
+	}
+
+	if subtle.ConstantTimeCompare(ciphertext[:commitmentSize], c.commitment) != 1 {
+		return nil, fmt.Errorf("Key commitment mismatch")
+	}
+
+	// Opening in place (with dst equal to ciphertext[:0]) would pass the inner
+	// AEAD buffers that overlap inexactly, so the inner ciphertext is first
+	// moved down to line up with dst.
+	inner := ciphertext[commitmentSize:]
+	if spare := dst[len(dst):cap(dst)]; len(spare) > 0 && &spare[0] == &ciphertext[0] {
+		inner = ciphertext[:copy(ciphertext, inner)]
+	}
+
+	return c.aead.Open(dst, nonce, inner, additionalData)
+}
+
 ///////
 // HKDF
 
@@ -846,42 +1077,73 @@ func (s hkdfScheme) Hash(message []byte) []byte {
 	return h.Sum(nil)
 }
 
+// zeroSalt supplies the all-zero salt used when none is provided, for hashes
+// up to 64 bytes long.  HMAC does not retain its key, so it can be shared.
+var zeroSalt [64]byte
+
 func (s hkdfScheme) Extract(salt, ikm []byte) []byte {
-	saltOrZero := salt
+	return s.extract(salt, ikm)
+}
 
+// extract computes HKDF-Extract over the concatenation of the parts of ikm,
+// feeding them to HMAC one by one rather than concatenating them first.
+func (s hkdfScheme) extract(salt []byte, ikm ...[]byte) []byte {
 	// if [salt is] not provided, it is set to a string of HashLen zeros
 	if salt == nil {
-		saltOrZero = make([]byte, s.hash.Size())
+		salt = zeroSalt[:s.hash.Size()]
 	}
 
-	h := hmac.New(s.hash.New, saltOrZero)
-	h.Write(ikm)
+	h := hmac.New(s.hash.New, salt)
+	for _, part := range ikm {
+		h.Write(part)
+	}
 	return h.Sum(nil)
 }
 
 func (s hkdfScheme) Expand(prk, info []byte, outLen int) []byte {
-	out := []byte{}
-	T := []byte{}
-	i := byte(1)
+	return s.expand(prk, outLen, info)
+}
+
+// expand computes HKDF-Expand with the concatenation of the parts of info.
+// It keys HMAC once, resetting it for each block, and writes the blocks
+// straight into the output buffer.
+func (s hkdfScheme) expand(prk []byte, outLen int, info ...[]byte) []byte {
+	hashLen := s.hash.Size()
+	out := make([]byte, 0, (outLen+hashLen-1)/hashLen*hashLen)
+
+	h := hmac.New(s.hash.New, prk)
+	var T []byte
+	var counter [1]byte
 	for len(out) < outLen {
-		block := append(T, info...)
-		block = append(block, i)
+		counter[0]++
 
-		h := hmac.New(s.hash.New, prk)
-		h.Write(block)
+		h.Reset()
+		h.Write(T)
+		for _, part := range info {
+			h.Write(part)
+		}
+		h.Write(counter[:])
 
-		T = h.Sum(nil)
-		out = append(out, T...)
-		i++
+		out = h.Sum(out)
+		T = out[len(out)-hashLen:]
 	}
-	return out[:outLen]
+	return out[:outLen:outLen]
+}
+
+// labelPrefix builds the part of a labeled input that precedes the caller's
+// input, in a single allocation:
+//
+//	[I2OSP(L, 2) ||] "HPKE-v1" || suite_id || label
+func labelPrefix(length []byte, suiteID []byte, label string) []byte {
+	prefix := make([]byte, 0, len(length)+len(versionLabel)+len(suiteID)+len(label))
+	prefix = append(prefix, length...)
+	prefix = append(prefix, versionLabel...)
+	prefix = append(prefix, suiteID...)
+	return append(prefix, label...)
 }
 
 func (s hkdfScheme) LabeledExtract(salt []byte, suiteID []byte, label string, ikm []byte) []byte {
-	labeledIKM := append([]byte(versionLabel), suiteID...)
-	labeledIKM = append(labeledIKM, []byte(label)...)
-	labeledIKM = append(labeledIKM, ikm...)
-	return s.Extract(salt, labeledIKM)
+	return s.extract(salt, cachedLabelPrefix(suiteID, label, -1), ikm)
 }
 
 func (s hkdfScheme) LabeledExpand(prk []byte, suiteID []byte, label string, info []byte, L int) []byte {
@@ -889,14 +1151,7 @@ func (s hkdfScheme) LabeledExpand(prk []byte, suiteID []byte, label string, info
 		panic("Expand length cannot be larger than 2^16")
 	}
 
-	lengthBuffer := make([]byte, 2)
-	binary.BigEndian.PutUint16(lengthBuffer, uint16(L))
-	labeledLength := append(lengthBuffer, []byte(versionLabel)...)
-	labeledInfo := append(labeledLength, suiteID...)
-	labeledInfo = append(labeledInfo, []byte(label)...)
-	labeledInfo = append(labeledInfo, info...)
-
-	return s.Expand(prk, labeledInfo, L)
+	return s.expand(prk, L, cachedLabelPrefix(suiteID, label, L), info)
 }
 
 func (s hkdfScheme) OutputSize() int {
@@ -917,32 +1172,11 @@ const (
 	KEM_SIKE751  KEMID = 0xFFFF
 )
 
+// The registries below hold the algorithms available in every build.  Unless
+// the hpke_minimal build tag is set, the remaining algorithms are registered
+// in crypto_full.go and crypto_sike.go.
 var kems = map[KEMID]KEMScheme{
-	DHKEM_X25519: &dhkemScheme{group: x25519Scheme{}},
-	DHKEM_X448:   &dhkemScheme{group: x448Scheme{}},
-	DHKEM_P256:   &dhkemScheme{group: ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}},
-	DHKEM_P521:   &dhkemScheme{group: ecdhScheme{curve: elliptic.P521(), KDF: hkdfScheme{hash: crypto.SHA512}}},
-	KEM_SIKE503:  &sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}},
-	KEM_SIKE751:  &sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}},
-}
-
-func newKEMScheme(kemID KEMID) (KEMScheme, bool) {
-	switch kemID {
-	case DHKEM_X25519:
-		return &dhkemScheme{group: x25519Scheme{}}, true
-	case DHKEM_X448:
-		return &dhkemScheme{group: x448Scheme{}}, true
-	case DHKEM_P256:
-		return &dhkemScheme{group: ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}}, true
-	case DHKEM_P521:
-		return &dhkemScheme{group: ecdhScheme{curve: elliptic.P521(), KDF: hkdfScheme{hash: crypto.SHA512}}}, true
-	case KEM_SIKE503:
-		return &sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}}, true
-	case KEM_SIKE751:
-		return &sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}}, true
-	default:
-		return nil, false
-	}
+	DHKEM_X25519: dhkemScheme{group: x25519Scheme{}},
 }
 
 ///////////////////////////
@@ -958,8 +1192,6 @@ const (
 
 var kdfs = map[KDFID]KDFScheme{
 	KDF_HKDF_SHA256: hkdfScheme{hash: crypto.SHA256},
-	KDF_HKDF_SHA384: hkdfScheme{hash: crypto.SHA384},
-	KDF_HKDF_SHA512: hkdfScheme{hash: crypto.SHA512},
 }
 
 ///////////////////////////
@@ -972,29 +1204,43 @@ const (
 	AEAD_AESGCM256        AEADID = 0x0002
 	AEAD_CHACHA20POLY1305 AEADID = 0x0003
 	AEAD_EXPORT_ONLY      AEADID = 0xFFFF
+
+	// Key-committing variants of the above AEADs.  These use private-use
+	// identifiers and will not interoperate with other implementations.
+	AEAD_AESGCM128_COMMIT        AEADID = 0xFF01
+	AEAD_AESGCM256_COMMIT        AEADID = 0xFF02
+	AEAD_CHACHA20POLY1305_COMMIT AEADID = 0xFF03
 )
 
 var aeads = map[AEADID]AEADScheme{
-	AEAD_AESGCM128:        aesgcmScheme{keySize: 16},
-	AEAD_AESGCM256:        aesgcmScheme{keySize: 32},
 	AEAD_CHACHA20POLY1305: chachaPolyScheme{},
 	AEAD_EXPORT_ONLY:      exportOnlyScheme{},
+
+	AEAD_CHACHA20POLY1305_COMMIT: committingScheme{id: AEAD_CHACHA20POLY1305_COMMIT, inner: chachaPolyScheme{}},
 }
 
 func AssembleCipherSuite(kemID KEMID, kdfID KDFID, aeadID AEADID) (CipherSuite, error) {
-	kem, ok := newKEMScheme(kemID)
+	if err := checkFIPS(kemID, kdfID, aeadID); err != nil {
+		return CipherSuite{}, err
+	}
+
+	if err := checkPolicy(kemID, kdfID, aeadID); err != nil {
+		return CipherSuite{}, err
+	}
+
+	kem, ok := kems[kemID]
 	if !ok {
-		return CipherSuite{}, fmt.Errorf("Unknown KEM id")
+		return CipherSuite{}, fmt.Errorf("%w: Unknown KEM id [%s]", ErrUnsupportedSuite, kemID)
 	}
 
 	kdf, ok := kdfs[kdfID]
 	if !ok {
-		return CipherSuite{}, fmt.Errorf("Unknown KDF id")
+		return CipherSuite{}, fmt.Errorf("%w: Unknown KDF id [%s]", ErrUnsupportedSuite, kdfID)
 	}
 
 	aead, ok := aeads[aeadID]
 	if !ok {
-		return CipherSuite{}, fmt.Errorf("Unknown AEAD id")
+		return CipherSuite{}, fmt.Errorf("%w: Unknown AEAD id [%s]", ErrUnsupportedSuite, aeadID)
 	}
 
 	return CipherSuite{
@@ -1004,11 +1250,33 @@ func AssembleCipherSuite(kemID KEMID, kdfID KDFID, aeadID AEADID) (CipherSuite,
 	}, nil
 }
 
+// lookupKEM returns the KEM registered under kemID.  Key parsers use it, since
+// a key may name a KEM that this build does not provide.
+func lookupKEM(kemID KEMID) (KEMScheme, error) {
+	kem, ok := kems[kemID]
+	if !ok {
+		return nil, fmt.Errorf("%w: Unknown KEM id [%s]", ErrUnsupportedSuite, kemID)
+	}
+	return kem, nil
+}
+
 //////////
 // Helpers
 
+// kemSuiteFromID returns the memoized suite_id used within a KEM, i.e.,
+// "KEM" followed by the KEM identifier.
 func kemSuiteFromID(id KEMID) []byte {
-	idBuffer := make([]byte, 2)
-	binary.BigEndian.PutUint16(idBuffer, uint16(id))
-	return append([]byte("KEM"), idBuffer...)
+	labelCache.RLock()
+	suiteID, ok := labelCache.kems[id]
+	labelCache.RUnlock()
+	if ok {
+		return suiteID
+	}
+
+	suiteID = appendUint16(append(make([]byte, 0, 5), "KEM"...), uint16(id))
+
+	labelCache.Lock()
+	labelCache.kems[id] = suiteID
+	labelCache.Unlock()
+	return suiteID
 }
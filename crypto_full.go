@@ -0,0 +1,41 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
+package hpke
+
+import (
+	"crypto"
+	"crypto/elliptic"
+
+	_ "crypto/sha512"
+
+	"github.com/cloudflare/circl/dh/x448"
+)
+
+// The full profile adds the NIST curves, X448, the SHA-2 KDFs with larger
+// hashes, and AES-GCM to the algorithms in crypto.go.
+func init() {
+	kems[DHKEM_X448] = dhkemScheme{group: x448Scheme{}}
+	kems[DHKEM_P256] = dhkemScheme{group: ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}}
+	kems[DHKEM_P521] = dhkemScheme{group: ecdhScheme{curve: elliptic.P521(), KDF: hkdfScheme{hash: crypto.SHA512}}}
+
+	kdfs[KDF_HKDF_SHA384] = hkdfScheme{hash: crypto.SHA384}
+	kdfs[KDF_HKDF_SHA512] = hkdfScheme{hash: crypto.SHA512}
+
+	aeads[AEAD_AESGCM128] = aesgcmScheme{keySize: 16}
+	aeads[AEAD_AESGCM256] = aesgcmScheme{keySize: 32}
+	aeads[AEAD_AESGCM128_COMMIT] = committingScheme{id: AEAD_AESGCM128_COMMIT, inner: aesgcmScheme{keySize: 16}}
+	aeads[AEAD_AESGCM256_COMMIT] = committingScheme{id: AEAD_AESGCM256_COMMIT, inner: aesgcmScheme{keySize: 32}}
+}
+
+// X448 uses CIRCL, whose field arithmetic is implemented in assembly on amd64
+// (with BMI2 and ADX, where available) and in 64-bit Go elsewhere.  Its
+// low-order point check is redundant with the one in x448Scheme.DH.
+
+func x448ScalarBaseMult(dst, scalar *[56]byte) {
+	x448.KeyGen((*x448.Key)(dst), (*x448.Key)(scalar))
+}
+
+func x448ScalarMult(dst, scalar, point *[56]byte) {
+	x448.Shared((*x448.Key)(dst), (*x448.Key)(scalar), (*x448.Key)(point))
+}
@@ -0,0 +1,19 @@
+//go:build hpke_minimal
+// +build hpke_minimal
+
+package hpke
+
+// The hpke_minimal build tag restricts the package to DHKEM(X25519,
+// HKDF-SHA256), HKDF-SHA256, and ChaCha20Poly1305 (plus the export-only AEAD),
+// dropping the dependency on CIRCL and the larger SHA-2 hashes.  This keeps
+// binaries small enough for TinyGo and microcontroller targets.
+//
+// X448 is not registered in this profile, so these are never called.
+
+func x448ScalarBaseMult(dst, scalar *[56]byte) {
+	panic("X448 is not available with the hpke_minimal build tag")
+}
+
+func x448ScalarMult(dst, scalar, point *[56]byte) {
+	panic("X448 is not available with the hpke_minimal build tag")
+}
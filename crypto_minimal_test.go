@@ -0,0 +1,31 @@
+//go:build hpke_minimal
+// +build hpke_minimal
+
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+const minimalProfile = true
+
+func TestMinimalProfile(t *testing.T) {
+	suite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+	fatalOnError(t, err, "Error looking up ciphersuite")
+
+	assert(t, suite, "Incorrect KEMs", len(kems) == 1)
+	assert(t, suite, "Incorrect KDFs", len(kdfs) == 1)
+	assert(t, suite, "AES-GCM available", aeads[AEAD_AESGCM128] == nil)
+
+	_, err = AssembleCipherSuite(DHKEM_X448, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+	assert(t, suite, "X448 available", err != nil)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ct, err := Seal(suite, rand.Reader, pkR, info, aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	pt, err := Open(suite, skR, enc, info, aad, ct)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", original, pt)
+}
@@ -0,0 +1,272 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
+package hpke
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	mrand "math/rand"
+
+	"github.com/cloudflare/circl/dh/sidh"
+)
+
+func init() {
+	kems[KEM_SIKE503] = sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}}
+	kems[KEM_SIKE751] = sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}}
+}
+
+///////
+// SIKE
+
+type sikePublicKey struct {
+	field uint8
+	pub   *sidh.PublicKey
+}
+
+type sikePrivateKey struct {
+	field uint8
+	priv  *sidh.PrivateKey
+	pub   *sidh.PublicKey
+}
+
+func (priv sikePrivateKey) PublicKey() KEMPublicKey {
+	return &sikePublicKey{priv.field, priv.pub}
+}
+
+// Equal reports whether other is the same public key, in constant time.
+func (pub *sikePublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*sikePublicKey)
+	if !ok || pub.field != o.field {
+		return false
+	}
+
+	lhs := make([]byte, pub.pub.Size())
+	rhs := make([]byte, o.pub.Size())
+	pub.pub.Export(lhs)
+	o.pub.Export(rhs)
+	return subtle.ConstantTimeCompare(lhs, rhs) == 1
+}
+
+// Equal reports whether other is the same private key, in constant time.  The
+// sidh package does not expose the private scalar, so SIKE private keys are
+// compared by their public keys, which they determine uniquely.
+func (priv *sikePrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*sikePrivateKey)
+	return ok && priv.PublicKey().(*sikePublicKey).Equal(o.PublicKey())
+}
+
+// Zeroize drops the reference to the SIDH private key.  The sidh package does
+// not expose its internal scalar, so it cannot be wiped in place.
+func (priv *sikePrivateKey) Zeroize() {
+	priv.priv = nil
+}
+
+func (priv *sikePrivateKey) kemID() KEMID {
+	return sikeScheme{field: priv.field}.ID()
+}
+
+func (priv *sikePrivateKey) MarshalBinary() ([]byte, error) {
+	return marshalPrivateKey(priv)
+}
+
+func (priv *sikePrivateKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPrivateKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*sikePrivateKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*priv = *decoded
+	return nil
+}
+
+func (pub *sikePublicKey) kemID() KEMID {
+	return sikeScheme{field: pub.field}.ID()
+}
+
+func (pub *sikePublicKey) MarshalBinary() ([]byte, error) {
+	return marshalPublicKey(pub)
+}
+
+func (pub *sikePublicKey) UnmarshalBinary(data []byte) error {
+	_, key, err := UnmarshalPublicKey(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, ok := key.(*sikePublicKey)
+	if !ok {
+		return fmt.Errorf("Key type mismatch [%T]", key)
+	}
+
+	*pub = *decoded
+	return nil
+}
+
+type sikeScheme struct {
+	field uint8
+	KDF   KDFScheme
+}
+
+func (s sikeScheme) internalKDF() KDFScheme {
+	return s.KDF
+}
+
+func (s sikeScheme) ID() KEMID {
+	switch s.field {
+	case sidh.Fp503:
+		return KEM_SIKE503
+	case sidh.Fp751:
+		return KEM_SIKE751
+	}
+	panic(fmt.Sprintf("Unsupported field: %d", s.field))
+}
+
+func (s sikeScheme) generateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
+	err := rawPriv.Generate(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	rawPriv.GeneratePublicKey(rawPub)
+
+	priv := &sikePrivateKey{s.field, rawPriv, rawPub}
+	return priv, priv.PublicKey(), nil
+}
+
+func (s sikeScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	// Note: DeriveKeyPair is not specified for SIKE, so we just use IKM to
+	// seed a DRBG, and then re-use the other APIs for generating key pairs
+	// from randomness.
+	var seed int64
+	ikmReader := bytes.NewReader(ikm)
+	if err := binary.Read(ikmReader, binary.BigEndian, &seed); err != nil {
+		return nil, nil, fmt.Errorf("Error deriving key pair")
+	}
+
+	source := mrand.NewSource(seed)
+	sk, pk, err := s.generateKeyPair(mrand.New(source))
+	auditKeyGeneration(s, pk, err)
+	return sk, pk, err
+}
+
+func (s sikeScheme) SerializePublicKey(pk KEMPublicKey) []byte {
+	if pk == nil {
+		return nil
+	}
+	raw := pk.(*sikePublicKey)
+	out := make([]byte, raw.pub.Size())
+	raw.pub.Export(out)
+	return out
+}
+
+func (s sikeScheme) SerializePrivateKey(sk KEMPrivateKey) []byte {
+	panic("Not implemented")
+	return nil
+}
+
+func (s sikeScheme) DeserializePublicKey(enc []byte) (KEMPublicKey, error) {
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	if len(enc) != rawPub.Size() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidPublicKey, len(enc), rawPub.Size())
+	}
+
+	err := rawPub.Import(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sikePublicKey{s.field, rawPub}, nil
+}
+
+func (s sikeScheme) DeserializePrivateKey(enc []byte) (KEMPrivateKey, error) {
+	panic("Not implemented")
+	return nil, nil
+}
+
+func (s sikeScheme) newKEM(rand io.Reader) (*sidh.KEM, error) {
+	switch s.field {
+	case sidh.Fp503:
+		return sidh.NewSike503(rand), nil
+	case sidh.Fp751:
+		return sidh.NewSike751(rand), nil
+	}
+	return nil, fmt.Errorf("Invalid field")
+}
+
+func (s sikeScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw := pkR.(*sikePublicKey)
+
+	kem, err := s.newKEM(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc := make([]byte, kem.CiphertextSize())
+	sharedSecret := make([]byte, s.KDF.OutputSize())
+	err = kem.Encapsulate(enc, sharedSecret, raw.pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sharedSecret, enc, nil
+}
+
+type panicReader struct{}
+
+func (p panicReader) Read(unused []byte) (int, error) {
+	panic("Should not read")
+}
+
+func (s sikeScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw := skR.(*sikePrivateKey)
+
+	kem, err := s.newKEM(panicReader{})
+	if err != nil {
+		return nil, err
+	}
+
+	sharedSecret := make([]byte, s.KDF.OutputSize())
+	err = kem.Decapsulate(sharedSecret, raw.priv, raw.pub, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return sharedSecret, nil
+}
+
+func (s sikeScheme) PublicKeySize() int {
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	return rawPub.Size()
+}
+
+func (s sikeScheme) EncapsulatedKeySize() int {
+	kem, err := s.newKEM(rand.Reader)
+	if err != nil {
+		panic("EncapsulatedKeySize failed")
+	}
+
+	return kem.CiphertextSize()
+}
+
+func (s sikeScheme) PrivateKeySize() int {
+	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
+	err := rawPriv.Generate(rand.Reader)
+	if err != nil {
+		panic("PrivateKeySize failed")
+	}
+
+	return rawPriv.Size()
+}
@@ -1,3 +1,6 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
 package hpke
 
 import (
@@ -5,6 +8,7 @@ import (
 	"crypto"
 	"crypto/elliptic"
 	"crypto/rand"
+	"math/big"
 	"testing"
 
 	"github.com/cloudflare/circl/dh/sidh"
@@ -12,20 +16,18 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func randomBytes(size int) []byte {
-	out := make([]byte, size)
-	rand.Read(out)
-	return out
-}
+// minimalProfile reports whether the tests run under the hpke_minimal build
+// tag.
+const minimalProfile = false
 
 func TestKEMSchemes(t *testing.T) {
 	schemes := []KEMScheme{
-		&dhkemScheme{group: x25519Scheme{}},
-		&dhkemScheme{group: x448Scheme{}},
-		&dhkemScheme{group: ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}},
-		&dhkemScheme{group: ecdhScheme{curve: elliptic.P521(), KDF: hkdfScheme{hash: crypto.SHA256}}},
-		&sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}},
-		&sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}},
+		dhkemScheme{group: x25519Scheme{}},
+		dhkemScheme{group: x448Scheme{}},
+		dhkemScheme{group: ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}},
+		dhkemScheme{group: ecdhScheme{curve: elliptic.P521(), KDF: hkdfScheme{hash: crypto.SHA256}}},
+		sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}},
+		sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}},
 	}
 
 	for i, s := range schemes {
@@ -53,6 +55,45 @@ func TestKEMSchemes(t *testing.T) {
 	}
 }
 
+func TestKeyEqual(t *testing.T) {
+	type publicKeyEqual interface {
+		Equal(other KEMPublicKey) bool
+	}
+	type privateKeyEqual interface {
+		Equal(other KEMPrivateKey) bool
+	}
+
+	var lastSK KEMPrivateKey
+	var lastPK KEMPublicKey
+	for kemID, s := range kems {
+		ikm := randomBytes(s.PrivateKeySize())
+		sk1, pk1, err := s.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error deriving key pair")
+
+		sk2, pk2, err := s.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error deriving key pair")
+
+		sk3, pk3, err := s.DeriveKeyPair(randomBytes(s.PrivateKeySize()))
+		require.Nil(t, err, "Error deriving key pair")
+
+		pkEq, ok := pk1.(publicKeyEqual)
+		require.True(t, ok, "Public key does not implement Equal [%s]", kemID)
+		require.True(t, pkEq.Equal(pk2), "Equal public keys compared unequal [%s]", kemID)
+		require.True(t, !pkEq.Equal(pk3), "Different public keys compared equal [%s]", kemID)
+
+		skEq, ok := sk1.(privateKeyEqual)
+		require.True(t, ok, "Private key does not implement Equal [%s]", kemID)
+		require.True(t, skEq.Equal(sk2), "Equal private keys compared unequal [%s]", kemID)
+		require.True(t, !skEq.Equal(sk3), "Different private keys compared equal [%s]", kemID)
+
+		if lastPK != nil {
+			require.True(t, !pkEq.Equal(lastPK), "Public keys for different KEMs compared equal [%s]", kemID)
+			require.True(t, !skEq.Equal(lastSK), "Private keys for different KEMs compared equal [%s]", kemID)
+		}
+		lastSK, lastPK = sk1, pk1
+	}
+}
+
 func TestDHSchemes(t *testing.T) {
 	schemes := []dhScheme{
 		ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}},
@@ -101,11 +142,202 @@ func TestDHSchemes(t *testing.T) {
 	}
 }
 
+func TestECDHConstantTime(t *testing.T) {
+	if !nistecAvailable {
+		t.Skip("crypto/ecdh not available")
+	}
+
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P521()} {
+		s := ecdhScheme{curve: curve, KDF: hkdfScheme{hash: crypto.SHA512}}
+		size := s.PrivateKeySize()
+
+		// Scalars outside [1, n) are rejected
+		_, err := s.DeserializePrivateKey(make([]byte, size))
+		require.NotNil(t, err, "Zero scalar accepted")
+		_, err = s.DeserializePrivateKey(curve.Params().N.Bytes())
+		require.NotNil(t, err, "Scalar equal to the order accepted")
+
+		// Results agree with the generic big.Int implementation
+		ikm := make([]byte, size)
+		rand.Reader.Read(ikm)
+		skA, _, err := s.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error generating DH key pair")
+		rand.Reader.Read(ikm)
+		_, pkB, err := s.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error generating DH key pair")
+
+		a := skA.(*ecdhPrivateKey)
+		require.NotNil(t, a.key, "Missing crypto/ecdh private key")
+		x, y := curve.Params().ScalarBaseMult(a.d)
+		require.Equal(t, elliptic.Marshal(curve, x, y), a.pub.point, "Incorrect public key")
+
+		b := pkB.(*ecdhPublicKey)
+		require.NotNil(t, b.key, "Missing crypto/ecdh public key")
+		x, y = elliptic.Unmarshal(curve, b.point)
+		x, _ = curve.Params().ScalarMult(x, y, a.d)
+		xx := x.Bytes()
+		expected := append(make([]byte, size-len(xx)), xx...)
+
+		dh, err := s.DH(skA, pkB)
+		require.Nil(t, err, "Error performing DH operation")
+		require.Equal(t, expected, dh, "Incorrect DH result")
+
+		a.Zeroize()
+		_, err = s.DH(skA, pkB)
+		require.NotNil(t, err, "DH succeeded with a zeroized key")
+	}
+}
+
+func TestPrivateKeyConstantTime(t *testing.T) {
+	// The range check accepts exactly [1, n-1]
+	n := elliptic.P256().Params().N
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	for _, c := range []struct {
+		d  *big.Int
+		ok bool
+	}{
+		{big.NewInt(0), false},
+		{big.NewInt(1), true},
+		{new(big.Int).Sub(n, big.NewInt(1)), true},
+		{n, false},
+		{new(big.Int).Add(n, big.NewInt(1)), false},
+		{max, false},
+	} {
+		db := c.d.Bytes()
+		d := append(make([]byte, 32-len(db)), db...)
+		require.Equal(t, c.ok, scalarInRange(d, n), "Incorrect range check for %x", d)
+	}
+
+	// Serialized private keys have a fixed length and do not alias the key
+	for _, kem := range []KEMScheme{
+		dhkemScheme{group: ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}},
+		dhkemScheme{group: x25519Scheme{}},
+		dhkemScheme{group: x448Scheme{}},
+	} {
+		ikm := make([]byte, kem.PrivateKeySize())
+		rand.Reader.Read(ikm)
+		sk, _, err := kem.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error deriving key pair")
+
+		skm := kem.SerializePrivateKey(sk)
+		require.Equal(t, kem.PrivateKeySize(), len(skm), "Incorrect private key length")
+		wipe(skm)
+
+		sk2, err := kem.DeserializePrivateKey(kem.SerializePrivateKey(sk))
+		require.Nil(t, err, "Error deserializing private key")
+		require.True(t, sk.(interface{ Equal(KEMPrivateKey) bool }).Equal(sk2), "Key modified through its serialization")
+	}
+
+	// Short NIST scalars are padded
+	s := ecdhScheme{curve: elliptic.P256(), KDF: hkdfScheme{hash: crypto.SHA256}}
+	sk, err := s.DeserializePrivateKey([]byte{0x01})
+	require.Nil(t, err, "Error deserializing short scalar")
+	require.Equal(t, append(make([]byte, 31), 0x01), s.SerializePrivateKey(sk), "Short scalar not padded")
+}
+
+func TestX448(t *testing.T) {
+	// RFC 7748, Section 6.2
+	skA := mustUnhex(t, "9a8f4925d1519f5775cf46b04b5800d4ee9ee8bae8bc5565d498c28dd9c9baf574a9419744897391006382a6f127ab1d9ac2d8c0a598726b")
+	pkA := mustUnhex(t, "9b08f7cc31b7e3e67d22d5aea121074a273bd2b83de09c63faa73d2c22c5d9bbc836647241d953d40c5b12da88120d53177f80e532c41fa0")
+	skB := mustUnhex(t, "1c306a7ac2a0e2e0990b294470cba339e6453772b075811d8fad0d1d6927c120bb5ee8972b0d3e21374c9c921b09d1b0366f10b65173992d")
+	pkB := mustUnhex(t, "3eb7a829b0cd20f5bcfc0b599b6feccf6da4627107bdb0d4f345b43027d8b972fc3e34fb4232a13ca706dcb57aec3dae07bdc1c67bf33609")
+	shared := mustUnhex(t, "07fff4181ac6cc95ec1c16a94a0f74d12da232ce40a77552281d282bb60c0b56fd2464c335543936521c24403085d59a449a5037514a879d")
+
+	s := x448Scheme{}
+	for _, c := range []struct{ sk, pk, peer []byte }{{skA, pkA, pkB}, {skB, pkB, pkA}} {
+		sk, err := s.DeserializePrivateKey(c.sk)
+		require.Nil(t, err, "Error deserializing private key")
+		require.Equal(t, c.pk, s.SerializePublicKey(sk.PublicKey()), "Incorrect public key")
+
+		peer, err := s.DeserializePublicKey(c.peer)
+		require.Nil(t, err, "Error deserializing public key")
+		dh, err := s.DH(sk, peer)
+		require.Nil(t, err, "Error performing DH operation")
+		require.Equal(t, shared, dh, "Incorrect shared secret")
+	}
+
+	// The all-zero output of a low-order point is rejected
+	sk, _ := s.DeserializePrivateKey(skA)
+	zero, _ := s.DeserializePublicKey(make([]byte, 56))
+	_, err := s.DH(sk, zero)
+	require.NotNil(t, err, "Low-order point accepted")
+}
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869, test cases 1 and 3
+	kdf := hkdfScheme{hash: crypto.SHA256}
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	cases := []struct {
+		salt, info, prk, okm string
+	}{
+		{
+			"000102030405060708090a0b0c",
+			"f0f1f2f3f4f5f6f7f8f9",
+			"077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+			"3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			"",
+			"",
+			"19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+			"8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+	}
+
+	for _, c := range cases {
+		prk := kdf.Extract(mustUnhex(t, c.salt), ikm)
+		require.Equal(t, mustUnhex(t, c.prk), prk, "Incorrect PRK")
+
+		okm := kdf.Expand(prk, mustUnhex(t, c.info), 42)
+		require.Equal(t, mustUnhex(t, c.okm), okm, "Incorrect OKM")
+	}
+
+	// A nil salt is a string of zeros, as is an empty one
+	require.Equal(t, mustUnhex(t, cases[1].prk), kdf.Extract(nil, ikm), "Incorrect PRK for nil salt")
+
+	// Labeled functions are the unlabeled ones over the labeled inputs
+	suiteID := []byte("HPKE\x00\x20\x00\x01\x00\x01")
+	labeledIKM := append(append([]byte("HPKE-v1"), suiteID...), "secret"...)
+	require.Equal(t, kdf.Extract(nil, append(labeledIKM, ikm...)), kdf.LabeledExtract(nil, suiteID, "secret", ikm), "Incorrect LabeledExtract")
+
+	labeledInfo := append(append([]byte{0x00, 0x2a, 'H', 'P', 'K', 'E', '-', 'v', '1'}, suiteID...), "key"...)
+	require.Equal(t, kdf.Expand(ikm, append(labeledInfo, 0x01), 42), kdf.LabeledExpand(ikm, suiteID, "key", []byte{0x01}, 42), "Incorrect LabeledExpand")
+}
+
+func TestLabelCache(t *testing.T) {
+	suite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	require.Nil(t, err, "Error assembling suite")
+
+	// SuiteID hands out copies, so callers cannot corrupt the cached value
+	suiteID := suite.SuiteID()
+	require.Equal(t, []byte("HPKE\x00\x20\x00\x01\x00\x01"), suiteID, "Incorrect suite ID")
+	suiteID[0] ^= 0xff
+	require.Equal(t, []byte("HPKE\x00\x20\x00\x01\x00\x01"), suite.SuiteID(), "Suite ID modified through copy")
+	require.Equal(t, []byte("KEM\x00\x20"), kemSuiteFromID(DHKEM_X25519), "Incorrect KEM suite ID")
+
+	// Prefixes are shared, and distinguished by output length
+	p1 := cachedLabelPrefix(suite.id(), "key", 16)
+	p2 := cachedLabelPrefix(suite.id(), "key", 16)
+	require.Equal(t, &p1[0], &p2[0], "Prefix not memoized")
+	require.Equal(t, labelPrefix([]byte{0x00, 0x10}, suite.id(), "key"), p1, "Incorrect prefix")
+	require.Equal(t, labelPrefix(nil, suite.id(), "key"), cachedLabelPrefix(suite.id(), "key", -1), "Incorrect extract prefix")
+
+	// Past the bound, prefixes are still correct, just not memoized
+	kdf := suite.KDF
+	for L := 1; L <= maxCachedPrefixes+1; L++ {
+		kdf.LabeledExpand(make([]byte, kdf.OutputSize()), suite.id(), "sec", nil, L)
+	}
+	require.Equal(t, labelPrefix([]byte{0xff, 0xff}, suite.id(), "bound"), cachedLabelPrefix(suite.id(), "bound", 0xffff), "Incorrect prefix past bound")
+}
+
 func TestAEADSchemes(t *testing.T) {
 	schemes := []AEADScheme{
 		aesgcmScheme{keySize: 16},
 		aesgcmScheme{keySize: 32},
 		chachaPolyScheme{},
+		aeads[AEAD_AESGCM128_COMMIT],
+		aeads[AEAD_AESGCM256_COMMIT],
+		aeads[AEAD_CHACHA20POLY1305_COMMIT],
 	}
 
 	for i, s := range schemes {
@@ -145,6 +377,38 @@ func TestAEADSchemes(t *testing.T) {
 	}
 }
 
+func TestCommittingAEADScheme(t *testing.T) {
+	scheme := aeads[AEAD_AESGCM128_COMMIT]
+	nonce := randomBytes(scheme.NonceSize())
+	pt := randomBytes(1024)
+
+	aead, err := scheme.New(randomBytes(scheme.KeySize()))
+	require.Nil(t, err, "Error instantiating AEAD")
+
+	other, err := scheme.New(randomBytes(scheme.KeySize()))
+	require.Nil(t, err, "Error instantiating AEAD")
+
+	ct := aead.Seal(nil, nonce, pt, nil)
+	require.Equal(t, len(ct), len(pt)+aead.Overhead(), "Incorrect ciphertext length")
+
+	_, err = other.Open(nil, nonce, ct, nil)
+	require.NotNil(t, err, "Ciphertext opened under a different key")
+
+	_, err = aead.Open(nil, nonce, ct[:aead.Overhead()-1], nil)
+	require.NotNil(t, err, "Truncated ciphertext was accepted")
+
+	// Sealing in place yields the same ciphertext
+	buf := make([]byte, len(pt), len(pt)+aead.Overhead())
+	copy(buf, pt)
+	inPlace := aead.Seal(buf[:0], nonce, buf, nil)
+	require.Equal(t, ct, inPlace, "In-place sealing produced a different ciphertext")
+
+	// As does opening in place
+	opened, err := aead.Open(inPlace[:0], nonce, inPlace, nil)
+	require.Nil(t, err, "Error opening in place")
+	require.Equal(t, pt, opened, "Incorrect in-place decryption")
+}
+
 func TestExportOnlyAEADScheme(t *testing.T) {
 	scheme, ok := aeads[AEAD_EXPORT_ONLY]
 
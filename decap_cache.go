@@ -0,0 +1,164 @@
+package hpke
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// decapKey identifies a decapsulation by the encapsulated key and, in the
+// authenticated modes, the serialized sender public key.
+type decapKey struct {
+	enc string
+	pkS string
+}
+
+type decapEntry struct {
+	key          decapKey
+	sharedSecret []byte
+}
+
+// DecapCache is an AuthKEMDecapsulator that remembers the shared secrets of
+// its most recent decapsulations, so that a receiver that sees the same
+// encapsulated key more than once, e.g., in a retransmitted first flight,
+// skips the KEM's expensive operations on the repeats.  It may be passed to
+// NewReceiver and the Setup*R functions in place of the private key it wraps.
+//
+// A DecapCache belongs to a single private key.  Entries are keyed by the
+// whole encapsulated key and, for AuthDecap, by the sender public key, so an
+// entry is only ever returned for exactly the inputs that produced it.
+// Failed decapsulations are not cached, so malformed encapsulated keys cannot
+// occupy the cache, and each caller receives its own copy of the shared
+// secret.  Since decapsulation is deterministic, caching does not change what
+// a replayed encapsulated key yields; applications that must reject replays
+// still need to do so themselves.
+//
+// A DecapCache is safe for concurrent use.  It holds shared secrets until
+// they are evicted or the cache is zeroized.
+type DecapCache struct {
+	kem   KEMScheme
+	inner AuthKEMDecapsulator
+	size  int
+
+	mu      sync.Mutex
+	entries map[decapKey]*list.Element
+	lru     *list.List
+}
+
+// NewDecapCache wraps skR, a private key for the given KEM or a
+// KEMDecapsulator, in a cache of up to size shared secrets.  Once the cache
+// is full, the least recently used entry is evicted.
+func NewDecapCache(kem KEMScheme, skR KEMPrivateKey, size int) (*DecapCache, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("Invalid decapsulation cache size [%d]", size)
+	}
+
+	if k, ok := skR.(kemKey); ok && k.kemID() != kem.ID() {
+		return nil, fmt.Errorf("%w: Key is for KEM [%s], not [%s]", ErrUnsupportedSuite, k.kemID(), kem.ID())
+	}
+
+	var inner AuthKEMDecapsulator
+	switch d := skR.(type) {
+	case AuthKEMDecapsulator:
+		inner = d
+	case KEMDecapsulator:
+		inner = decapOnly{d}
+	default:
+		inner = softwareDecapsulator{kem: kem, skR: skR}
+	}
+
+	return &DecapCache{
+		kem:     kem,
+		inner:   inner,
+		size:    size,
+		entries: map[decapKey]*list.Element{},
+		lru:     list.New(),
+	}, nil
+}
+
+func (c *DecapCache) kemID() KEMID {
+	return c.kem.ID()
+}
+
+func (c *DecapCache) PublicKey() KEMPublicKey {
+	return c.inner.PublicKey()
+}
+
+func (c *DecapCache) Decap(enc []byte) ([]byte, error) {
+	return c.decap(decapKey{enc: string(enc)}, func() ([]byte, error) {
+		return c.inner.Decap(enc)
+	})
+}
+
+func (c *DecapCache) AuthDecap(enc []byte, pkS KEMPublicKey) ([]byte, error) {
+	key := decapKey{enc: string(enc), pkS: string(c.kem.SerializePublicKey(pkS))}
+	return c.decap(key, func() ([]byte, error) {
+		return c.inner.AuthDecap(enc, pkS)
+	})
+}
+
+// decap returns a copy of the cached shared secret for key, or computes and
+// caches it.  The KEM operation runs without the lock held, so concurrent
+// misses on the same key may both compute it.
+func (c *DecapCache) decap(key decapKey, compute func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		sharedSecret := append([]byte(nil), elem.Value.(*decapEntry).sharedSecret...)
+		c.mu.Unlock()
+		return sharedSecret, nil
+	}
+	c.mu.Unlock()
+
+	sharedSecret, err := compute()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		if c.lru.Len() >= c.size {
+			c.evict(c.lru.Back())
+		}
+
+		entry := &decapEntry{key: key, sharedSecret: append([]byte(nil), sharedSecret...)}
+		c.entries[key] = c.lru.PushFront(entry)
+	}
+	return sharedSecret, nil
+}
+
+func (c *DecapCache) evict(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*decapEntry)
+	wipe(entry.sharedSecret)
+	delete(c.entries, entry.key)
+}
+
+// Len returns the number of cached shared secrets.
+func (c *DecapCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Zeroize wipes and drops every cached shared secret.  The cache remains
+// usable, and the wrapped private key is not affected.
+func (c *DecapCache) Zeroize() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+}
+
+// decapOnly adapts a KEMDecapsulator without AuthDecap support.
+type decapOnly struct {
+	KEMDecapsulator
+}
+
+func (d decapOnly) AuthDecap(enc []byte, pkS KEMPublicKey) ([]byte, error) {
+	return nil, fmt.Errorf("Receiver key does not support AuthDecap")
+}
@@ -0,0 +1,85 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+// countingDecapsulator counts the decapsulations that reach the KEM.
+type countingDecapsulator struct {
+	AuthKEMDecapsulator
+	calls int
+}
+
+func (d *countingDecapsulator) Decap(enc []byte) ([]byte, error) {
+	d.calls++
+	return d.AuthKEMDecapsulator.Decap(enc)
+}
+
+func (d *countingDecapsulator) AuthDecap(enc []byte, pkS KEMPublicKey) ([]byte, error) {
+	d.calls++
+	return d.AuthKEMDecapsulator.AuthDecap(enc, pkS)
+}
+
+func TestDecapCache(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	counter := &countingDecapsulator{AuthKEMDecapsulator: NewKEMDecapsulator(suite.KEM, skR)}
+	cache, err := NewDecapCache(suite.KEM, counter, 2)
+	fatalOnError(t, err, "Error in NewDecapCache")
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	fatalOnError(t, err, "Error in SetupBaseS")
+	ct, err := ctxS.Seal(aad, original)
+	fatalOnError(t, err, "Error in Seal")
+
+	// A retransmitted enc is decapsulated once, and zeroizing one receiver
+	// context does not disturb the cached secret
+	for i := 0; i < 3; i++ {
+		ctxR, err := SetupBaseR(suite, cache, enc, info)
+		fatalOnError(t, err, "Error in SetupBaseR")
+
+		pt, err := ctxR.Open(aad, ct)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", original, pt)
+		ctxR.Zeroize()
+	}
+	assert(t, suite, "Repeated enc not served from cache", counter.calls == 1)
+
+	// Failures are not cached
+	_, err = SetupBaseR(suite, cache, enc[1:], info)
+	assert(t, suite, "Malformed enc accepted", err != nil)
+	assert(t, suite, "Failed decapsulation cached", cache.Len() == 1)
+
+	// Authenticated decapsulations are keyed by the sender too
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	encA, _, err := SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	fatalOnError(t, err, "Error in SetupAuthS")
+	_, err = SetupAuthR(suite, cache, pkS, encA, info)
+	fatalOnError(t, err, "Error in SetupAuthR")
+
+	_, pkOther, _ := mustGenerateKeyPair(t, suite)
+	calls := counter.calls
+	_, err = SetupAuthR(suite, cache, pkOther, encA, info)
+	fatalOnError(t, err, "Error in SetupAuthR")
+	assert(t, suite, "Cached secret reused for another sender", counter.calls == calls+1)
+
+	// The least recently used entry is evicted, and Zeroize empties the cache
+	assert(t, suite, "Cache not bounded", cache.Len() == 2)
+	calls = counter.calls
+	_, err = SetupBaseR(suite, cache, enc, info)
+	fatalOnError(t, err, "Error in SetupBaseR")
+	assert(t, suite, "Evicted entry served from cache", counter.calls == calls+1)
+
+	cache.Zeroize()
+	assert(t, suite, "Cache not emptied by Zeroize", cache.Len() == 0)
+
+	_, err = NewDecapCache(suite.KEM, skR, 0)
+	assert(t, suite, "Zero cache size accepted", err != nil)
+
+	if otherKEM, ok := kems[DHKEM_P256]; ok {
+		_, err = NewDecapCache(otherKEM, skR, 1)
+		assert(t, suite, "Key for another KEM accepted", err != nil)
+	}
+}
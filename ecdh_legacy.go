@@ -0,0 +1,47 @@
+//go:build !go1.20
+// +build !go1.20
+
+package hpke
+
+import (
+	"crypto/elliptic"
+)
+
+// Toolchains before Go 1.20 have no crypto/ecdh, so the NIST curves use the
+// crypto/elliptic implementations, which are constant-time for P-256 and
+// P-521 from Go 1.19.  Keys hold only their encodings.
+const nistecAvailable = false
+
+type (
+	nistecPrivateKey struct{}
+	nistecPublicKey  struct{}
+)
+
+// newECDHPrivateKey returns the private key for the scalar d, which must be
+// Nsk bytes long and is retained by the key.
+func newECDHPrivateKey(curve elliptic.Curve, d []byte) (*ecdhPrivateKey, error) {
+	x, y := curve.ScalarBaseMult(d)
+	pub := &ecdhPublicKey{curve: curve, point: elliptic.Marshal(curve, x, y)}
+	return &ecdhPrivateKey{curve: curve, d: d, pub: pub}, nil
+}
+
+// newECDHPublicKey returns the public key with the uncompressed encoding enc,
+// failing if it is not a point on the curve.
+func newECDHPublicKey(curve elliptic.Curve, enc []byte) (*ecdhPublicKey, error) {
+	x, y := elliptic.Unmarshal(curve, enc)
+	if x == nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return &ecdhPublicKey{curve: curve, point: elliptic.Marshal(curve, x, y)}, nil
+}
+
+func ecdhSharedSecret(priv *ecdhPrivateKey, pub *ecdhPublicKey) ([]byte, error) {
+	x, y := elliptic.Unmarshal(pub.curve, pub.point)
+	x, _ = priv.curve.ScalarMult(x, y, priv.d)
+	xx := x.Bytes()
+
+	size := (priv.curve.Params().BitSize + 7) >> 3
+	pad := make([]byte, size-len(xx))
+	return append(pad, xx...), nil
+}
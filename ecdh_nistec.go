@@ -0,0 +1,77 @@
+//go:build go1.20
+// +build go1.20
+
+package hpke
+
+import (
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"fmt"
+)
+
+// nistecAvailable reports whether the NIST curve operations below are
+// implemented.
+const nistecAvailable = true
+
+// The NIST curve operations use crypto/ecdh, which implements P-256 and
+// P-521 in constant time, without big.Int scalar arithmetic.  It is also
+// what BoringCrypto and the Go Cryptographic Module replace with validated
+// code.  Keys hold their crypto/ecdh form, so that the DH operation does not
+// convert them.
+type (
+	nistecPrivateKey = *ecdh.PrivateKey
+	nistecPublicKey  = *ecdh.PublicKey
+)
+
+func nistecCurve(curve elliptic.Curve) (ecdh.Curve, error) {
+	switch curve {
+	case elliptic.P256():
+		return ecdh.P256(), nil
+	case elliptic.P521():
+		return ecdh.P521(), nil
+	default:
+		return nil, fmt.Errorf("%w: Unsupported curve [%s]", ErrUnsupportedSuite, curve.Params().Name)
+	}
+}
+
+// newECDHPrivateKey returns the private key for the scalar d, which must be
+// Nsk bytes long and is retained by the key.
+func newECDHPrivateKey(curve elliptic.Curve, d []byte) (*ecdhPrivateKey, error) {
+	c, err := nistecCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err := c.NewPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := sk.PublicKey()
+	pub := &ecdhPublicKey{curve: curve, point: pk.Bytes(), key: pk}
+	return &ecdhPrivateKey{curve: curve, d: d, key: sk, pub: pub}, nil
+}
+
+// newECDHPublicKey returns the public key with the uncompressed encoding enc,
+// failing if it is not a point on the curve.
+func newECDHPublicKey(curve elliptic.Curve, enc []byte) (*ecdhPublicKey, error) {
+	c, err := nistecCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := c.NewPublicKey(enc)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return &ecdhPublicKey{curve: curve, point: pk.Bytes(), key: pk}, nil
+}
+
+func ecdhSharedSecret(priv *ecdhPrivateKey, pub *ecdhPublicKey) ([]byte, error) {
+	if priv.key == nil {
+		return nil, fmt.Errorf("Private key has been zeroized")
+	}
+
+	return priv.key.ECDH(pub.key)
+}
@@ -0,0 +1,338 @@
+// Package ech implements the HPKE-related parts of TLS Encrypted Client Hello
+// (draft-ietf-tls-esni): parsing and constructing ECHConfig and ECHConfigList
+// structures, selecting an HPKE suite from a configuration, and setting up
+// HPKE contexts with the info string that ECH requires.
+package ech
+
+import (
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Version is the ECHConfig version implemented by this package.
+const Version uint16 = 0xfe0d
+
+// infoLabel is prepended to the serialized ECHConfig to form the HPKE info
+// string.
+const infoLabel = "tls ech\x00"
+
+// SymmetricCipherSuite is a KDF and AEAD pair that a server supports for a
+// given key.
+type SymmetricCipherSuite struct {
+	KDFID  hpke.KDFID
+	AEADID hpke.AEADID
+}
+
+// KeyConfig describes the server's HPKE public key and the symmetric suites
+// it can be used with.
+type KeyConfig struct {
+	ConfigID     uint8
+	KEMID        hpke.KEMID
+	PublicKey    []byte                 `tls:"head=2"`
+	CipherSuites []SymmetricCipherSuite `tls:"head=2"`
+}
+
+// Extension is an ECHConfig extension.  Extensions whose type has the high
+// bit set are mandatory; clients must ignore configurations containing
+// mandatory extensions that they do not understand.
+type Extension struct {
+	Type uint16
+	Data []byte `tls:"head=2"`
+}
+
+// Mandatory reports whether the extension is mandatory.
+func (ext Extension) Mandatory() bool {
+	return ext.Type&0x8000 != 0
+}
+
+// Config is an ECHConfig with version Version.
+type Config struct {
+	KeyConfig         KeyConfig
+	MaximumNameLength uint8
+	PublicName        []byte      `tls:"head=1"`
+	Extensions        []Extension `tls:"head=2"`
+}
+
+// wireConfig is the versioned framing of an ECHConfig, which allows
+// configurations with unknown versions to be skipped.
+type wireConfig struct {
+	Version  uint16
+	Contents []byte `tls:"head=2"`
+}
+
+// wireConfigList represents an ECHConfigList encoded on the wire.
+type wireConfigList struct {
+	Configs []wireConfig `tls:"head=2"`
+}
+
+// NewConfig constructs a configuration for the public key pkR, usable with
+// each of the given symmetric suites.
+func NewConfig(configID uint8, kem hpke.KEMScheme, pkR hpke.KEMPublicKey, publicName string, suites ...SymmetricCipherSuite) (Config, error) {
+	config := Config{
+		KeyConfig: KeyConfig{
+			ConfigID:     configID,
+			KEMID:        kem.ID(),
+			PublicKey:    kem.SerializePublicKey(pkR),
+			CipherSuites: suites,
+		},
+		PublicName: []byte(publicName),
+	}
+
+	if err := config.validate(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func (config Config) validate() error {
+	if len(config.KeyConfig.PublicKey) == 0 {
+		return fmt.Errorf("Empty ECH public key")
+	}
+
+	if len(config.KeyConfig.CipherSuites) == 0 {
+		return fmt.Errorf("No ECH cipher suites")
+	}
+
+	if len(config.PublicName) == 0 || len(config.PublicName) > 255 {
+		return fmt.Errorf("Invalid ECH public name length [%d]", len(config.PublicName))
+	}
+
+	return nil
+}
+
+// Marshal returns the serialized ECHConfig, including its version and length.
+func (config Config) Marshal() ([]byte, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	contents, err := syntax.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return syntax.Marshal(wireConfig{Version: Version, Contents: contents})
+}
+
+// Info returns the HPKE info string for setups using this configuration,
+// "tls ech" || 0x00 || ECHConfig.
+func (config Config) Info() ([]byte, error) {
+	data, err := config.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(infoLabel), data...), nil
+}
+
+// supported reports whether the configuration can be used by this package,
+// i.e., it contains no mandatory extensions.
+func (config Config) supported() bool {
+	for _, ext := range config.Extensions {
+		if ext.Mandatory() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Suite selects the first of the configuration's cipher suites that this
+// package supports, in the server's order of preference.
+func (config Config) Suite() (hpke.CipherSuite, error) {
+	for _, cs := range config.KeyConfig.CipherSuites {
+		if cs.AEADID == hpke.AEAD_EXPORT_ONLY {
+			continue
+		}
+
+		suite, err := hpke.AssembleCipherSuite(config.KeyConfig.KEMID, cs.KDFID, cs.AEADID)
+		if err == nil {
+			return suite, nil
+		}
+	}
+
+	return hpke.CipherSuite{}, fmt.Errorf("%w: No supported ECH cipher suite [%d]", hpke.ErrUnsupportedSuite, config.KeyConfig.ConfigID)
+}
+
+// hasSuite reports whether the configuration lists the given symmetric suite.
+func (config Config) hasSuite(kdfID hpke.KDFID, aeadID hpke.AEADID) bool {
+	for _, cs := range config.KeyConfig.CipherSuites {
+		if cs.KDFID == kdfID && cs.AEADID == aeadID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseConfig parses a single serialized ECHConfig, including its version and
+// length.
+func ParseConfig(data []byte) (Config, error) {
+	var wire wireConfig
+	read, err := syntax.Unmarshal(data, &wire)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if read != len(data) {
+		return Config{}, fmt.Errorf("Trailing data after ECHConfig")
+	}
+
+	if wire.Version != Version {
+		return Config{}, fmt.Errorf("Unsupported ECHConfig version [%04x]", wire.Version)
+	}
+
+	return parseContents(wire.Contents)
+}
+
+func parseContents(contents []byte) (Config, error) {
+	var config Config
+	read, err := syntax.Unmarshal(contents, &config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if read != len(contents) {
+		return Config{}, fmt.Errorf("Trailing data after ECHConfigContents")
+	}
+
+	if err := config.validate(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// ParseConfigList parses an ECHConfigList, as published in DNS.  As required
+// by the specification, configurations with an unknown version or with
+// mandatory extensions are skipped; it is an error if none remain.
+func ParseConfigList(data []byte) ([]Config, error) {
+	var list wireConfigList
+	read, err := syntax.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	if read != len(data) {
+		return nil, fmt.Errorf("Trailing data after ECHConfigList")
+	}
+
+	if len(list.Configs) == 0 {
+		return nil, fmt.Errorf("Empty ECHConfigList")
+	}
+
+	configs := make([]Config, 0, len(list.Configs))
+	for _, wire := range list.Configs {
+		if wire.Version != Version {
+			continue
+		}
+
+		config, err := parseContents(wire.Contents)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.supported() {
+			configs = append(configs, config)
+		}
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("No supported ECHConfig in list")
+	}
+
+	return configs, nil
+}
+
+// MarshalConfigList serializes a list of configurations as an ECHConfigList.
+func MarshalConfigList(configs []Config) ([]byte, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("Empty ECHConfigList")
+	}
+
+	list := wireConfigList{Configs: make([]wireConfig, len(configs))}
+	for i, config := range configs {
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+
+		contents, err := syntax.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+
+		list.Configs[i] = wireConfig{Version: Version, Contents: contents}
+	}
+
+	return syntax.Marshal(list)
+}
+
+// SelectConfig returns the first configuration in the list that has a cipher
+// suite supported by this package, along with that suite.
+func SelectConfig(configs []Config) (Config, hpke.CipherSuite, error) {
+	for _, config := range configs {
+		if !config.supported() {
+			continue
+		}
+
+		suite, err := config.Suite()
+		if err == nil {
+			return config, suite, nil
+		}
+	}
+
+	return Config{}, hpke.CipherSuite{}, fmt.Errorf("%w: No usable ECHConfig", hpke.ErrUnsupportedSuite)
+}
+
+// SetupSender selects a suite from the configuration and sets up a sender
+// context to the configuration's public key, bound to the configuration as
+// ECH requires.  The client sends the returned suite's KDF and AEAD IDs, the
+// configuration ID, and the encapsulated key in its ECH extension.
+func SetupSender(config Config, rand io.Reader) ([]byte, *hpke.SenderContext, hpke.CipherSuite, error) {
+	suite, err := config.Suite()
+	if err != nil {
+		return nil, nil, hpke.CipherSuite{}, err
+	}
+
+	pkR, err := suite.KEM.DeserializePublicKey(config.KeyConfig.PublicKey)
+	if err != nil {
+		return nil, nil, hpke.CipherSuite{}, err
+	}
+
+	info, err := config.Info()
+	if err != nil {
+		return nil, nil, hpke.CipherSuite{}, err
+	}
+
+	enc, ctx, err := hpke.SetupBaseS(suite, rand, pkR, info)
+	if err != nil {
+		return nil, nil, hpke.CipherSuite{}, err
+	}
+
+	return enc, ctx, suite, nil
+}
+
+// SetupReceiver sets up the server's receiver context for a ClientHello that
+// offered the given configuration with the given KDF and AEAD.  The suite must
+// be one of those listed in the configuration.
+func SetupReceiver(config Config, kdfID hpke.KDFID, aeadID hpke.AEADID, skR hpke.KEMPrivateKey, enc []byte) (*hpke.ReceiverContext, error) {
+	if !config.hasSuite(kdfID, aeadID) || aeadID == hpke.AEAD_EXPORT_ONLY {
+		return nil, fmt.Errorf("%w: Suite not offered in ECHConfig [%s, %s]", hpke.ErrUnsupportedSuite, kdfID, aeadID)
+	}
+
+	suite, err := hpke.AssembleCipherSuite(config.KeyConfig.KEMID, kdfID, aeadID)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := config.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return hpke.SetupBaseR(suite, skR, enc, info)
+}
@@ -0,0 +1,144 @@
+package ech
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	publicName = "example.com"
+	helloInner = []byte("ClientHelloInner")
+	helloOuter = []byte("ClientHelloOuterAAD")
+
+	// An ECHConfigList with one configuration: config_id 0x2a, X25519 with a
+	// dummy public key, HKDF-SHA256 with AES-128-GCM, and no extensions.
+	fixedConfigList = "003e" + "fe0d003a" + "2a" + "0020" +
+		"0020" + "1111111111111111111111111111111111111111111111111111111111111111" +
+		"000400010001" + "00" + "0b6578616d706c652e636f6d" + "0000"
+)
+
+func newTestConfig(t *testing.T, configID uint8, suites ...SymmetricCipherSuite) (Config, hpke.KEMPrivateKey) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	config, err := NewConfig(configID, suite.KEM, pkR, publicName, suites...)
+	require.Nil(t, err, "Error in NewConfig")
+	return config, skR
+}
+
+func TestParseConfigList(t *testing.T) {
+	data, err := hex.DecodeString(fixedConfigList)
+	require.Nil(t, err, "Error decoding test vector")
+
+	configs, err := ParseConfigList(data)
+	require.Nil(t, err, "Error in ParseConfigList")
+	require.Equal(t, 1, len(configs), "Incorrect number of configs")
+
+	config := configs[0]
+	require.Equal(t, uint8(0x2a), config.KeyConfig.ConfigID, "Incorrect config ID")
+	require.Equal(t, hpke.DHKEM_X25519, config.KeyConfig.KEMID, "Incorrect KEM ID")
+	require.Equal(t, []SymmetricCipherSuite{{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128}}, config.KeyConfig.CipherSuites, "Incorrect cipher suites")
+	require.Equal(t, publicName, string(config.PublicName), "Incorrect public name")
+
+	encoded, err := MarshalConfigList(configs)
+	require.Nil(t, err, "Error in MarshalConfigList")
+	require.Equal(t, data, encoded, "ECHConfigList did not round-trip")
+
+	single, err := config.Marshal()
+	require.Nil(t, err, "Error in Marshal")
+	require.Equal(t, data[2:], single, "Incorrect ECHConfig encoding")
+
+	parsed, err := ParseConfig(single)
+	require.Nil(t, err, "Error in ParseConfig")
+	require.Equal(t, config, parsed, "ECHConfig did not round-trip")
+
+	_, err = ParseConfigList(data[:len(data)-1])
+	require.NotNil(t, err, "Truncated ECHConfigList accepted")
+
+	_, err = ParseConfigList(append(data, 0x00))
+	require.NotNil(t, err, "ECHConfigList with trailing data accepted")
+}
+
+func TestParseConfigListSkipsUnsupported(t *testing.T) {
+	suites := []SymmetricCipherSuite{{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128}}
+	mandatory, _ := newTestConfig(t, 1, suites...)
+	mandatory.Extensions = []Extension{{Type: 0xfe01, Data: []byte{0x00}}}
+
+	optional, _ := newTestConfig(t, 2, suites...)
+	optional.Extensions = []Extension{{Type: 0x0001, Data: []byte{0x00}}}
+
+	data, err := MarshalConfigList([]Config{mandatory, optional})
+	require.Nil(t, err, "Error in MarshalConfigList")
+
+	// Prepend a configuration with an unknown version
+	unknown := []byte{0xff, 0xff, 0x00, 0x02, 0xab, 0xcd}
+	listLen := len(data) - 2 + len(unknown)
+	data = append([]byte{byte(listLen >> 8), byte(listLen)}, append(unknown, data[2:]...)...)
+
+	configs, err := ParseConfigList(data)
+	require.Nil(t, err, "Error in ParseConfigList")
+	require.Equal(t, 1, len(configs), "Unsupported configs not skipped")
+	require.Equal(t, uint8(2), configs[0].KeyConfig.ConfigID, "Incorrect config selected")
+
+	data, err = MarshalConfigList([]Config{mandatory})
+	require.Nil(t, err, "Error in MarshalConfigList")
+
+	_, err = ParseConfigList(data)
+	require.NotNil(t, err, "ECHConfigList without supported configs accepted")
+}
+
+func TestSelectConfig(t *testing.T) {
+	unsupported, _ := newTestConfig(t, 1, SymmetricCipherSuite{0xfffe, hpke.AEAD_AESGCM128})
+	supported, _ := newTestConfig(t, 2,
+		SymmetricCipherSuite{hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY},
+		SymmetricCipherSuite{hpke.KDF_HKDF_SHA384, hpke.AEAD_CHACHA20POLY1305},
+		SymmetricCipherSuite{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128})
+
+	config, suite, err := SelectConfig([]Config{unsupported, supported})
+	require.Nil(t, err, "Error in SelectConfig")
+	require.Equal(t, uint8(2), config.KeyConfig.ConfigID, "Incorrect config selected")
+	require.Equal(t, hpke.KDF_HKDF_SHA384, suite.KDF.ID(), "Incorrect KDF selected")
+	require.Equal(t, hpke.AEAD_CHACHA20POLY1305, suite.AEAD.ID(), "Incorrect AEAD selected")
+
+	_, _, err = SelectConfig([]Config{unsupported})
+	require.NotNil(t, err, "Config without supported suites selected")
+}
+
+func TestSetup(t *testing.T) {
+	config, skR := newTestConfig(t, 7, SymmetricCipherSuite{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128})
+
+	enc, ctxS, suite, err := SetupSender(config, rand.Reader)
+	require.Nil(t, err, "Error in SetupSender")
+
+	ct, err := ctxS.Seal(helloOuter, helloInner)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err := SetupReceiver(config, suite.KDF.ID(), suite.AEAD.ID(), skR, enc)
+	require.Nil(t, err, "Error in SetupReceiver")
+
+	pt, err := ctxR.Open(helloOuter, ct)
+	require.Nil(t, err, "Error in Open")
+	require.True(t, bytes.Equal(pt, helloInner), "Incorrect decryption")
+
+	// The context is bound to the exact configuration
+	other := config
+	other.MaximumNameLength = 32
+	ctxR, err = SetupReceiver(other, suite.KDF.ID(), suite.AEAD.ID(), skR, enc)
+	require.Nil(t, err, "Error in SetupReceiver")
+
+	_, err = ctxR.Open(helloOuter, ct)
+	require.NotNil(t, err, "Open succeeded with a different ECHConfig")
+
+	_, err = SetupReceiver(config, hpke.KDF_HKDF_SHA512, hpke.AEAD_AESGCM128, skR, enc)
+	require.NotNil(t, err, "SetupReceiver accepted a suite not in the ECHConfig")
+}
@@ -0,0 +1,65 @@
+package ech
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SvcParamKeyECH is the SvcParamKey of the "ech" parameter in DNS HTTPS and
+// SVCB records.
+const SvcParamKeyECH uint16 = 5
+
+// ErrNoECHParam is returned when the SvcParams of a record do not include an
+// "ech" parameter, i.e., when the service does not offer ECH.
+var ErrNoECHParam = errors.New("No ech SvcParam in record")
+
+// ConfigsFromSvcParams extracts the "ech" parameter from the SvcParams of a
+// DNS HTTPS or SVCB record, in the wire format of RFC 9460, and parses it
+// with ParseConfigList.  The SvcParams must be well-formed, with keys in
+// strictly increasing order.
+func ConfigsFromSvcParams(params []byte) ([]Config, error) {
+	var value []byte
+	found := false
+	haveKey := false
+	lastKey := uint16(0)
+	for len(params) > 0 {
+		if len(params) < 4 {
+			return nil, fmt.Errorf("Truncated SvcParam")
+		}
+
+		key := binary.BigEndian.Uint16(params)
+		length := int(binary.BigEndian.Uint16(params[2:]))
+		if len(params)-4 < length {
+			return nil, fmt.Errorf("Truncated SvcParam value [%d]", key)
+		}
+
+		if haveKey && key <= lastKey {
+			return nil, fmt.Errorf("SvcParam keys out of order [%d]", key)
+		}
+		haveKey, lastKey = true, key
+
+		if key == SvcParamKeyECH {
+			value, found = params[4:4+length], true
+		}
+		params = params[4+length:]
+	}
+
+	if !found {
+		return nil, ErrNoECHParam
+	}
+
+	return ParseConfigList(value)
+}
+
+// ConfigsFromSvcParamValue parses the presentation format of an "ech"
+// SvcParam value, i.e., the base64 encoding of an ECHConfigList.
+func ConfigsFromSvcParamValue(value string) ([]Config, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ech SvcParam value: %v", err)
+	}
+
+	return ParseConfigList(data)
+}
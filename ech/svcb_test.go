@@ -0,0 +1,43 @@
+package ech
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// SvcParams with alpn=h2, port=443, and ech=fixedConfigList
+var fixedSvcParams = "0001" + "0003" + "026832" +
+	"0003" + "0002" + "01bb" +
+	"0005" + "0040" + fixedConfigList
+
+func TestConfigsFromSvcParams(t *testing.T) {
+	params, err := hex.DecodeString(fixedSvcParams)
+	require.Nil(t, err, "Error decoding SvcParams")
+
+	configs, err := ConfigsFromSvcParams(params)
+	require.Nil(t, err, "Error in ConfigsFromSvcParams")
+	require.Equal(t, 1, len(configs), "Incorrect number of configs")
+	require.Equal(t, uint8(0x2a), configs[0].KeyConfig.ConfigID, "Incorrect config ID")
+
+	_, err = ConfigsFromSvcParams(params[:len(params)-1])
+	require.NotNil(t, err, "Truncated SvcParams accepted")
+
+	noECH, _ := hex.DecodeString("0001000302" + "6832" + "0003000201bb")
+	_, err = ConfigsFromSvcParams(noECH)
+	require.Equal(t, ErrNoECHParam, err, "Missing ech SvcParam not reported")
+
+	outOfOrder, _ := hex.DecodeString("0003000201bb" + "0001000302" + "6832")
+	_, err = ConfigsFromSvcParams(outOfOrder)
+	require.NotNil(t, err, "Out-of-order SvcParams accepted")
+
+	list, _ := hex.DecodeString(fixedConfigList)
+	configs, err = ConfigsFromSvcParamValue(base64.StdEncoding.EncodeToString(list))
+	require.Nil(t, err, "Error in ConfigsFromSvcParamValue")
+	require.Equal(t, 1, len(configs), "Incorrect number of configs")
+
+	_, err = ConfigsFromSvcParamValue("not base64!")
+	require.NotNil(t, err, "Invalid presentation value accepted")
+}
@@ -0,0 +1,96 @@
+package ech
+
+import (
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// TLSCipherSuites are the symmetric suites that Go's crypto/tls implements
+// for ECH, in its order of preference.
+var TLSCipherSuites = []SymmetricCipherSuite{
+	{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128},
+	{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM256},
+	{hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305},
+}
+
+// TLSServerKey is the server-side key material for one ECH configuration.
+// Its fields correspond to those of crypto/tls.EncryptedClientHelloKey
+// (Go 1.24 and later), so a server can be provisioned with:
+//
+//	tlsConfig.EncryptedClientHelloKeys = append(tlsConfig.EncryptedClientHelloKeys,
+//		tls.EncryptedClientHelloKey{Config: key.Config, PrivateKey: key.PrivateKey, SendAsRetry: key.SendAsRetry})
+type TLSServerKey struct {
+	// Config is the serialized ECHConfig, including its version and length.
+	Config []byte
+
+	// PrivateKey is the serialized X25519 private key for the configuration.
+	PrivateKey []byte
+
+	// SendAsRetry indicates whether the configuration is sent to clients in
+	// retry_configs when they fail to use ECH.
+	SendAsRetry bool
+}
+
+// GenerateTLSKey generates an X25519 key pair and an ECH configuration for
+// it with the given configuration ID and public name, offering
+// TLSCipherSuites.  Go's crypto/tls supports only the X25519 KEM for ECH.
+// The returned key is marked to be sent as a retry configuration.
+func GenerateTLSKey(rand io.Reader, configID uint8, publicName string) (TLSServerKey, error) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305)
+	if err != nil {
+		return TLSServerKey{}, err
+	}
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	if _, err := io.ReadFull(rand, ikm); err != nil {
+		return TLSServerKey{}, err
+	}
+
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	if err != nil {
+		return TLSServerKey{}, err
+	}
+
+	config, err := NewConfig(configID, suite.KEM, pkR, publicName, TLSCipherSuites...)
+	if err != nil {
+		return TLSServerKey{}, err
+	}
+
+	data, err := config.Marshal()
+	if err != nil {
+		return TLSServerKey{}, err
+	}
+
+	key := TLSServerKey{
+		Config:      data,
+		PrivateKey:  suite.KEM.SerializePrivateKey(skR),
+		SendAsRetry: true,
+	}
+	return key, nil
+}
+
+// TLSConfigList serializes the configurations of the given keys as an
+// ECHConfigList, the format of crypto/tls.Config.EncryptedClientHelloConfigList
+// and of the "ech" SvcParam in DNS.
+func TLSConfigList(keys ...TLSServerKey) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Empty ECHConfigList")
+	}
+
+	list := wireConfigList{Configs: make([]wireConfig, len(keys))}
+	for i, key := range keys {
+		read, err := syntax.Unmarshal(key.Config, &list.Configs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if read != len(key.Config) {
+			return nil, fmt.Errorf("Trailing data after ECHConfig")
+		}
+	}
+
+	return syntax.Marshal(list)
+}
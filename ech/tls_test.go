@@ -0,0 +1,55 @@
+package ech
+
+import (
+	"crypto/rand"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSKeys(t *testing.T) {
+	key1, err := GenerateTLSKey(rand.Reader, 1, publicName)
+	require.Nil(t, err, "Error in GenerateTLSKey")
+	require.True(t, key1.SendAsRetry, "Key not sent as retry")
+	require.Equal(t, 32, len(key1.PrivateKey), "Incorrect private key size")
+
+	key2, err := GenerateTLSKey(rand.Reader, 2, publicName)
+	require.Nil(t, err, "Error in GenerateTLSKey")
+
+	data, err := TLSConfigList(key1, key2)
+	require.Nil(t, err, "Error in TLSConfigList")
+	require.Equal(t, len(key1.Config)+len(key2.Config)+2, len(data), "Incorrect ECHConfigList length")
+
+	configs, err := ParseConfigList(data)
+	require.Nil(t, err, "Error in ParseConfigList")
+	require.Equal(t, 2, len(configs), "Incorrect number of configs")
+	require.Equal(t, uint8(2), configs[1].KeyConfig.ConfigID, "Incorrect config ID")
+	require.Equal(t, hpke.DHKEM_X25519, configs[0].KeyConfig.KEMID, "Incorrect KEM ID")
+	require.Equal(t, TLSCipherSuites, configs[0].KeyConfig.CipherSuites, "Incorrect cipher suites")
+
+	// The private key decrypts messages sent to the configuration
+	config, suite, err := SelectConfig(configs)
+	require.Nil(t, err, "Error in SelectConfig")
+
+	enc, ctxS, _, err := SetupSender(config, rand.Reader)
+	require.Nil(t, err, "Error in SetupSender")
+	ct, err := ctxS.Seal(helloOuter, helloInner)
+	require.Nil(t, err, "Error in Seal")
+
+	skR, err := suite.KEM.DeserializePrivateKey(key1.PrivateKey)
+	require.Nil(t, err, "Error deserializing private key")
+
+	ctxR, err := SetupReceiver(config, suite.KDF.ID(), suite.AEAD.ID(), skR, enc)
+	require.Nil(t, err, "Error in SetupReceiver")
+	pt, err := ctxR.Open(helloOuter, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, helloInner, pt, "Incorrect decryption")
+
+	_, err = TLSConfigList()
+	require.NotNil(t, err, "Empty ECHConfigList generated")
+
+	key1.Config = append(key1.Config, 0)
+	_, err = TLSConfigList(key1)
+	require.NotNil(t, err, "Malformed ECHConfig accepted")
+}
@@ -0,0 +1,167 @@
+package hpke
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Recipient identifies one of the recipients of a multi-recipient envelope.
+// The key ID is an application-defined label that allows each recipient to
+// locate its slot in the envelope.
+type Recipient struct {
+	KeyID     []byte
+	PublicKey KEMPublicKey
+}
+
+// envelopeSlot holds the content key wrapped to a single recipient.
+type envelopeSlot struct {
+	KeyID      []byte `tls:"head=1"`
+	Enc        []byte `tls:"head=2"`
+	WrappedKey []byte `tls:"head=2"`
+}
+
+// envelope represents a multi-recipient envelope encoded on the wire.
+type envelope struct {
+	KEMID      KEMID
+	KDFID      KDFID
+	AEADID     AEADID
+	Slots      []envelopeSlot `tls:"head=4"`
+	Ciphertext []byte         `tls:"head=4"`
+}
+
+// SealEnvelope encrypts the plaintext once under a fresh content key, then
+// wraps the content key to each recipient with a single-shot HPKE encryption.
+// The result is a self-contained envelope that any listed recipient can open
+// with OpenEnvelope.
+//
+// Because all recipients share the content key, a malicious sender could
+// construct a ciphertext that decrypts differently for different recipients
+// unless the AEAD is key-committing.  Applications that need all recipients
+// to agree on the plaintext should use one of the AEAD_*_COMMIT suites.
+func SealEnvelope(suite CipherSuite, rand io.Reader, recipients []Recipient, info, aad, pt []byte) ([]byte, error) {
+	if suite.AEAD.ID() == AEAD_EXPORT_ONLY {
+		return nil, fmt.Errorf("Envelope encryption not supported for export-only AEAD")
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("No recipients")
+	}
+
+	contentKey := make([]byte, suite.AEAD.KeySize())
+	if _, err := io.ReadFull(rand, contentKey); err != nil {
+		return nil, err
+	}
+
+	aead, err := suite.AEAD.New(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The content key is used exactly once, so a fixed nonce is safe.
+	nonce := make([]byte, suite.AEAD.NonceSize())
+
+	env := envelope{
+		KEMID:      suite.KEM.ID(),
+		KDFID:      suite.KDF.ID(),
+		AEADID:     suite.AEAD.ID(),
+		Slots:      make([]envelopeSlot, len(recipients)),
+		Ciphertext: aead.Seal(nil, nonce, pt, aad),
+	}
+
+	pkRs := make([]KEMPublicKey, len(recipients))
+	for i, recipient := range recipients {
+		for _, prev := range recipients[:i] {
+			if bytes.Equal(prev.KeyID, recipient.KeyID) {
+				return nil, fmt.Errorf("Duplicate recipient key ID [%x]", recipient.KeyID)
+			}
+		}
+
+		pkRs[i] = recipient.PublicKey
+	}
+
+	encs, ctxs, err := NewSenders(suite, pkRs, WithRandom(rand), WithInfo(info))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, recipient := range recipients {
+		wrappedKey, err := ctxs[i].Seal(recipient.KeyID, contentKey)
+		if err != nil {
+			return nil, err
+		}
+
+		env.Slots[i] = envelopeSlot{
+			KeyID:      recipient.KeyID,
+			Enc:        encs[i],
+			WrappedKey: wrappedKey,
+		}
+	}
+
+	return syntax.Marshal(env)
+}
+
+// parseEnvelope decodes an envelope and assembles the ciphersuite it names.
+func parseEnvelope(opaque []byte) (envelope, CipherSuite, error) {
+	var env envelope
+	read, err := syntax.Unmarshal(opaque, &env)
+	if err != nil {
+		return envelope{}, CipherSuite{}, err
+	}
+
+	if read != len(opaque) {
+		return envelope{}, CipherSuite{}, fmt.Errorf("Trailing data after envelope")
+	}
+
+	suite, err := AssembleCipherSuite(env.KEMID, env.KDFID, env.AEADID)
+	if err != nil {
+		return envelope{}, CipherSuite{}, err
+	}
+
+	if suite.AEAD.ID() == AEAD_EXPORT_ONLY {
+		return envelope{}, CipherSuite{}, fmt.Errorf("Envelope encryption not supported for export-only AEAD")
+	}
+
+	return env, suite, nil
+}
+
+// openEnvelopeSlot unwraps the content key in a slot with skR and decrypts
+// the payload of the envelope.
+func openEnvelopeSlot(suite CipherSuite, env envelope, slot envelopeSlot, skR KEMPrivateKey, info, aad []byte) ([]byte, error) {
+	contentKey, err := Open(suite, skR, slot.Enc, info, slot.KeyID, slot.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := suite.AEAD.New(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, suite.AEAD.NonceSize())
+	pt, err := aead.Open(nil, nonce, env.Ciphertext, aad)
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+
+	return pt, nil
+}
+
+// OpenEnvelope locates the slot for the given key ID in an envelope produced
+// by SealEnvelope, unwraps the content key with skR, and decrypts the payload.
+func OpenEnvelope(keyID []byte, skR KEMPrivateKey, info, aad, opaque []byte) ([]byte, error) {
+	env, suite, err := parseEnvelope(opaque)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, slot := range env.Slots {
+		if bytes.Equal(slot.KeyID, keyID) {
+			return openEnvelopeSlot(suite, env, slot, skR, info, aad)
+		}
+	}
+
+	return nil, fmt.Errorf("No recipient slot for key ID [%x]", keyID)
+}
@@ -0,0 +1,53 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128_COMMIT)
+
+	recipients := make([]Recipient, 3)
+	privateKeys := make([]KEMPrivateKey, len(recipients))
+	for i := range recipients {
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		privateKeys[i] = skR
+		recipients[i] = Recipient{
+			KeyID:     []byte(fmt.Sprintf("recipient-%d", i)),
+			PublicKey: pkR,
+		}
+	}
+
+	env, err := SealEnvelope(suite, rand.Reader, recipients, info, aad, original)
+	assertNotError(t, suite, "Error in SealEnvelope", err)
+
+	for i, recipient := range recipients {
+		decrypted, err := OpenEnvelope(recipient.KeyID, privateKeys[i], info, aad, env)
+		assertNotError(t, suite, "Error in OpenEnvelope", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	_, err = OpenEnvelope(recipients[0].KeyID, privateKeys[1], info, aad, env)
+	assert(t, suite, "Envelope opened with the wrong private key", err != nil)
+
+	_, err = OpenEnvelope([]byte("unknown"), privateKeys[0], info, aad, env)
+	assert(t, suite, "Envelope opened with an unknown key ID", err != nil)
+
+	_, err = OpenEnvelope(recipients[0].KeyID, privateKeys[0], info, nil, env)
+	assert(t, suite, "Envelope opened with incorrect AAD", err != nil)
+}
+
+func TestEnvelopeDuplicateKeyID(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	recipients := []Recipient{
+		{KeyID: []byte("duplicate"), PublicKey: pkR},
+		{KeyID: []byte("duplicate"), PublicKey: pkR},
+	}
+
+	_, err := SealEnvelope(suite, rand.Reader, recipients, info, aad, original)
+	assert(t, suite, "SealEnvelope accepted duplicate key IDs", err != nil)
+}
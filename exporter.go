@@ -0,0 +1,128 @@
+package hpke
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// KeyingMaterialExporter is the exporter interface of
+// crypto/tls.ConnectionState.  Sender and receiver contexts implement it, so
+// that they can be used by code written against TLS exporters.
+type KeyingMaterialExporter interface {
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
+}
+
+var (
+	_ KeyingMaterialExporter = (*SenderContext)(nil)
+	_ KeyingMaterialExporter = (*ReceiverContext)(nil)
+)
+
+// ExportKeyingMaterial derives length bytes from the context's exporter
+// secret, bound to the given label and context.  It is equivalent to Export
+// with the exporter context
+//
+//	uint16 label_length || label || context
+//
+// but, like ExportSecret, returns an error rather than panicking if the
+// context is closed or expired, or the length is out of range.  As in TLS 1.3,
+// a nil context and an empty one produce the same output.
+func (ctx *context) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if len(label) > 0xFFFF {
+		return nil, fmt.Errorf("Exporter label too long [%d]", len(label))
+	}
+
+	exporterContext := make([]byte, 2, 2+len(label)+len(context))
+	binary.BigEndian.PutUint16(exporterContext, uint16(len(label)))
+	exporterContext = append(exporterContext, label...)
+	exporterContext = append(exporterContext, context...)
+	return ctx.ExportSecret(exporterContext, length)
+}
+
+// exportKey identifies an exported secret by its exporter context and
+// length.
+type exportKey struct {
+	context string
+	length  int
+}
+
+// exportCache holds up to size exported secrets, evicting the oldest entry
+// to make room for a new one.
+type exportCache struct {
+	entries map[exportKey][]byte
+	order   []exportKey
+	next    int
+}
+
+func newExportCache(size int) *exportCache {
+	return &exportCache{
+		entries: make(map[exportKey][]byte, size),
+		order:   make([]exportKey, 0, size),
+	}
+}
+
+func (c *exportCache) get(context []byte, length int) ([]byte, bool) {
+	secret, ok := c.entries[exportKey{string(context), length}]
+	return secret, ok
+}
+
+func (c *exportCache) put(context []byte, length int, secret []byte) {
+	key := exportKey{string(context), length}
+	if len(c.order) < cap(c.order) {
+		c.order = append(c.order, key)
+	} else {
+		evicted := c.order[c.next]
+		wipe(c.entries[evicted])
+		delete(c.entries, evicted)
+		c.order[c.next] = key
+		c.next = (c.next + 1) % len(c.order)
+	}
+	c.entries[key] = secret
+}
+
+// clear wipes and drops every cached secret.
+func (c *exportCache) clear() {
+	if c == nil {
+		return
+	}
+
+	for key, secret := range c.entries {
+		wipe(secret)
+		delete(c.entries, key)
+	}
+	c.order = c.order[:0]
+	c.next = 0
+}
+
+// SetExportCache makes Export and ExportKeyingMaterial remember up to size
+// of their results, so that repeated calls with the same exporter context
+// and length, as in protocols that export the same value for every message,
+// return a copy of the earlier output instead of rederiving it.  Once the
+// cache is full, the oldest entry is evicted.  A size of zero, the default,
+// disables caching.
+//
+// Cached secrets are wiped by KeyUpdate and Zeroize.  The cache is not
+// serialized by Marshal, and is not inherited by response contexts.
+func (ctx *context) SetExportCache(size int) {
+	defer ctx.lock()()
+
+	ctx.exportCache.clear()
+	ctx.exportCache = nil
+	if size > 0 {
+		ctx.exportCache = newExportCache(size)
+	}
+}
+
+// cachedExport is export, consulting and filling the export cache if there
+// is one.  The caller always receives its own copy of the secret.
+func (ctx *context) cachedExport(context []byte, L int) []byte {
+	if ctx.exportCache == nil {
+		return ctx.export(context, L)
+	}
+
+	secret, ok := ctx.exportCache.get(context, L)
+	if !ok {
+		secret = ctx.export(context, L)
+		ctx.exportCache.put(context, L, secret)
+	}
+	return append([]byte(nil), secret...)
+}
@@ -0,0 +1,105 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestExportKeyingMaterial(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_EXPORT_ONLY)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	var exporterS, exporterR KeyingMaterialExporter = ctxS, ctxR
+	keyS, err := exporterS.ExportKeyingMaterial("EXPORTER-test", exportContext, exportLength)
+	assertNotError(t, suite, "Error in ExportKeyingMaterial", err)
+
+	keyR, err := exporterR.ExportKeyingMaterial("EXPORTER-test", exportContext, exportLength)
+	assertNotError(t, suite, "Error in ExportKeyingMaterial", err)
+	assertBytesEqual(t, suite, "Exported keying material mismatch", keyS, keyR)
+
+	expected := ctxS.Export(append([]byte{0x00, 0x0d}, append([]byte("EXPORTER-test"), exportContext...)...), exportLength)
+	assertBytesEqual(t, suite, "Incorrect exporter context encoding", keyS, expected)
+
+	// The label and context cannot be traded off against each other
+	other, err := ctxS.ExportKeyingMaterial("EXPORTER-tes", append([]byte("t"), exportContext...), exportLength)
+	assertNotError(t, suite, "Error in ExportKeyingMaterial", err)
+	assert(t, suite, "Label and context not separated", string(other) != string(keyS))
+
+	_, err = ctxS.ExportKeyingMaterial("EXPORTER-test", nil, 255*32+1)
+	assert(t, suite, "Export length beyond the KDF limit accepted", err != nil)
+
+	ctxS.Close()
+	_, err = ctxS.ExportKeyingMaterial("EXPORTER-test", nil, exportLength)
+	assert(t, suite, "ExportKeyingMaterial succeeded on a closed context", err == ErrContextClosed)
+}
+
+func TestExportOnlyContext(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_EXPORT_ONLY)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info))
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithReplayWindow(64))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	// No AEAD or nonce state is derived
+	assert(t, suite, "AEAD state derived", ctxS.aead == nil && ctxS.Key == nil && ctxS.BaseNonce == nil)
+	assert(t, suite, "Replay state allocated", ctxR.replay == nil)
+
+	_, err = ctxS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded for export-only AEAD", err != nil)
+	_, err = ctxR.Open(aad, original)
+	assert(t, suite, "Open succeeded for export-only AEAD", err != nil)
+	_, err = ctxR.OpenWithSeq(0, aad, original)
+	assert(t, suite, "OpenWithSeq succeeded for export-only AEAD", err != nil)
+
+	assertBytesEqual(t, suite, "Exported secrets differ", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+}
+
+func TestExportCache(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithExportCache(2))
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	// Cached results match uncached ones, and each caller gets its own copy
+	first := ctxS.Export(exportContext, exportLength)
+	assertBytesEqual(t, suite, "Cached export mismatch", first, ctxR.Export(exportContext, exportLength))
+	first[0] ^= 0xff
+	second := ctxS.Export(exportContext, exportLength)
+	assertBytesEqual(t, suite, "Cache modified through returned secret", second, ctxR.Export(exportContext, exportLength))
+	assert(t, suite, "Export cache not used", len(ctxS.exportCache.entries) == 1)
+
+	// Length is part of the key, and the oldest entry is evicted
+	assertBytesEqual(t, suite, "Cached export mismatch for other length", ctxS.Export(exportContext, 16), ctxR.Export(exportContext, 16))
+	keyS, err := ctxS.ExportKeyingMaterial("EXPORTER-test", nil, exportLength)
+	assertNotError(t, suite, "Error in ExportKeyingMaterial", err)
+	keyR, err := ctxR.ExportKeyingMaterial("EXPORTER-test", nil, exportLength)
+	assertNotError(t, suite, "Error in ExportKeyingMaterial", err)
+	assertBytesEqual(t, suite, "Cached keying material mismatch", keyS, keyR)
+	assert(t, suite, "Export cache not bounded", len(ctxS.exportCache.entries) == 2)
+	_, ok := ctxS.exportCache.get(exportContext, exportLength)
+	assert(t, suite, "Oldest entry not evicted", !ok)
+
+	// Key updates invalidate the cache
+	fatalOnError(t, ctxS.KeyUpdate(), "Error in KeyUpdate")
+	fatalOnError(t, ctxR.KeyUpdate(), "Error in KeyUpdate")
+	assert(t, suite, "Export cache not cleared by KeyUpdate", len(ctxS.exportCache.entries) == 0)
+	assertBytesEqual(t, suite, "Stale export after KeyUpdate", ctxS.Export(exportContext, 16), ctxR.Export(exportContext, 16))
+
+	// Disabling the cache drops it
+	ctxS.SetExportCache(0)
+	assert(t, suite, "Export cache not disabled", ctxS.exportCache == nil)
+	assertBytesEqual(t, suite, "Uncached export mismatch", ctxS.Export(exportContext, 16), ctxR.Export(exportContext, 16))
+}
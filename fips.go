@@ -0,0 +1,69 @@
+package hpke
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// fipsMode is nonzero when FIPS mode is enabled.
+var fipsMode int32
+
+// SetFIPSMode enables or disables FIPS mode.  In FIPS mode,
+// AssembleCipherSuite only assembles suites built from FIPS 140 approved
+// algorithms: DHKEM over P-256 or P-521, HKDF with SHA-2, and AES-GCM (or
+// the export-only AEAD, which uses only the KDF).  X25519, X448,
+// ChaCha20Poly1305, the key-committing AEADs, and SIKE are refused.
+//
+// With Go 1.20 and later, ECDH over P-256 and P-521 is computed with
+// crypto/ecdh, so that binaries built with GOEXPERIMENT=boringcrypto, or
+// running the Go Cryptographic Module, use the validated implementation;
+// older toolchains fall back to crypto/elliptic.  The hash, HMAC, and AES-GCM
+// primitives always come from the standard library, which routes them to the
+// validated implementation itself.
+//
+// FIPS mode is enabled at startup in boringcrypto builds and, with Go 1.24
+// and later, when the Go Cryptographic Module runs in FIPS 140-3 mode (e.g.,
+// GODEBUG=fips140=on).  Importing the fipsonly subpackage enables it too.
+func SetFIPSMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&fipsMode, v)
+}
+
+// FIPSMode reports whether FIPS mode is enabled.
+func FIPSMode() bool {
+	return atomic.LoadInt32(&fipsMode) != 0
+}
+
+func fipsApprovedKEM(kemID KEMID) bool {
+	return kemID == DHKEM_P256 || kemID == DHKEM_P521
+}
+
+func fipsApprovedKDF(kdfID KDFID) bool {
+	return kdfID == KDF_HKDF_SHA256 || kdfID == KDF_HKDF_SHA384 || kdfID == KDF_HKDF_SHA512
+}
+
+func fipsApprovedAEAD(aeadID AEADID) bool {
+	return aeadID == AEAD_AESGCM128 || aeadID == AEAD_AESGCM256 || aeadID == AEAD_EXPORT_ONLY
+}
+
+// checkFIPS returns an error if FIPS mode is enabled and the suite uses an
+// algorithm that is not approved.
+func checkFIPS(kemID KEMID, kdfID KDFID, aeadID AEADID) error {
+	if !FIPSMode() {
+		return nil
+	}
+
+	switch {
+	case !fipsApprovedKEM(kemID):
+		return fmt.Errorf("%w: KEM not allowed in FIPS mode [%s]", ErrUnsupportedSuite, kemID)
+	case !fipsApprovedKDF(kdfID):
+		return fmt.Errorf("%w: KDF not allowed in FIPS mode [%s]", ErrUnsupportedSuite, kdfID)
+	case !fipsApprovedAEAD(aeadID):
+		return fmt.Errorf("%w: AEAD not allowed in FIPS mode [%s]", ErrUnsupportedSuite, aeadID)
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package hpke
+
+import "crypto/boring"
+
+// Binaries built with GOEXPERIMENT=boringcrypto start in FIPS mode.
+func init() {
+	if boring.Enabled() {
+		SetFIPSMode(true)
+	}
+}
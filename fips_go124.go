@@ -0,0 +1,14 @@
+//go:build go1.24
+// +build go1.24
+
+package hpke
+
+import "crypto/fips140"
+
+// Binaries running the Go Cryptographic Module in FIPS 140-3 mode, e.g.,
+// with GODEBUG=fips140=on, start in FIPS mode.
+func init() {
+	if fips140.Enabled() {
+		SetFIPSMode(true)
+	}
+}
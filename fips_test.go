@@ -0,0 +1,53 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
+package hpke
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFIPSMode(t *testing.T) {
+	defer SetFIPSMode(FIPSMode())
+	SetFIPSMode(true)
+	require.True(t, FIPSMode(), "FIPS mode not enabled")
+
+	for _, kemID := range []KEMID{DHKEM_P256, DHKEM_P521} {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA384, AEAD_AESGCM256)
+		require.Nil(t, err, "Approved suite refused")
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		enc, ct, err := Seal(suite, rand.Reader, pkR, info, nil, original)
+		require.Nil(t, err, "Error in Seal")
+
+		// Keys and ciphertexts are interchangeable with the default mode
+		SetFIPSMode(false)
+		pt, err := Open(suite, skR, enc, info, nil, ct)
+		SetFIPSMode(true)
+		require.Nil(t, err, "Error in Open")
+		require.Equal(t, original, pt, "Incorrect plaintext")
+	}
+
+	refused := []struct {
+		kem  KEMID
+		kdf  KDFID
+		aead AEADID
+	}{
+		{DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128},
+		{DHKEM_X448, KDF_HKDF_SHA512, AEAD_AESGCM256},
+		{DHKEM_P256, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305},
+		{DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128_COMMIT},
+	}
+	for _, r := range refused {
+		_, err := AssembleCipherSuite(r.kem, r.kdf, r.aead)
+		require.True(t, errors.Is(err, ErrUnsupportedSuite), "Unapproved suite assembled")
+	}
+
+	SetFIPSMode(false)
+	_, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+	require.Nil(t, err, "Suite refused outside FIPS mode")
+}
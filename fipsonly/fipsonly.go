@@ -0,0 +1,14 @@
+// Package fipsonly enables HPKE's FIPS mode when it is imported, in the
+// manner of crypto/tls/fipsonly:
+//
+//	import _ "github.com/cisco/go-hpke/fipsonly"
+//
+// After that, AssembleCipherSuite refuses suites that use algorithms that are
+// not FIPS 140 approved.  See hpke.SetFIPSMode.
+package fipsonly
+
+import hpke "github.com/cisco/go-hpke"
+
+func init() {
+	hpke.SetFIPSMode(true)
+}
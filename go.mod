@@ -3,9 +3,16 @@ module github.com/cisco/go-hpke
 go 1.14
 
 require (
-	git.schwanenlied.me/yawning/x448.git v0.0.0-20170617130356-01b048fb03d6
+	github.com/aws/aws-sdk-go v1.55.5
 	github.com/cisco/go-tls-syntax v0.0.0-20200617162716-46b0cfb76b9b
 	github.com/cloudflare/circl v1.0.0
+	github.com/go-piv/piv-go v1.11.0
+	github.com/golang/protobuf v1.4.1
+	github.com/google/go-tpm v0.3.3
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	golang.org/x/sys v0.0.0-20210629170331-7dc0b73dc9fb
+	google.golang.org/grpc v1.31.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,70 @@
+package hpke
+
+import (
+	"fmt"
+	"io"
+)
+
+// GreaseEnc returns an encapsulated key that is indistinguishable from one
+// produced by a real setup with the given suite, but that no one can
+// decapsulate.  It is intended for protocols such as TLS Encrypted Client
+// Hello, which send "GREASE" values to keep middleboxes from ossifying on the
+// absence of the real extension.
+func GreaseEnc(suite CipherSuite, rand io.Reader) ([]byte, error) {
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	if _, err := io.ReadFull(rand, ikm); err != nil {
+		return nil, err
+	}
+
+	// Encapsulate to a throwaway key pair, so that the encapsulated key is
+	// valid, then discard the shared secret.
+	_, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	if err != nil {
+		return nil, err
+	}
+
+	_, enc, err := suite.KEM.Encap(rand, pkR)
+	if err != nil {
+		return nil, err
+	}
+
+	return enc, nil
+}
+
+// GreaseCiphertextLength returns the length of the ciphertext that sealing a
+// ptLen-byte plaintext with the given suite would produce.
+func GreaseCiphertextLength(suite CipherSuite, ptLen int) (int, error) {
+	if suite.AEAD.ID() == AEAD_EXPORT_ONLY {
+		return 0, fmt.Errorf("GREASE ciphertext not supported for export-only AEAD")
+	}
+
+	if ptLen < 0 {
+		return 0, fmt.Errorf("Invalid plaintext length [%d]", ptLen)
+	}
+
+	aead, err := suite.AEAD.New(make([]byte, suite.AEAD.KeySize()))
+	if err != nil {
+		return 0, err
+	}
+
+	return ptLen + aead.Overhead(), nil
+}
+
+// GreaseCiphertext returns random bytes of the same length as the ciphertext
+// of a ptLen-byte plaintext sealed with the given suite.  Together with
+// GreaseEnc, it can be used to fill in a GREASE ECH extension, in which case
+// ptLen should be the padded length of the inner ClientHello that would have
+// been sent.
+func GreaseCiphertext(suite CipherSuite, rand io.Reader, ptLen int) ([]byte, error) {
+	ctLen, err := GreaseCiphertextLength(suite, ptLen)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := make([]byte, ctLen)
+	if _, err := io.ReadFull(rand, ct); err != nil {
+		return nil, err
+	}
+
+	return ct, nil
+}
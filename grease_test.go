@@ -0,0 +1,33 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestGrease(t *testing.T) {
+	for kemID := range kems {
+		suite := mustAssembleSuite(t, kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		realEnc, realCT, err := Seal(suite, rand.Reader, pkR, info, aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+
+		enc, err := GreaseEnc(suite, rand.Reader)
+		assertNotError(t, suite, "Error in GreaseEnc", err)
+		assert(t, suite, "Incorrect GREASE enc length", len(enc) == len(realEnc))
+
+		ct, err := GreaseCiphertext(suite, rand.Reader, len(original))
+		assertNotError(t, suite, "Error in GreaseCiphertext", err)
+		assert(t, suite, "Incorrect GREASE ciphertext length", len(ct) == len(realCT))
+
+		// The GREASE values are well-formed, but cannot be opened
+		_, err = Open(suite, skR, enc, info, aad, ct)
+		assert(t, suite, "GREASE ciphertext opened", err != nil)
+	}
+
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_EXPORT_ONLY)
+
+	_, err := GreaseCiphertext(suite, rand.Reader, len(original))
+	assert(t, suite, "GREASE ciphertext generated for export-only AEAD", err != nil)
+}
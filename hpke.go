@@ -2,11 +2,20 @@ package hpke
 
 import (
 	"bytes"
+	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"math"
+	"sync"
+	"time"
 
 	syntax "github.com/cisco/go-tls-syntax"
 )
@@ -16,12 +25,186 @@ const (
 	versionLabel = "HPKE-v1"
 )
 
+var (
+	// ErrMessageLimit is returned by Seal and Open once the sequence number
+	// space of a context has been exhausted.
+	ErrMessageLimit = errors.New("Message limit reached")
+
+	// ErrMessageLimitReached is an alias for ErrMessageLimit.
+	//
+	// Deprecated: Use ErrMessageLimit.
+	ErrMessageLimitReached = ErrMessageLimit
+
+	// ErrOpenFailed is returned when a ciphertext fails to decrypt, e.g.,
+	// because it was modified or the wrong key or AAD was used.
+	ErrOpenFailed = errors.New("Message authentication failed")
+
+	// ErrInvalidPublicKey is returned for public keys and encapsulated keys
+	// that are malformed or unsuitable for key agreement.
+	ErrInvalidPublicKey = errors.New("Invalid public key")
+
+	// ErrUnsupportedSuite is returned when an algorithm identifier is unknown,
+	// or an algorithm does not support the requested operation.
+	ErrUnsupportedSuite = errors.New("Unsupported cipher suite")
+
+	// ErrPSKRequired is returned by setup in the PSK modes when no PSK is
+	// provided.
+	ErrPSKRequired = errors.New("Missing required PSK input")
+
+	// ErrReplayedMessage is returned by OpenWithSeq when replay protection is
+	// enabled and the sequence number has already been seen or is too old.
+	ErrReplayedMessage = errors.New("Replayed message")
+
+	// ErrContextClosed is returned by operations on a context after Zeroize
+	// or Close has been called.
+	ErrContextClosed = errors.New("Context closed")
+
+	// ErrContextExpired is returned by operations on a context after its
+	// expiry time has passed.
+	ErrContextExpired = errors.New("Context expired")
+)
+
+// KEMPrivateKey is a private key for one of the KEMs.  The private keys of
+// the built-in KEMs also provide a constant-time Equal(KEMPrivateKey) method.
 type KEMPrivateKey interface {
 	PublicKey() KEMPublicKey
 }
 
+// KEMPublicKey is a public key for one of the KEMs.  The public keys of the
+// built-in KEMs also provide a constant-time Equal(KEMPublicKey) method.
 type KEMPublicKey interface{}
 
+// Zeroizer is implemented by private keys and contexts whose secret material
+// can be explicitly wiped from memory.  The private keys returned by the
+// built-in KEMs implement Zeroizer.
+//
+// Zeroizing only affects the memory currently holding the secret; copies made
+// earlier, e.g., by the garbage collector moving or growing a slice, are not
+// reachable and cannot be wiped.
+type Zeroizer interface {
+	Zeroize()
+}
+
+// KEMDecapsulator is a receiver private key held behind an opaque handle,
+// such as a key in an HSM or a remote decapsulation service, in the manner of
+// crypto.Decrypter.  A KEMDecapsulator may be passed to NewReceiver and the
+// Setup*R functions in place of a private key, in which case decapsulation is
+// delegated to it rather than to the suite's KEM.
+type KEMDecapsulator interface {
+	KEMPrivateKey
+	Decap(enc []byte) ([]byte, error)
+}
+
+// AuthKEMDecapsulator is a KEMDecapsulator that also supports decapsulation
+// in the authenticated modes.
+type AuthKEMDecapsulator interface {
+	KEMDecapsulator
+	AuthDecap(enc []byte, pkS KEMPublicKey) ([]byte, error)
+}
+
+// DHPrivateKey is a DHKEM private key held behind an opaque handle, such as
+// a key in a PKCS#11 token or a TPM, that can perform Diffie-Hellman without
+// revealing the scalar.  Where a KEMDecapsulator replaces all of Decap, the
+// built-in DHKEMs use a DHPrivateKey for the DH operations alone, so the rest
+// of the KEM and the key schedule run locally.  It may be used as a receiver
+// key or as the sender key in the authenticated modes.
+//
+// DH receives the peer's serialized public key and returns the DH output in
+// the form the KEM uses, i.e., the x-coordinate for the NIST curves.
+// PublicKey must return a public key of the KEM, e.g., one obtained with
+// DeserializePublicKey.
+type DHPrivateKey interface {
+	KEMPrivateKey
+	DH(pkXm []byte) ([]byte, error)
+}
+
+// KeyAgreer is the capability of a platform keystore key, such as a Secure
+// Enclave or Android Keystore key, that cannot be exported but can perform
+// key agreement by handle.  Its methods deal only in byte strings, so that it
+// can be implemented by platform code through gomobile bindings.
+// NewDHPrivateKey adapts a KeyAgreer for use with the receiver setup
+// functions.
+type KeyAgreer interface {
+	// PublicKeyBytes returns the serialized public key, i.e., the
+	// uncompressed point for the NIST curves.
+	PublicKeyBytes() ([]byte, error)
+
+	// KeyAgreement performs Diffie-Hellman with the peer's serialized public
+	// key, returning the DH output as for DHPrivateKey.DH.
+	KeyAgreement(pkXm []byte) ([]byte, error)
+}
+
+type keyAgreerKey struct {
+	id KEMID
+	pk KEMPublicKey
+	ka KeyAgreer
+}
+
+// NewDHPrivateKey wraps a KeyAgreer as a private key for the given DHKEM.
+func NewDHPrivateKey(kemID KEMID, ka KeyAgreer) (DHPrivateKey, error) {
+	kem, ok := kems[kemID]
+	if !ok {
+		return nil, fmt.Errorf("%w: Unknown KEM id [%s]", ErrUnsupportedSuite, kemID)
+	}
+
+	if _, ok := kem.(dhkemScheme); !ok {
+		return nil, fmt.Errorf("%w: KEM does not use Diffie-Hellman [%s]", ErrUnsupportedSuite, kemID)
+	}
+
+	pkXm, err := ka.PublicKeyBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := kem.DeserializePublicKey(pkXm)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyAgreerKey{id: kemID, pk: pk, ka: ka}, nil
+}
+
+func (k keyAgreerKey) kemID() KEMID {
+	return k.id
+}
+
+func (k keyAgreerKey) PublicKey() KEMPublicKey {
+	return k.pk
+}
+
+func (k keyAgreerKey) DH(pkXm []byte) ([]byte, error) {
+	return k.ka.KeyAgreement(pkXm)
+}
+
+type softwareDecapsulator struct {
+	kem KEMScheme
+	skR KEMPrivateKey
+}
+
+// NewKEMDecapsulator wraps a private key for the given KEM as an
+// AuthKEMDecapsulator.  It is mainly useful for testing code that works with
+// opaque receiver keys.
+func NewKEMDecapsulator(kem KEMScheme, skR KEMPrivateKey) AuthKEMDecapsulator {
+	return softwareDecapsulator{kem: kem, skR: skR}
+}
+
+func (d softwareDecapsulator) PublicKey() KEMPublicKey {
+	return d.skR.PublicKey()
+}
+
+func (d softwareDecapsulator) Decap(enc []byte) ([]byte, error) {
+	return d.kem.Decap(enc, d.skR)
+}
+
+func (d softwareDecapsulator) AuthDecap(enc []byte, pkS KEMPublicKey) ([]byte, error) {
+	auth, ok := d.kem.(AuthKEMScheme)
+	if !ok {
+		return nil, fmt.Errorf("KEM does not support AuthDecap")
+	}
+
+	return auth.AuthDecap(enc, d.skR, pkS)
+}
+
 type KEMScheme interface {
 	ID() KEMID
 	DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error)
@@ -34,8 +217,21 @@ type KEMScheme interface {
 
 	SerializePrivateKey(skX KEMPrivateKey) []byte
 	DeserializePrivateKey(skXm []byte) (KEMPrivateKey, error)
+}
+
+// encapsulatedKeySizer is implemented by KEMs whose encapsulated keys differ
+// in size from their serialized public keys.
+type encapsulatedKeySizer interface {
+	EncapsulatedKeySize() int
+}
 
-	setEphemeralKeyPair(sk KEMPrivateKey)
+// EncapsulatedKeySize returns Nenc, the size of the encapsulated keys the KEM
+// produces.  For DH-based KEMs, this is the size of a serialized public key.
+func EncapsulatedKeySize(kem KEMScheme) int {
+	if sizer, ok := kem.(encapsulatedKeySizer); ok {
+		return sizer.EncapsulatedKeySize()
+	}
+	return kem.PublicKeySize()
 }
 
 type AuthKEMScheme interface {
@@ -44,6 +240,16 @@ type AuthKEMScheme interface {
 	AuthDecap(enc []byte, skR KEMPrivateKey, pkS KEMPublicKey) ([]byte, error)
 }
 
+// DeterministicKEMScheme is implemented by KEMs whose encapsulation can be
+// derandomized, deriving the ephemeral key pair from a seed rather than from
+// a source of randomness.  This is mainly useful for generating and checking
+// test vectors.
+type DeterministicKEMScheme interface {
+	KEMScheme
+	DeriveEncap(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error)
+	DeriveAuthEncap(ikmE []byte, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error)
+}
+
 type KDFScheme interface {
 	ID() KDFID
 	Hash(message []byte) []byte
@@ -61,29 +267,69 @@ type AEADScheme interface {
 	NonceSize() int
 }
 
+// AEADReleaser is implemented by AEAD schemes that recycle cipher.AEAD
+// instances, e.g., by pooling them and re-keying them in New, to cut setup
+// cost and garbage on servers that churn through many short-lived contexts.
+// A context passes its AEAD instance to Release once it no longer needs it:
+// when the context is zeroized or closed, and when KeyUpdate replaces the
+// instance.  Instances exposed through the context's AEAD method are never
+// released.
+//
+// The built-in ChaCha20-Poly1305 scheme implements AEADReleaser, so that
+// setting up a context with it allocates no AEAD instance once released
+// instances are available.  The AES-GCM schemes do not, since the standard
+// library's AES-GCM cannot be re-keyed.
+type AEADReleaser interface {
+	Release(aead cipher.AEAD)
+}
+
 type CipherSuite struct {
 	KEM  KEMScheme
 	KDF  KDFScheme
 	AEAD AEADScheme
 }
 
+// SuiteID returns the suite_id used to bind the key schedule to the suite,
+// i.e., "HPKE" followed by the KEM, KDF, and AEAD identifiers.  The returned
+// slice is a fresh copy that the caller may modify.
+func (suite CipherSuite) SuiteID() []byte {
+	return append([]byte(nil), suite.id()...)
+}
+
+// ID is equivalent to SuiteID.
 func (suite CipherSuite) ID() []byte {
-	suiteID := make([]byte, 6)
-	binary.BigEndian.PutUint16(suiteID, uint16(suite.KEM.ID()))
-	binary.BigEndian.PutUint16(suiteID[2:], uint16(suite.KDF.ID()))
-	binary.BigEndian.PutUint16(suiteID[4:], uint16(suite.AEAD.ID()))
-	return append([]byte("HPKE"), suiteID...)
+	return suite.SuiteID()
+}
+
+// String returns the canonical name of the suite, as produced by
+// FormatCipherSuite.
+func (suite CipherSuite) String() string {
+	return FormatCipherSuite(suite.KEM.ID(), suite.KDF.ID(), suite.AEAD.ID())
 }
 
 type Mode uint8
 
 const (
-	modeBase    Mode = 0x00
-	modePSK     Mode = 0x01
-	modeAuth    Mode = 0x02
-	modeAuthPSK Mode = 0x03
+	ModeBase    Mode = 0x00
+	ModePSK     Mode = 0x01
+	ModeAuth    Mode = 0x02
+	ModeAuthPSK Mode = 0x03
 )
 
+func (mode Mode) String() string {
+	switch mode {
+	case ModeBase:
+		return "Base"
+	case ModePSK:
+		return "PSK"
+	case ModeAuth:
+		return "Auth"
+	case ModeAuthPSK:
+		return "AuthPSK"
+	}
+	return fmt.Sprintf("Mode(0x%02x)", uint8(mode))
+}
+
 func logString(val string) {
 	if debug {
 		log.Printf("%s", val)
@@ -110,9 +356,10 @@ func defaultPSKID(suite CipherSuite) []byte {
 func verifyPSKInputs(suite CipherSuite, mode Mode, psk, pskID []byte) error {
 	defaultPSK := defaultPSK(suite)
 	defaultPSKID := defaultPSKID(suite)
-	pskMode := map[Mode]bool{modePSK: true, modeAuthPSK: true}
+	pskMode := map[Mode]bool{ModePSK: true, ModeAuthPSK: true}
 
-	gotPSK := !bytes.Equal(psk, defaultPSK)
+	// The PSK is secret, so it is compared in constant time
+	gotPSK := subtle.ConstantTimeCompare(psk, defaultPSK) != 1
 	gotPSKID := !bytes.Equal(pskID, defaultPSKID)
 
 	switch {
@@ -121,7 +368,7 @@ func verifyPSKInputs(suite CipherSuite, mode Mode, psk, pskID []byte) error {
 	case gotPSK && !pskMode[mode]:
 		return fmt.Errorf("PSK input provided when not needed [%d]", mode)
 	case !gotPSK && pskMode[mode]:
-		return fmt.Errorf("Missing required PSK input [%d]", mode)
+		return fmt.Errorf("%w [%d]", ErrPSKRequired, mode)
 	}
 
 	return nil
@@ -135,20 +382,21 @@ type hpkeContext struct {
 
 type contextParameters struct {
 	suite              CipherSuite
+	mode               Mode
 	keyScheduleContext []byte
 	secret             []byte
 }
 
 func (cp contextParameters) aeadKey() []byte {
-	return cp.suite.KDF.LabeledExpand(cp.secret, cp.suite.ID(), "key", cp.keyScheduleContext, cp.suite.AEAD.KeySize())
+	return cp.suite.KDF.LabeledExpand(cp.secret, cp.suite.id(), "key", cp.keyScheduleContext, cp.suite.AEAD.KeySize())
 }
 
 func (cp contextParameters) exporterSecret() []byte {
-	return cp.suite.KDF.LabeledExpand(cp.secret, cp.suite.ID(), "exp", cp.keyScheduleContext, cp.suite.KDF.OutputSize())
+	return cp.suite.KDF.LabeledExpand(cp.secret, cp.suite.id(), "exp", cp.keyScheduleContext, cp.suite.KDF.OutputSize())
 }
 
 func (cp contextParameters) aeadBaseNonce() []byte {
-	return cp.suite.KDF.LabeledExpand(cp.secret, cp.suite.ID(), "base_nonce", cp.keyScheduleContext, cp.suite.AEAD.NonceSize())
+	return cp.suite.KDF.LabeledExpand(cp.secret, cp.suite.id(), "base_nonce", cp.keyScheduleContext, cp.suite.AEAD.NonceSize())
 }
 
 type setupParameters struct {
@@ -162,7 +410,7 @@ func keySchedule(suite CipherSuite, mode Mode, sharedSecret, info, psk, pskID []
 		return contextParameters{}, err
 	}
 
-	suiteID := suite.ID()
+	suiteID := suite.id()
 	pskIDHash := suite.KDF.LabeledExtract(nil, suiteID, "psk_id_hash", pskID)
 	infoHash := suite.KDF.LabeledExtract(nil, suiteID, "info_hash", info)
 
@@ -176,6 +424,7 @@ func keySchedule(suite CipherSuite, mode Mode, sharedSecret, info, psk, pskID []
 
 	params := contextParameters{
 		suite:              suite,
+		mode:               mode,
 		keyScheduleContext: keyScheduleContext,
 		secret:             secret,
 	}
@@ -200,19 +449,41 @@ type context struct {
 	KEMID          KEMID
 	KDFID          KDFID
 	AEADID         AEADID
-	ExporterSecret []byte `tls:"head=1"`
-	Key            []byte `tls:"head=1"`
-	BaseNonce      []byte `tls:"head=1"`
+	ExporterSecret []byte
+	Key            []byte
+	BaseNonce      []byte
 	Seq            uint64
+	Epoch          uint64
 
 	// Operational structures
-	aead  cipher.AEAD `tls:"omit"`
-	suite CipherSuite `tls:"omit"`
+	aead       cipher.AEAD
+	aeadShared bool
+	suite      CipherSuite
+	mu         *sync.Mutex
+
+	policy  MessagePolicy
+	expiry  time.Time
+	binding []byte
+	closed  bool
+
+	exportCache *exportCache
 
-	// Historical record
-	nonces        [][]byte          `tls:"omit"`
-	setupParams   setupParameters   `tls:"omit"`
-	contextParams contextParameters `tls:"omit"`
+	// HMAC keyed for the Marshal MAC, kept until the exporter secret changes
+	mac hash.Hash
+
+	// Scratch space for the per-message nonce, reused across messages
+	nonce []byte
+
+	// Locked memory holding the secrets above, if enabled
+	secure *secureBuffer
+
+	// Mode of the setup that created the context, if it was created by one
+	mode      Mode
+	fromSetup bool
+
+	// Historical record, kept only if requested with WithIntermediates
+	setupParams   setupParameters
+	contextParams contextParameters
 }
 
 func newContext(role contextRole, suite CipherSuite, setupParams setupParameters, contextParams contextParameters) (context, error) {
@@ -240,8 +511,11 @@ func newContext(role contextRole, suite CipherSuite, setupParams setupParameters
 		Key:            key,
 		BaseNonce:      baseNonce,
 		Seq:            0,
+		Epoch:          0,
 		aead:           aead,
 		suite:          suite,
+		mode:           contextParams.mode,
+		fromSetup:      true,
 		setupParams:    setupParams,
 		contextParams:  contextParams,
 	}
@@ -249,250 +523,1528 @@ func newContext(role contextRole, suite CipherSuite, setupParams setupParameters
 	return ctx, nil
 }
 
-func unmarshalContext(role contextRole, opaque []byte) (context, error) {
-	var ctx context
-	var err error
-	if _, err = syntax.Unmarshal(opaque, &ctx); err != nil {
-		return context{}, err
+// contextFormatVersion3 identifies the serialization format produced by
+// Marshal:
+//
+//	struct {
+//	  uint8 role;
+//	  uint16 kem_id;
+//	  uint16 kdf_id;
+//	  uint16 aead_id;
+//	  opaque exporter_secret<0..255>;
+//	  opaque key<0..255>;
+//	  opaque base_nonce<0..255>;
+//	  uint64 seq;
+//	  uint64 epoch;
+//	} Context;
+//
+//	struct {
+//	  uint8 version;
+//	  Context context;
+//	  uint64 expiry;       // Unix time in seconds; zero if none
+//	  opaque binding<0..255>;  // suite binding prefix; empty if none
+//	  opaque mac[Nh];
+//	} SerializedContext;
+//
+// The MAC is HMAC over everything before it, under a key derived from the
+// exporter secret.  Because the key is derived from the serialized context
+// itself, the MAC detects corruption and format mismatches, but does not
+// protect against deliberate modification.
+//
+// Version 2 is the same, but without the binding field, and version 1 also
+// lacks the expiry field.  Both are still accepted by unmarshalContext.
+const (
+	contextFormatVersion1 uint8 = 0x01
+	contextFormatVersion2 uint8 = 0x02
+	contextFormatVersion3 uint8 = 0x03
+)
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// expiryToUnix and expiryFromUnix convert between the expiry time of a
+// context and its serialized form, in which zero represents no expiry.
+func expiryToUnix(expiry time.Time) uint64 {
+	if expiry.IsZero() {
+		return 0
+	}
+
+	secs := expiry.Unix()
+	if secs < 1 {
+		secs = 1
+	}
+	return uint64(secs)
+}
+
+func expiryFromUnix(secs uint64) time.Time {
+	if secs == 0 {
+		return time.Time{}
+	}
+
+	if secs > math.MaxInt64 {
+		secs = math.MaxInt64
+	}
+	return time.Unix(int64(secs), 0)
+}
+
+// appendMAC appends the MAC over body to b.  For HKDF, the HMAC instance is
+// keyed once per exporter secret and reused, so that repeated calls do not
+// allocate.
+func (ctx *context) appendMAC(b, body []byte) []byte {
+	if ctx.mac == nil {
+		kdf := ctx.suite.KDF
+		macKey := kdf.LabeledExpand(ctx.ExporterSecret, ctx.suite.id(), "marshal_mac_key", nil, kdf.OutputSize())
+		defer wipe(macKey)
+
+		// HKDF-Extract is HMAC keyed with the salt
+		hkdf, ok := kdf.(hkdfScheme)
+		if !ok {
+			return append(b, kdf.Extract(macKey, body)...)
+		}
+		ctx.mac = hmac.New(hkdf.hash.New, macKey)
+	}
+
+	ctx.mac.Reset()
+	ctx.mac.Write(body)
+	return ctx.mac.Sum(b)
+}
+
+// appendFields appends the Context structure to b.
+func (ctx *context) appendFields(b []byte) ([]byte, error) {
+	if len(ctx.ExporterSecret) > 255 || len(ctx.Key) > 255 || len(ctx.BaseNonce) > 255 {
+		return b, fmt.Errorf("Context secrets too long to marshal")
+	}
+
+	b = append(b, uint8(ctx.Role))
+	b = appendUint16(b, uint16(ctx.KEMID))
+	b = appendUint16(b, uint16(ctx.KDFID))
+	b = appendUint16(b, uint16(ctx.AEADID))
+	b = append(append(b, uint8(len(ctx.ExporterSecret))), ctx.ExporterSecret...)
+	b = append(append(b, uint8(len(ctx.Key))), ctx.Key...)
+	b = append(append(b, uint8(len(ctx.BaseNonce))), ctx.BaseNonce...)
+	b = appendUint64(b, ctx.Seq)
+	return appendUint64(b, ctx.Epoch), nil
+}
+
+// unmarshalFields parses the Context structure at the start of data,
+// returning the number of bytes read.  The secrets are copied into a single
+// buffer.
+func (ctx *context) unmarshalFields(data []byte) (int, error) {
+	if len(data) < 7 {
+		return 0, fmt.Errorf("Truncated context")
+	}
+
+	ctx.Role = contextRole(data[0])
+	ctx.KEMID = KEMID(binary.BigEndian.Uint16(data[1:]))
+	ctx.KDFID = KDFID(binary.BigEndian.Uint16(data[3:]))
+	ctx.AEADID = AEADID(binary.BigEndian.Uint16(data[5:]))
+	read := 7
+
+	var secrets [3][]byte
+	total := 0
+	for i := range secrets {
+		if len(data) < read+1 || len(data) < read+1+int(data[read]) {
+			return 0, fmt.Errorf("Truncated context")
+		}
+
+		n := int(data[read])
+		secrets[i] = data[read+1 : read+1+n]
+		total += n
+		read += 1 + n
+	}
+
+	if len(data) < read+16 {
+		return 0, fmt.Errorf("Truncated context")
+	}
+	ctx.Seq = binary.BigEndian.Uint64(data[read:])
+	ctx.Epoch = binary.BigEndian.Uint64(data[read+8:])
+	read += 16
+
+	buf := make([]byte, total)
+	for i, field := range []*[]byte{&ctx.ExporterSecret, &ctx.Key, &ctx.BaseNonce} {
+		if n := copy(buf, secrets[i]); n > 0 {
+			*field = buf[:n:n]
+			buf = buf[n:]
+		}
 	}
 
+	return read, nil
+}
+
+// restore validates the marshaled fields of a deserialized context and
+// reconstructs its operational structures.
+func (ctx *context) restore(role contextRole) error {
+	var err error
 	if ctx.Role != role {
-		return context{}, fmt.Errorf("role mismatch")
+		return fmt.Errorf("role mismatch")
 	}
 
 	ctx.suite, err = AssembleCipherSuite(ctx.KEMID, ctx.KDFID, ctx.AEADID)
 	if err != nil {
-		return context{}, err
+		return err
 	}
 
 	// Construct AEAD and validate the key length, if applcable.
 	if ctx.AEADID != AEAD_EXPORT_ONLY {
 		ctx.aead, err = ctx.suite.AEAD.New(ctx.Key)
 		if err != nil {
-			return context{}, err
+			return err
 		}
 
 		// Validate the nonce length.
 		if len(ctx.BaseNonce) != ctx.aead.NonceSize() {
-			return context{}, fmt.Errorf("base nonce length: got %d; want %d", len(ctx.BaseNonce), ctx.aead.NonceSize())
+			return fmt.Errorf("base nonce length: got %d; want %d", len(ctx.BaseNonce), ctx.aead.NonceSize())
 		}
 	}
 
 	// Validate the exporter secret length.
 	if len(ctx.ExporterSecret) != ctx.suite.KDF.OutputSize() {
-		return context{}, fmt.Errorf("exporter secret length: got %d; want %d", len(ctx.ExporterSecret), ctx.suite.KDF.OutputSize())
+		return fmt.Errorf("exporter secret length: got %d; want %d", len(ctx.ExporterSecret), ctx.suite.KDF.OutputSize())
 	}
 
-	return ctx, nil
+	return nil
 }
 
-func (ctx *context) computeNonce() []byte {
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, ctx.Seq)
+func unmarshalContext(role contextRole, opaque []byte) (context, error) {
+	if len(opaque) == 0 {
+		return context{}, fmt.Errorf("Empty context")
+	}
 
-	Nn := len(ctx.BaseNonce)
-	nonce := make([]byte, Nn)
-	copy(nonce, ctx.BaseNonce)
-	for i := range buf {
-		nonce[Nn-8+i] ^= buf[i]
+	version := opaque[0]
+	if version < contextFormatVersion1 || version > contextFormatVersion3 {
+		return context{}, fmt.Errorf("Unsupported context format version [%d]", version)
 	}
 
-	ctx.nonces = append(ctx.nonces, nonce)
-	return nonce
-}
+	var ctx context
+	read, err := ctx.unmarshalFields(opaque[1:])
+	if err != nil {
+		return context{}, err
+	}
+	read += 1
 
-func (ctx *context) incrementSeq() {
-	ctx.Seq += 1
-	if ctx.Seq == 0 {
-		panic("sequence number wrapped")
+	if version >= contextFormatVersion2 {
+		if len(opaque) < read+8 {
+			return context{}, fmt.Errorf("Truncated context expiry")
+		}
+
+		ctx.expiry = expiryFromUnix(binary.BigEndian.Uint64(opaque[read:]))
+		read += 8
+	}
+
+	if version >= contextFormatVersion3 {
+		if len(opaque) < read+1 || len(opaque) < read+1+int(opaque[read]) {
+			return context{}, fmt.Errorf("Truncated context binding")
+		}
+
+		n := int(opaque[read])
+		if n > 0 {
+			ctx.binding = append([]byte{}, opaque[read+1:read+1+n]...)
+		}
+		read += 1 + n
+	}
+
+	if err := ctx.restore(role); err != nil {
+		return context{}, err
+	}
+
+	if ctx.binding != nil {
+		if len(ctx.binding) != len(ctx.suite.id())+1 || !bytes.Equal(ctx.binding[:len(ctx.binding)-1], ctx.suite.id()) {
+			return context{}, fmt.Errorf("Context binding does not match suite")
+		}
+	}
+
+	// Validate the MAC over the serialized context.
+	body, mac := opaque[:read], opaque[read:]
+	if len(mac) != ctx.suite.KDF.OutputSize() || !hmac.Equal(mac, ctx.appendMAC(nil, body)) {
+		return context{}, fmt.Errorf("Context integrity check failed")
 	}
+
+	return ctx, nil
 }
 
-func (ctx *context) Export(context []byte, L int) []byte {
-	return ctx.suite.KDF.LabeledExpand(ctx.ExporterSecret, ctx.suite.ID(), "sec", context, L)
+// EnableLocking makes the context safe for concurrent use by multiple
+// goroutines, by serializing all operations on it.  It must be called before
+// the context is shared.
+func (ctx *context) EnableLocking() {
+	if ctx.mu == nil {
+		ctx.mu = &sync.Mutex{}
+	}
 }
 
-func (ctx *context) Marshal() ([]byte, error) {
-	return syntax.Marshal(ctx)
+// lock acquires the context's mutex, if locking is enabled, and returns the
+// corresponding unlock function.
+func (ctx *context) lock() func() {
+	if ctx.mu == nil {
+		return func() {}
+	}
+
+	ctx.mu.Lock()
+	return ctx.mu.Unlock
 }
 
-type SenderContext struct {
-	context
+func (ctx *context) computeNonce() []byte {
+	return ctx.nonceForSeq(ctx.Seq)
 }
 
-func newSenderContext(suite CipherSuite, setupParams setupParameters, contextParams contextParameters) (*SenderContext, error) {
-	ctx, err := newContext(contextRoleSender, suite, setupParams, contextParams)
-	if err != nil {
-		return nil, err
+// nonceForSeq computes the nonce for a sequence number into the context's
+// scratch buffer, so the result is only valid until the next call.
+func (ctx *context) nonceForSeq(seq uint64) []byte {
+	if len(ctx.nonce) != len(ctx.BaseNonce) {
+		ctx.nonce = make([]byte, len(ctx.BaseNonce))
 	}
 
-	return &SenderContext{ctx}, nil
+	return xorNonceTo(ctx.nonce, ctx.BaseNonce, seq)
 }
 
-func (ctx *SenderContext) Seal(aad, pt []byte) []byte {
-	ct := ctx.aead.Seal(nil, ctx.computeNonce(), pt, aad)
-	ctx.incrementSeq()
-	return ct
+// xorNonce computes the per-message nonce for a given sequence number, as the
+// XOR of the base nonce with the big-endian encoding of the sequence number.
+func xorNonce(baseNonce []byte, seq uint64) []byte {
+	return xorNonceTo(make([]byte, len(baseNonce)), baseNonce, seq)
 }
 
-func UnmarshalSenderContext(opaque []byte) (*SenderContext, error) {
-	ctx, err := unmarshalContext(contextRoleSender, opaque)
-	if err != nil {
-		return nil, err
+// xorNonceTo is like xorNonce, but writes the nonce into dst, which must be
+// the same length as baseNonce.
+func xorNonceTo(dst, baseNonce []byte, seq uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+
+	Nn := len(baseNonce)
+	copy(dst, baseNonce)
+	for i := range buf {
+		dst[Nn-8+i] ^= buf[i]
 	}
 
-	return &SenderContext{ctx}, nil
+	return dst
 }
 
-type ReceiverContext struct {
-	context
+// MessagePolicy bounds the number of messages processed by a context, so
+// that applications can force a new encapsulation well before the limits of
+// the AEAD are approached.  Messages are counted by sequence number, so the
+// count starts over after a KeyUpdate.
+type MessagePolicy struct {
+	// MaxMessages is the number of messages after which Seal and Open fail
+	// with ErrMessageLimit.  Zero means no limit other than the sequence
+	// number space.
+	MaxMessages uint64
+
+	// WarnAt is the number of messages after which OnWarn is called.  Zero
+	// disables the warning.
+	WarnAt uint64
+
+	// OnWarn is called, with the number of messages processed, once WarnAt
+	// messages have been processed.  It is called while the context is locked,
+	// so it must not call back into the context.
+	OnWarn func(count uint64)
 }
 
-func newReceiverContext(suite CipherSuite, setupParams setupParameters, contextParams contextParameters) (*ReceiverContext, error) {
-	ctx, err := newContext(contextRoleReceiver, suite, setupParams, contextParams)
-	if err != nil {
-		return nil, err
-	}
+// SetMessagePolicy applies a message-count policy to the context.  A zero
+// policy removes any limits.  Policies are not serialized by Marshal, and are
+// not inherited by response contexts.
+func (ctx *context) SetMessagePolicy(policy MessagePolicy) {
+	defer ctx.lock()()
 
-	return &ReceiverContext{ctx}, nil
+	ctx.policy = policy
 }
 
-func (ctx *ReceiverContext) Open(aad, ct []byte) ([]byte, error) {
-	pt, err := ctx.aead.Open(nil, ctx.computeNonce(), ct, aad)
-	if err != nil {
-		return nil, err
+// limitReached reports whether a message with the given sequence number would
+// exceed the context's message limit.
+func (ctx *context) limitReached(seq uint64) bool {
+	if seq == math.MaxUint64 {
+		return true
 	}
 
-	ctx.incrementSeq()
-	return pt, nil
+	return ctx.policy.MaxMessages != 0 && seq >= ctx.policy.MaxMessages
 }
 
-func UnmarshalReceiverContext(opaque []byte) (*ReceiverContext, error) {
-	ctx, err := unmarshalContext(contextRoleReceiver, opaque)
-	if err != nil {
-		return nil, err
-	}
+// Expiry returns the time after which the context can no longer be used, or
+// the zero time if the context does not expire.
+func (ctx *context) Expiry() time.Time {
+	defer ctx.lock()()
 
-	return &ReceiverContext{ctx}, nil
+	return ctx.expiry
 }
 
-///////
-// Base
+// SetExpiry sets the time after which Seal and Open fail with
+// ErrContextExpired.  The zero time removes any expiry.  The expiry is
+// serialized by Marshal and inherited by response contexts.
+func (ctx *context) SetExpiry(expiry time.Time) {
+	defer ctx.lock()()
 
-func SetupBaseS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info []byte) ([]byte, *SenderContext, error) {
-	// sharedSecret, enc = Encap(pkR)
-	sharedSecret, enc, err := suite.KEM.Encap(rand, pkR)
-	if err != nil {
-		return nil, nil, err
+	ctx.expiry = expiry
+}
+
+// SetSuiteBinding enables or disables suite binding.  With suite binding,
+// Seal and Open prepend the suite ID ("HPKE" || kem_id || kdf_id ||
+// aead_id) and the mode to the AAD of every message, so that a ciphertext
+// cannot be opened by a context for any other suite or mode, without the
+// application having to bind them itself.  Both sides must agree to use it.
+//
+// The mode is known only to contexts created by setup, so binding must be
+// enabled on such a context or with WithSuiteBinding; it then survives
+// Marshal and is inherited by response contexts.  Enabling it on another
+// context fails.
+func (ctx *context) SetSuiteBinding(enabled bool) error {
+	defer ctx.lock()()
+
+	if !enabled {
+		ctx.binding = nil
+		return nil
 	}
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+	if ctx.binding != nil {
+		return nil
 	}
 
-	params, err := keySchedule(suite, modeBase, sharedSecret, info, defaultPSK(suite), defaultPSKID(suite))
-	if err != nil {
-		return nil, nil, err
+	if !ctx.fromSetup {
+		return fmt.Errorf("Suite binding requires a context created by setup")
 	}
 
-	ctx, err := newSenderContext(suite, setupParams, params)
-	return enc, ctx, err
+	ctx.binding = append(append([]byte{}, ctx.suite.id()...), byte(ctx.mode))
+	return nil
 }
 
-func SetupBaseR(suite CipherSuite, skR KEMPrivateKey, enc, info []byte) (*ReceiverContext, error) {
-	// sharedSecret = Decap(enc, skR)
-	sharedSecret, err := suite.KEM.Decap(enc, skR)
-	if err != nil {
-		return nil, err
-	}
+// SuiteBinding reports whether suite binding is enabled.
+func (ctx *context) SuiteBinding() bool {
+	defer ctx.lock()()
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+	return ctx.binding != nil
+}
+
+// bindAAD returns the AAD passed to the AEAD: the binding prefix, if any,
+// followed by the application's AAD.
+func bindAAD(binding, aad []byte) []byte {
+	if binding == nil {
+		return aad
 	}
 
-	params, err := keySchedule(suite, modeBase, sharedSecret, info, defaultPSK(suite), defaultPSKID(suite))
-	if err != nil {
-		return nil, err
+	bound := make([]byte, 0, len(binding)+len(aad))
+	bound = append(bound, binding...)
+	return append(bound, aad...)
+}
+
+// checkAEAD verifies that the context is live and has an AEAD, i.e., that its
+// suite does not use the export-only AEAD, for which no AEAD key, base nonce,
+// or AEAD instance is ever derived.
+func (ctx *context) checkAEAD() error {
+	if err := ctx.checkLive(); err != nil {
+		return err
 	}
 
-	return newReceiverContext(suite, setupParams, params)
+	if ctx.AEADID == AEAD_EXPORT_ONLY {
+		return fmt.Errorf("Encryption not supported for export-only AEAD")
+	}
+	return nil
 }
 
-//////
-// PSK
+// truncated reports whether ct is too short to hold even an authentication
+// tag, so that it can be rejected before any nonce, AAD, or AEAD work is done.
+// The context must have an AEAD.
+func (ctx *context) truncated(ct []byte) bool {
+	return len(ct) < ctx.aead.Overhead()
+}
 
-func SetupPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, psk, pskID, info []byte) ([]byte, *SenderContext, error) {
-	// sharedSecret, enc = Encap(pkR)
-	sharedSecret, enc, err := suite.KEM.Encap(rand, pkR)
-	if err != nil {
-		return nil, nil, err
+// checkLive verifies that the context has been neither closed nor expired.
+func (ctx *context) checkLive() error {
+	if ctx.closed {
+		return ErrContextClosed
 	}
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+	if !ctx.expiry.IsZero() && !time.Now().Before(ctx.expiry) {
+		return ErrContextExpired
 	}
+	return nil
+}
 
-	params, err := keySchedule(suite, modePSK, sharedSecret, info, psk, pskID)
-	if err != nil {
-		return nil, nil, err
+// checkSeq verifies that the context is still live and that the
+// sequence number space has not been exhausted.
+// Once the sequence number reaches its maximum value, or the limit set by the
+// message policy, the context is in a terminal state and can no longer be
+// used for encryption or decryption.
+func (ctx *context) checkSeq() error {
+	if err := ctx.checkAEAD(); err != nil {
+		return err
 	}
 
-	ctx, err := newSenderContext(suite, setupParams, params)
-	return enc, ctx, err
+	if ctx.limitReached(ctx.Seq) {
+		return ErrMessageLimit
+	}
+	return nil
 }
 
-func SetupPSKR(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info []byte) (*ReceiverContext, error) {
-	// sharedSecret = Decap(enc, skR)
-	sharedSecret, err := suite.KEM.Decap(enc, skR)
-	if err != nil {
-		return nil, err
+// incrementSeq is called once a message has been sealed or opened
+// successfully, so that failed attempts do not count towards the warning.
+func (ctx *context) incrementSeq() {
+	ctx.Seq += 1
+
+	if ctx.policy.OnWarn != nil && ctx.policy.WarnAt != 0 && ctx.Seq == ctx.policy.WarnAt {
+		ctx.policy.OnWarn(ctx.Seq)
 	}
+}
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+// SkipTo advances the sequence number to seq, e.g., to resynchronize after
+// messages have been lost.  The sequence number can never move backwards, as
+// that would result in nonce reuse.
+func (ctx *context) SkipTo(seq uint64) error {
+	defer ctx.lock()()
+
+	if ctx.closed {
+		return ErrContextClosed
 	}
 
-	params, err := keySchedule(suite, modePSK, sharedSecret, info, psk, pskID)
-	if err != nil {
-		return nil, err
+	if seq < ctx.Seq {
+		return fmt.Errorf("Cannot move sequence number backwards [%d] < [%d]", seq, ctx.Seq)
 	}
 
-	return newReceiverContext(suite, setupParams, params)
+	ctx.Seq = seq
+	return nil
 }
 
-///////
-// Auth
+// KeyUpdate replaces the context's key, base nonce, and exporter secret with
+// values derived from the current exporter secret, and resets the sequence
+// number.  The Epoch field counts the number of updates performed; both
+// parties must update at the same point in the message stream in order to
+// remain in sync.
+func (ctx *context) KeyUpdate() error {
+	defer ctx.lock()()
 
-func SetupAuthS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info []byte) ([]byte, *SenderContext, error) {
-	// sharedSecret, enc = AuthEncap(pkR, skS)
-	auth := suite.KEM.(AuthKEMScheme)
-	sharedSecret, enc, err := auth.AuthEncap(rand, pkR, skS)
-	if err != nil {
-		return nil, nil, err
-	}
+	return ctx.keyUpdate()
+}
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+// keyUpdate performs KeyUpdate; the caller holds the lock.
+func (ctx *context) keyUpdate() error {
+	if ctx.closed {
+		return ErrContextClosed
 	}
 
-	params, err := keySchedule(suite, modeAuth, sharedSecret, info, defaultPSK(suite), defaultPSKID(suite))
-	if err != nil {
-		return nil, nil, err
+	epoch := ctx.Epoch + 1
+	if epoch == 0 {
+		return fmt.Errorf("Key update epoch wrapped")
 	}
 
-	ctx, err := newSenderContext(suite, setupParams, params)
-	return enc, ctx, err
-}
+	epochBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(epochBuf, epoch)
+
+	suiteID := ctx.suite.id()
+	kdf := ctx.suite.KDF
+
+	var err error
+	var key, baseNonce []byte
+	var aead cipher.AEAD
+	if ctx.AEADID != AEAD_EXPORT_ONLY {
+		key = kdf.LabeledExpand(ctx.ExporterSecret, suiteID, "upd_key", epochBuf, ctx.suite.AEAD.KeySize())
+		baseNonce = kdf.LabeledExpand(ctx.ExporterSecret, suiteID, "upd_base_nonce", epochBuf, ctx.suite.AEAD.NonceSize())
+		aead, err = ctx.suite.AEAD.New(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx.releaseAEAD()
+	ctx.exportCache.clear()
+	ctx.mac = nil
+	ctx.ExporterSecret = ctx.replaceSecret(ctx.ExporterSecret, kdf.LabeledExpand(ctx.ExporterSecret, suiteID, "upd_exp", epochBuf, kdf.OutputSize()))
+	ctx.Key = ctx.replaceSecret(ctx.Key, key)
+	ctx.BaseNonce = ctx.replaceSecret(ctx.BaseNonce, baseNonce)
+	ctx.aead = aead
+	ctx.Seq = 0
+	ctx.Epoch = epoch
+	return nil
+}
+
+// Export derives a secret of length L from the exporter secret, bound to the
+// given exporter context.  Export panics if the context has been closed or
+// has expired; use ExportSecret where that is not a programming error.
+func (ctx *context) Export(context []byte, L int) []byte {
+	secret, err := ctx.ExportSecret(context, L)
+	if err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// ExportSecret is like Export, but returns ErrContextClosed or
+// ErrContextExpired rather than panicking, and an error if L is out of range.
+func (ctx *context) ExportSecret(context []byte, L int) ([]byte, error) {
+	defer ctx.lock()()
+
+	if err := ctx.checkLive(); err != nil {
+		return nil, err
+	}
+
+	if L < 0 || L > 255*ctx.suite.KDF.OutputSize() {
+		return nil, fmt.Errorf("Invalid exporter length [%d]", L)
+	}
+
+	auditExport(ctx.suite, L)
+	return ctx.cachedExport(context, L), nil
+}
+
+func (ctx *context) export(context []byte, L int) []byte {
+	return ctx.suite.KDF.LabeledExpand(ctx.ExporterSecret, ctx.suite.id(), "sec", context, L)
+}
+
+// AEAD returns a cipher.AEAD view of the context's key and base nonce.  The
+// "nonce" passed to the returned AEAD is the 8-byte big-endian encoding of a
+// sequence number, which is combined with the base nonce as in Seal and Open.
+// The view does not track or advance the context's sequence number, so the
+// caller is responsible for never reusing a sequence number under the same
+// key.  The view is not affected by subsequent calls to KeyUpdate.
+func (ctx *context) AEAD() (cipher.AEAD, error) {
+	defer ctx.lock()()
+
+	if ctx.closed {
+		return nil, ErrContextClosed
+	}
+
+	if ctx.AEADID == AEAD_EXPORT_ONLY {
+		return nil, fmt.Errorf("AEAD view not supported for export-only AEAD")
+	}
+
+	baseNonce := make([]byte, len(ctx.BaseNonce))
+	copy(baseNonce, ctx.BaseNonce)
+	ctx.aeadShared = true
+	return &contextAEAD{aead: ctx.aead, baseNonce: baseNonce, binding: ctx.binding}, nil
+}
+
+type contextAEAD struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	binding   []byte
+}
+
+func (c *contextAEAD) NonceSize() int {
+	return 8
+}
+
+func (c *contextAEAD) Overhead() int {
+	return c.aead.Overhead()
+}
+
+func (c *contextAEAD) nonce(seq []byte) []byte {
+	if len(seq) != c.NonceSize() {
+		panic("hpke: incorrect nonce length given to AEAD view")
+	}
+
+	return xorNonce(c.baseNonce, binary.BigEndian.Uint64(seq))
+}
+
+func (c *contextAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return c.aead.Seal(dst, c.nonce(nonce), plaintext, bindAAD(c.binding, additionalData))
+}
+
+func (c *contextAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return c.aead.Open(dst, c.nonce(nonce), ciphertext, bindAAD(c.binding, additionalData))
+}
+
+// responseContext derives a context for the reverse direction from the
+// exporter, following the pattern in RFC 9180, Section 9.8.
+func (ctx *context) responseContext(role contextRole) (context, error) {
+	return ctx.derivedContext(role, "Response", []byte("response "))
+}
+
+// shardContext derives the context for the shard with the given index.
+func (ctx *context) shardContext(role contextRole, index uint32) (context, error) {
+	// "shard " || uint32 index || " "
+	prefix := []byte("shard 0000 ")
+	binary.BigEndian.PutUint32(prefix[6:], index)
+	return ctx.derivedContext(role, "Shard", prefix)
+}
+
+// derivedContext derives a fresh context from the exporter secret, with the
+// key, base nonce, and exporter secret exported under prefix || "key",
+// prefix || "nonce", and prefix || "exporter".
+func (ctx *context) derivedContext(role contextRole, kind string, prefix []byte) (context, error) {
+	defer ctx.lock()()
+
+	if err := ctx.checkLive(); err != nil {
+		return context{}, err
+	}
+
+	if ctx.AEADID == AEAD_EXPORT_ONLY {
+		return context{}, fmt.Errorf("%s context not supported for export-only AEAD", kind)
+	}
+
+	label := func(name string) []byte {
+		return append(append([]byte{}, prefix...), name...)
+	}
+
+	key := ctx.export(label("key"), ctx.suite.AEAD.KeySize())
+	baseNonce := ctx.export(label("nonce"), ctx.suite.AEAD.NonceSize())
+	exporterSecret := ctx.export(label("exporter"), ctx.suite.KDF.OutputSize())
+
+	aead, err := ctx.suite.AEAD.New(key)
+	if err != nil {
+		return context{}, err
+	}
+
+	response := context{
+		Role:           role,
+		KEMID:          ctx.KEMID,
+		KDFID:          ctx.KDFID,
+		AEADID:         ctx.AEADID,
+		ExporterSecret: exporterSecret,
+		Key:            key,
+		BaseNonce:      baseNonce,
+		Seq:            0,
+		Epoch:          0,
+		aead:           aead,
+		suite:          ctx.suite,
+		expiry:         ctx.expiry,
+		binding:        ctx.binding,
+	}
+
+	return response, nil
+}
+
+// KeyScheduleIntermediates holds the intermediate values computed while
+// setting up a context.  These values are secret; they are exposed only for
+// generating test vectors and debugging interoperability failures.
+type KeyScheduleIntermediates struct {
+	SharedSecret       []byte
+	Enc                []byte
+	KeyScheduleContext []byte
+	Secret             []byte
+}
+
+// DebugIntermediates returns copies of the key schedule intermediates for
+// this context.  They are only available for contexts created by NewSender or
+// NewReceiver with WithIntermediates, not for those created without it, by
+// the Setup* functions, by Unmarshal, or from another context.
+//
+// Applications should not use these values for anything other than
+// debugging.  Exposing them leaks the context's keys.
+func (ctx *context) DebugIntermediates() (KeyScheduleIntermediates, error) {
+	defer ctx.lock()()
+
+	if ctx.contextParams.secret == nil {
+		return KeyScheduleIntermediates{}, fmt.Errorf("Key schedule intermediates not available")
+	}
+
+	dup := func(val []byte) []byte {
+		return append([]byte{}, val...)
+	}
+
+	return KeyScheduleIntermediates{
+		SharedSecret:       dup(ctx.setupParams.sharedSecret),
+		Enc:                dup(ctx.setupParams.enc),
+		KeyScheduleContext: dup(ctx.contextParams.keyScheduleContext),
+		Secret:             dup(ctx.contextParams.secret),
+	}, nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Zeroize wipes the key, base nonce, exporter secret, and any retained key
+// schedule intermediates from memory.  After Zeroize, operations on the
+// context fail with ErrContextClosed.  AEAD views obtained with AEAD are not
+// affected.
+func (ctx *context) Zeroize() {
+	defer ctx.lock()()
+
+	wipe(ctx.ExporterSecret)
+	wipe(ctx.Key)
+	wipe(ctx.BaseNonce)
+	wipe(ctx.nonce)
+	ctx.dropIntermediates()
+	ctx.exportCache.clear()
+
+	ctx.ExporterSecret = nil
+	ctx.Key = nil
+	ctx.BaseNonce = nil
+	ctx.releaseAEAD()
+	ctx.aead = nil
+	ctx.nonce = nil
+	ctx.exportCache = nil
+	ctx.mac = nil
+	ctx.secure.free()
+	ctx.secure = nil
+	ctx.closed = true
+}
+
+// dropIntermediates wipes and forgets the key schedule intermediates.  The
+// encapsulated key is not wiped, since it may belong to the caller.
+func (ctx *context) dropIntermediates() {
+	wipe(ctx.setupParams.sharedSecret)
+	wipe(ctx.contextParams.keyScheduleContext)
+	wipe(ctx.contextParams.secret)
+	ctx.setupParams = setupParameters{}
+	ctx.contextParams = contextParameters{}
+}
+
+// releaseAEAD hands the context's AEAD instance back to the scheme, if the
+// scheme recycles instances and the instance has not been exposed by AEAD.
+func (ctx *context) releaseAEAD() {
+	if releaser, ok := ctx.suite.AEAD.(AEADReleaser); ok && ctx.aead != nil && !ctx.aeadShared {
+		releaser.Release(ctx.aead)
+	}
+
+	ctx.aeadShared = false
+}
+
+// Close zeroizes the context.  It always returns nil, and is provided so that
+// contexts satisfy io.Closer.
+func (ctx *context) Close() error {
+	ctx.Zeroize()
+	return nil
+}
+
+// Marshal serializes the context in a versioned format with an integrity
+// check, for use with UnmarshalSenderContext or UnmarshalReceiverContext.
+func (ctx *context) Marshal() ([]byte, error) {
+	defer ctx.lock()()
+
+	if ctx.closed {
+		return nil, ErrContextClosed
+	}
+
+	size := 1 + 7 + 3 + len(ctx.ExporterSecret) + len(ctx.Key) + len(ctx.BaseNonce) + 16 +
+		8 + 1 + len(ctx.binding) + ctx.suite.KDF.OutputSize()
+	return ctx.appendBinary(make([]byte, 0, size))
+}
+
+// AppendBinary appends the serialization produced by Marshal to b.  If b has
+// enough spare capacity, no memory is allocated, which suits applications
+// that marshal a context for every request.
+func (ctx *context) AppendBinary(b []byte) ([]byte, error) {
+	defer ctx.lock()()
+
+	return ctx.appendBinary(b)
+}
+
+func (ctx *context) appendBinary(b []byte) ([]byte, error) {
+	if ctx.closed {
+		return b, ErrContextClosed
+	}
+
+	if len(ctx.binding) > 255 {
+		return b, fmt.Errorf("Context binding too long to marshal")
+	}
+
+	start := len(b)
+	b = append(b, contextFormatVersion3)
+	b, err := ctx.appendFields(b)
+	if err != nil {
+		return b[:start], err
+	}
+
+	b = appendUint64(b, expiryToUnix(ctx.expiry))
+	b = append(append(b, uint8(len(ctx.binding))), ctx.binding...)
+	return ctx.appendMAC(b, b[start:]), nil
+}
+
+// sealedContextAAD is the associated data for contexts sealed under a KEK.
+var sealedContextAAD = []byte("HPKE sealed context")
+
+func newKEKAEAD(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// MarshalSealed serializes the context as with Marshal, then encrypts the
+// result with AES-GCM under the key-encryption key kek, which must be 16, 24,
+// or 32 bytes long.  This allows contexts to be persisted without storing the
+// key, base nonce, or exporter secret in plaintext.
+func (ctx *context) MarshalSealed(kek []byte) ([]byte, error) {
+	aead, err := newKEKAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ctx.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, sealedContextAAD), nil
+}
+
+func openSealedContext(kek, sealed []byte) ([]byte, error) {
+	aead, err := newKEKAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("Sealed context too short")
+	}
+
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ct, sealedContextAAD)
+}
+
+type SenderContext struct {
+	context
+}
+
+func (ctx *SenderContext) Seal(aad, pt []byte) ([]byte, error) {
+	return ctx.SealTo(nil, aad, pt)
+}
+
+// SealTo encrypts pt and appends the ciphertext to dst, returning the updated
+// slice.  As with cipher.AEAD, dst and pt may overlap exactly or not at all.
+// On error, dst is returned unmodified.
+func (ctx *SenderContext) SealTo(dst, aad, pt []byte) ([]byte, error) {
+	defer ctx.lock()()
+
+	if err := ctx.checkSeq(); err != nil {
+		return dst, err
+	}
+
+	ct := ctx.aead.Seal(dst, ctx.computeNonce(), pt, bindAAD(ctx.binding, aad))
+	ctx.incrementSeq()
+	return ct, nil
+}
+
+// Seq returns the sequence number that will be used for the next call to Seal.
+func (ctx *SenderContext) Seq() uint64 {
+	defer ctx.lock()()
+
+	return ctx.context.Seq
+}
+
+// ResponseReceiver returns a context for decrypting responses sent by the
+// receiver with ReceiverContext.ResponseSender.
+func (ctx *SenderContext) ResponseReceiver() (*ReceiverContext, error) {
+	response, err := ctx.responseContext(contextRoleReceiver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceiverContext{context: response}, nil
+}
+
+// Shard derives the sender context for the shard with the given index.
+// Shards are independent of each other and of the parent context: each has
+// its own key, base nonce, and sequence number, exported from the parent's
+// exporter secret.  Handing one shard to each worker lets several goroutines
+// encrypt independent messages in parallel, without serializing on a single
+// sequence number.  The receiver decrypts messages from a shard with the
+// ReceiverContext.Shard of the same index, so the index must accompany each
+// message.  An index must not be used for more than one shard of the same
+// parent.
+func (ctx *SenderContext) Shard(index uint32) (*SenderContext, error) {
+	shard, err := ctx.shardContext(contextRoleSender, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SenderContext{context: shard}, nil
+}
+
+func UnmarshalSenderContext(opaque []byte) (*SenderContext, error) {
+	ctx, err := unmarshalContext(contextRoleSender, opaque)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SenderContext{ctx}, nil
+}
+
+// UnmarshalSealedSenderContext decrypts and deserializes a context produced by
+// MarshalSealed with the same key-encryption key.
+func UnmarshalSealedSenderContext(kek, sealed []byte) (*SenderContext, error) {
+	opaque, err := openSealedContext(kek, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnmarshalSenderContext(opaque)
+}
+
+type ReceiverContext struct {
+	context
+
+	replay *replayWindow
+}
+
+func (ctx *ReceiverContext) Open(aad, ct []byte) ([]byte, error) {
+	return ctx.OpenTo(nil, aad, ct)
+}
+
+// OpenTo decrypts ct and appends the plaintext to dst, returning the updated
+// slice.  As with cipher.AEAD, dst and ct may overlap exactly or not at all.
+// On error, dst is returned unmodified.
+func (ctx *ReceiverContext) OpenTo(dst, aad, ct []byte) ([]byte, error) {
+	defer ctx.lock()()
+
+	if err := ctx.checkSeq(); err != nil {
+		return dst, err
+	}
+
+	if ctx.truncated(ct) {
+		return dst, ErrOpenFailed
+	}
+
+	pt, err := ctx.aead.Open(dst, ctx.computeNonce(), ct, bindAAD(ctx.binding, aad))
+	if err != nil {
+		return dst, ErrOpenFailed
+	}
+
+	ctx.incrementSeq()
+	return pt, nil
+}
+
+// Seq returns the sequence number that will be used for the next call to Open.
+func (ctx *ReceiverContext) Seq() uint64 {
+	defer ctx.lock()()
+
+	return ctx.context.Seq
+}
+
+// OpenWithSeq decrypts a ciphertext that was produced with the explicitly
+// provided sequence number.  This allows messages that arrive out of order to
+// be decrypted; the context's own sequence number is left unchanged.
+//
+// If replay protection has been enabled with SetReplayWindow, sequence
+// numbers that have already been opened, or that fall behind the window, are
+// rejected with ErrReplayedMessage.
+func (ctx *ReceiverContext) OpenWithSeq(seq uint64, aad, ct []byte) ([]byte, error) {
+	defer ctx.lock()()
+
+	if err := ctx.checkAEAD(); err != nil {
+		return nil, err
+	}
+
+	if ctx.truncated(ct) {
+		return nil, ErrOpenFailed
+	}
+
+	if ctx.limitReached(seq) {
+		return nil, ErrMessageLimit
+	}
+
+	if ctx.replay != nil && !ctx.replay.check(seq) {
+		return nil, ErrReplayedMessage
+	}
+
+	pt, err := ctx.aead.Open(nil, ctx.nonceForSeq(seq), ct, bindAAD(ctx.binding, aad))
+	if err != nil {
+		return nil, ErrOpenFailed
+	}
+
+	if ctx.replay != nil {
+		ctx.replay.accept(seq)
+	}
+
+	return pt, nil
+}
+
+// KeyUpdate updates the context's keys as for SenderContext.  Since
+// sequence numbers start over in the new epoch, it also empties the replay
+// window, if any.
+func (ctx *ReceiverContext) KeyUpdate() error {
+	defer ctx.lock()()
+
+	if err := ctx.keyUpdate(); err != nil {
+		return err
+	}
+
+	if ctx.replay != nil {
+		ctx.replay = newReplayWindow(ctx.replay.size)
+	}
+	return nil
+}
+
+// SetReplayWindow enables replay protection for OpenWithSeq, tracking the
+// most recent `size` sequence numbers.  A size of zero disables replay
+// protection.
+func (ctx *ReceiverContext) SetReplayWindow(size uint64) {
+	defer ctx.lock()()
+
+	if size == 0 {
+		ctx.replay = nil
+		return
+	}
+
+	ctx.replay = newReplayWindow(size)
+}
+
+// replayWindow is a sliding bitmap over the most recently accepted sequence
+// numbers, in the style of the IPsec anti-replay window.
+type replayWindow struct {
+	size    uint64
+	top     uint64
+	started bool
+	bits    []uint64
+}
+
+func newReplayWindow(size uint64) *replayWindow {
+	return &replayWindow{
+		size: size,
+		bits: make([]uint64, (size+63)/64),
+	}
+}
+
+func (w *replayWindow) bit(seq uint64) (int, uint64) {
+	pos := seq % w.size
+	return int(pos / 64), uint64(1) << (pos % 64)
+}
+
+func (w *replayWindow) check(seq uint64) bool {
+	if !w.started || seq > w.top {
+		return true
+	}
+
+	if w.top-seq >= w.size {
+		return false
+	}
+
+	word, mask := w.bit(seq)
+	return w.bits[word]&mask == 0
+}
+
+func (w *replayWindow) accept(seq uint64) {
+	switch {
+	case !w.started || (seq > w.top && seq-w.top >= w.size):
+		for i := range w.bits {
+			w.bits[i] = 0
+		}
+	case seq > w.top:
+		for s := w.top + 1; s < seq; s++ {
+			word, mask := w.bit(s)
+			w.bits[word] &^= mask
+		}
+	}
+
+	if !w.started || seq > w.top {
+		w.top = seq
+		w.started = true
+	}
+
+	word, mask := w.bit(seq)
+	w.bits[word] |= mask
+}
+
+// ResponseSender returns a context for encrypting responses back to the
+// sender, who decrypts them with SenderContext.ResponseReceiver.
+func (ctx *ReceiverContext) ResponseSender() (*SenderContext, error) {
+	response, err := ctx.responseContext(contextRoleSender)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SenderContext{response}, nil
+}
+
+// Shard derives the receiver context for the shard with the given index,
+// which decrypts the messages encrypted by SenderContext.Shard with the same
+// index.
+func (ctx *ReceiverContext) Shard(index uint32) (*ReceiverContext, error) {
+	shard, err := ctx.shardContext(contextRoleReceiver, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceiverContext{context: shard}, nil
+}
+
+func UnmarshalReceiverContext(opaque []byte) (*ReceiverContext, error) {
+	ctx, err := unmarshalContext(contextRoleReceiver, opaque)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceiverContext{context: ctx}, nil
+}
+
+// UnmarshalSealedReceiverContext decrypts and deserializes a context produced
+// by MarshalSealed with the same key-encryption key.
+func UnmarshalSealedReceiverContext(kek, sealed []byte) (*ReceiverContext, error) {
+	opaque, err := openSealedContext(kek, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnmarshalReceiverContext(opaque)
+}
+
+////////
+// Setup
+
+type setupConfig struct {
+	rand  io.Reader
+	info  []byte
+	psk   []byte
+	pskID []byte
+	skS   KEMPrivateKey
+	pkS   KEMPublicKey
+
+	ikmE         []byte
+	pskStore     PSKStore
+	replayWindow uint64
+	policy       MessagePolicy
+	expiry       time.Time
+	exportCache  int
+	locking      bool
+	suiteBinding bool
+	secureMemory bool
+
+	intermediates bool
+
+	withPSK  bool
+	withAuth bool
+}
+
+// SetupOption configures the mode and inputs of an HPKE setup performed with
+// NewSender or NewReceiver.
+type SetupOption func(*setupConfig)
+
+// WithInfo sets the application-supplied info string.
+func WithInfo(info []byte) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.info = info
+	}
+}
+
+// WithRandom sets the source of randomness used for encapsulation.  If not
+// provided, crypto/rand.Reader is used.
+func WithRandom(rand io.Reader) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.rand = rand
+	}
+}
+
+// WithEphemeralSeed derives the sender's ephemeral key pair from ikmE rather
+// than generating it from randomness.  The KEM must implement
+// DeterministicKEMScheme.  Reusing a seed across encapsulations is insecure;
+// this option is intended for test vectors and reproducible tests.
+func WithEphemeralSeed(ikmE []byte) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.ikmE = ikmE
+	}
+}
+
+// WithPSK selects one of the PSK modes, using the given pre-shared key and
+// its identifier.
+func WithPSK(psk, pskID []byte) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.psk = psk
+		cfg.pskID = pskID
+		cfg.withPSK = true
+	}
+}
+
+// PSKStore resolves a pre-shared key from its identifier, allowing a receiver
+// with many provisioned PSKs to select the right one at setup time.
+type PSKStore interface {
+	LookupPSK(pskID []byte) ([]byte, error)
+}
+
+// PSKStoreFunc adapts an ordinary function to the PSKStore interface.
+type PSKStoreFunc func(pskID []byte) ([]byte, error)
+
+func (f PSKStoreFunc) LookupPSK(pskID []byte) ([]byte, error) {
+	return f(pskID)
+}
+
+// WithPSKStore selects one of the PSK modes, resolving the pre-shared key for
+// pskID from the store when the context is set up.
+func WithPSKStore(store PSKStore, pskID []byte) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.pskStore = store
+		cfg.pskID = pskID
+		cfg.withPSK = true
+	}
+}
+
+// WithSenderAuth selects one of the Auth modes on the sender side,
+// authenticating with the sender's private key.
+func WithSenderAuth(skS KEMPrivateKey) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.skS = skS
+		cfg.withAuth = true
+	}
+}
+
+// WithSenderPublicKey selects one of the Auth modes on the receiver side,
+// authenticating the sender against its public key.
+func WithSenderPublicKey(pkS KEMPublicKey) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.pkS = pkS
+		cfg.withAuth = true
+	}
+}
+
+// WithReplayWindow enables replay protection on a receiver context; see
+// ReceiverContext.SetReplayWindow.  It has no effect on sender contexts.
+func WithReplayWindow(size uint64) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.replayWindow = size
+	}
+}
+
+// WithMessagePolicy applies a message-count policy to the resulting context;
+// see SetMessagePolicy.
+func WithMessagePolicy(policy MessagePolicy) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.policy = policy
+	}
+}
+
+// WithExpiry sets the time after which the resulting context can no longer be
+// used; see SetExpiry.
+func WithExpiry(expiry time.Time) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.expiry = expiry
+	}
+}
+
+// WithSuiteBinding binds the suite and mode into the AAD of every message
+// on the resulting context; see SetSuiteBinding.
+func WithSuiteBinding() SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.suiteBinding = true
+	}
+}
+
+// WithExportCache caches up to size exported secrets on the resulting
+// context; see SetExportCache.
+func WithExportCache(size int) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.exportCache = size
+	}
+}
+
+// WithSecureMemory keeps the secrets of the resulting context in locked
+// memory; see EnableSecureMemory.  Setup fails if secure memory cannot be
+// allocated.
+func WithSecureMemory() SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.secureMemory = true
+	}
+}
+
+// WithIntermediates keeps the key schedule intermediates of the resulting
+// context, i.e., the shared secret, encapsulated key, key schedule context,
+// and secret, for DebugIntermediates.  Without it, they are wiped once the
+// context's keys have been derived.  It is intended for generating test
+// vectors and debugging interoperability failures, not for production use.
+func WithIntermediates() SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.intermediates = true
+	}
+}
+
+// WithLocking makes the resulting context safe for concurrent use; see
+// EnableLocking.
+func WithLocking() SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.locking = true
+	}
+}
+
+func newSetupConfig(suite CipherSuite, opts []SetupOption) (setupConfig, error) {
+	cfg := setupConfig{
+		rand:  rand.Reader,
+		psk:   defaultPSK(suite),
+		pskID: defaultPSKID(suite),
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.pskStore != nil {
+		psk, err := cfg.pskStore.LookupPSK(cfg.pskID)
+		if err != nil {
+			return setupConfig{}, err
+		}
+
+		cfg.psk = psk
+	}
+
+	return cfg, nil
+}
+
+func (cfg setupConfig) mode() Mode {
+	switch {
+	case cfg.withAuth && cfg.withPSK:
+		return ModeAuthPSK
+	case cfg.withAuth:
+		return ModeAuth
+	case cfg.withPSK:
+		return ModePSK
+	default:
+		return ModeBase
+	}
+}
+
+func authKEMScheme(suite CipherSuite) (AuthKEMScheme, error) {
+	auth, ok := suite.KEM.(AuthKEMScheme)
+	if !ok {
+		return nil, fmt.Errorf("%w: KEM does not support authentication [%s]", ErrUnsupportedSuite, suite.KEM.ID())
+	}
+
+	return auth, nil
+}
+
+func deterministicKEMScheme(suite CipherSuite) (DeterministicKEMScheme, error) {
+	det, ok := suite.KEM.(DeterministicKEMScheme)
+	if !ok {
+		return nil, fmt.Errorf("%w: KEM does not support deterministic encapsulation [%s]", ErrUnsupportedSuite, suite.KEM.ID())
+	}
+
+	return det, nil
+}
+
+// encap performs the encapsulation appropriate to the configured mode,
+// returning the shared secret and the encapsulated key.
+func (cfg setupConfig) encap(suite CipherSuite, pkR KEMPublicKey) ([]byte, []byte, error) {
+	var det DeterministicKEMScheme
+	if cfg.ikmE != nil {
+		var err error
+		det, err = deterministicKEMScheme(suite)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !cfg.withAuth {
+		// sharedSecret, enc = Encap(pkR)
+		if det != nil {
+			return det.DeriveEncap(cfg.ikmE, pkR)
+		}
+		return suite.KEM.Encap(cfg.rand, pkR)
+	}
+
+	if cfg.skS == nil {
+		return nil, nil, fmt.Errorf("Missing sender private key")
+	}
+
+	auth, err := authKEMScheme(suite)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// sharedSecret, enc = AuthEncap(pkR, skS)
+	if det != nil {
+		return det.DeriveAuthEncap(cfg.ikmE, pkR, cfg.skS)
+	}
+	return auth.AuthEncap(cfg.rand, pkR, cfg.skS)
+}
+
+// decap performs the decapsulation appropriate to the configured mode,
+// returning the shared secret.
+func (cfg setupConfig) decap(suite CipherSuite, skR KEMPrivateKey, enc []byte) ([]byte, error) {
+	if !cfg.withAuth {
+		// sharedSecret = Decap(enc, skR)
+		if decapsulator, ok := skR.(KEMDecapsulator); ok {
+			return decapsulator.Decap(enc)
+		}
+
+		return suite.KEM.Decap(enc, skR)
+	}
+
+	if cfg.pkS == nil {
+		return nil, fmt.Errorf("Missing sender public key")
+	}
+
+	if _, ok := skR.(KEMDecapsulator); ok {
+		decapsulator, ok := skR.(AuthKEMDecapsulator)
+		if !ok {
+			return nil, fmt.Errorf("Receiver key does not support AuthDecap")
+		}
+
+		// sharedSecret = AuthDecap(enc, skR, pkS)
+		return decapsulator.AuthDecap(enc, cfg.pkS)
+	}
+
+	auth, err := authKEMScheme(suite)
+	if err != nil {
+		return nil, err
+	}
 
-func SetupAuthR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info []byte) (*ReceiverContext, error) {
 	// sharedSecret = AuthDecap(enc, skR, pkS)
-	auth := suite.KEM.(AuthKEMScheme)
-	sharedSecret, err := auth.AuthDecap(enc, skR, pkS)
+	return auth.AuthDecap(enc, skR, cfg.pkS)
+}
+
+// NewSender sets up a sender context for the recipient public key pkR,
+// returning the encapsulated key along with the context.  The mode is
+// determined by the options provided.
+func NewSender(suite CipherSuite, pkR KEMPublicKey, opts ...SetupOption) ([]byte, *SenderContext, error) {
+	cfg, err := newSetupConfig(suite, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := &SenderContext{}
+	enc, err := cfg.setupSender(ctx, suite, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ctx, nil
+}
+
+// NewSenders sets up a sender context for each of the recipient public keys
+// pkRs, as NewSender does for one, returning the encapsulated keys and the
+// contexts in the same order.  The options are processed once, and the
+// randomness for all of the ephemeral keys is read at once, so fanning out
+// to many recipients costs less than calling NewSender for each.  KEMs that
+// do not support deterministic encapsulation fall back to reading randomness
+// per recipient.  WithEphemeralSeed cannot be used, since each recipient
+// needs its own ephemeral key.
+func NewSenders(suite CipherSuite, pkRs []KEMPublicKey, opts ...SetupOption) ([][]byte, []*SenderContext, error) {
+	cfg, err := newSetupConfig(suite, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.ikmE != nil {
+		return nil, nil, fmt.Errorf("Ephemeral seed not supported for multiple recipients")
+	}
+
+	var seeds []byte
+	seedSize := suite.KEM.PrivateKeySize()
+	if _, ok := suite.KEM.(DeterministicKEMScheme); ok {
+		seeds = make([]byte, len(pkRs)*seedSize)
+		if _, err := io.ReadFull(cfg.rand, seeds); err != nil {
+			return nil, nil, err
+		}
+		defer wipe(seeds)
+	}
+
+	encs := make([][]byte, len(pkRs))
+	ctxs := make([]*SenderContext, len(pkRs))
+	for i, pkR := range pkRs {
+		recipientCfg := cfg
+		if seeds != nil {
+			recipientCfg.ikmE = seeds[i*seedSize : (i+1)*seedSize]
+		}
+
+		ctxs[i] = &SenderContext{}
+		encs[i], err = recipientCfg.setupSender(ctxs[i], suite, pkR)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return encs, ctxs, nil
+}
+
+// setupSender performs the sender setup for a single recipient, storing the
+// resulting state in ctx.
+func (cfg setupConfig) setupSender(ctx *SenderContext, suite CipherSuite, pkR KEMPublicKey) ([]byte, error) {
+	sharedSecret, enc, err := cfg.encap(suite, pkR)
+	auditSetup(AuditEncap, suite, cfg.mode(), enc, err)
 	if err != nil {
 		return nil, err
 	}
@@ -502,56 +2054,325 @@ func SetupAuthR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, inf
 		enc:          enc,
 	}
 
-	params, err := keySchedule(suite, modeAuth, sharedSecret, info, defaultPSK(suite), defaultPSKID(suite))
+	params, err := keySchedule(suite, cfg.mode(), sharedSecret, cfg.info, cfg.psk, cfg.pskID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.context, err = newContext(contextRoleSender, suite, setupParams, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.applyConfig(cfg); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// Reset re-initializes the context as NewSender would, returning the new
+// encapsulated key.  The previous state is zeroized first, and the context's
+// storage is reused, so that busy servers can keep contexts in a sync.Pool
+// rather than allocating one per encapsulation.  The context must not be in
+// use by other goroutines during Reset.  If Reset fails, the context is left
+// closed.
+func (ctx *SenderContext) Reset(suite CipherSuite, pkR KEMPublicKey, opts ...SetupOption) ([]byte, error) {
+	nonce := ctx.reusableNonce()
+	ctx.Zeroize()
+
+	cfg, err := newSetupConfig(suite, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := cfg.setupSender(ctx, suite, pkR)
+	if err != nil {
+		ctx.Zeroize()
+		return nil, err
+	}
+
+	ctx.reuseNonce(nonce)
+	return enc, nil
+}
+
+// applyConfig applies the options that configure a freshly set-up context.
+func (ctx *context) applyConfig(cfg setupConfig) error {
+	if !cfg.intermediates {
+		ctx.dropIntermediates()
+	}
+
+	if cfg.locking {
+		ctx.EnableLocking()
+	}
+
+	ctx.SetMessagePolicy(cfg.policy)
+	ctx.SetExpiry(cfg.expiry)
+	ctx.SetExportCache(cfg.exportCache)
+	if cfg.secureMemory {
+		if err := ctx.EnableSecureMemory(); err != nil {
+			return err
+		}
+	}
+	return ctx.SetSuiteBinding(cfg.suiteBinding)
+}
+
+// reusableNonce returns the nonce buffer to carry over to a new state, or nil
+// if it is in secure memory, which Zeroize releases.
+func (ctx *context) reusableNonce() []byte {
+	if ctx.secure != nil {
+		return nil
+	}
+	return ctx.nonce
+}
+
+// reuseNonce adopts a nonce buffer left over from a previous state, if it
+// has the right length and the context does not keep its own in secure
+// memory.
+func (ctx *context) reuseNonce(nonce []byte) {
+	if ctx.secure == nil && len(nonce) == len(ctx.BaseNonce) {
+		ctx.nonce = nonce
+	}
+}
+
+// NewReceiver sets up a receiver context from the encapsulated key enc using
+// the recipient private key skR.  The mode is determined by the options
+// provided.
+func NewReceiver(suite CipherSuite, skR KEMPrivateKey, enc []byte, opts ...SetupOption) (*ReceiverContext, error) {
+	cfg, err := newSetupConfig(suite, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return newReceiverContext(suite, setupParams, params)
+	ctx := &ReceiverContext{}
+	if err := cfg.setupReceiver(ctx, suite, skR, enc); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// setupReceiver performs the receiver setup, storing the resulting state in
+// ctx.
+func (cfg setupConfig) setupReceiver(ctx *ReceiverContext, suite CipherSuite, skR KEMPrivateKey, enc []byte) error {
+	sharedSecret, err := cfg.decap(suite, skR, enc)
+	auditSetup(AuditDecap, suite, cfg.mode(), enc, err)
+	if err != nil {
+		return err
+	}
+
+	setupParams := setupParameters{
+		sharedSecret: sharedSecret,
+		enc:          enc,
+	}
+
+	params, err := keySchedule(suite, cfg.mode(), sharedSecret, cfg.info, cfg.psk, cfg.pskID)
+	if err != nil {
+		return err
+	}
+
+	ctx.context, err = newContext(contextRoleReceiver, suite, setupParams, params)
+	if err != nil {
+		return err
+	}
+
+	// Export-only contexts never open messages, so need no replay state
+	if ctx.AEADID != AEAD_EXPORT_ONLY {
+		ctx.SetReplayWindow(cfg.replayWindow)
+	}
+	return ctx.applyConfig(cfg)
+}
+
+// Reset re-initializes the context as NewReceiver would, reusing its storage;
+// see SenderContext.Reset.
+func (ctx *ReceiverContext) Reset(suite CipherSuite, skR KEMPrivateKey, enc []byte, opts ...SetupOption) error {
+	nonce := ctx.reusableNonce()
+	ctx.Zeroize()
+
+	cfg, err := newSetupConfig(suite, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.setupReceiver(ctx, suite, skR, enc); err != nil {
+		ctx.Zeroize()
+		return err
+	}
+
+	ctx.reuseNonce(nonce)
+	return nil
+}
+
+///////
+// Base
+
+func SetupBaseS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info []byte) ([]byte, *SenderContext, error) {
+	return NewSender(suite, pkR, WithRandom(rand), WithInfo(info))
+}
+
+func SetupBaseR(suite CipherSuite, skR KEMPrivateKey, enc, info []byte) (*ReceiverContext, error) {
+	return NewReceiver(suite, skR, enc, WithInfo(info))
+}
+
+//////
+// PSK
+
+func SetupPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, psk, pskID, info []byte) ([]byte, *SenderContext, error) {
+	return NewSender(suite, pkR, WithRandom(rand), WithPSK(psk, pskID), WithInfo(info))
+}
+
+func SetupPSKR(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info []byte) (*ReceiverContext, error) {
+	return NewReceiver(suite, skR, enc, WithPSK(psk, pskID), WithInfo(info))
+}
+
+func SetupPSKRWithStore(suite CipherSuite, skR KEMPrivateKey, enc []byte, store PSKStore, pskID, info []byte) (*ReceiverContext, error) {
+	return NewReceiver(suite, skR, enc, WithPSKStore(store, pskID), WithInfo(info))
+}
+
+///////
+// Auth
+
+func SetupAuthS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info []byte) ([]byte, *SenderContext, error) {
+	return NewSender(suite, pkR, WithRandom(rand), WithSenderAuth(skS), WithInfo(info))
+}
+
+func SetupAuthR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info []byte) (*ReceiverContext, error) {
+	return NewReceiver(suite, skR, enc, WithSenderPublicKey(pkS), WithInfo(info))
 }
 
 /////////////
 // PSK + Auth
 
 func SetupAuthPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info []byte) ([]byte, *SenderContext, error) {
-	// sharedSecret, enc = AuthEncap(pkR, skS)
-	auth := suite.KEM.(AuthKEMScheme)
-	sharedSecret, enc, err := auth.AuthEncap(rand, pkR, skS)
+	return NewSender(suite, pkR, WithRandom(rand), WithSenderAuth(skS), WithPSK(psk, pskID), WithInfo(info))
+}
+
+func SetupAuthPSKR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info []byte) (*ReceiverContext, error) {
+	return NewReceiver(suite, skR, enc, WithSenderPublicKey(pkS), WithPSK(psk, pskID), WithInfo(info))
+}
+
+func SetupAuthPSKRWithStore(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc []byte, store PSKStore, pskID, info []byte) (*ReceiverContext, error) {
+	return NewReceiver(suite, skR, enc, WithSenderPublicKey(pkS), WithPSKStore(store, pskID), WithInfo(info))
+}
+
+//////////////
+// Single-shot
+
+func Seal(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupBaseS(suite, rand, pkR, info)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+	ct, err := ctx.Seal(aad, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ct, nil
+}
+
+func Open(suite CipherSuite, skR KEMPrivateKey, enc, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupBaseR(suite, skR, enc, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.Open(aad, ct)
+}
+
+func SealPSK(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, psk, pskID, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupPSKS(suite, rand, pkR, psk, pskID, info)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	params, err := keySchedule(suite, modeAuthPSK, sharedSecret, info, psk, pskID)
+	ct, err := ctx.Seal(aad, pt)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	ctx, err := newSenderContext(suite, setupParams, params)
-	return enc, ctx, err
+	return enc, ct, nil
 }
 
-func SetupAuthPSKR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info []byte) (*ReceiverContext, error) {
-	// sharedSecret = AuthDecap(enc, skR, pkS)
-	auth := suite.KEM.(AuthKEMScheme)
-	sharedSecret, err := auth.AuthDecap(enc, skR, pkS)
+func OpenPSK(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupPSKR(suite, skR, enc, psk, pskID, info)
 	if err != nil {
 		return nil, err
 	}
 
-	setupParams := setupParameters{
-		sharedSecret: sharedSecret,
-		enc:          enc,
+	return ctx.Open(aad, ct)
+}
+
+func SealAuth(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupAuthS(suite, rand, pkR, skS, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := ctx.Seal(aad, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ct, nil
+}
+
+func OpenAuth(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupAuthR(suite, skR, pkS, enc, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.Open(aad, ct)
+}
+
+func SealAuthPSK(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupAuthPSKS(suite, rand, pkR, skS, psk, pskID, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := ctx.Seal(aad, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ct, nil
+}
+
+func OpenAuthPSK(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupAuthPSKR(suite, skR, pkS, enc, psk, pskID, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.Open(aad, ct)
+}
+
+// SendExport performs an encapsulation and returns the encapsulated key
+// together with a secret exported from the resulting context, without
+// exposing the context itself.  The mode and other inputs are configured
+// with the same options as NewSender.
+func SendExport(suite CipherSuite, pkR KEMPublicKey, exporterContext []byte, L int, opts ...SetupOption) ([]byte, []byte, error) {
+	enc, ctx, err := NewSender(suite, pkR, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret, err := ctx.ExportSecret(exporterContext, L)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	params, err := keySchedule(suite, modeAuthPSK, sharedSecret, info, psk, pskID)
+	return enc, secret, nil
+}
+
+// ReceiveExport is the receiver-side counterpart to SendExport.
+func ReceiveExport(suite CipherSuite, skR KEMPrivateKey, enc, exporterContext []byte, L int, opts ...SetupOption) ([]byte, error) {
+	ctx, err := NewReceiver(suite, skR, enc, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return newReceiverContext(suite, setupParams, params)
+	return ctx.ExportSecret(exporterContext, L)
 }
@@ -2,13 +2,22 @@ package hpke
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/cisco/go-hpke/testvectors"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 var (
@@ -28,6 +37,9 @@ var (
 const (
 	outputTestVectorEnvironmentKey = "HPKE_TEST_VECTORS_OUT"
 	inputTestVectorEnvironmentKey  = "HPKE_TEST_VECTORS_IN"
+	rfcTestVectorEnvironmentKey    = "HPKE_RFC_VECTORS_IN"
+	interopVectorEnvironmentKey    = "HPKE_INTEROP_VECTORS_IN"
+	interopFormatEnvironmentKey    = "HPKE_INTEROP_VECTORS_FORMAT"
 	testVectorEncryptionCount      = 257
 	testVectorExportLength         = 32
 )
@@ -45,6 +57,19 @@ func fatalOnError(t *testing.T, err error, msg string) {
 	}
 }
 
+// mustAssembleSuite looks up a cipher suite for a test.  Under the
+// hpke_minimal build tag, tests that need a suite outside the minimal profile
+// are skipped rather than failed.
+func mustAssembleSuite(t *testing.T, kemID KEMID, kdfID KDFID, aeadID AEADID) CipherSuite {
+	suite, err := AssembleCipherSuite(kemID, kdfID, aeadID)
+	if err != nil && minimalProfile {
+		t.Skipf("Suite not in the minimal profile: %v", err)
+	}
+
+	fatalOnError(t, err, "Error looking up ciphersuite")
+	return suite
+}
+
 func mustUnhex(t *testing.T, h string) []byte {
 	out, err := hex.DecodeString(h)
 	fatalOnError(t, err, "Unhex failed")
@@ -81,6 +106,12 @@ func mustSerializePub(suite CipherSuite, pub KEMPublicKey) string {
 	return mustHex(suite.KEM.SerializePublicKey(pub))
 }
 
+func randomBytes(size int) []byte {
+	out := make([]byte, size)
+	rand.Read(out)
+	return out
+}
+
 func mustGenerateKeyPair(t *testing.T, suite CipherSuite) (KEMPrivateKey, KEMPublicKey, []byte) {
 	ikm := make([]byte, suite.KEM.PrivateKeySize())
 	rand.Reader.Read(ikm)
@@ -93,7 +124,7 @@ func mustGenerateKeyPair(t *testing.T, suite CipherSuite) (KEMPrivateKey, KEMPub
 // Assertions
 func assert(t *testing.T, suite CipherSuite, msg string, test bool) {
 	if !test {
-		t.Fatalf("[%04x, %04x, %04x] %s", suite.KEM.ID(), suite.KDF.ID(), suite.AEAD.ID(), msg)
+		t.Fatalf("[%04x, %04x, %04x] %s", uint16(suite.KEM.ID()), uint16(suite.KDF.ID()), uint16(suite.AEAD.ID()), msg)
 	}
 }
 
@@ -117,6 +148,7 @@ func assertCipherContextEqual(t *testing.T, suite CipherSuite, msg string, lhs,
 	assertBytesEqual(t, suite, fmt.Sprintf("%s: %s", msg, "key"), lhs.Key, rhs.Key)
 	assertBytesEqual(t, suite, fmt.Sprintf("%s: %s", msg, "base_nonce"), lhs.BaseNonce, rhs.BaseNonce)
 	assert(t, suite, fmt.Sprintf("%s: %s", msg, "sequence number"), lhs.Seq == rhs.Seq)
+	assert(t, suite, fmt.Sprintf("%s: %s", msg, "epoch"), lhs.Epoch == rhs.Epoch)
 
 	// Verify that the internal representation of the cipher suite matches.
 	assert(t, suite, fmt.Sprintf("%s: %s", msg, "KEM scheme representation"), lhs.suite.KEM.ID() == rhs.suite.KEM.ID())
@@ -206,10 +238,10 @@ func (etv *exporterTestVector) UnmarshalJSON(data []byte) error {
 // HPKE test vector structures
 type rawTestVector struct {
 	// Parameters
-	Mode   Mode   `json:"mode"`
-	KEMID  KEMID  `json:"kem_id"`
-	KDFID  KDFID  `json:"kdf_id"`
-	AEADID AEADID `json:"aead_id"`
+	Mode   uint8  `json:"mode"`
+	KEMID  uint16 `json:"kem_id"`
+	KDFID  uint16 `json:"kdf_id"`
+	AEADID uint16 `json:"aead_id"`
 	Info   string `json:"info"`
 
 	// Private keys
@@ -281,10 +313,10 @@ type testVector struct {
 
 func (tv testVector) MarshalJSON() ([]byte, error) {
 	return json.Marshal(rawTestVector{
-		Mode:   tv.mode,
-		KEMID:  tv.kem_id,
-		KDFID:  tv.kdf_id,
-		AEADID: tv.aead_id,
+		Mode:   uint8(tv.mode),
+		KEMID:  uint16(tv.kem_id),
+		KDFID:  uint16(tv.kdf_id),
+		AEADID: uint16(tv.aead_id),
 		Info:   mustHex(tv.info),
 
 		IKMR:  mustHex(tv.ikmR),
@@ -320,18 +352,18 @@ func (tv *testVector) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	tv.mode = raw.Mode
-	tv.kem_id = raw.KEMID
-	tv.kdf_id = raw.KDFID
-	tv.aead_id = raw.AEADID
+	tv.mode = Mode(raw.Mode)
+	tv.kem_id = KEMID(raw.KEMID)
+	tv.kdf_id = KDFID(raw.KDFID)
+	tv.aead_id = AEADID(raw.AEADID)
 	tv.info = mustUnhex(tv.t, raw.Info)
 
-	tv.suite, err = AssembleCipherSuite(raw.KEMID, raw.KDFID, raw.AEADID)
+	tv.suite, err = AssembleCipherSuite(tv.kem_id, tv.kdf_id, tv.aead_id)
 	if err != nil {
 		return err
 	}
 
-	modeRequiresSenderKey := (tv.mode == modeAuth || tv.mode == modeAuthPSK)
+	modeRequiresSenderKey := (tv.mode == ModeAuth || tv.mode == ModeAuthPSK)
 	tv.skR = mustDeserializePriv(tv.t, tv.suite, raw.SKR, true)
 	tv.skS = mustDeserializePriv(tv.t, tv.suite, raw.SKS, modeRequiresSenderKey)
 	tv.skE = mustDeserializePriv(tv.t, tv.suite, raw.SKE, true)
@@ -386,59 +418,93 @@ func (tva *testVectorArray) UnmarshalJSON(data []byte) error {
 type setupMode struct {
 	Mode Mode
 	OK   func(suite CipherSuite) bool
-	I    func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte) ([]byte, *SenderContext, error)
-	R    func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte) (*ReceiverContext, error)
+	I    func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error)
+	R    func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error)
 }
 
 var setupModes = map[Mode]setupMode{
-	modeBase: {
-		Mode: modeBase,
+	ModeBase: {
+		Mode: ModeBase,
 		OK:   func(suite CipherSuite) bool { return true },
-		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte) ([]byte, *SenderContext, error) {
-			return SetupBaseS(suite, rand.Reader, pkR, info)
+		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+			return NewSender(suite, pkR, append(opts, WithInfo(info))...)
 		},
-		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte) (*ReceiverContext, error) {
-			return SetupBaseR(suite, skR, enc, info)
+		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+			return NewReceiver(suite, skR, enc, append(opts, WithInfo(info))...)
 		},
 	},
-	modePSK: {
-		Mode: modePSK,
+	ModePSK: {
+		Mode: ModePSK,
 		OK:   func(suite CipherSuite) bool { return true },
-		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte) ([]byte, *SenderContext, error) {
-			return SetupPSKS(suite, rand.Reader, pkR, psk, psk_id, info)
+		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+			return NewSender(suite, pkR, append(opts, WithPSK(psk, psk_id), WithInfo(info))...)
 		},
-		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte) (*ReceiverContext, error) {
-			return SetupPSKR(suite, skR, enc, psk, psk_id, info)
+		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+			return NewReceiver(suite, skR, enc, append(opts, WithPSK(psk, psk_id), WithInfo(info))...)
 		},
 	},
-	modeAuth: {
-		Mode: modeAuth,
+	ModeAuth: {
+		Mode: ModeAuth,
 		OK: func(suite CipherSuite) bool {
 			_, ok := suite.KEM.(AuthKEMScheme)
 			return ok
 		},
-		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte) ([]byte, *SenderContext, error) {
-			return SetupAuthS(suite, rand.Reader, pkR, skS, info)
+		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+			return NewSender(suite, pkR, append(opts, WithSenderAuth(skS), WithInfo(info))...)
 		},
-		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte) (*ReceiverContext, error) {
-			return SetupAuthR(suite, skR, pkS, enc, info)
+		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+			return NewReceiver(suite, skR, enc, append(opts, WithSenderPublicKey(pkS), WithInfo(info))...)
 		},
 	},
-	modeAuthPSK: {
-		Mode: modeAuthPSK,
+	ModeAuthPSK: {
+		Mode: ModeAuthPSK,
 		OK: func(suite CipherSuite) bool {
 			_, ok := suite.KEM.(AuthKEMScheme)
 			return ok
 		},
-		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte) ([]byte, *SenderContext, error) {
-			return SetupAuthPSKS(suite, rand.Reader, pkR, skS, psk, psk_id, info)
+		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+			return NewSender(suite, pkR, append(opts, WithSenderAuth(skS), WithPSK(psk, psk_id), WithInfo(info))...)
 		},
-		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte) (*ReceiverContext, error) {
-			return SetupAuthPSKR(suite, skR, pkS, enc, psk, psk_id, info)
+		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+			return NewReceiver(suite, skR, enc, append(opts, WithSenderPublicKey(pkS), WithPSK(psk, psk_id), WithInfo(info))...)
 		},
 	},
 }
 
+// legacySenders sets up sender contexts with the Setup*S functions, which
+// predate NewSender and remain as wrappers around it.
+var legacySenders = map[Mode]func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error){
+	ModeBase: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+		return SetupBaseS(suite, rand.Reader, pkR, info)
+	},
+	ModePSK: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+		return SetupPSKS(suite, rand.Reader, pkR, psk, psk_id, info)
+	},
+	ModeAuth: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+		return SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	},
+	ModeAuthPSK: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, psk_id []byte, opts ...SetupOption) ([]byte, *SenderContext, error) {
+		return SetupAuthPSKS(suite, rand.Reader, pkR, skS, psk, psk_id, info)
+	},
+}
+
+// legacyReceivers is the counterpart of legacySenders for the Setup*R
+// functions.
+var legacyReceivers = map[Mode]func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error){
+	ModeBase: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+		return SetupBaseR(suite, skR, enc, info)
+	},
+	ModePSK: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+		return SetupPSKR(suite, skR, enc, psk, psk_id, info)
+	},
+	ModeAuth: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+		return SetupAuthR(suite, skR, pkS, enc, info)
+	},
+	ModeAuthPSK: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, psk_id []byte, opts ...SetupOption) (*ReceiverContext, error) {
+		return SetupAuthPSKR(suite, skR, pkS, enc, psk, psk_id, info)
+	},
+}
+
 ///////
 // Direct tests
 
@@ -452,7 +518,7 @@ type roundTripTest struct {
 func (rtt roundTripTest) Test(t *testing.T) {
 	suite, err := AssembleCipherSuite(rtt.kem_id, rtt.kdf_id, rtt.aead_id)
 	if err != nil {
-		t.Fatalf("[%04x, %04x, %04x] Error looking up ciphersuite: %v", rtt.kem_id, rtt.kdf_id, rtt.aead_id, err)
+		t.Fatalf("[%04x, %04x, %04x] Error looking up ciphersuite: %v", uint16(rtt.kem_id), uint16(rtt.kdf_id), uint16(rtt.aead_id), err)
 	}
 
 	if !rtt.setup.OK(suite) {
@@ -471,7 +537,9 @@ func (rtt roundTripTest) Test(t *testing.T) {
 	// Verify encryption functionality, if applicable
 	if rtt.aead_id != AEAD_EXPORT_ONLY {
 		for range make([]struct{}, rtts) {
-			encrypted := ctxS.Seal(aad, original)
+			encrypted, err := ctxS.Seal(aad, original)
+			assertNotError(t, suite, "Error in Seal", err)
+
 			decrypted, err := ctxR.Open(aad, encrypted)
 			assertNotError(t, suite, "Error in Open", err)
 			assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
@@ -486,12 +554,12 @@ func (rtt roundTripTest) Test(t *testing.T) {
 	// Verify encryption context serialization functionality
 	opaqueI, err := ctxS.Marshal()
 	if err != nil {
-		t.Fatalf("[%04x, %04x, %04x] Error serializing encrypt context: %v", rtt.kem_id, rtt.kdf_id, rtt.aead_id, err)
+		t.Fatalf("[%04x, %04x, %04x] Error serializing encrypt context: %v", uint16(rtt.kem_id), uint16(rtt.kdf_id), uint16(rtt.aead_id), err)
 	}
 
 	unmarshaledI, err := UnmarshalSenderContext(opaqueI)
 	if err != nil {
-		t.Fatalf("[%04x, %04x, %04x] Error serializing encrypt context: %v", rtt.kem_id, rtt.kdf_id, rtt.aead_id, err)
+		t.Fatalf("[%04x, %04x, %04x] Error serializing encrypt context: %v", uint16(rtt.kem_id), uint16(rtt.kdf_id), uint16(rtt.aead_id), err)
 	}
 
 	assertCipherContextEqual(t, suite, "Encrypt context serialization mismatch", ctxS.context, unmarshaledI.context)
@@ -499,12 +567,12 @@ func (rtt roundTripTest) Test(t *testing.T) {
 	// Verify decryption context serialization functionality
 	opaqueR, err := ctxR.Marshal()
 	if err != nil {
-		t.Fatalf("[%04x, %04x, %04x] Error serializing decrypt context: %v", rtt.kem_id, rtt.kdf_id, rtt.aead_id, err)
+		t.Fatalf("[%04x, %04x, %04x] Error serializing decrypt context: %v", uint16(rtt.kem_id), uint16(rtt.kdf_id), uint16(rtt.aead_id), err)
 	}
 
 	unmarshaledR, err := UnmarshalReceiverContext(opaqueR)
 	if err != nil {
-		t.Fatalf("[%04x, %04x, %04x] Error serializing decrypt context: %v", rtt.kem_id, rtt.kdf_id, rtt.aead_id, err)
+		t.Fatalf("[%04x, %04x, %04x] Error serializing decrypt context: %v", uint16(rtt.kem_id), uint16(rtt.kdf_id), uint16(rtt.aead_id), err)
 	}
 
 	assertCipherContextEqual(t, suite, "Decrypt context serialization mismatch", ctxR.context, unmarshaledR.context)
@@ -519,7 +587,7 @@ func TestModes(t *testing.T) {
 		for kdf_id, _ := range kdfs {
 			for aead_id, _ := range aeads {
 				for mode, setup := range setupModes {
-					label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%02x", kem_id, kdf_id, aead_id, mode)
+					label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%s", uint16(kem_id), uint16(kdf_id), uint16(aead_id), mode)
 					rtt := roundTripTest{kem_id, kdf_id, aead_id, setup}
 					t.Run(label, rtt.Test)
 				}
@@ -528,265 +596,1852 @@ func TestModes(t *testing.T) {
 	}
 }
 
-///////
-// Generation and processing of test vectors
+// TestLegacyModes checks that contexts set up with the legacy wrappers
+// interoperate with those set up with NewSender and NewReceiver.
+func TestLegacyModes(t *testing.T) {
+	for kem_id := range kems {
+		for mode, setup := range setupModes {
+			legacySender := setup
+			legacySender.I = legacySenders[mode]
 
-func verifyEncryptions(tv testVector, enc *SenderContext, dec *ReceiverContext) {
-	for _, data := range tv.encryptions {
-		encrypted := enc.Seal(data.aad, data.plaintext)
-		decrypted, err := dec.Open(data.aad, encrypted)
+			label := fmt.Sprintf("kem=%04x/mode=%s/legacy=sender", uint16(kem_id), mode)
+			rtt := roundTripTest{kem_id, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305, legacySender}
+			t.Run(label, rtt.Test)
 
-		assertNotError(tv.t, tv.suite, "Error in Open", err)
-		assertBytesEqual(tv.t, tv.suite, "Incorrect encryption", encrypted, data.ciphertext)
-		assertBytesEqual(tv.t, tv.suite, "Incorrect decryption", decrypted, data.plaintext)
+			legacyReceiver := setup
+			legacyReceiver.R = legacyReceivers[mode]
+
+			label = fmt.Sprintf("kem=%04x/mode=%s/legacy=receiver", uint16(kem_id), mode)
+			rtt = roundTripTest{kem_id, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305, legacyReceiver}
+			t.Run(label, rtt.Test)
+		}
 	}
 }
 
-func verifyParameters(tv testVector, ctx context) {
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'shared_secret'", tv.sharedSecret, ctx.setupParams.sharedSecret)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'enc'", tv.enc, ctx.setupParams.enc)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key_schedule_context'", tv.keyScheduleContext, ctx.contextParams.keyScheduleContext)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'secret'", tv.secret, ctx.contextParams.secret)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key'", tv.key, ctx.Key)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'base_nonce'", tv.baseNonce, ctx.BaseNonce)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'exporter_secret'", tv.exporterSecret, ctx.ExporterSecret)
-}
+func TestEphemeralSeed(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
 
-func verifyPublicKeysEqual(tv testVector, pkX, pkY KEMPublicKey) {
-	pkXm := mustSerializePub(tv.suite, pkX)
-	pkYm := mustSerializePub(tv.suite, pkY)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect public key", []byte(pkXm), []byte(pkYm))
-}
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	ikmE := randomBytes(suite.KEM.PrivateKeySize())
 
-func verifyPrivateKeysEqual(tv testVector, skX, skY KEMPrivateKey) {
-	skXm := mustSerializePriv(tv.suite, skX)
-	skYm := mustSerializePriv(tv.suite, skY)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect private key", []byte(skXm), []byte(skYm))
-}
+	encA, _, err := NewSender(suite, pkR, WithInfo(info), WithEphemeralSeed(ikmE))
+	assertNotError(t, suite, "Error in NewSender", err)
 
-func verifyTestVector(tv testVector) {
-	setup := setupModes[tv.mode]
+	encB, _, err := NewSender(suite, pkR, WithInfo(info), WithEphemeralSeed(ikmE))
+	assertNotError(t, suite, "Error in NewSender", err)
+	assertBytesEqual(t, suite, "Seeded encapsulation is not deterministic", encA, encB)
 
-	skR, pkR, err := tv.suite.KEM.DeriveKeyPair(tv.ikmR)
-	assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
-	verifyPublicKeysEqual(tv, tv.pkR, pkR)
-	verifyPrivateKeysEqual(tv, tv.skR, skR)
+	// The seed applies only to the call it is passed to
+	encC, _, err := NewSender(suite, pkR, WithInfo(info))
+	assertNotError(t, suite, "Error in NewSender", err)
+	assert(t, suite, "Seed leaked into subsequent encapsulation", !bytes.Equal(encA, encC))
 
-	skE, pkE, err := tv.suite.KEM.DeriveKeyPair(tv.ikmE)
-	assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
-	verifyPublicKeysEqual(tv, tv.pkE, pkE)
-	verifyPrivateKeysEqual(tv, tv.skE, skE)
+	sike := mustAssembleSuite(t, KEM_SIKE503, KDF_HKDF_SHA256, AEAD_AESGCM128)
 
-	tv.suite.KEM.setEphemeralKeyPair(skE)
+	_, pkSIKE, _ := mustGenerateKeyPair(t, sike)
+	_, _, err = NewSender(sike, pkSIKE, WithEphemeralSeed(ikmE))
+	assert(t, sike, "Seeded encapsulation succeeded for SIKE", err != nil)
+}
 
-	var pkS KEMPublicKey
-	var skS KEMPrivateKey
-	if setup.Mode == modeAuth || setup.Mode == modeAuthPSK {
-		skS, pkS, err = tv.suite.KEM.DeriveKeyPair(tv.ikmS)
-		assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
-		verifyPublicKeysEqual(tv, tv.pkS, pkS)
-		verifyPrivateKeysEqual(tv, tv.skS, skS)
-	}
+func TestNewSenders(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
 
-	enc, ctxS, err := setup.I(tv.suite, pkR, tv.info, skS, tv.psk, tv.psk_id)
-	assertNotError(tv.t, tv.suite, "Error in SetupI", err)
-	assertBytesEqual(tv.t, tv.suite, "Encapsulated key mismatch", enc, tv.enc)
+	const count = 5
+	skRs := make([]KEMPrivateKey, count)
+	pkRs := make([]KEMPublicKey, count)
+	for i := range pkRs {
+		skRs[i], pkRs[i], _ = mustGenerateKeyPair(t, suite)
+	}
 
-	ctxR, err := setup.R(tv.suite, skR, tv.enc, tv.info, pkS, tv.psk, tv.psk_id)
-	assertNotError(tv.t, tv.suite, "Error in SetupR", err)
+	seeds := randomBytes(count * suite.KEM.PrivateKeySize())
+	encs, ctxs, err := NewSenders(suite, pkRs, WithInfo(info), WithRandom(bytes.NewReader(seeds)))
+	assertNotError(t, suite, "Error in NewSenders", err)
+	assert(t, suite, "Incorrect number of senders", len(encs) == count && len(ctxs) == count)
+
+	for i := range pkRs {
+		// Each recipient gets the encapsulation NewSender would produce
+		ikmE := seeds[i*suite.KEM.PrivateKeySize() : (i+1)*suite.KEM.PrivateKeySize()]
+		enc, _, err := NewSender(suite, pkRs[i], WithInfo(info), WithEphemeralSeed(ikmE))
+		assertNotError(t, suite, "Error in NewSender", err)
+		assertBytesEqual(t, suite, "Incorrect encapsulation", encs[i], enc)
+
+		encrypted, err := ctxs[i].Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+		ctxR, err := NewReceiver(suite, skRs[i], encs[i], WithInfo(info))
+		assertNotError(t, suite, "Error in NewReceiver", err)
+		decrypted, err := ctxR.Open(aad, encrypted)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
 
-	verifyParameters(tv, ctxS.context)
-	verifyParameters(tv, ctxR.context)
+	_, _, err = NewSenders(suite, pkRs, WithRandom(bytes.NewReader(seeds[:len(seeds)-1])))
+	assert(t, suite, "Short randomness accepted", err != nil)
 
-	verifyEncryptions(tv, ctxS, ctxR)
+	_, _, err = NewSenders(suite, pkRs, WithEphemeralSeed(seeds[:suite.KEM.PrivateKeySize()]))
+	assert(t, suite, "Ephemeral seed accepted for multiple recipients", err != nil)
 }
 
-func vectorTest(vector testVector) func(t *testing.T) {
-	return func(t *testing.T) {
-		verifyTestVector(vector)
-	}
-}
+func TestContextReset(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
 
-func verifyTestVectors(t *testing.T, vectorString []byte, subtest bool) {
-	vectors := testVectorArray{t: t}
-	err := json.Unmarshal(vectorString, &vectors)
-	if err != nil {
-		t.Fatalf("Error decoding test vector string: %v", err)
-	}
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
 
-	for _, tv := range vectors.vectors {
-		test := vectorTest(tv)
-		if !subtest {
-			test(t)
-		} else {
-			label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%02x", tv.kem_id, tv.kdf_id, tv.aead_id, tv.mode)
-			t.Run(label, test)
+	pool := sync.Pool{New: func() interface{} { return &ReceiverContext{} }}
+	ctxS := &SenderContext{}
+	var lastKey []byte
+	for i := 0; i < rtts; i++ {
+		enc, err := ctxS.Reset(suite, pkR, WithInfo(info))
+		assertNotError(t, suite, "Error in SenderContext.Reset", err)
+		assert(t, suite, "Key reused across resets", !bytes.Equal(ctxS.Key, lastKey))
+		lastKey = append([]byte{}, ctxS.Key...)
+
+		ctxR := pool.Get().(*ReceiverContext)
+		err = ctxR.Reset(suite, skR, enc, WithInfo(info), WithReplayWindow(8))
+		assertNotError(t, suite, "Error in ReceiverContext.Reset", err)
+
+		// The sequence number is reset along with the keys
+		for j := 0; j < 2; j++ {
+			encrypted, err := ctxS.Seal(aad, original)
+			assertNotError(t, suite, "Error in Seal", err)
+			decrypted, err := ctxR.Open(aad, encrypted)
+			assertNotError(t, suite, "Error in Open", err)
+			assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
 		}
+
+		pool.Put(ctxR)
 	}
+
+	// A failed reset leaves the context closed
+	err := pool.Get().(*ReceiverContext).Reset(suite, skR, []byte{0x01}, WithInfo(info))
+	assert(t, suite, "Reset with invalid enc succeeded", err != nil)
+	_, err = ctxS.Reset(suite, pkR, WithSenderAuth(nil))
+	assert(t, suite, "Reset with invalid options succeeded", err != nil)
+	_, err = ctxS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded after failed reset", errors.Is(err, ErrContextClosed))
 }
 
-func generateEncryptions(t *testing.T, suite CipherSuite, ctxS *SenderContext, ctxR *ReceiverContext) ([]encryptionTestVector, error) {
-	vectors := make([]encryptionTestVector, testVectorEncryptionCount)
-	for i := 0; i < len(vectors); i++ {
-		aad := []byte(fmt.Sprintf("Count-%d", i))
-		encrypted := ctxS.Seal(aad, original)
-		decrypted, err := ctxR.Open(aad, encrypted)
-		assertNotError(t, suite, "Decryption failure", err)
-		assertBytesEqual(t, suite, "Incorrect decryption", original, decrypted)
+func TestDebugIntermediates(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
 
-		vectors[i] = encryptionTestVector{
-			plaintext:  original,
-			aad:        aad,
-			nonce:      ctxS.nonces[i],
-			ciphertext: encrypted,
-		}
-	}
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
 
-	return vectors, nil
-}
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithIntermediates())
+	assertNotError(t, suite, "Error in NewSender", err)
 
-func generateExports(t *testing.T, suite CipherSuite, ctxS *SenderContext, ctxR *ReceiverContext) ([]exporterTestVector, error) {
-	exportContexts := [][]byte{
-		[]byte(""),
-		[]byte{0x00},
-		[]byte("TestContext"),
-	}
-	vectors := make([]exporterTestVector, len(exportContexts))
-	for i := 0; i < len(vectors); i++ {
-		exportI := ctxS.Export(exportContexts[i], testVectorExportLength)
-		exportR := ctxR.Export(exportContexts[i], testVectorExportLength)
-		assertBytesEqual(t, suite, "Incorrect export", exportI, exportR)
-		vectors[i] = exporterTestVector{
-			exportContext: exportContexts[i],
-			exportLength:  testVectorExportLength,
-			exportValue:   exportI,
-		}
-	}
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithIntermediates())
+	assertNotError(t, suite, "Error in NewReceiver", err)
 
-	return vectors, nil
-}
+	intS, err := ctxS.DebugIntermediates()
+	assertNotError(t, suite, "Error in DebugIntermediates", err)
 
-func generateTestVector(t *testing.T, setup setupMode, kem_id KEMID, kdf_id KDFID, aead_id AEADID) testVector {
-	suite, err := AssembleCipherSuite(kem_id, kdf_id, aead_id)
-	if err != nil {
-		t.Fatalf("[%x, %x, %x] Error looking up ciphersuite: %s", kem_id, kdf_id, aead_id, err)
-	}
+	intR, err := ctxR.DebugIntermediates()
+	assertNotError(t, suite, "Error in DebugIntermediates", err)
 
-	skR, pkR, ikmR := mustGenerateKeyPair(t, suite)
-	skE, pkE, ikmE := mustGenerateKeyPair(t, suite)
+	assertBytesEqual(t, suite, "Incorrect enc", intS.Enc, enc)
+	assertBytesEqual(t, suite, "Mismatched shared_secret", intS.SharedSecret, intR.SharedSecret)
+	assertBytesEqual(t, suite, "Mismatched key_schedule_context", intS.KeyScheduleContext, intR.KeyScheduleContext)
+	assertBytesEqual(t, suite, "Mismatched secret", intS.Secret, intR.Secret)
 
-	// The sender key share is only required for Auth mode variants.
-	var pkS KEMPublicKey
-	var skS KEMPrivateKey
-	var ikmS []byte
-	if setup.Mode == modeAuth || setup.Mode == modeAuthPSK {
-		skS, pkS, ikmS = mustGenerateKeyPair(t, suite)
-	}
+	opaque, err := ctxS.Marshal()
+	assertNotError(t, suite, "Error serializing context", err)
 
-	// A PSK is only required for PSK mode variants.
-	var psk []byte
-	var psk_id []byte
-	if setup.Mode == modePSK || setup.Mode == modeAuthPSK {
-		psk = fixedPSK
-		psk_id = fixedPSKID
-	}
+	unmarshaled, err := UnmarshalSenderContext(opaque)
+	assertNotError(t, suite, "Error deserializing context", err)
 
-	suite.KEM.setEphemeralKeyPair(skE)
+	_, err = unmarshaled.DebugIntermediates()
+	assert(t, suite, "Intermediates available after serialization", err != nil)
 
-	enc, ctxS, err := setup.I(suite, pkR, info, skS, psk, psk_id)
-	assertNotError(t, suite, "Error in SetupPSKS", err)
+	// Without WithIntermediates, they are dropped once setup completes
+	_, ctxS, err = NewSender(suite, pkR, WithInfo(info))
+	assertNotError(t, suite, "Error in NewSender", err)
 
-	ctxR, err := setup.R(suite, skR, enc, info, pkS, psk, psk_id)
-	assertNotError(t, suite, "Error in SetupPSKR", err)
+	_, err = ctxS.DebugIntermediates()
+	assert(t, suite, "Intermediates retained by default", err != nil)
+	assert(t, suite, "Secret retained by default", ctxS.contextParams.secret == nil && ctxS.setupParams.sharedSecret == nil)
+}
 
-	encryptionVectors := []encryptionTestVector{}
-	if aead_id != AEAD_EXPORT_ONLY {
-		encryptionVectors, err = generateEncryptions(t, suite, ctxS, ctxR)
-		assertNotError(t, suite, "Error in generateEncryptions", err)
-	}
+func TestMessageLimit(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
 
-	exportVectors, err := generateExports(t, suite, ctxS, ctxR)
-	assertNotError(t, suite, "Error in generateExports", err)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
 
-	vector := testVector{
-		t:                  t,
-		suite:              suite,
-		mode:               setup.Mode,
-		kem_id:             kem_id,
-		kdf_id:             kdf_id,
-		aead_id:            aead_id,
-		info:               info,
-		skR:                skR,
-		pkR:                pkR,
-		skS:                skS,
-		pkS:                pkS,
-		skE:                skE,
-		pkE:                pkE,
-		ikmR:               ikmR,
-		ikmS:               ikmS,
-		ikmE:               ikmE,
-		psk:                psk,
-		psk_id:             psk_id,
-		enc:                ctxS.setupParams.enc,
-		sharedSecret:       ctxS.setupParams.sharedSecret,
-		keyScheduleContext: ctxS.contextParams.keyScheduleContext,
-		secret:             ctxS.contextParams.secret,
-		key:                ctxS.Key,
-		baseNonce:          ctxS.BaseNonce,
-		exporterSecret:     ctxS.ExporterSecret,
-		encryptions:        encryptionVectors,
-		exports:            exportVectors,
-	}
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
 
-	return vector
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	err = ctxS.SkipTo(math.MaxUint64 - 1)
+	assertNotError(t, suite, "Error in SkipTo", err)
+
+	err = ctxR.SkipTo(math.MaxUint64 - 1)
+	assertNotError(t, suite, "Error in SkipTo", err)
+
+	encrypted, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	_, err = ctxR.Open(aad, encrypted)
+	assertNotError(t, suite, "Error in Open", err)
+
+	_, err = ctxS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded after message limit", err == ErrMessageLimit)
+
+	_, err = ctxR.Open(aad, encrypted)
+	assert(t, suite, "Open succeeded after message limit", err == ErrMessageLimit)
 }
 
-func TestVectorGenerate(t *testing.T) {
-	// We only generate test vectors for select ciphersuites
-	supportedKEMs := []KEMID{DHKEM_X25519, DHKEM_X448, DHKEM_P256, DHKEM_P521}
-	supportedKDFs := []KDFID{KDF_HKDF_SHA256, KDF_HKDF_SHA512}
-	supportedAEADs := []AEADID{AEAD_AESGCM128, AEAD_AESGCM256, AEAD_CHACHA20POLY1305, AEAD_EXPORT_ONLY}
+func TestMessagePolicy(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
 
-	vectors := make([]testVector, 0)
-	for _, kem_id := range supportedKEMs {
-		for _, kdf_id := range supportedKDFs {
-			for _, aead_id := range supportedAEADs {
-				for _, setup := range setupModes {
-					vectors = append(vectors, generateTestVector(t, setup, kem_id, kdf_id, aead_id))
-				}
-			}
-		}
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	var warned []uint64
+	policy := MessagePolicy{
+		MaxMessages: uint64(rtts),
+		WarnAt:      uint64(rtts) - 2,
+		OnWarn:      func(count uint64) { warned = append(warned, count) },
 	}
 
-	// Encode the test vectors
-	encoded, err := json.Marshal(vectors)
-	if err != nil {
-		t.Fatalf("Error producing test vectors: %v", err)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithMessagePolicy(policy))
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	var warnedR []uint64
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithMessagePolicy(MessagePolicy{
+		MaxMessages: uint64(rtts),
+		WarnAt:      1,
+		OnWarn:      func(count uint64) { warnedR = append(warnedR, count) },
+	}))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	var encrypted []byte
+	for i := 0; i < rtts; i++ {
+		encrypted, err = ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+
+		// Failed attempts to open a message do not trigger the warning
+		tampered := append([]byte{}, encrypted...)
+		tampered[0] ^= 0xff
+		before := len(warnedR)
+		_, err = ctxR.Open(aad, tampered)
+		assert(t, suite, "Open succeeded on a tampered ciphertext", err == ErrOpenFailed)
+		assert(t, suite, "Warning callback on a failed Open", len(warnedR) == before)
+
+		_, err = ctxR.Open(aad, encrypted)
+		assertNotError(t, suite, "Error in Open", err)
 	}
 
-	// Verify that we process them correctly
-	verifyTestVectors(t, encoded, false)
+	assert(t, suite, "Incorrect warning callbacks", len(warned) == 1 && warned[0] == uint64(rtts)-2)
+	assert(t, suite, "Incorrect receiver warning callbacks", len(warnedR) == 1 && warnedR[0] == 1)
 
-	// Write them to a file if requested
-	var outputFile string
-	if outputFile = os.Getenv(outputTestVectorEnvironmentKey); len(outputFile) > 0 {
-		err = ioutil.WriteFile(outputFile, encoded, 0644)
-		if err != nil {
-			t.Fatalf("Error writing test vectors: %v", err)
-		}
+	// A zero WarnAt disables the warning
+	called := false
+	_, ctxZ, err := NewSender(suite, pkR, WithInfo(info), WithMessagePolicy(MessagePolicy{
+		OnWarn: func(count uint64) { called = true },
+	}))
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	for i := 0; i < rtts; i++ {
+		_, err = ctxZ.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
 	}
+	assert(t, suite, "Warning callback with a zero WarnAt", !called)
+
+	_, err = ctxS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded past the message limit", err == ErrMessageLimit)
+
+	_, err = ctxR.Open(aad, encrypted)
+	assert(t, suite, "Open succeeded past the message limit", err == ErrMessageLimit)
+
+	_, err = ctxR.OpenWithSeq(uint64(rtts), aad, encrypted)
+	assert(t, suite, "OpenWithSeq succeeded past the message limit", err == ErrMessageLimit)
+
+	err = ctxS.KeyUpdate()
+	assertNotError(t, suite, "Error in KeyUpdate", err)
+
+	_, err = ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal after KeyUpdate", err)
 }
 
-func TestVectorVerify(t *testing.T) {
-	var inputFile string
-	if inputFile = os.Getenv(inputTestVectorEnvironmentKey); len(inputFile) == 0 {
-		t.Skip("Test vectors were not provided")
-	}
+func TestContextExpiry(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
 
-	encoded, err := ioutil.ReadFile(inputFile)
-	if err != nil {
-		t.Fatalf("Failed reading test vectors: %v", err)
-	}
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
 
-	verifyTestVectors(t, encoded, true)
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithExpiry(expiry))
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithExpiry(expiry))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	encrypted, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	// The expiry survives serialization in both formats
+	opaque, err := ctxR.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+
+	restored, err := UnmarshalReceiverContext(opaque)
+	assertNotError(t, suite, "Error in UnmarshalReceiverContext", err)
+	assert(t, suite, "Expiry not preserved by Marshal", restored.Expiry().Equal(expiry))
+
+	encoded, err := ctxR.MarshalCBOR()
+	assertNotError(t, suite, "Error in MarshalCBOR", err)
+
+	restoredCBOR, err := UnmarshalReceiverContextCBOR(encoded)
+	assertNotError(t, suite, "Error in UnmarshalReceiverContextCBOR", err)
+	assert(t, suite, "Expiry not preserved by MarshalCBOR", restoredCBOR.Expiry().Equal(expiry))
+
+	_, err = restored.Open(aad, encrypted)
+	assertNotError(t, suite, "Error in Open before expiry", err)
+
+	// Once expired, the context and anything derived from it are unusable
+	restored.SetExpiry(time.Now().Add(-time.Second))
+
+	_, err = restored.Open(aad, encrypted)
+	assert(t, suite, "Open succeeded after expiry", err == ErrContextExpired)
+
+	_, err = restored.OpenWithSeq(0, aad, encrypted)
+	assert(t, suite, "OpenWithSeq succeeded after expiry", err == ErrContextExpired)
+
+	_, err = restored.ResponseSender()
+	assert(t, suite, "Response context derived after expiry", err == ErrContextExpired)
+
+	_, err = restored.ExportSecret(exportContext, exportLength)
+	assert(t, suite, "ExportSecret succeeded after expiry", err == ErrContextExpired)
+
+	ctxS.SetExpiry(time.Now().Add(-time.Second))
+	_, err = ctxS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded after expiry", err == ErrContextExpired)
+
+	opaque, err = ctxS.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+
+	restoredS, err := UnmarshalSenderContext(opaque)
+	assertNotError(t, suite, "Error in UnmarshalSenderContext", err)
+
+	_, err = restoredS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded after expiry and Marshal", err == ErrContextExpired)
+}
+
+func TestOpenWithSeq(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	encrypted := make([][]byte, rtts)
+	for i := range encrypted {
+		encrypted[i], err = ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+	}
+
+	for i := len(encrypted) - 1; i >= 0; i-- {
+		decrypted, err := ctxR.OpenWithSeq(uint64(i), aad, encrypted[i])
+		assertNotError(t, suite, "Error in OpenWithSeq", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	_, err = ctxR.OpenWithSeq(1, aad, encrypted[0])
+	assert(t, suite, "OpenWithSeq succeeded with incorrect sequence number", err != nil)
+	assert(t, suite, "OpenWithSeq modified the sequence number", ctxR.Seq() == 0)
+}
+
+func TestSkipTo(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	for i := 0; i < rtts; i++ {
+		_, err = ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+	}
+	assert(t, suite, "Incorrect sender sequence number", ctxS.Seq() == uint64(rtts))
+
+	encrypted, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	err = ctxR.SkipTo(uint64(rtts))
+	assertNotError(t, suite, "Error in SkipTo", err)
+
+	decrypted, err := ctxR.Open(aad, encrypted)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	assert(t, suite, "Incorrect receiver sequence number", ctxR.Seq() == uint64(rtts+1))
+
+	err = ctxR.SkipTo(0)
+	assert(t, suite, "SkipTo moved the sequence number backwards", err != nil)
+	assert(t, suite, "Failed SkipTo modified the sequence number", ctxR.Seq() == uint64(rtts+1))
+}
+
+func TestKeyUpdate(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	oldKey := ctxS.Key
+	oldExport := ctxS.Export(exportContext, exportLength)
+	staleCT, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	err = ctxS.KeyUpdate()
+	assertNotError(t, suite, "Error in KeyUpdate", err)
+
+	err = ctxR.KeyUpdate()
+	assertNotError(t, suite, "Error in KeyUpdate", err)
+
+	assert(t, suite, "Key was not updated", !bytes.Equal(oldKey, ctxS.Key))
+	assert(t, suite, "Exporter secret was not updated", !bytes.Equal(oldExport, ctxS.Export(exportContext, exportLength)))
+	assert(t, suite, "Incorrect epoch", ctxS.Epoch == 1 && ctxR.Epoch == 1)
+	assert(t, suite, "Sequence number was not reset", ctxS.Seq() == 0)
+
+	_, err = ctxR.Open(aad, staleCT)
+	assert(t, suite, "Open succeeded with stale key", err != nil)
+
+	for i := 0; i < rtts; i++ {
+		encrypted, err := ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+
+		decrypted, err := ctxR.Open(aad, encrypted)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	assertBytesEqual(t, suite, "Incorrect exported secret", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+}
+
+func TestResponseContexts(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	responseS, err := ctxR.ResponseSender()
+	assertNotError(t, suite, "Error in ResponseSender", err)
+
+	responseR, err := ctxS.ResponseReceiver()
+	assertNotError(t, suite, "Error in ResponseReceiver", err)
+
+	assert(t, suite, "Response key matches request key", !bytes.Equal(responseS.Key, ctxS.Key))
+
+	for i := 0; i < rtts; i++ {
+		encrypted, err := responseS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+
+		decrypted, err := responseR.Open(aad, encrypted)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	opaque, err := responseS.Marshal()
+	assertNotError(t, suite, "Error serializing response context", err)
+
+	_, err = UnmarshalSenderContext(opaque)
+	assertNotError(t, suite, "Error deserializing response context", err)
+}
+
+func TestShards(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	const workers = 4
+	sealed := make([][][]byte, workers)
+	sealErrs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		shard, err := ctxS.Shard(uint32(i))
+		assertNotError(t, suite, "Error in Shard", err)
+
+		wg.Add(1)
+		go func(i int, shard *SenderContext) {
+			defer wg.Done()
+			for j := 0; j < rtts; j++ {
+				ct, err := shard.Seal(aad, original)
+				if err != nil {
+					sealErrs[i] = err
+					return
+				}
+				sealed[i] = append(sealed[i], ct)
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+
+	// Failures are reported from the test goroutine
+	for _, err := range sealErrs {
+		assertNotError(t, suite, "Error in Seal", err)
+	}
+
+	for i := 0; i < workers; i++ {
+		shard, err := ctxR.Shard(uint32(i))
+		assertNotError(t, suite, "Error in Shard", err)
+
+		for _, ct := range sealed[i] {
+			pt, err := shard.Open(aad, ct)
+			assertNotError(t, suite, "Error in Open", err)
+			assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+		}
+	}
+
+	// Shards are independent of each other and of the parent
+	other, err := ctxR.Shard(1)
+	assertNotError(t, suite, "Error in Shard", err)
+	_, err = other.Open(aad, sealed[0][0])
+	assert(t, suite, "Message opened by the wrong shard", err != nil)
+	_, err = ctxR.Open(aad, sealed[0][0])
+	assert(t, suite, "Shard message opened by the parent", err != nil)
+}
+
+func TestContextAEAD(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	aeadR, err := ctxR.AEAD()
+	assertNotError(t, suite, "Error in AEAD", err)
+
+	seq := make([]byte, aeadR.NonceSize())
+	for i := 0; i < rtts; i++ {
+		encrypted, err := ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+
+		binary.BigEndian.PutUint64(seq, uint64(i))
+		decrypted, err := aeadR.Open(nil, seq, encrypted, aad)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	encrypted := aeadR.Seal(nil, seq, original, aad)
+	decrypted, err := ctxR.OpenWithSeq(uint64(rtts-1), aad, encrypted)
+	assertNotError(t, suite, "Error in OpenWithSeq", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+}
+
+func TestSealToOpenTo(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	prefix := []byte("prefix")
+	buf := make([]byte, 0, len(prefix)+len(original)+ctxS.aead.Overhead())
+	for i := 0; i < rtts; i++ {
+		buf = append(buf[:0], prefix...)
+		sealed, err := ctxS.SealTo(buf, aad, original)
+		assertNotError(t, suite, "Error in SealTo", err)
+		assert(t, suite, "SealTo reallocated a sufficient buffer", &sealed[0] == &buf[0])
+		assertBytesEqual(t, suite, "SealTo did not preserve prefix", sealed[:len(prefix)], prefix)
+
+		// Decrypt in place
+		ct := sealed[len(prefix):]
+		opened, err := ctxR.OpenTo(ct[:0], aad, ct)
+		assertNotError(t, suite, "Error in OpenTo", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", opened, original)
+	}
+}
+
+func TestOpenToInPlaceCommitting(t *testing.T) {
+	// The plaintext is longer than the commitment, so that the inner
+	// ciphertext overlaps the start of the buffer
+	pt := randomBytes(100)
+
+	for _, aeadID := range []AEADID{AEAD_AESGCM128_COMMIT, AEAD_AESGCM256_COMMIT, AEAD_CHACHA20POLY1305_COMMIT} {
+		t.Run(aeadID.String(), func(t *testing.T) {
+			suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, aeadID)
+
+			skR, pkR, _ := mustGenerateKeyPair(t, suite)
+			enc, ctxS, err := NewSender(suite, pkR, WithInfo(info))
+			assertNotError(t, suite, "Error in NewSender", err)
+
+			ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info))
+			assertNotError(t, suite, "Error in NewReceiver", err)
+
+			for i := 0; i < rtts; i++ {
+				ct, err := ctxS.Seal(aad, pt)
+				assertNotError(t, suite, "Error in Seal", err)
+
+				opened, err := ctxR.OpenTo(ct[:0], aad, ct)
+				assertNotError(t, suite, "Error in OpenTo", err)
+				assertBytesEqual(t, suite, "Incorrect decryption", opened, pt)
+			}
+		})
+	}
+}
+
+func TestNonceBuffer(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	_, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	for seq := uint64(0); seq < uint64(rtts); seq++ {
+		assertBytesEqual(t, suite, "Incorrect nonce", ctxS.nonceForSeq(seq), xorNonce(ctxS.BaseNonce, seq))
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ctxS.computeNonce()
+		ctxS.context.Seq++
+	})
+	assert(t, suite, "Nonce computation allocated", allocs == 0)
+}
+
+type releasingScheme struct {
+	AEADScheme
+	released *[]cipher.AEAD
+}
+
+func (s releasingScheme) Release(aead cipher.AEAD) {
+	*s.released = append(*s.released, aead)
+}
+
+func TestAEADReleaser(t *testing.T) {
+	base := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	var released []cipher.AEAD
+	suite := CipherSuite{KEM: base.KEM, KDF: base.KDF, AEAD: releasingScheme{base.AEAD, &released}}
+
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	_, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	first := ctxS.aead
+	err = ctxS.KeyUpdate()
+	assertNotError(t, suite, "Error in KeyUpdate", err)
+	assert(t, suite, "AEAD not released on KeyUpdate", len(released) == 1 && released[0] == first)
+
+	// Exposed instances are never released
+	_, err = ctxS.AEAD()
+	assertNotError(t, suite, "Error in AEAD", err)
+	ctxS.Close()
+	ctxS.Close()
+	assert(t, suite, "Shared AEAD released", len(released) == 1)
+
+	_, ctxS, err = SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+	ctxS.Close()
+	ctxS.Close()
+	assert(t, suite, "AEAD not released exactly once on Close", len(released) == 2)
+}
+
+func TestChaChaPolyReuse(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+	scheme := chachaPolyScheme{}
+
+	key := randomBytes(chacha20poly1305.KeySize)
+	nonce := randomBytes(chacha20poly1305.NonceSize)
+	pt := randomBytes(64)
+
+	aead, err := scheme.New(key)
+	assertNotError(t, suite, "Error in New", err)
+	scheme.Release(aead)
+	assert(t, suite, "Released key was not wiped", bytes.Equal(aead.(*chachaPolyAEAD).key[:], make([]byte, chacha20poly1305.KeySize)))
+
+	// Whether or not New reuses the released instance, it must be keyed anew
+	aead, err = scheme.New(key)
+	assertNotError(t, suite, "Error in New", err)
+	reference, err := chacha20poly1305.New(key)
+	assertNotError(t, suite, "Error in chacha20poly1305.New", err)
+
+	ct := aead.Seal(nil, nonce, pt, aad)
+	assertBytesEqual(t, suite, "Incorrect ciphertext", ct, reference.Seal(nil, nonce, pt, aad))
+	decrypted, err := aead.Open(nil, nonce, ct, aad)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, pt)
+
+	_, err = scheme.New(key[1:])
+	assert(t, suite, "New accepted a short key", err != nil)
+
+	buf := make([]byte, 0, len(pt)+aead.Overhead())
+	allocs := testing.AllocsPerRun(100, func() {
+		aead.Seal(buf[:0], nonce, pt, aad)
+	})
+	assert(t, suite, "Seal allocated", allocs == 0)
+}
+
+func TestLocking(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithLocking())
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithLocking(), WithReplayWindow(128))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	const workers = 8
+	encrypted := make(chan []byte, workers*rtts)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rtts; i++ {
+				ct, err := ctxS.Seal(aad, original)
+				if err != nil {
+					t.Errorf("Error in Seal: %v", err)
+					return
+				}
+				encrypted <- ct
+			}
+		}()
+	}
+	wg.Wait()
+	close(encrypted)
+
+	total := uint64(workers * rtts)
+	assert(t, suite, "Incorrect sender sequence number", ctxS.Seq() == total)
+
+	// Every sequence number was used exactly once, so each ciphertext opens
+	// under exactly one of them.
+	opened := uint64(0)
+	for ct := range encrypted {
+		for seq := uint64(0); seq < total; seq++ {
+			if _, err := ctxR.OpenWithSeq(seq, aad, ct); err == nil {
+				opened += 1
+				break
+			}
+		}
+	}
+
+	assert(t, suite, "Not all ciphertexts decrypted", opened == total)
+}
+
+func TestReplayWindow(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info))
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithReplayWindow(4))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	encrypted := make([][]byte, rtts)
+	for i := range encrypted {
+		encrypted[i], err = ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+	}
+
+	for _, seq := range []uint64{2, 0, 3, 1, 6} {
+		_, err = ctxR.OpenWithSeq(seq, aad, encrypted[seq])
+		assertNotError(t, suite, "Error in OpenWithSeq", err)
+	}
+
+	// Replays within the window, and messages behind it, are rejected
+	for _, seq := range []uint64{6, 3, 2, 1} {
+		_, err = ctxR.OpenWithSeq(seq, aad, encrypted[seq])
+		assert(t, suite, fmt.Sprintf("Replay of %d not detected", seq), err == ErrReplayedMessage)
+	}
+
+	// Unseen messages within the window are accepted
+	_, err = ctxR.OpenWithSeq(5, aad, encrypted[5])
+	assertNotError(t, suite, "Error in OpenWithSeq", err)
+
+	// A failed decryption does not mark the sequence number as seen
+	_, err = ctxR.OpenWithSeq(9, aad, encrypted[8])
+	assert(t, suite, "OpenWithSeq succeeded with incorrect sequence number", err != nil && err != ErrReplayedMessage)
+
+	_, err = ctxR.OpenWithSeq(9, aad, encrypted[9])
+	assertNotError(t, suite, "Error in OpenWithSeq", err)
+
+	// Sequence numbers start over after a key update
+	err = ctxS.KeyUpdate()
+	assertNotError(t, suite, "Error in KeyUpdate", err)
+	err = ctxR.KeyUpdate()
+	assertNotError(t, suite, "Error in KeyUpdate", err)
+
+	updated, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	decrypted, err := ctxR.OpenWithSeq(0, aad, updated)
+	assertNotError(t, suite, "Error in OpenWithSeq after KeyUpdate", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	_, err = ctxR.OpenWithSeq(0, aad, updated)
+	assert(t, suite, "Replay accepted after KeyUpdate", err == ErrReplayedMessage)
+}
+
+func TestModeString(t *testing.T) {
+	names := map[Mode]string{
+		ModeBase:    "Base",
+		ModePSK:     "PSK",
+		ModeAuth:    "Auth",
+		ModeAuthPSK: "AuthPSK",
+		Mode(0x42):  "Mode(0x42)",
+	}
+
+	for mode, name := range names {
+		if mode.String() != name {
+			t.Fatalf("Incorrect mode name: %s != %s", mode.String(), name)
+		}
+	}
+}
+
+func TestSetupOptions(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithPSK(fixedPSK, fixedPSKID), WithSenderAuth(skS))
+	assertNotError(t, suite, "Error in NewSender", err)
+	assert(t, suite, "Incorrect sender mode", ctxS.mode == ModeAuthPSK)
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithPSK(fixedPSK, fixedPSKID), WithSenderPublicKey(pkS))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	encrypted, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	decrypted, err := ctxR.Open(aad, encrypted)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	_, _, err = NewSender(suite, pkR, WithSenderPublicKey(pkS))
+	assert(t, suite, "NewSender succeeded without a sender private key", err != nil)
+
+	_, err = NewReceiver(suite, skR, enc, WithSenderAuth(skS))
+	assert(t, suite, "NewReceiver succeeded without a sender public key", err != nil)
+}
+
+func TestSuiteBinding(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithSuiteBinding())
+	assertNotError(t, suite, "Error in NewSender", err)
+	assert(t, suite, "Suite binding not enabled", ctxS.SuiteBinding())
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithSuiteBinding())
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	unbound, err := NewReceiver(suite, skR, enc, WithInfo(info))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+
+	encrypted, err := ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	_, err = unbound.Open(aad, encrypted)
+	assert(t, suite, "Bound ciphertext opened without binding", err != nil)
+
+	decrypted, err := ctxR.Open(aad, encrypted)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	// The binding is the AAD prefix
+	bound := append(append(suite.ID(), byte(ModeBase)), aad...)
+	decrypted, err = unbound.OpenWithSeq(0, bound, encrypted)
+	assertNotError(t, suite, "Error in OpenWithSeq with explicit binding", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	// The binding survives serialization and is inherited by responses
+	opaque, err := ctxS.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+	restored, err := UnmarshalSenderContext(opaque)
+	assertNotError(t, suite, "Error in UnmarshalSenderContext", err)
+	assert(t, suite, "Suite binding lost in serialization", restored.SuiteBinding())
+
+	encrypted, err = restored.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+	decrypted, err = ctxR.Open(aad, encrypted)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	responseS, err := ctxR.ResponseSender()
+	assertNotError(t, suite, "Error in ResponseSender", err)
+	assert(t, suite, "Suite binding not inherited", responseS.SuiteBinding())
+
+	_, err = ctxS.MarshalCBOR()
+	assert(t, suite, "Bound context encoded in CBOR", err != nil)
+
+	// Contexts that do not know their mode cannot be bound
+	err = unbound.SetSuiteBinding(false)
+	assertNotError(t, suite, "Error disabling suite binding", err)
+	opaque, err = unbound.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+	restored2, err := UnmarshalReceiverContext(opaque)
+	assertNotError(t, suite, "Error in UnmarshalReceiverContext", err)
+	err = restored2.SetSuiteBinding(true)
+	assert(t, suite, "Suite binding enabled without a mode", err != nil)
+}
+
+func TestContextMarshalIntegrity(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	_, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	opaque, err := ctxS.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+	assert(t, suite, "Incorrect format version", opaque[0] == contextFormatVersion3)
+
+	_, err = UnmarshalSenderContext(opaque)
+	assertNotError(t, suite, "Error in UnmarshalSenderContext", err)
+
+	for i := range opaque {
+		corrupted := append([]byte{}, opaque...)
+		corrupted[i] ^= 0x01
+		_, err = UnmarshalSenderContext(corrupted)
+		assert(t, suite, fmt.Sprintf("Corrupted context accepted [%d]", i), err != nil)
+	}
+
+	_, err = UnmarshalSenderContext(opaque[:len(opaque)-1])
+	assert(t, suite, "Truncated context accepted", err != nil)
+
+	_, err = UnmarshalSenderContext(append(opaque, 0x00))
+	assert(t, suite, "Context with trailing data accepted", err != nil)
+
+	_, err = UnmarshalReceiverContext(opaque)
+	assert(t, suite, "Context accepted with the wrong role", err != nil)
+}
+
+func TestContextAppendBinary(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	_, pkR, _ := mustGenerateKeyPair(t, suite)
+	_, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithSuiteBinding())
+	assertNotError(t, suite, "Error in NewSender", err)
+
+	opaque, err := ctxS.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+
+	// AppendBinary appends exactly what Marshal returns
+	prefix := []byte("prefix")
+	appended, err := ctxS.AppendBinary(prefix)
+	assertNotError(t, suite, "Error in AppendBinary", err)
+	assertBytesEqual(t, suite, "Prefix not preserved", prefix, appended[:len(prefix)])
+	assertBytesEqual(t, suite, "AppendBinary does not match Marshal", opaque, appended[len(prefix):])
+
+	// Every prefix of the serialization is rejected
+	for i := 0; i < len(opaque); i++ {
+		_, err = UnmarshalSenderContext(opaque[:i])
+		assert(t, suite, fmt.Sprintf("Truncated context accepted [%d]", i), err != nil)
+	}
+
+	// The MAC is rekeyed when the exporter secret changes
+	fatalOnError(t, ctxS.KeyUpdate(), "Error in KeyUpdate")
+	updated, err := ctxS.Marshal()
+	assertNotError(t, suite, "Error in Marshal", err)
+	restored, err := UnmarshalSenderContext(updated)
+	assertNotError(t, suite, "Error in UnmarshalSenderContext", err)
+	assert(t, suite, "Incorrect epoch after round trip", restored.Epoch == 1)
+
+	buf := make([]byte, 0, len(opaque))
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := ctxS.AppendBinary(buf[:0]); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert(t, suite, "AppendBinary allocated", allocs == 0)
+
+	ctxS.Zeroize()
+	_, err = ctxS.AppendBinary(nil)
+	assert(t, suite, "AppendBinary succeeded on a closed context", err == ErrContextClosed)
+}
+
+func TestMarshalSealed(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	kek := make([]byte, 32)
+	rand.Read(kek)
+
+	sealedS, err := ctxS.MarshalSealed(kek)
+	assertNotError(t, suite, "Error in MarshalSealed", err)
+	assert(t, suite, "Sealed context contains the plaintext key", !bytes.Contains(sealedS, ctxS.Key))
+	assert(t, suite, "Sealed context contains the exporter secret", !bytes.Contains(sealedS, ctxS.ExporterSecret))
+
+	sealedR, err := ctxR.MarshalSealed(kek)
+	assertNotError(t, suite, "Error in MarshalSealed", err)
+
+	restoredS, err := UnmarshalSealedSenderContext(kek, sealedS)
+	assertNotError(t, suite, "Error in UnmarshalSealedSenderContext", err)
+	assertCipherContextEqual(t, suite, "Sealed sender context mismatch", ctxS.context, restoredS.context)
+
+	restoredR, err := UnmarshalSealedReceiverContext(kek, sealedR)
+	assertNotError(t, suite, "Error in UnmarshalSealedReceiverContext", err)
+	assertCipherContextEqual(t, suite, "Sealed receiver context mismatch", ctxR.context, restoredR.context)
+
+	wrongKEK := append([]byte{}, kek...)
+	wrongKEK[0] ^= 0x01
+	_, err = UnmarshalSealedSenderContext(wrongKEK, sealedS)
+	assert(t, suite, "Sealed context opened with the wrong KEK", err != nil)
+
+	_, err = ctxS.MarshalSealed(kek[:10])
+	assert(t, suite, "MarshalSealed accepted an invalid KEK length", err != nil)
+}
+
+func TestZeroize(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	key, exporterSecret := ctxS.Key, ctxS.ExporterSecret
+	assertNotError(t, suite, "Error in Close", ctxS.Close())
+	assert(t, suite, "Key not wiped", bytes.Equal(key, make([]byte, len(key))))
+	assert(t, suite, "Exporter secret not wiped", bytes.Equal(exporterSecret, make([]byte, len(exporterSecret))))
+
+	_, err = ctxS.Seal(aad, original)
+	assert(t, suite, "Seal succeeded on a closed context", err == ErrContextClosed)
+
+	_, err = ctxS.Marshal()
+	assert(t, suite, "Marshal succeeded on a closed context", err == ErrContextClosed)
+
+	assert(t, suite, "KeyUpdate succeeded on a closed context", ctxS.KeyUpdate() == ErrContextClosed)
+
+	func() {
+		defer func() {
+			assert(t, suite, "Export did not panic on a closed context", recover() == ErrContextClosed)
+		}()
+		ctxS.Export(exportContext, exportLength)
+	}()
+	_, err = ctxS.ExportSecret(exportContext, exportLength)
+	assert(t, suite, "ExportSecret succeeded on a closed context", err == ErrContextClosed)
+
+	ctxR.Zeroize()
+	_, err = ctxR.Open(aad, original)
+	assert(t, suite, "Open succeeded on a closed context", err == ErrContextClosed)
+	_, err = ctxR.OpenWithSeq(0, aad, original)
+	assert(t, suite, "OpenWithSeq succeeded on a closed context", err == ErrContextClosed)
+
+	for kemID := range kems {
+		kemSuite := mustAssembleSuite(t, kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+		sk, _, _ := mustGenerateKeyPair(t, kemSuite)
+		zeroizer, ok := sk.(Zeroizer)
+		assert(t, kemSuite, "Private key does not implement Zeroizer", ok)
+		zeroizer.Zeroize()
+	}
+
+	zeroizer := skR.(Zeroizer)
+	zeroizer.Zeroize()
+	assert(t, suite, "X25519 private key not wiped", skR.(*x25519PrivateKey).val == [32]byte{})
+}
+
+type baseOnlyDecapsulator struct {
+	KEMDecapsulator
+}
+
+func TestKEMDecapsulator(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	decapsulator := NewKEMDecapsulator(suite.KEM, skR)
+
+	for mode, setup := range setupModes {
+		enc, ctxS, err := setup.I(suite, pkR, info, skS, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, "Error in sender setup", err)
+
+		ctxR, err := setup.R(suite, decapsulator, enc, info, pkS, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, fmt.Sprintf("Error in receiver setup with decapsulator [%s]", mode), err)
+		assertBytesEqual(t, suite, "Incorrect exported secret", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+	}
+
+	enc, _, err := SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	assertNotError(t, suite, "Error in SetupAuthS", err)
+
+	_, err = SetupAuthR(suite, baseOnlyDecapsulator{decapsulator}, pkS, enc, info)
+	assert(t, suite, "Auth setup succeeded with a base-only decapsulator", err != nil)
+}
+
+// opaqueDHKey performs DH for a software key, standing in for a key held in
+// hardware.
+type opaqueDHKey struct {
+	group dhScheme
+	sk    KEMPrivateKey
+	calls int
+}
+
+func (k *opaqueDHKey) PublicKey() KEMPublicKey {
+	return k.sk.PublicKey()
+}
+
+func (k *opaqueDHKey) DH(pkXm []byte) ([]byte, error) {
+	k.calls++
+	pk, err := k.group.DeserializePublicKey(pkXm)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.group.DH(k.sk, pk)
+}
+
+func TestDHPrivateKey(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	group := suite.KEM.(dhkemScheme).group
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	opaqueS := &opaqueDHKey{group: group, sk: skS}
+	opaqueR := &opaqueDHKey{group: group, sk: skR}
+
+	for mode, setup := range setupModes {
+		enc, ctxS, err := setup.I(suite, pkR, info, opaqueS, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, fmt.Sprintf("Error in sender setup with opaque key [%s]", mode), err)
+
+		ctxR, err := setup.R(suite, opaqueR, enc, info, pkS, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, fmt.Sprintf("Error in receiver setup with opaque key [%s]", mode), err)
+		assertBytesEqual(t, suite, "Incorrect exported secret", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+	}
+
+	// Two DH operations per receiver setup in the authenticated modes, one
+	// otherwise
+	assert(t, suite, "Receiver DH not delegated to the key", opaqueR.calls == 6)
+	assert(t, suite, "Sender DH not delegated to the key", opaqueS.calls == 2)
+}
+
+// keyAgreer exposes an opaqueDHKey through the byte-string KeyAgreer
+// interface, as platform code would.
+type keyAgreer struct {
+	*opaqueDHKey
+}
+
+func (k keyAgreer) PublicKeyBytes() ([]byte, error) {
+	return k.group.SerializePublicKey(k.sk.PublicKey()), nil
+}
+
+func (k keyAgreer) KeyAgreement(pkXm []byte) ([]byte, error) {
+	return k.DH(pkXm)
+}
+
+func TestKeyAgreer(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	group := suite.KEM.(dhkemScheme).group
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	platform := keyAgreer{&opaqueDHKey{group: group, sk: skR}}
+
+	opaqueR, err := NewDHPrivateKey(DHKEM_X25519, platform)
+	fatalOnError(t, err, "Error in NewDHPrivateKey")
+
+	kemID, err := KeyKEMID(opaqueR)
+	assertNotError(t, suite, "Error in KeyKEMID", err)
+	assert(t, suite, "Incorrect KEM", kemID == DHKEM_X25519)
+	assertBytesEqual(t, suite, "Incorrect public key", suite.KEM.SerializePublicKey(pkR), suite.KEM.SerializePublicKey(opaqueR.PublicKey()))
+
+	enc, ct, err := Seal(suite, rand.Reader, pkR, info, nil, original)
+	assertNotError(t, suite, "Error in Seal", err)
+	pt, err := Open(suite, opaqueR, enc, info, nil, ct)
+	assertNotError(t, suite, "Error in Open with platform key", err)
+	assertBytesEqual(t, suite, "Incorrect plaintext", original, pt)
+	assert(t, suite, "DH not delegated to the platform key", platform.calls == 1)
+
+	_, err = NewDHPrivateKey(DHKEM_P256, platform)
+	assert(t, suite, "Public key for another KEM accepted", err != nil)
+}
+
+func TestPSKStore(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	store := PSKStoreFunc(func(pskID []byte) ([]byte, error) {
+		if !bytes.Equal(pskID, fixedPSKID) {
+			return nil, fmt.Errorf("Unknown PSK ID")
+		}
+		return fixedPSK, nil
+	})
+
+	enc, ctxS, err := SetupPSKS(suite, rand.Reader, pkR, fixedPSK, fixedPSKID, info)
+	assertNotError(t, suite, "Error in SetupPSKS", err)
+
+	ctxR, err := SetupPSKRWithStore(suite, skR, enc, store, fixedPSKID, info)
+	assertNotError(t, suite, "Error in SetupPSKRWithStore", err)
+	assertBytesEqual(t, suite, "Incorrect exported secret", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+
+	enc, ctxS, err = SetupAuthPSKS(suite, rand.Reader, pkR, skS, fixedPSK, fixedPSKID, info)
+	assertNotError(t, suite, "Error in SetupAuthPSKS", err)
+
+	ctxR, err = SetupAuthPSKRWithStore(suite, skR, pkS, enc, store, fixedPSKID, info)
+	assertNotError(t, suite, "Error in SetupAuthPSKRWithStore", err)
+	assertBytesEqual(t, suite, "Incorrect exported secret", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+
+	_, err = SetupPSKRWithStore(suite, skR, enc, store, []byte("unknown"), info)
+	assert(t, suite, "Setup succeeded with unknown PSK ID", err != nil)
+}
+
+func TestSentinelErrors(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	_, err := AssembleCipherSuite(KEMID(0x0000), KDF_HKDF_SHA256, AEAD_AESGCM128)
+	assert(t, suite, "Unknown KEM not reported as unsupported", errors.Is(err, ErrUnsupportedSuite))
+
+	_, err = suite.KEM.DeserializePublicKey([]byte{0x00})
+	assert(t, suite, "Short public key not reported as invalid", errors.Is(err, ErrInvalidPublicKey))
+
+	_, _, err = SetupPSKS(suite, rand.Reader, pkR, nil, nil, info)
+	assert(t, suite, "Missing PSK not reported", errors.Is(err, ErrPSKRequired))
+
+	enc, ct, err := Seal(suite, rand.Reader, pkR, info, aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	_, err = Open(suite, skR, enc, info, nil, ct)
+	assert(t, suite, "Failed Open not reported", errors.Is(err, ErrOpenFailed))
+}
+
+type singleShotMode struct {
+	Seal func(suite CipherSuite, pkR KEMPublicKey, skS KEMPrivateKey, psk, psk_id, aad, pt []byte) ([]byte, []byte, error)
+	Open func(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, psk_id, aad, ct []byte) ([]byte, error)
+}
+
+var singleShotModes = map[Mode]singleShotMode{
+	ModeBase: {
+		Seal: func(suite CipherSuite, pkR KEMPublicKey, skS KEMPrivateKey, psk, psk_id, aad, pt []byte) ([]byte, []byte, error) {
+			return Seal(suite, rand.Reader, pkR, info, aad, pt)
+		},
+		Open: func(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, psk_id, aad, ct []byte) ([]byte, error) {
+			return Open(suite, skR, enc, info, aad, ct)
+		},
+	},
+	ModePSK: {
+		Seal: func(suite CipherSuite, pkR KEMPublicKey, skS KEMPrivateKey, psk, psk_id, aad, pt []byte) ([]byte, []byte, error) {
+			return SealPSK(suite, rand.Reader, pkR, psk, psk_id, info, aad, pt)
+		},
+		Open: func(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, psk_id, aad, ct []byte) ([]byte, error) {
+			return OpenPSK(suite, skR, enc, psk, psk_id, info, aad, ct)
+		},
+	},
+	ModeAuth: {
+		Seal: func(suite CipherSuite, pkR KEMPublicKey, skS KEMPrivateKey, psk, psk_id, aad, pt []byte) ([]byte, []byte, error) {
+			return SealAuth(suite, rand.Reader, pkR, skS, info, aad, pt)
+		},
+		Open: func(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, psk_id, aad, ct []byte) ([]byte, error) {
+			return OpenAuth(suite, skR, pkS, enc, info, aad, ct)
+		},
+	},
+	ModeAuthPSK: {
+		Seal: func(suite CipherSuite, pkR KEMPublicKey, skS KEMPrivateKey, psk, psk_id, aad, pt []byte) ([]byte, []byte, error) {
+			return SealAuthPSK(suite, rand.Reader, pkR, skS, psk, psk_id, info, aad, pt)
+		},
+		Open: func(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, psk_id, aad, ct []byte) ([]byte, error) {
+			return OpenAuthPSK(suite, skR, pkS, enc, psk, psk_id, info, aad, ct)
+		},
+	},
+}
+
+func TestSingleShot(t *testing.T) {
+	for kem_id, _ := range kems {
+		for kdf_id, _ := range kdfs {
+			for aead_id, _ := range aeads {
+				if aead_id == AEAD_EXPORT_ONLY {
+					continue
+				}
+
+				suite := mustAssembleSuite(t, kem_id, kdf_id, aead_id)
+
+				for mode, shot := range singleShotModes {
+					if !setupModes[mode].OK(suite) {
+						continue
+					}
+
+					// A PSK is only required for PSK mode variants.
+					var psk, psk_id []byte
+					if mode == ModePSK || mode == ModeAuthPSK {
+						psk = fixedPSK
+						psk_id = fixedPSKID
+					}
+
+					skS, pkS, _ := mustGenerateKeyPair(t, suite)
+					skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+					enc, ct, err := shot.Seal(suite, pkR, skS, psk, psk_id, aad, original)
+					assertNotError(t, suite, "Error in Seal", err)
+
+					pt, err := shot.Open(suite, skR, pkS, enc, psk, psk_id, aad, ct)
+					assertNotError(t, suite, "Error in Open", err)
+					assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+					_, err = shot.Open(suite, skR, pkS, enc, psk, psk_id, nil, ct)
+					assert(t, suite, "Open succeeded with incorrect AAD", err != nil)
+				}
+			}
+		}
+	}
+}
+
+func TestSingleShotExport(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_EXPORT_ONLY)
+
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, exportedI, err := SendExport(suite, pkR, exportContext, exportLength, WithInfo(info), WithSenderAuth(skS))
+	assertNotError(t, suite, "Error in SendExport", err)
+
+	exportedR, err := ReceiveExport(suite, skR, enc, exportContext, exportLength, WithInfo(info), WithSenderPublicKey(pkS))
+	assertNotError(t, suite, "Error in ReceiveExport", err)
+	assertBytesEqual(t, suite, "Incorrect exported secret", exportedI, exportedR)
+	assert(t, suite, "Incorrect export length", len(exportedI) == exportLength)
+
+	exportedBase, err := ReceiveExport(suite, skR, enc, exportContext, exportLength, WithInfo(info))
+	assertNotError(t, suite, "Error in ReceiveExport", err)
+	assert(t, suite, "Export matched with incorrect mode", !bytes.Equal(exportedI, exportedBase))
+}
+
+///////
+// Generation and processing of test vectors
+
+// seqFromNonce recovers the sequence number at which a nonce was used.  It
+// returns false if the nonce is absent or does not belong to the context.
+func seqFromNonce(baseNonce, nonce []byte) (uint64, bool) {
+	if len(nonce) != len(baseNonce) || len(nonce) < 8 {
+		return 0, false
+	}
+
+	Nn := len(nonce)
+	for i := 0; i < Nn-8; i++ {
+		if nonce[i] != baseNonce[i] {
+			return 0, false
+		}
+	}
+
+	return binary.BigEndian.Uint64(nonce[Nn-8:]) ^ binary.BigEndian.Uint64(baseNonce[Nn-8:]), true
+}
+
+// verifyEncryptions checks the encryptions of a vector.  Encryptions that
+// record a nonce are checked at the corresponding sequence number, so vectors
+// that only list some sequence numbers, like those published in RFC 9180, can
+// be verified.
+func verifyEncryptions(tv testVector, enc *SenderContext, dec *ReceiverContext) {
+	for _, data := range tv.encryptions {
+		if len(data.nonce) > 0 {
+			seq, ok := seqFromNonce(enc.BaseNonce, data.nonce)
+			assert(tv.t, tv.suite, "Nonce does not match base nonce", ok)
+			assertNotError(tv.t, tv.suite, "Error in SkipTo", enc.SkipTo(seq))
+			assertNotError(tv.t, tv.suite, "Error in SkipTo", dec.SkipTo(seq))
+		}
+
+		encrypted, err := enc.Seal(data.aad, data.plaintext)
+		assertNotError(tv.t, tv.suite, "Error in Seal", err)
+
+		decrypted, err := dec.Open(data.aad, encrypted)
+
+		assertNotError(tv.t, tv.suite, "Error in Open", err)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect encryption", encrypted, data.ciphertext)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect decryption", decrypted, data.plaintext)
+	}
+}
+
+func verifyParameters(tv testVector, ctx context) {
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'shared_secret'", tv.sharedSecret, ctx.setupParams.sharedSecret)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'enc'", tv.enc, ctx.setupParams.enc)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key_schedule_context'", tv.keyScheduleContext, ctx.contextParams.keyScheduleContext)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'secret'", tv.secret, ctx.contextParams.secret)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key'", tv.key, ctx.Key)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'base_nonce'", tv.baseNonce, ctx.BaseNonce)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'exporter_secret'", tv.exporterSecret, ctx.ExporterSecret)
+}
+
+func verifyPublicKeysEqual(tv testVector, pkX, pkY KEMPublicKey) {
+	pkXm := mustSerializePub(tv.suite, pkX)
+	pkYm := mustSerializePub(tv.suite, pkY)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect public key", []byte(pkXm), []byte(pkYm))
+}
+
+func verifyPrivateKeysEqual(tv testVector, skX, skY KEMPrivateKey) {
+	skXm := mustSerializePriv(tv.suite, skX)
+	skYm := mustSerializePriv(tv.suite, skY)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect private key", []byte(skXm), []byte(skYm))
+}
+
+func verifyTestVector(tv testVector) {
+	setup := setupModes[tv.mode]
+
+	skR, pkR, err := tv.suite.KEM.DeriveKeyPair(tv.ikmR)
+	assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
+	verifyPublicKeysEqual(tv, tv.pkR, pkR)
+	verifyPrivateKeysEqual(tv, tv.skR, skR)
+
+	skE, pkE, err := tv.suite.KEM.DeriveKeyPair(tv.ikmE)
+	assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
+	verifyPublicKeysEqual(tv, tv.pkE, pkE)
+	verifyPrivateKeysEqual(tv, tv.skE, skE)
+
+	var pkS KEMPublicKey
+	var skS KEMPrivateKey
+	if setup.Mode == ModeAuth || setup.Mode == ModeAuthPSK {
+		skS, pkS, err = tv.suite.KEM.DeriveKeyPair(tv.ikmS)
+		assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
+		verifyPublicKeysEqual(tv, tv.pkS, pkS)
+		verifyPrivateKeysEqual(tv, tv.skS, skS)
+	}
+
+	enc, ctxS, err := setup.I(tv.suite, pkR, tv.info, skS, tv.psk, tv.psk_id, WithEphemeralSeed(tv.ikmE), WithIntermediates())
+	assertNotError(tv.t, tv.suite, "Error in SetupI", err)
+	assertBytesEqual(tv.t, tv.suite, "Encapsulated key mismatch", enc, tv.enc)
+
+	ctxR, err := setup.R(tv.suite, skR, tv.enc, tv.info, pkS, tv.psk, tv.psk_id, WithIntermediates())
+	assertNotError(tv.t, tv.suite, "Error in SetupR", err)
+
+	verifyParameters(tv, ctxS.context)
+	verifyParameters(tv, ctxR.context)
+
+	verifyEncryptions(tv, ctxS, ctxR)
+}
+
+func vectorTest(vector testVector) func(t *testing.T) {
+	return func(t *testing.T) {
+		verifyTestVector(vector)
+	}
+}
+
+func verifyTestVectors(t *testing.T, vectorString []byte, subtest bool) {
+	vectors := testVectorArray{t: t}
+	err := json.Unmarshal(vectorString, &vectors)
+	if err != nil {
+		t.Fatalf("Error decoding test vector string: %v", err)
+	}
+
+	runTestVectors(t, vectors, subtest)
+}
+
+func runTestVectors(t *testing.T, vectors testVectorArray, subtest bool) {
+
+	for _, tv := range vectors.vectors {
+		test := vectorTest(tv)
+		if !subtest {
+			test(t)
+		} else {
+			label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%s", uint16(tv.kem_id), uint16(tv.kdf_id), uint16(tv.aead_id), tv.mode)
+			t.Run(label, test)
+		}
+	}
+}
+
+func generateEncryptions(t *testing.T, suite CipherSuite, ctxS *SenderContext, ctxR *ReceiverContext) ([]encryptionTestVector, error) {
+	vectors := make([]encryptionTestVector, testVectorEncryptionCount)
+	for i := 0; i < len(vectors); i++ {
+		aad := []byte(fmt.Sprintf("Count-%d", i))
+		encrypted, err := ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Encryption failure", err)
+
+		decrypted, err := ctxR.Open(aad, encrypted)
+		assertNotError(t, suite, "Decryption failure", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", original, decrypted)
+
+		vectors[i] = encryptionTestVector{
+			plaintext:  original,
+			aad:        aad,
+			nonce:      xorNonce(ctxS.BaseNonce, uint64(i)),
+			ciphertext: encrypted,
+		}
+	}
+
+	return vectors, nil
+}
+
+func generateExports(t *testing.T, suite CipherSuite, ctxS *SenderContext, ctxR *ReceiverContext) ([]exporterTestVector, error) {
+	exportContexts := [][]byte{
+		[]byte(""),
+		[]byte{0x00},
+		[]byte("TestContext"),
+	}
+	vectors := make([]exporterTestVector, len(exportContexts))
+	for i := 0; i < len(vectors); i++ {
+		exportI := ctxS.Export(exportContexts[i], testVectorExportLength)
+		exportR := ctxR.Export(exportContexts[i], testVectorExportLength)
+		assertBytesEqual(t, suite, "Incorrect export", exportI, exportR)
+		vectors[i] = exporterTestVector{
+			exportContext: exportContexts[i],
+			exportLength:  testVectorExportLength,
+			exportValue:   exportI,
+		}
+	}
+
+	return vectors, nil
+}
+
+func generateTestVector(t *testing.T, setup setupMode, kem_id KEMID, kdf_id KDFID, aead_id AEADID) testVector {
+	suite, err := AssembleCipherSuite(kem_id, kdf_id, aead_id)
+	if err != nil {
+		t.Fatalf("[%x, %x, %x] Error looking up ciphersuite: %s", uint16(kem_id), uint16(kdf_id), uint16(aead_id), err)
+	}
+
+	skR, pkR, ikmR := mustGenerateKeyPair(t, suite)
+	skE, pkE, ikmE := mustGenerateKeyPair(t, suite)
+
+	// The sender key share is only required for Auth mode variants.
+	var pkS KEMPublicKey
+	var skS KEMPrivateKey
+	var ikmS []byte
+	if setup.Mode == ModeAuth || setup.Mode == ModeAuthPSK {
+		skS, pkS, ikmS = mustGenerateKeyPair(t, suite)
+	}
+
+	// A PSK is only required for PSK mode variants.
+	var psk []byte
+	var psk_id []byte
+	if setup.Mode == ModePSK || setup.Mode == ModeAuthPSK {
+		psk = fixedPSK
+		psk_id = fixedPSKID
+	}
+
+	enc, ctxS, err := setup.I(suite, pkR, info, skS, psk, psk_id, WithEphemeralSeed(ikmE), WithIntermediates())
+	assertNotError(t, suite, "Error in SetupPSKS", err)
+
+	ctxR, err := setup.R(suite, skR, enc, info, pkS, psk, psk_id)
+	assertNotError(t, suite, "Error in SetupPSKR", err)
+
+	encryptionVectors := []encryptionTestVector{}
+	if aead_id != AEAD_EXPORT_ONLY {
+		encryptionVectors, err = generateEncryptions(t, suite, ctxS, ctxR)
+		assertNotError(t, suite, "Error in generateEncryptions", err)
+	}
+
+	exportVectors, err := generateExports(t, suite, ctxS, ctxR)
+	assertNotError(t, suite, "Error in generateExports", err)
+
+	vector := testVector{
+		t:                  t,
+		suite:              suite,
+		mode:               setup.Mode,
+		kem_id:             kem_id,
+		kdf_id:             kdf_id,
+		aead_id:            aead_id,
+		info:               info,
+		skR:                skR,
+		pkR:                pkR,
+		skS:                skS,
+		pkS:                pkS,
+		skE:                skE,
+		pkE:                pkE,
+		ikmR:               ikmR,
+		ikmS:               ikmS,
+		ikmE:               ikmE,
+		psk:                psk,
+		psk_id:             psk_id,
+		enc:                ctxS.setupParams.enc,
+		sharedSecret:       ctxS.setupParams.sharedSecret,
+		keyScheduleContext: ctxS.contextParams.keyScheduleContext,
+		secret:             ctxS.contextParams.secret,
+		key:                ctxS.Key,
+		baseNonce:          ctxS.BaseNonce,
+		exporterSecret:     ctxS.ExporterSecret,
+		encryptions:        encryptionVectors,
+		exports:            exportVectors,
+	}
+
+	return vector
+}
+
+func TestVectorGenerate(t *testing.T) {
+	// We only generate test vectors for select ciphersuites
+	supportedKEMs := []KEMID{DHKEM_X25519, DHKEM_X448, DHKEM_P256, DHKEM_P521}
+	supportedKDFs := []KDFID{KDF_HKDF_SHA256, KDF_HKDF_SHA512}
+	supportedAEADs := []AEADID{AEAD_AESGCM128, AEAD_AESGCM256, AEAD_CHACHA20POLY1305, AEAD_EXPORT_ONLY}
+
+	vectors := make([]testVector, 0)
+	for _, kem_id := range supportedKEMs {
+		for _, kdf_id := range supportedKDFs {
+			for _, aead_id := range supportedAEADs {
+				if _, err := AssembleCipherSuite(kem_id, kdf_id, aead_id); err != nil && minimalProfile {
+					continue
+				}
+
+				for _, setup := range setupModes {
+					vectors = append(vectors, generateTestVector(t, setup, kem_id, kdf_id, aead_id))
+				}
+			}
+		}
+	}
+
+	// Encode the test vectors
+	encoded, err := json.Marshal(vectors)
+	if err != nil {
+		t.Fatalf("Error producing test vectors: %v", err)
+	}
+
+	// Verify that we process them correctly
+	verifyTestVectors(t, encoded, false)
+
+	// Write them to a file if requested
+	var outputFile string
+	if outputFile = os.Getenv(outputTestVectorEnvironmentKey); len(outputFile) > 0 {
+		err = ioutil.WriteFile(outputFile, encoded, 0644)
+		if err != nil {
+			t.Fatalf("Error writing test vectors: %v", err)
+		}
+	}
+}
+
+// TestVectorSchema checks that generated vectors have exactly the fields of
+// the RFC 9180 test vector schema for their mode.
+func TestVectorSchema(t *testing.T) {
+	common := []string{
+		"mode", "kem_id", "kdf_id", "aead_id", "info",
+		"ikmR", "ikmE", "skRm", "skEm", "pkRm", "pkEm",
+		"enc", "shared_secret", "key_schedule_context", "secret", "key", "base_nonce", "exporter_secret",
+		"encryptions", "exports",
+	}
+	pskFields := []string{"psk", "psk_id"}
+	authFields := []string{"ikmS", "skSm", "pkSm"}
+
+	checkKeys := func(suite CipherSuite, obj map[string]json.RawMessage, expected []string) {
+		assert(t, suite, fmt.Sprintf("Incorrect number of fields [%d] != [%d]", len(obj), len(expected)), len(obj) == len(expected))
+		for _, key := range expected {
+			_, ok := obj[key]
+			assert(t, suite, fmt.Sprintf("Missing field %q", key), ok)
+		}
+	}
+
+	for _, setup := range setupModes {
+		tv := generateTestVector(t, setup, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+		encoded, err := json.Marshal(tv)
+		assertNotError(t, tv.suite, "Error marshaling test vector", err)
+
+		var obj map[string]json.RawMessage
+		err = json.Unmarshal(encoded, &obj)
+		assertNotError(t, tv.suite, "Error unmarshaling test vector", err)
+
+		expected := append([]string{}, common...)
+		if setup.Mode == ModePSK || setup.Mode == ModeAuthPSK {
+			expected = append(expected, pskFields...)
+		}
+		if setup.Mode == ModeAuth || setup.Mode == ModeAuthPSK {
+			expected = append(expected, authFields...)
+		}
+		checkKeys(tv.suite, obj, expected)
+
+		var encryptions, exports []map[string]json.RawMessage
+		err = json.Unmarshal(obj["encryptions"], &encryptions)
+		assertNotError(t, tv.suite, "Error unmarshaling encryptions", err)
+		err = json.Unmarshal(obj["exports"], &exports)
+		assertNotError(t, tv.suite, "Error unmarshaling exports", err)
+
+		checkKeys(tv.suite, encryptions[0], []string{"pt", "aad", "nonce", "ct"})
+		checkKeys(tv.suite, exports[0], []string{"exporter_context", "L", "exported_value"})
+	}
+}
+
+func TestVectorVerify(t *testing.T) {
+	var inputFile string
+	if inputFile = os.Getenv(inputTestVectorEnvironmentKey); len(inputFile) == 0 {
+		t.Skip("Test vectors were not provided")
+	}
+
+	encoded, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed reading test vectors: %v", err)
+	}
+
+	verifyTestVectors(t, encoded, true)
+}
+
+// TestVectorVerifyRFC verifies the test vectors published with RFC 9180.
+// Unlike TestVectorVerify, it skips vectors for suites that this package does
+// not implement, rather than failing.
+func TestVectorVerifyRFC(t *testing.T) {
+	var inputFile string
+	if inputFile = os.Getenv(rfcTestVectorEnvironmentKey); len(inputFile) == 0 {
+		t.Skip("RFC test vectors were not provided")
+	}
+
+	encoded, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed reading test vectors: %v", err)
+	}
+
+	var raw []json.RawMessage
+	err = json.Unmarshal(encoded, &raw)
+	if err != nil {
+		t.Fatalf("Error decoding test vector string: %v", err)
+	}
+
+	vectors := testVectorArray{t: t}
+	for _, data := range raw {
+		var ids rawTestVector
+		err = json.Unmarshal(data, &ids)
+		if err != nil {
+			t.Fatalf("Error decoding test vector: %v", err)
+		}
+
+		_, err = AssembleCipherSuite(KEMID(ids.KEMID), KDFID(ids.KDFID), AEADID(ids.AEADID))
+		if _, ok := setupModes[Mode(ids.Mode)]; err != nil || !ok {
+			t.Logf("Skipping unsupported vector kem=%04x/kdf=%04x/aead=%04x/mode=%d", ids.KEMID, ids.KDFID, ids.AEADID, ids.Mode)
+			continue
+		}
+
+		tv := testVector{t: t}
+		err = json.Unmarshal(data, &tv)
+		if err != nil {
+			t.Fatalf("Error decoding test vector: %v", err)
+		}
+		vectors.vectors = append(vectors.vectors, tv)
+	}
+
+	runTestVectors(t, vectors, true)
+}
+
+// verifyInteropVector checks a vector produced by another implementation from
+// the receiver's side, using only the fields that all sources provide.
+// Encryptions with a recorded nonce are opened at the corresponding sequence
+// number; others are opened in order.
+func verifyInteropVector(tv testVector) {
+	setup := setupModes[tv.mode]
+
+	ctxR, err := setup.R(tv.suite, tv.skR, tv.enc, tv.info, tv.pkS, tv.psk, tv.psk_id, WithIntermediates())
+	assertNotError(tv.t, tv.suite, "Error in SetupR", err)
+
+	if len(tv.baseNonce) > 0 {
+		verifyParameters(tv, ctxR.context)
+	}
+
+	for _, data := range tv.encryptions {
+		var decrypted []byte
+		if seq, ok := seqFromNonce(ctxR.BaseNonce, data.nonce); ok {
+			decrypted, err = ctxR.OpenWithSeq(seq, data.aad, data.ciphertext)
+		} else {
+			decrypted, err = ctxR.Open(data.aad, data.ciphertext)
+		}
+
+		assertNotError(tv.t, tv.suite, "Error in Open", err)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect decryption", decrypted, data.plaintext)
+	}
+
+	for _, data := range tv.exports {
+		exported := ctxR.Export(data.exportContext, data.exportLength)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect export", exported, data.exportValue)
+	}
+}
+
+func TestVectorInterop(t *testing.T) {
+	var inputFile string
+	if inputFile = os.Getenv(interopVectorEnvironmentKey); len(inputFile) == 0 {
+		t.Skip("Interop test vectors were not provided")
+	}
+
+	file, err := os.Open(inputFile)
+	if err != nil {
+		t.Fatalf("Failed opening interop test vectors: %v", err)
+	}
+	defer file.Close()
+
+	var loaded []testvectors.Vector
+	switch format := os.Getenv(interopFormatEnvironmentKey); format {
+	case "boringssl":
+		loaded, err = testvectors.LoadBoringSSL(file)
+	case "rust-hpke":
+		loaded, err = testvectors.LoadRustHPKE(file)
+	case "hpke-js":
+		loaded, err = testvectors.LoadHPKEJS(file)
+	default:
+		t.Fatalf("Unknown interop test vector format %q", format)
+	}
+	if err != nil {
+		t.Fatalf("Failed loading interop test vectors: %v", err)
+	}
+
+	// Skip suites that this package does not implement
+	supported := make([]testvectors.Vector, 0, len(loaded))
+	for _, v := range loaded {
+		_, err := AssembleCipherSuite(KEMID(v.KEMID), KDFID(v.KDFID), AEADID(v.AEADID))
+		if _, ok := setupModes[Mode(v.Mode)]; err != nil || !ok {
+			t.Logf("Skipping unsupported vector kem=%04x/kdf=%04x/aead=%04x/mode=%d", v.KEMID, v.KDFID, v.AEADID, v.Mode)
+			continue
+		}
+		supported = append(supported, v)
+	}
+
+	encoded, err := testvectors.Marshal(supported)
+	if err != nil {
+		t.Fatalf("Error normalizing interop test vectors: %v", err)
+	}
+
+	vectors := testVectorArray{t: t}
+	err = json.Unmarshal(encoded, &vectors)
+	if err != nil {
+		t.Fatalf("Error decoding normalized test vectors: %v", err)
+	}
+
+	for _, tv := range vectors.vectors {
+		tv := tv
+		label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%s", uint16(tv.kem_id), uint16(tv.kdf_id), uint16(tv.aead_id), tv.mode)
+		t.Run(label, func(t *testing.T) {
+			verifyInteropVector(tv)
+		})
+	}
+}
+
+func TestOpenRejectsShortCiphertext(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, _, err := NewSender(suite, pkR, WithInfo(info), WithSuiteBinding())
+	fatalOnError(t, err, "Error in NewSender")
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info), WithSuiteBinding(), WithReplayWindow(16))
+	fatalOnError(t, err, "Error in NewReceiver")
+
+	short := make([]byte, ctxR.aead.Overhead()-1)
+	for i := 0; i <= len(short); i++ {
+		_, err = ctxR.Open(aad, short[:i])
+		assert(t, suite, "Short ciphertext not rejected by Open", errors.Is(err, ErrOpenFailed))
+		_, err = ctxR.OpenWithSeq(0, aad, short[:i])
+		assert(t, suite, "Short ciphertext not rejected by OpenWithSeq", errors.Is(err, ErrOpenFailed))
+	}
+	assert(t, suite, "Sequence number advanced by rejected ciphertext", ctxR.Seq() == 0)
+
+	// Rejection happens before the AAD is bound, so it does not allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		ctxR.Open(aad, short)
+	})
+	assert(t, suite, "Rejecting a short ciphertext allocated", allocs == 0)
 }
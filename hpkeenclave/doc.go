@@ -0,0 +1,13 @@
+// Package hpkeenclave provides HPKE receiver keys held in the Apple Secure
+// Enclave, as a reference implementation of hpke.KeyAgreer for platform
+// keystores.  Secure Enclave keys are P-256 keys that cannot be exported;
+// the enclave performs ECDH by key reference, and the rest of DHKEM(P-256)
+// and the key schedule run locally.  This lets an app decrypt end-to-end
+// encrypted messages with a key bound to the device.
+//
+// The package requires cgo and is available only on Darwin.  Apps built
+// with gomobile for Android can implement hpke.KeyAgreer in Kotlin or Java
+// with an Android Keystore key created for KeyProperties.PURPOSE_AGREE_KEY,
+// performing javax.crypto.KeyAgreement "ECDH" with the AndroidKeyStore
+// provider.
+package hpkeenclave
@@ -0,0 +1,223 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package hpkeenclave
+
+/*
+#cgo LDFLAGS: -framework Security -framework CoreFoundation
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+static CFMutableDictionaryRef hpke_dictionary() {
+	return CFDictionaryCreateMutable(kCFAllocatorDefault, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+}
+
+static CFDataRef hpke_data(const void *p, int n) {
+	return CFDataCreate(kCFAllocatorDefault, p, n);
+}
+
+static SecKeyRef hpke_generate(CFDataRef tag, CFErrorRef *err) {
+	SecAccessControlRef access = SecAccessControlCreateWithFlags(kCFAllocatorDefault,
+		kSecAttrAccessibleWhenUnlockedThisDeviceOnly, kSecAccessControlPrivateKeyUsage, err);
+	if (access == NULL) {
+		return NULL;
+	}
+
+	CFMutableDictionaryRef priv = hpke_dictionary();
+	CFDictionarySetValue(priv, kSecAttrIsPermanent, kCFBooleanTrue);
+	CFDictionarySetValue(priv, kSecAttrApplicationTag, tag);
+	CFDictionarySetValue(priv, kSecAttrAccessControl, access);
+
+	int bits = 256;
+	CFNumberRef size = CFNumberCreate(kCFAllocatorDefault, kCFNumberIntType, &bits);
+
+	CFMutableDictionaryRef attrs = hpke_dictionary();
+	CFDictionarySetValue(attrs, kSecAttrKeyType, kSecAttrKeyTypeECSECPrimeRandom);
+	CFDictionarySetValue(attrs, kSecAttrKeySizeInBits, size);
+	CFDictionarySetValue(attrs, kSecAttrTokenID, kSecAttrTokenIDSecureEnclave);
+	CFDictionarySetValue(attrs, kSecPrivateKeyAttrs, priv);
+
+	SecKeyRef key = SecKeyCreateRandomKey(attrs, err);
+
+	CFRelease(attrs);
+	CFRelease(size);
+	CFRelease(priv);
+	CFRelease(access);
+	return key;
+}
+
+static CFMutableDictionaryRef hpke_query(CFDataRef tag) {
+	CFMutableDictionaryRef query = hpke_dictionary();
+	CFDictionarySetValue(query, kSecClass, kSecClassKey);
+	CFDictionarySetValue(query, kSecAttrApplicationTag, tag);
+	CFDictionarySetValue(query, kSecAttrKeyType, kSecAttrKeyTypeECSECPrimeRandom);
+	CFDictionarySetValue(query, kSecAttrTokenID, kSecAttrTokenIDSecureEnclave);
+	return query;
+}
+
+static SecKeyRef hpke_load(CFDataRef tag, OSStatus *status) {
+	CFMutableDictionaryRef query = hpke_query(tag);
+	CFDictionarySetValue(query, kSecReturnRef, kCFBooleanTrue);
+
+	SecKeyRef key = NULL;
+	*status = SecItemCopyMatching(query, (CFTypeRef *)&key);
+	CFRelease(query);
+	return key;
+}
+
+static OSStatus hpke_delete(CFDataRef tag) {
+	CFMutableDictionaryRef query = hpke_query(tag);
+	OSStatus status = SecItemDelete(query);
+	CFRelease(query);
+	return status;
+}
+
+static CFDataRef hpke_public_key(SecKeyRef key, CFErrorRef *err) {
+	SecKeyRef pub = SecKeyCopyPublicKey(key);
+	if (pub == NULL) {
+		return NULL;
+	}
+
+	CFDataRef data = SecKeyCopyExternalRepresentation(pub, err);
+	CFRelease(pub);
+	return data;
+}
+
+static CFDataRef hpke_ecdh(SecKeyRef key, CFDataRef peer, CFErrorRef *err) {
+	CFMutableDictionaryRef attrs = hpke_dictionary();
+	CFDictionarySetValue(attrs, kSecAttrKeyType, kSecAttrKeyTypeECSECPrimeRandom);
+	CFDictionarySetValue(attrs, kSecAttrKeyClass, kSecAttrKeyClassPublic);
+
+	SecKeyRef pub = SecKeyCreateWithData(peer, attrs, err);
+	CFRelease(attrs);
+	if (pub == NULL) {
+		return NULL;
+	}
+
+	CFMutableDictionaryRef params = hpke_dictionary();
+	CFDataRef secret = SecKeyCopyKeyExchangeResult(key, kSecKeyAlgorithmECDHKeyExchangeStandard, pub, params, err);
+	CFRelease(params);
+	CFRelease(pub);
+	return secret;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// Key is a Secure Enclave private key, stored in the keychain under an
+// application tag.  It implements hpke.KeyAgreer.
+type Key struct {
+	ref C.SecKeyRef
+}
+
+func cfData(b []byte) C.CFDataRef {
+	if len(b) == 0 {
+		return C.hpke_data(nil, 0)
+	}
+	return C.hpke_data(unsafe.Pointer(&b[0]), C.int(len(b)))
+}
+
+// goBytes copies and releases a CFData.
+func goBytes(data C.CFDataRef) []byte {
+	defer C.CFRelease(C.CFTypeRef(data))
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+}
+
+// cfError converts and releases a CFError.
+func cfError(err C.CFErrorRef) error {
+	if err == 0 {
+		return fmt.Errorf("Secure Enclave operation failed")
+	}
+
+	defer C.CFRelease(C.CFTypeRef(err))
+	return fmt.Errorf("Secure Enclave operation failed [%d]", int(C.CFErrorGetCode(err)))
+}
+
+// GenerateKey creates a P-256 key in the Secure Enclave and stores a
+// reference to it in the keychain under the given tag.  The key is usable
+// only while the device is unlocked and is never synchronized to other
+// devices.
+func GenerateKey(tag string) (*Key, error) {
+	cfTag := cfData([]byte(tag))
+	defer C.CFRelease(C.CFTypeRef(cfTag))
+
+	var err C.CFErrorRef
+	ref := C.hpke_generate(cfTag, &err)
+	if ref == 0 {
+		return nil, cfError(err)
+	}
+
+	return &Key{ref: ref}, nil
+}
+
+// LoadKey finds the Secure Enclave key stored under the given tag.
+func LoadKey(tag string) (*Key, error) {
+	cfTag := cfData([]byte(tag))
+	defer C.CFRelease(C.CFTypeRef(cfTag))
+
+	var status C.OSStatus
+	ref := C.hpke_load(cfTag, &status)
+	if status != C.errSecSuccess || ref == 0 {
+		return nil, fmt.Errorf("Secure Enclave key %q not found [%d]", tag, int(status))
+	}
+
+	return &Key{ref: ref}, nil
+}
+
+// DeleteKey deletes the Secure Enclave key stored under the given tag.
+func DeleteKey(tag string) error {
+	cfTag := cfData([]byte(tag))
+	defer C.CFRelease(C.CFTypeRef(cfTag))
+
+	if status := C.hpke_delete(cfTag); status != C.errSecSuccess {
+		return fmt.Errorf("Error deleting Secure Enclave key %q [%d]", tag, int(status))
+	}
+
+	return nil
+}
+
+// Close releases the key reference.  The key remains in the keychain.
+func (k *Key) Close() {
+	if k.ref != 0 {
+		C.CFRelease(C.CFTypeRef(k.ref))
+		k.ref = 0
+	}
+}
+
+// PublicKeyBytes returns the key's public key as an uncompressed point.
+func (k *Key) PublicKeyBytes() ([]byte, error) {
+	var err C.CFErrorRef
+	data := C.hpke_public_key(k.ref, &err)
+	if data == 0 {
+		return nil, cfError(err)
+	}
+
+	return goBytes(data), nil
+}
+
+// KeyAgreement performs ECDH in the Secure Enclave with the peer's
+// uncompressed public key, returning the x-coordinate of the shared point.
+func (k *Key) KeyAgreement(pkXm []byte) ([]byte, error) {
+	peer := cfData(pkXm)
+	defer C.CFRelease(C.CFTypeRef(peer))
+
+	var err C.CFErrorRef
+	secret := C.hpke_ecdh(k.ref, peer, &err)
+	if secret == 0 {
+		return nil, cfError(err)
+	}
+
+	return goBytes(secret), nil
+}
+
+// PrivateKey returns the key as an HPKE private key for DHKEM(P-256).
+func (k *Key) PrivateKey() (hpke.DHPrivateKey, error) {
+	return hpke.NewDHPrivateKey(hpke.DHKEM_P256, k)
+}
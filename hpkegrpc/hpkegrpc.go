@@ -0,0 +1,351 @@
+// Package hpkegrpc provides gRPC interceptors that encrypt message payloads
+// end-to-end with HPKE, independently of any transport security.  This
+// protects payloads that pass through TLS-terminating proxies, for example.
+//
+// The client encapsulates to the server's public key when a call or stream
+// starts and sends the encapsulated key in the "hpke-enc-bin" header.  Each
+// request message is sealed with the resulting context, and each response is
+// sealed with the response context derived from it (see
+// ReceiverContext.ResponseSender), so one HPKE setup covers all messages of a
+// stream.  The call's full method name is used as the AAD, binding each
+// message to the method it was sent to.
+//
+// Sealed messages travel with the "hpke" content subtype, whose codec is
+// registered by this package.  Both sides must install the interceptors.
+// gRPC decodes unary requests before the server interceptor runs, so the codec
+// stores the sealed payload in the request message itself, as an unknown
+// field, for the interceptor to open; unary request messages must therefore be
+// protocol buffers.
+package hpkegrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
+
+const (
+	// ContentSubtype is the gRPC content subtype of sealed messages.
+	ContentSubtype = "hpke"
+
+	// EncHeader is the metadata key that carries the encapsulated key.
+	EncHeader = "hpke-enc-bin"
+)
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// sealedMessage is a message payload that has already been encrypted.
+type sealedMessage struct {
+	data []byte
+}
+
+// sealedField is the number of the unknown field in which the codec stores a
+// sealed unary request.  It is the largest valid field number, which messages
+// do not use in practice.
+const sealedField = protowire.MaxValidNumber
+
+// protoMessage returns the reflective view of a protobuf message, generated
+// with either version of the protobuf API.
+func protoMessage(v interface{}) (protoreflect.Message, bool) {
+	switch m := v.(type) {
+	case protoreflect.ProtoMessage:
+		return m.ProtoReflect(), true
+	case protoiface.MessageV1:
+		return protoimpl.X.ProtoMessageV2Of(m).ProtoReflect(), true
+	}
+	return nil, false
+}
+
+// codec passes sealed payloads through unchanged.  When gRPC decodes a unary
+// request into a typed message, the payload is stored in the message, for the
+// server interceptor to decrypt.
+type codec struct{}
+
+func (codec) Name() string {
+	return ContentSubtype
+}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	sealed, ok := v.(*sealedMessage)
+	if !ok {
+		return nil, fmt.Errorf("The hpke codec requires the hpkegrpc interceptors [%T]", v)
+	}
+
+	return sealed.data, nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if sealed, ok := v.(*sealedMessage); ok {
+		sealed.data = append([]byte{}, data...)
+		return nil
+	}
+
+	m, ok := protoMessage(v)
+	if !ok {
+		return fmt.Errorf("The hpke codec requires protobuf request messages [%T]", v)
+	}
+
+	field := protowire.AppendTag(nil, sealedField, protowire.BytesType)
+	m.SetUnknown(protowire.AppendBytes(field, data))
+	return nil
+}
+
+// takeSealedRequest removes the sealed payload that the codec stored in a
+// unary request message.
+func takeSealedRequest(req interface{}) ([]byte, bool) {
+	m, ok := protoMessage(req)
+	if !ok {
+		return nil, false
+	}
+
+	unknown := m.GetUnknown()
+	num, typ, n := protowire.ConsumeTag(unknown)
+	if n < 0 || num != sealedField || typ != protowire.BytesType {
+		return nil, false
+	}
+
+	data, length := protowire.ConsumeBytes(unknown[n:])
+	if length < 0 || n+length != len(unknown) {
+		return nil, false
+	}
+
+	m.SetUnknown(nil)
+	return data, true
+}
+
+// Options configures the interceptors.
+type Options struct {
+	// Codec serializes messages before encryption.  The default is the
+	// "proto" codec.
+	Codec encoding.Codec
+
+	// Info is the HPKE info string, which both sides must agree on.
+	Info []byte
+}
+
+func (opts Options) codec() encoding.Codec {
+	if opts.Codec != nil {
+		return opts.Codec
+	}
+	return encoding.GetCodec("proto")
+}
+
+// seal serializes and encrypts a message.
+func seal(ctx *hpke.SenderContext, inner encoding.Codec, method string, m interface{}) (*sealedMessage, error) {
+	pt, err := inner.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := ctx.Seal([]byte(method), pt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealedMessage{data: ct}, nil
+}
+
+// open decrypts and deserializes a message.
+func open(ctx *hpke.ReceiverContext, inner encoding.Codec, method string, data []byte, m interface{}) error {
+	pt, err := ctx.Open([]byte(method), data)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return inner.Unmarshal(pt, m)
+}
+
+// clientSetup encapsulates to the server and attaches the encapsulated key to
+// the outgoing metadata.
+func clientSetup(ctx context.Context, suite hpke.CipherSuite, rand io.Reader, pkR hpke.KEMPublicKey, opts Options) (context.Context, *hpke.SenderContext, *hpke.ReceiverContext, error) {
+	enc, sender, err := hpke.SetupBaseS(suite, rand, pkR, opts.Info)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	receiver, err := sender.ResponseReceiver()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, EncHeader, string(enc))
+	return ctx, sender, receiver, nil
+}
+
+// serverSetup decapsulates the key from the incoming metadata.
+func serverSetup(ctx context.Context, suite hpke.CipherSuite, skR hpke.KEMPrivateKey, opts Options) (*hpke.ReceiverContext, *hpke.SenderContext, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	encs := md.Get(EncHeader)
+	if len(encs) != 1 {
+		return nil, nil, status.Error(codes.InvalidArgument, "Missing HPKE encapsulated key")
+	}
+
+	receiver, err := hpke.SetupBaseR(suite, skR, []byte(encs[0]), opts.Info)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	sender, err := receiver.ResponseSender()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return receiver, sender, nil
+}
+
+// UnaryClientInterceptor returns an interceptor that encrypts unary requests
+// to the server's public key pkR and decrypts the responses.
+func UnaryClientInterceptor(suite hpke.CipherSuite, rand io.Reader, pkR hpke.KEMPublicKey, opts Options) grpc.UnaryClientInterceptor {
+	inner := opts.codec()
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		ctx, sender, receiver, err := clientSetup(ctx, suite, rand, pkR, opts)
+		if err != nil {
+			return err
+		}
+
+		sealedReq, err := seal(sender, inner, method, req)
+		if err != nil {
+			return err
+		}
+
+		sealedReply := &sealedMessage{}
+		callOpts = append(callOpts, grpc.CallContentSubtype(ContentSubtype))
+		if err := invoker(ctx, method, sealedReq, sealedReply, cc, callOpts...); err != nil {
+			return err
+		}
+
+		return open(receiver, inner, method, sealedReply.data, reply)
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor that decrypts unary requests
+// with the server's private key skR and encrypts the responses.
+func UnaryServerInterceptor(suite hpke.CipherSuite, skR hpke.KEMPrivateKey, opts Options) grpc.UnaryServerInterceptor {
+	inner := opts.codec()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		data, ok := takeSealedRequest(req)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "Request was not encrypted with HPKE")
+		}
+
+		receiver, sender, err := serverSetup(ctx, suite, skR, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := open(receiver, inner, info.FullMethod, data, req); err != nil {
+			return nil, err
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return seal(sender, inner, info.FullMethod, resp)
+	}
+}
+
+// clientStream seals outgoing messages and opens incoming ones.
+type clientStream struct {
+	grpc.ClientStream
+	method   string
+	inner    encoding.Codec
+	sender   *hpke.SenderContext
+	receiver *hpke.ReceiverContext
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	sealed, err := seal(s.sender, s.inner, s.method, m)
+	if err != nil {
+		return err
+	}
+
+	return s.ClientStream.SendMsg(sealed)
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	sealed := &sealedMessage{}
+	if err := s.ClientStream.RecvMsg(sealed); err != nil {
+		return err
+	}
+
+	return open(s.receiver, s.inner, s.method, sealed.data, m)
+}
+
+// StreamClientInterceptor returns an interceptor that encrypts the messages
+// of client streams to the server's public key pkR and decrypts the messages
+// that the server sends.
+func StreamClientInterceptor(suite hpke.CipherSuite, rand io.Reader, pkR hpke.KEMPublicKey, opts Options) grpc.StreamClientInterceptor {
+	inner := opts.codec()
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, sender, receiver, err := clientSetup(ctx, suite, rand, pkR, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		callOpts = append(callOpts, grpc.CallContentSubtype(ContentSubtype))
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &clientStream{ClientStream: stream, method: method, inner: inner, sender: sender, receiver: receiver}, nil
+	}
+}
+
+// serverStream opens incoming messages and seals outgoing ones.
+type serverStream struct {
+	grpc.ServerStream
+	method   string
+	inner    encoding.Codec
+	receiver *hpke.ReceiverContext
+	sender   *hpke.SenderContext
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	sealed, err := seal(s.sender, s.inner, s.method, m)
+	if err != nil {
+		return err
+	}
+
+	return s.ServerStream.SendMsg(sealed)
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	sealed := &sealedMessage{}
+	if err := s.ServerStream.RecvMsg(sealed); err != nil {
+		return err
+	}
+
+	return open(s.receiver, s.inner, s.method, sealed.data, m)
+}
+
+// StreamServerInterceptor returns an interceptor that decrypts the messages
+// of streams with the server's private key skR and encrypts the messages that
+// the server sends.
+func StreamServerInterceptor(suite hpke.CipherSuite, skR hpke.KEMPrivateKey, opts Options) grpc.StreamServerInterceptor {
+	inner := opts.codec()
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		receiver, sender, err := serverSetup(ss.Context(), suite, skR, opts)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, method: info.FullMethod, inner: inner, receiver: receiver, sender: sender})
+	}
+}
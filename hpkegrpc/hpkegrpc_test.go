@@ -0,0 +1,244 @@
+package hpkegrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testMethod = "/test.Echo/Echo"
+
+// stringCodec serializes *string messages, standing in for protobuf.
+type stringCodec struct{}
+
+func (stringCodec) Name() string {
+	return "string"
+}
+
+func (stringCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(*v.(*string)), nil
+}
+
+func (stringCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func setup(t *testing.T) (hpke.CipherSuite, hpke.KEMPrivateKey, hpke.KEMPublicKey, Options) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	return suite, skR, pkR, Options{Codec: stringCodec{}, Info: []byte("hpkegrpc test")}
+}
+
+// toServer carries the client's outgoing metadata to the server.
+func toServer(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func hasContentSubtype(opts []grpc.CallOption) bool {
+	for _, opt := range opts {
+		if opt == grpc.CallContentSubtype(ContentSubtype) {
+			return true
+		}
+	}
+	return false
+}
+
+func echo(ctx context.Context, req interface{}) (interface{}, error) {
+	return wrapperspb.String("echo: " + req.(*wrapperspb.StringValue).Value), nil
+}
+
+func TestUnary(t *testing.T) {
+	// Unary requests are protobuf messages, serialized with the default codec
+	suite, skR, pkR, opts := setup(t)
+	opts.Codec = nil
+	client := UnaryClientInterceptor(suite, rand.Reader, pkR, opts)
+	server := UnaryServerInterceptor(suite, skR, opts)
+	wire := encoding.GetCodec(ContentSubtype)
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+
+	var observed []byte
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		require.True(t, hasContentSubtype(callOpts), "Content subtype not set")
+
+		data, err := wire.Marshal(req)
+		if err != nil {
+			return err
+		}
+		observed = data
+
+		// gRPC decodes the request before the server interceptor runs.
+		serverReq := new(wrapperspb.StringValue)
+		if err := wire.Unmarshal(data, serverReq); err != nil {
+			return err
+		}
+
+		resp, err := server(toServer(ctx), serverReq, info, echo)
+		if err != nil {
+			return err
+		}
+
+		data, err = wire.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		return wire.Unmarshal(data, reply)
+	}
+
+	req, reply := wrapperspb.String("hello"), new(wrapperspb.StringValue)
+	err := client(context.Background(), testMethod, req, reply, nil, invoker)
+	require.Nil(t, err, "Error in unary call")
+	require.Equal(t, "echo: hello", reply.Value, "Incorrect reply")
+	require.False(t, bytes.Contains(observed, []byte(req.Value)), "Request sent in the clear")
+
+	// The server rejects requests bound to another method
+	wrongMethod := &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Other"}
+	invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		data, _ := wire.Marshal(req)
+		serverReq := new(wrapperspb.StringValue)
+		wire.Unmarshal(data, serverReq)
+		_, err := server(toServer(ctx), serverReq, wrongMethod, echo)
+		return err
+	}
+	err = client(context.Background(), testMethod, req, reply, nil, invoker)
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "Request for another method accepted")
+
+	// The server rejects requests without an encapsulated key
+	serverReq := new(wrapperspb.StringValue)
+	wire.Unmarshal([]byte("hello"), serverReq)
+	_, err = server(context.Background(), serverReq, info, echo)
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "Request without encapsulated key accepted")
+
+	// The server rejects requests that were not decoded with the hpke codec
+	_, err = server(context.Background(), wrapperspb.String("hello"), info, echo)
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "Plaintext request accepted")
+
+	_, err = wire.Marshal(req)
+	require.NotNil(t, err, "Codec marshaled an unsealed message")
+
+	// Only protobuf requests can carry a sealed payload
+	err = wire.Unmarshal([]byte("hello"), new(string))
+	require.NotNil(t, err, "Codec decoded a request into a non-protobuf message")
+}
+
+// pipeStream is one end of an in-memory stream, encoding messages with the
+// hpke codec as gRPC would.
+type pipeStream struct {
+	grpc.ClientStream
+	grpc.ServerStream
+	ctx  context.Context
+	send chan []byte
+	recv chan []byte
+}
+
+func newPipe(ctx context.Context) (*pipeStream, *pipeStream) {
+	a, b := make(chan []byte, 16), make(chan []byte, 16)
+	return &pipeStream{ctx: ctx, send: a, recv: b}, &pipeStream{ctx: toServer(ctx), send: b, recv: a}
+}
+
+func (p *pipeStream) Context() context.Context {
+	return p.ctx
+}
+
+func (p *pipeStream) SendMsg(m interface{}) error {
+	data, err := encoding.GetCodec(ContentSubtype).Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	p.send <- data
+	return nil
+}
+
+func (p *pipeStream) RecvMsg(m interface{}) error {
+	return encoding.GetCodec(ContentSubtype).Unmarshal(<-p.recv, m)
+}
+
+func TestStream(t *testing.T) {
+	suite, skR, pkR, opts := setup(t)
+	client := StreamClientInterceptor(suite, rand.Reader, pkR, opts)
+	server := StreamServerInterceptor(suite, skR, opts)
+
+	var serverEnd *pipeStream
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		require.True(t, hasContentSubtype(callOpts), "Content subtype not set")
+
+		var clientEnd *pipeStream
+		clientEnd, serverEnd = newPipe(ctx)
+		return clientEnd, nil
+	}
+
+	stream, err := client(context.Background(), &grpc.StreamDesc{}, nil, testMethod, streamer)
+	require.Nil(t, err, "Error opening stream")
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		require.Nil(t, stream.SendMsg(&msg), "Error sending message")
+	}
+
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		for i := 0; i < count; i++ {
+			var msg string
+			if err := ss.RecvMsg(&msg); err != nil {
+				return err
+			}
+
+			resp := "echo: " + msg
+			if err := ss.SendMsg(&resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = server(nil, serverEnd, &grpc.StreamServerInfo{FullMethod: testMethod}, handler)
+	require.Nil(t, err, "Error in stream handler")
+
+	for i := 0; i < count; i++ {
+		var msg string
+		require.Nil(t, stream.RecvMsg(&msg), "Error receiving message")
+		require.Equal(t, fmt.Sprintf("echo: message %d", i), msg, "Incorrect message")
+	}
+
+	// A message replayed within a stream is rejected
+	stream, err = client(context.Background(), &grpc.StreamDesc{}, nil, testMethod, streamer)
+	require.Nil(t, err, "Error opening stream")
+
+	msg := "replayed"
+	require.Nil(t, stream.SendMsg(&msg), "Error sending message")
+	sealed := <-serverEnd.recv
+	serverEnd.recv <- sealed
+	serverEnd.recv <- sealed
+
+	received := 0
+	err = server(nil, serverEnd, &grpc.StreamServerInfo{FullMethod: testMethod}, func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			var msg string
+			if err := ss.RecvMsg(&msg); err != nil {
+				return err
+			}
+			received++
+		}
+	})
+	require.Equal(t, 1, received, "Incorrect number of messages received")
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "Replayed message accepted")
+}
@@ -0,0 +1,69 @@
+// Package awskms adapts AWS KMS key agreement keys for use as HPKE receiver
+// keys.  The key must be an ECC_NIST_P256 or ECC_NIST_P521 key with the
+// KEY_AGREEMENT usage; the caller needs kms:GetPublicKey and
+// kms:DeriveSharedSecret permissions on it.
+package awskms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/cisco/go-hpke/hpkekms"
+)
+
+// API is the subset of the KMS client that the adapter uses.  *kms.KMS and
+// kmsiface.KMSAPI implement it.
+type API interface {
+	GetPublicKeyWithContext(aws.Context, *kms.GetPublicKeyInput, ...request.Option) (*kms.GetPublicKeyOutput, error)
+	DeriveSharedSecretWithContext(aws.Context, *kms.DeriveSharedSecretInput, ...request.Option) (*kms.DeriveSharedSecretOutput, error)
+}
+
+// Client is an hpkekms.Client for an AWS KMS key.  KeyID may be a key ID,
+// key ARN, alias name, or alias ARN.
+type Client struct {
+	API         API
+	KeyID       string
+	GrantTokens []*string
+}
+
+// PublicKey fetches the key's public key, checking that it is a key
+// agreement key.
+func (c *Client) PublicKey(ctx context.Context) ([]byte, error) {
+	out, err := c.API.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+		KeyId:       aws.String(c.KeyID),
+		GrantTokens: c.GrantTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if usage := aws.StringValue(out.KeyUsage); usage != kms.KeyUsageTypeKeyAgreement {
+		return nil, fmt.Errorf("KMS key is not a key agreement key [%s]", usage)
+	}
+
+	return out.PublicKey, nil
+}
+
+// DeriveSharedSecret performs ECDH in KMS.
+func (c *Client) DeriveSharedSecret(ctx context.Context, peer []byte) ([]byte, error) {
+	out, err := c.API.DeriveSharedSecretWithContext(ctx, &kms.DeriveSharedSecretInput{
+		KeyId:                 aws.String(c.KeyID),
+		KeyAgreementAlgorithm: aws.String(kms.KeyAgreementAlgorithmSpecEcdh),
+		PublicKey:             peer,
+		GrantTokens:           c.GrantTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.SharedSecret, nil
+}
+
+// NewKey returns an HPKE private key backed by the KMS key with the given
+// ID.  See hpkekms.NewKey for the use of the context.
+func NewKey(ctx context.Context, api API, keyID string) (*hpkekms.Key, error) {
+	return hpkekms.NewKey(ctx, &Client{API: api, KeyID: keyID})
+}
@@ -0,0 +1,80 @@
+package awskms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeKMS struct {
+	t     *testing.T
+	keyID string
+	usage string
+	sk    *ecdsa.PrivateKey
+}
+
+func (f *fakeKMS) GetPublicKeyWithContext(ctx aws.Context, in *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+	require.Equal(f.t, f.keyID, aws.StringValue(in.KeyId), "Incorrect key ID")
+
+	der, err := x509.MarshalPKIXPublicKey(&f.sk.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kms.GetPublicKeyOutput{
+		KeyId:     in.KeyId,
+		KeySpec:   aws.String(kms.KeySpecEccNistP256),
+		KeyUsage:  aws.String(f.usage),
+		PublicKey: der,
+	}, nil
+}
+
+func (f *fakeKMS) DeriveSharedSecretWithContext(ctx aws.Context, in *kms.DeriveSharedSecretInput, opts ...request.Option) (*kms.DeriveSharedSecretOutput, error) {
+	require.Equal(f.t, f.keyID, aws.StringValue(in.KeyId), "Incorrect key ID")
+	require.Equal(f.t, kms.KeyAgreementAlgorithmSpecEcdh, aws.StringValue(in.KeyAgreementAlgorithm), "Incorrect algorithm")
+
+	pub, err := x509.ParsePKIXPublicKey(in.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ecPub := pub.(*ecdsa.PublicKey)
+	x, _ := ecPub.Curve.ScalarMult(ecPub.X, ecPub.Y, f.sk.D.Bytes())
+	z := make([]byte, 32)
+	xb := x.Bytes()
+	copy(z[len(z)-len(xb):], xb)
+
+	return &kms.DeriveSharedSecretOutput{KeyId: in.KeyId, SharedSecret: z}, nil
+}
+
+func TestAWSKMS(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "Error generating key")
+	api := &fakeKMS{t: t, keyID: "alias/hpke", usage: kms.KeyUsageTypeKeyAgreement, sk: ecKey}
+
+	skR, err := NewKey(context.Background(), api, "alias/hpke")
+	require.Nil(t, err, "Error in NewKey")
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+	enc, ct, err := hpke.Seal(suite, rand.Reader, skR.PublicKey(), info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+	opened, err := hpke.Open(suite, skR, enc, info, aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	api.usage = "SIGN_VERIFY"
+	_, err = NewKey(context.Background(), api, "alias/hpke")
+	require.NotNil(t, err, "Signing key accepted")
+}
@@ -0,0 +1,98 @@
+// Package hpkekms provides HPKE receiver keys held in a cloud key management
+// service.  A Key implements hpke.DHPrivateKey on top of a KMS that can
+// perform ECDH with a non-exportable key: the DHKEM's Diffie-Hellman
+// operation is a KMS call, and the rest of the KEM and the key schedule run
+// locally.  Senders are unchanged, since they only see an ordinary public
+// key.
+//
+// A Client adapts a particular KMS.  The awskms subpackage provides one for
+// AWS KMS, whose DeriveSharedSecret API performs ECDH with ECC_NIST_P256 and
+// ECC_NIST_P521 key agreement keys.  Google Cloud KMS does not offer ECDH on
+// its EC keys, so there is no adapter for it; any KMS that does can be used
+// by implementing Client.
+package hpkekms
+
+import (
+	"context"
+	"fmt"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// Client is a key agreement key in a KMS.  Keys are exchanged in the form
+// that KMS APIs use, DER SubjectPublicKeyInfo.
+type Client interface {
+	// PublicKey returns the key's public key.
+	PublicKey(ctx context.Context) ([]byte, error)
+
+	// DeriveSharedSecret performs ECDH with the peer's public key, returning
+	// the raw shared secret, i.e., the x-coordinate of the shared point.
+	DeriveSharedSecret(ctx context.Context, peer []byte) ([]byte, error)
+}
+
+// Key is a private key in a KMS, for use with the HPKE receiver setup
+// functions or as an Auth mode sender key.
+type Key struct {
+	client Client
+	ctx    context.Context
+	kem    hpke.KEMScheme
+	pk     hpke.KEMPublicKey
+}
+
+// NewKey fetches the public key of a KMS key.  The context is used for that
+// request and for the KMS calls that the returned key makes during
+// decapsulation, which have no context of their own, so it should not be a
+// short-lived request context.
+func NewKey(ctx context.Context, client Client) (*Key, error) {
+	der, err := client.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kemID, pk, err := hpke.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	// The KEM ID alone determines the KEM, so any KDF and AEAD will do.
+	suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{client: client, ctx: ctx, kem: suite.KEM, pk: pk}, nil
+}
+
+// KEMID returns the identifier of the key's KEM.
+func (k *Key) KEMID() hpke.KEMID {
+	return k.kem.ID()
+}
+
+// PublicKey returns the key's public key.
+func (k *Key) PublicKey() hpke.KEMPublicKey {
+	return k.pk
+}
+
+// DH asks the KMS to perform ECDH with the peer's serialized public key.
+func (k *Key) DH(pkXm []byte) ([]byte, error) {
+	pk, err := k.kem.DeserializePublicKey(pkXm)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := hpke.MarshalPKIXPublicKey(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := k.client.DeriveSharedSecret(k.ctx, der)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(z) != (len(pkXm)-1)/2 {
+		return nil, fmt.Errorf("KMS returned a shared secret of the wrong size [%d]", len(z))
+	}
+
+	return z, nil
+}
@@ -0,0 +1,89 @@
+package hpkekms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+// softwareKMS stands in for a KMS holding a P-256 key.
+type softwareKMS struct {
+	sk    *ecdsa.PrivateKey
+	calls int
+}
+
+func (k *softwareKMS) PublicKey(ctx context.Context) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(&k.sk.PublicKey)
+}
+
+func (k *softwareKMS) DeriveSharedSecret(ctx context.Context, peer []byte) ([]byte, error) {
+	k.calls++
+	pub, err := x509.ParsePKIXPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok || ecPub.Curve != k.sk.Curve {
+		return nil, fmt.Errorf("Wrong key type")
+	}
+
+	x, _ := ecPub.Curve.ScalarMult(ecPub.X, ecPub.Y, k.sk.D.Bytes())
+	z := make([]byte, 32)
+	xb := x.Bytes()
+	copy(z[len(z)-len(xb):], xb)
+	return z, nil
+}
+
+func TestKMSKey(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "Error generating key")
+	kms := &softwareKMS{sk: ecKey}
+
+	skR, err := NewKey(context.Background(), kms)
+	require.Nil(t, err, "Error in NewKey")
+	require.Equal(t, hpke.DHKEM_P256, skR.KEMID(), "Incorrect KEM")
+	pkR := skR.PublicKey()
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+
+	enc, ct, err := hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+	opened, err := hpke.Open(suite, skR, enc, info, aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+	require.Equal(t, 1, kms.calls, "DH not performed by the KMS")
+
+	// Auth mode
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skS, pkS, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	enc, ctxS, err := hpke.SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	require.Nil(t, err, "Error in SetupAuthS")
+	ct, err = ctxS.Seal(aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err := hpke.SetupAuthR(suite, skR, pkS, enc, info)
+	require.Nil(t, err, "Error in SetupAuthR")
+	opened, err = ctxR.Open(aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// Malformed encapsulated keys are rejected before reaching the KMS
+	calls := kms.calls
+	_, err = hpke.SetupBaseR(suite, skR, []byte{0x04, 0x01}, info)
+	require.NotNil(t, err, "Malformed encapsulated key accepted")
+	require.Equal(t, calls, kms.calls, "Malformed key sent to the KMS")
+}
@@ -0,0 +1,57 @@
+// Package hpkepb defines a protocol buffer message type for carrying HPKE
+// ciphertexts, so that gRPC and other protobuf-based systems can exchange them
+// in a standard form.  The schema is in envelope.proto, from which the
+// Envelope type is generated; envelopes are serialized with the protobuf
+// runtime, e.g., google.golang.org/protobuf/proto.
+package hpkepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative envelope.proto
+
+import (
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// Seal encrypts the plaintext to pkR with a single-shot HPKE encryption and
+// returns the result as an envelope labeled with keyID.
+func Seal(suite hpke.CipherSuite, rand io.Reader, pkR hpke.KEMPublicKey, keyID, info, aad, pt []byte) (*Envelope, error) {
+	enc, ct, err := hpke.Seal(suite, rand, pkR, info, aad, pt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		KemId:      uint32(suite.KEM.ID()),
+		KdfId:      uint32(suite.KDF.ID()),
+		AeadId:     uint32(suite.AEAD.ID()),
+		KeyId:      keyID,
+		Enc:        enc,
+		Ciphertext: ct,
+	}, nil
+}
+
+// Suite assembles the ciphersuite that the envelope names.  The identifiers
+// are uint32 fields in the schema, but HPKE identifiers are 16 bits.
+func (x *Envelope) Suite() (hpke.CipherSuite, error) {
+	ids := []uint32{x.GetKemId(), x.GetKdfId(), x.GetAeadId()}
+	for i, id := range ids {
+		if id > 0xFFFF {
+			return hpke.CipherSuite{}, fmt.Errorf("Algorithm identifier out of range [%d: %d]", i+1, id)
+		}
+	}
+
+	return hpke.AssembleCipherSuite(hpke.KEMID(ids[0]), hpke.KDFID(ids[1]), hpke.AEADID(ids[2]))
+}
+
+// Open decrypts the envelope with skR, which must be the private key for the
+// envelope's key ID.
+func (x *Envelope) Open(skR hpke.KEMPrivateKey, info, aad []byte) ([]byte, error) {
+	suite, err := x.Suite()
+	if err != nil {
+		return nil, err
+	}
+
+	return hpke.Open(suite, skR, x.GetEnc(), info, aad, x.GetCiphertext())
+}
@@ -0,0 +1,90 @@
+package hpkepb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEnvelopeEncoding(t *testing.T) {
+	env := &Envelope{
+		KemId:      uint32(hpke.DHKEM_X25519),
+		KdfId:      uint32(hpke.KDF_HKDF_SHA256),
+		AeadId:     uint32(hpke.AEAD_CHACHA20POLY1305),
+		KeyId:      []byte{0x01},
+		Enc:        []byte{0x02, 0x03},
+		Ciphertext: []byte{0x04},
+	}
+
+	expected := "0820100118032201012a020203320104"
+
+	data, err := proto.Marshal(env)
+	require.Nil(t, err, "Error in Marshal")
+	require.Equal(t, expected, hex.EncodeToString(data), "Incorrect encoding")
+
+	decoded := &Envelope{}
+	require.Nil(t, proto.Unmarshal(data, decoded), "Error in Unmarshal")
+	require.True(t, proto.Equal(env, decoded), "Envelope did not round-trip")
+
+	// Unknown fields of every wire type are accepted
+	unknown := append(append([]byte{}, data...), 0x38, 0x01, 0x41, 0, 0, 0, 0, 0, 0, 0, 0, 0x4a, 0x00, 0x55, 0, 0, 0, 0)
+	require.Nil(t, proto.Unmarshal(unknown, decoded), "Error skipping unknown fields")
+	require.Equal(t, env.GetEnc(), decoded.GetEnc(), "Unknown fields changed the envelope")
+
+	// The zero envelope encodes to nothing
+	data, err = proto.Marshal(&Envelope{})
+	require.Nil(t, err, "Error in Marshal")
+	require.Equal(t, 0, len(data), "Default values encoded")
+
+	for _, invalid := range []string{
+		"08",       // truncated varint
+		"2205",     // truncated bytes
+		"41000000", // truncated fixed64
+		"0b",       // unterminated group
+		"0001",     // field number zero
+	} {
+		raw, _ := hex.DecodeString(invalid)
+		require.NotNil(t, proto.Unmarshal(raw, decoded), "Invalid envelope accepted: %s", invalid)
+	}
+}
+
+func TestEnvelopeSealOpen(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+	env, err := Seal(suite, rand.Reader, pkR, []byte("key-1"), info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	data, err := proto.Marshal(env)
+	require.Nil(t, err, "Error in Marshal")
+
+	decoded := &Envelope{}
+	require.Nil(t, proto.Unmarshal(data, decoded), "Error in Unmarshal")
+	require.Equal(t, []byte("key-1"), decoded.GetKeyId(), "Incorrect key ID")
+
+	opened, err := decoded.Open(skR, info, aad)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	_, err = decoded.Open(skR, info, []byte("other"))
+	require.NotNil(t, err, "Envelope opened with incorrect AAD")
+
+	decoded.AeadId = 0x7777
+	_, err = decoded.Open(skR, info, aad)
+	require.NotNil(t, err, "Envelope with unknown AEAD opened")
+
+	// HPKE identifiers are 16 bits
+	decoded.AeadId = 0x10000 | uint32(hpke.AEAD_AESGCM128)
+	_, err = decoded.Suite()
+	require.NotNil(t, err, "Out-of-range identifier accepted")
+}
@@ -0,0 +1,13 @@
+// Package hpkepiv provides HPKE receiver keys held in a YubiKey's PIV
+// applet, so that operator-held hardware tokens can receive encrypted
+// secrets such as break-glass credentials.  A Key implements
+// hpke.DHPrivateKey: the DHKEM's Diffie-Hellman operation runs on the token,
+// which may require the PIN or a touch, and the rest of the KEM and the key
+// schedule run locally.
+//
+// PIV keys for ECDH are P-256 keys, usually in the key management slot, so
+// only DHKEM(P-256) is supported.
+//
+// The package requires cgo and the PC/SC library (pcsc-lite on Linux), so it
+// is built only with the hpkepiv build tag, e.g., go build -tags hpkepiv.
+package hpkepiv
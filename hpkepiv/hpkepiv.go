@@ -0,0 +1,118 @@
+//go:build cgo && hpkepiv
+// +build cgo,hpkepiv
+
+package hpkepiv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+	"sync"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/go-piv/piv-go/piv"
+)
+
+// sharedKeyer is the ECDH capability of a PIV private key, which
+// *piv.ECDSAPrivateKey provides.
+type sharedKeyer interface {
+	Public() crypto.PublicKey
+	SharedKey(peer *ecdsa.PublicKey) ([]byte, error)
+}
+
+// Key is a private key in a PIV slot, for use with the HPKE receiver setup
+// functions or as an Auth mode sender key.
+type Key struct {
+	priv sharedKeyer
+	kem  hpke.KEMScheme
+	pk   hpke.KEMPublicKey
+
+	// A YubiKey handles one command at a time.
+	mu sync.Mutex
+}
+
+// NewKey wraps a private key returned by piv.YubiKey.PrivateKey.  The key
+// must be a P-256 key.
+func NewKey(priv crypto.PrivateKey) (*Key, error) {
+	sk, ok := priv.(sharedKeyer)
+	if !ok {
+		return nil, fmt.Errorf("%w: PIV key does not support ECDH", hpke.ErrUnsupportedSuite)
+	}
+
+	pub, ok := sk.Public().(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: PIV key is not a P-256 key", hpke.ErrUnsupportedSuite)
+	}
+
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := suite.KEM.DeserializePublicKey(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{priv: sk, kem: suite.KEM, pk: pk}, nil
+}
+
+// OpenKey returns the key in the given slot.  The public key is read from the
+// slot's certificate; auth supplies the PIN if the key's policy requires it.
+func OpenKey(yk *piv.YubiKey, slot piv.Slot, auth piv.KeyAuth) (*Key, error) {
+	cert, err := yk.Certificate(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := yk.PrivateKey(slot, cert.PublicKey, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(priv)
+}
+
+// GenerateKey generates a P-256 key in the given slot, authorized by the
+// management key, with the PIN and touch policies in opts.  The slot has no
+// certificate afterward, so the caller should store one, e.g., a self-signed
+// certificate for the returned public key, so that OpenKey can find the key
+// later.
+func GenerateKey(yk *piv.YubiKey, managementKey [24]byte, slot piv.Slot, opts piv.Key, auth piv.KeyAuth) (*Key, error) {
+	opts.Algorithm = piv.AlgorithmEC256
+	pub, err := yk.GenerateKey(managementKey, slot, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := yk.PrivateKey(slot, pub, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(priv)
+}
+
+// KEMID returns the identifier of the key's KEM.
+func (k *Key) KEMID() hpke.KEMID {
+	return k.kem.ID()
+}
+
+// PublicKey returns the key's public key.
+func (k *Key) PublicKey() hpke.KEMPublicKey {
+	return k.pk
+}
+
+// DH performs ECDH on the token with the peer's serialized public key.
+func (k *Key) DH(pkXm []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), pkXm)
+	if x == nil {
+		return nil, fmt.Errorf("%w: Invalid peer public key", hpke.ErrInvalidPublicKey)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.priv.SharedKey(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+}
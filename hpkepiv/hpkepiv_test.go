@@ -0,0 +1,88 @@
+//go:build cgo && hpkepiv
+// +build cgo,hpkepiv
+
+package hpkepiv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+// softwareKey stands in for a *piv.ECDSAPrivateKey.
+type softwareKey struct {
+	sk    *ecdsa.PrivateKey
+	calls int
+}
+
+func (k *softwareKey) Public() crypto.PublicKey {
+	return &k.sk.PublicKey
+}
+
+func (k *softwareKey) SharedKey(peer *ecdsa.PublicKey) ([]byte, error) {
+	k.calls++
+	x, _ := peer.Curve.ScalarMult(peer.X, peer.Y, k.sk.D.Bytes())
+	z := make([]byte, 32)
+	xb := x.Bytes()
+	copy(z[len(z)-len(xb):], xb)
+	return z, nil
+}
+
+func TestPIVKey(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "Error generating key")
+	token := &softwareKey{sk: ecKey}
+
+	skR, err := NewKey(token)
+	require.Nil(t, err, "Error in NewKey")
+	require.Equal(t, hpke.DHKEM_P256, skR.KEMID(), "Incorrect KEM")
+	pkR := skR.PublicKey()
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("break-glass credential")
+
+	enc, ct, err := hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+	opened, err := hpke.Open(suite, skR, enc, info, aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+	require.Equal(t, 1, token.calls, "DH not performed on the token")
+
+	// Auth mode uses the token for both DH operations
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skS, pkS, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	enc, ctxS, err := hpke.SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	require.Nil(t, err, "Error in SetupAuthS")
+	ct, err = ctxS.Seal(aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err := hpke.SetupAuthR(suite, skR, pkS, enc, info)
+	require.Nil(t, err, "Error in SetupAuthR")
+	opened, err = ctxR.Open(aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+	require.Equal(t, 3, token.calls, "DH not performed on the token")
+
+	_, err = skR.DH([]byte{0x04, 0x01})
+	require.True(t, errors.Is(err, hpke.ErrInvalidPublicKey), "Invalid peer public key accepted")
+
+	// Only P-256 keys with ECDH are supported
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.Nil(t, err, "Error generating key")
+	_, err = NewKey(&softwareKey{sk: p384})
+	require.True(t, errors.Is(err, hpke.ErrUnsupportedSuite), "P-384 key accepted")
+
+	_, err = NewKey(ecKey)
+	require.True(t, errors.Is(err, hpke.ErrUnsupportedSuite), "Key without ECDH accepted")
+}
@@ -0,0 +1,12 @@
+// Package hpkepkcs11 provides HPKE receiver keys held in a PKCS#11 token,
+// such as an HSM.  The private key never leaves the token: a Key implements
+// hpke.DHPrivateKey, so the DHKEM's Diffie-Hellman operation runs inside the
+// token with CKM_ECDH1_DERIVE, and the rest of the KEM and the key schedule
+// run locally.
+//
+// Keys for DHKEM(P-256), DHKEM(P-521), and DHKEM(X25519) are supported, the
+// latter on tokens that implement PKCS#11 3.0 Montgomery keys.
+//
+// The package requires cgo, through github.com/miekg/pkcs11, which loads the
+// token's PKCS#11 module at run time.
+package hpkepkcs11
@@ -0,0 +1,292 @@
+//go:build cgo
+// +build cgo
+
+package hpkepkcs11
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"sync"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 3.0 identifiers for Montgomery keys, which
+// github.com/miekg/pkcs11 v1.1.1 does not define
+const (
+	ckkECMontgomery           = 0x00000041 // CKK_EC_MONTGOMERY
+	ckmECMontgomeryKeyPairGen = 0x00001056 // CKM_EC_MONTGOMERY_KEY_PAIR_GEN
+)
+
+// Named curve identifiers for CKA_EC_PARAMS
+var (
+	oidP256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP521   = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+	oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+)
+
+// kemForParams returns the KEM for the DER-encoded CKA_EC_PARAMS of a key,
+// which names its curve.
+func kemForParams(params []byte) (hpke.KEMID, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err == nil {
+		switch {
+		case oid.Equal(oidP256):
+			return hpke.DHKEM_P256, nil
+		case oid.Equal(oidP521):
+			return hpke.DHKEM_P521, nil
+		case oid.Equal(oidX25519):
+			return hpke.DHKEM_X25519, nil
+		}
+		return 0, fmt.Errorf("%w: Unsupported curve [%v]", hpke.ErrUnsupportedSuite, oid)
+	}
+
+	// PKCS#11 3.0 also allows Montgomery curves to be named by a string.
+	var name string
+	if _, err := asn1.UnmarshalWithParams(params, &name, "printable"); err == nil && name == "curve25519" {
+		return hpke.DHKEM_X25519, nil
+	}
+
+	return 0, fmt.Errorf("Invalid EC parameters")
+}
+
+// paramsForKEM returns the DER-encoded CKA_EC_PARAMS for the KEM's curve.
+func paramsForKEM(kemID hpke.KEMID) ([]byte, error) {
+	switch kemID {
+	case hpke.DHKEM_P256:
+		return asn1.Marshal(oidP256)
+	case hpke.DHKEM_P521:
+		return asn1.Marshal(oidP521)
+	case hpke.DHKEM_X25519:
+		return asn1.Marshal(oidX25519)
+	}
+
+	return nil, fmt.Errorf("%w: Unsupported KEM [%s]", hpke.ErrUnsupportedSuite, kemID)
+}
+
+// decodePoint returns the serialized public key in a CKA_EC_POINT value.  The
+// value should be a DER OCTET STRING, but some tokens return the raw point.
+func decodePoint(kem hpke.KEMScheme, point []byte) ([]byte, error) {
+	if len(point) == kem.PublicKeySize() {
+		return point, nil
+	}
+
+	var raw []byte
+	rest, err := asn1.Unmarshal(point, &raw)
+	if err != nil || len(rest) != 0 || len(raw) != kem.PublicKeySize() {
+		return nil, fmt.Errorf("%w: Malformed EC point", hpke.ErrInvalidPublicKey)
+	}
+
+	return raw, nil
+}
+
+// dhSize returns the size of the DH output for the KEM, which is the size of
+// a field element.
+func dhSize(kemID hpke.KEMID) int {
+	switch kemID {
+	case hpke.DHKEM_P521:
+		return 66
+	default:
+		return 32
+	}
+}
+
+// Key is a private key in a PKCS#11 token, for use with the HPKE receiver
+// setup functions or as an Auth mode sender key.
+type Key struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	handle  pkcs11.ObjectHandle
+	kem     hpke.KEMScheme
+	pk      hpke.KEMPublicKey
+
+	// PKCS#11 sessions must not be used concurrently.
+	mu sync.Mutex
+}
+
+func getAttributes(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle, types ...uint) ([][]byte, error) {
+	template := make([]*pkcs11.Attribute, len(types))
+	for i, typ := range types {
+		template[i] = pkcs11.NewAttribute(typ, nil)
+	}
+
+	attrs, err := ctx.GetAttributeValue(session, handle, template)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(types))
+	for i, attr := range attrs {
+		values[i] = attr.Value
+	}
+	return values, nil
+}
+
+func findObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+
+	handles, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(handles) {
+	case 0:
+		return 0, fmt.Errorf("Key not found")
+	case 1:
+		return handles[0], nil
+	default:
+		return 0, fmt.Errorf("Key is ambiguous")
+	}
+}
+
+// FindKey looks up the private key with the given label in a logged-in
+// session.  Its public key is read from the public key object with the same
+// CKA_ID.  The session must remain open for as long as the key is used.
+func FindKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (*Key, error) {
+	handle, err := findObject(ctx, session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Private key %q: %v", label, err)
+	}
+
+	values, err := getAttributes(ctx, session, handle, pkcs11.CKA_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pubHandle, err := findObject(ctx, session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, values[0]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Public key %q: %v", label, err)
+	}
+
+	return newKey(ctx, session, handle, pubHandle)
+}
+
+func newKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle, pubHandle pkcs11.ObjectHandle) (*Key, error) {
+	values, err := getAttributes(ctx, session, pubHandle, pkcs11.CKA_EC_PARAMS, pkcs11.CKA_EC_POINT)
+	if err != nil {
+		return nil, err
+	}
+
+	kemID, err := kemForParams(values[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// The KEM ID alone determines the KEM, so any KDF and AEAD will do.
+	suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	point, err := decodePoint(suite.KEM, values[1])
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := suite.KEM.DeserializePublicKey(point)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{ctx: ctx, session: session, handle: handle, kem: suite.KEM, pk: pk}, nil
+}
+
+// GenerateKey generates a key pair for the KEM in the token, with the given
+// label and ID.  The private key is marked sensitive and non-extractable, and
+// may be used only for key derivation.
+func GenerateKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, kemID hpke.KEMID, label string, id []byte) (*Key, error) {
+	params, err := paramsForKEM(kemID)
+	if err != nil {
+		return nil, err
+	}
+
+	keyType, mechanism := pkcs11.CKK_EC, pkcs11.CKM_EC_KEY_PAIR_GEN
+	if kemID == hpke.DHKEM_X25519 {
+		keyType, mechanism = ckkECMontgomery, ckmECMontgomeryKeyPairGen
+	}
+
+	public := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+	}
+	private := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+	}
+
+	pubHandle, handle, err := ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(uint(mechanism), nil)}, public, private)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(ctx, session, handle, pubHandle)
+}
+
+// KEMID returns the identifier of the key's KEM.
+func (k *Key) KEMID() hpke.KEMID {
+	return k.kem.ID()
+}
+
+// PublicKey returns the key's public key.
+func (k *Key) PublicKey() hpke.KEMPublicKey {
+	return k.pk
+}
+
+// DH performs ECDH in the token with the peer's serialized public key.  The
+// shared secret is derived as a temporary session object, read out, and
+// destroyed.
+func (k *Key) DH(pkXm []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_ECDH1_DERIVE, pkcs11.NewECDH1DeriveParams(pkcs11.CKD_NULL, nil, pkXm))
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, dhSize(k.kem.ID())),
+	}
+
+	secret, err := k.ctx.DeriveKey(k.session, []*pkcs11.Mechanism{mechanism}, k.handle, template)
+	if err != nil {
+		return nil, err
+	}
+	defer k.ctx.DestroyObject(k.session, secret)
+
+	values, err := getAttributes(k.ctx, k.session, secret, pkcs11.CKA_VALUE)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values[0]) != dhSize(k.kem.ID()) {
+		return nil, fmt.Errorf("Token returned a shared secret of the wrong size [%d]", len(values[0]))
+	}
+
+	return values[0], nil
+}
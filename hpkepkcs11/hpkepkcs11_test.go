@@ -0,0 +1,132 @@
+//go:build cgo
+// +build cgo
+
+package hpkepkcs11
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"os"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+func TestECParams(t *testing.T) {
+	for _, kemID := range []hpke.KEMID{hpke.DHKEM_P256, hpke.DHKEM_P521, hpke.DHKEM_X25519} {
+		params, err := paramsForKEM(kemID)
+		require.Nil(t, err, "Error encoding EC parameters")
+
+		decoded, err := kemForParams(params)
+		require.Nil(t, err, "Error decoding EC parameters")
+		require.Equal(t, kemID, decoded, "Incorrect KEM")
+	}
+
+	curve25519, err := asn1.MarshalWithParams("curve25519", "printable")
+	require.Nil(t, err, "Error encoding curve name")
+	kemID, err := kemForParams(curve25519)
+	require.Nil(t, err, "Error decoding curve name")
+	require.Equal(t, hpke.DHKEM_X25519, kemID, "Incorrect KEM")
+
+	_, err = paramsForKEM(hpke.DHKEM_X448)
+	require.True(t, errors.Is(err, hpke.ErrUnsupportedSuite), "Unsupported KEM accepted")
+
+	p384, err := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+	require.Nil(t, err, "Error encoding OID")
+	_, err = kemForParams(p384)
+	require.True(t, errors.Is(err, hpke.ErrUnsupportedSuite), "Unsupported curve accepted")
+
+	_, err = kemForParams([]byte{0x01, 0x02})
+	require.NotNil(t, err, "Malformed EC parameters accepted")
+}
+
+func TestDecodePoint(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	raw := make([]byte, suite.KEM.PublicKeySize())
+	raw[0] = 0x04
+	rand.Read(raw[1:])
+
+	wrapped, err := asn1.Marshal(raw)
+	require.Nil(t, err, "Error encoding EC point")
+
+	decoded, err := decodePoint(suite.KEM, wrapped)
+	require.Nil(t, err, "Error decoding DER EC point")
+	require.Equal(t, raw, decoded, "Incorrect DER EC point")
+
+	decoded, err = decodePoint(suite.KEM, raw)
+	require.Nil(t, err, "Error decoding raw EC point")
+	require.Equal(t, raw, decoded, "Incorrect raw EC point")
+
+	_, err = decodePoint(suite.KEM, wrapped[:len(wrapped)-1])
+	require.True(t, errors.Is(err, hpke.ErrInvalidPublicKey), "Truncated EC point accepted")
+}
+
+// TestToken runs against a real token, such as SoftHSM, when
+// HPKE_PKCS11_MODULE names its module and HPKE_PKCS11_PIN the user PIN for
+// the token in the first slot.
+func TestToken(t *testing.T) {
+	module, pin := os.Getenv("HPKE_PKCS11_MODULE"), os.Getenv("HPKE_PKCS11_PIN")
+	if module == "" {
+		t.Skip("HPKE_PKCS11_MODULE not set")
+	}
+
+	ctx := pkcs11.New(module)
+	require.NotNil(t, ctx, "Error loading PKCS#11 module")
+	require.Nil(t, ctx.Initialize(), "Error initializing PKCS#11 module")
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	require.Nil(t, err, "Error listing slots")
+	require.NotEmpty(t, slots, "No token present")
+
+	session, err := ctx.OpenSession(slots[0], pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	require.Nil(t, err, "Error opening session")
+	defer ctx.CloseSession(session)
+	require.Nil(t, ctx.Login(session, pkcs11.CKU_USER, pin), "Error logging in")
+
+	for _, kemID := range []hpke.KEMID{hpke.DHKEM_P256, hpke.DHKEM_P521} {
+		suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+		require.Nil(t, err, "Error looking up ciphersuite")
+
+		label := "hpke-test-" + kemID.String()
+		generated, err := GenerateKey(ctx, session, kemID, label, []byte(label))
+		require.Nil(t, err, "Error generating key")
+		defer ctx.DestroyObject(session, generated.handle)
+
+		skR, err := FindKey(ctx, session, label)
+		require.Nil(t, err, "Error finding key")
+		require.Equal(t, kemID, skR.KEMID(), "Incorrect KEM")
+		pkR := skR.PublicKey()
+
+		info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+
+		enc, ct, err := hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+		require.Nil(t, err, "Error in Seal")
+		opened, err := hpke.Open(suite, skR, enc, info, aad, ct)
+		require.Nil(t, err, "Error in Open")
+		require.Equal(t, pt, opened, "Incorrect plaintext")
+
+		// The token's key can also authenticate a sender
+		ikm := make([]byte, suite.KEM.PrivateKeySize())
+		rand.Read(ikm)
+		skOther, pkOther, err := suite.KEM.DeriveKeyPair(ikm)
+		require.Nil(t, err, "Error deriving key pair")
+
+		enc, ctxS, err := hpke.SetupAuthS(suite, rand.Reader, pkOther, skR, info)
+		require.Nil(t, err, "Error in SetupAuthS")
+		ct, err = ctxS.Seal(aad, pt)
+		require.Nil(t, err, "Error in Seal")
+
+		ctxR, err := hpke.SetupAuthR(suite, skOther, pkR, enc, info)
+		require.Nil(t, err, "Error in SetupAuthR")
+		opened, err = ctxR.Open(aad, ct)
+		require.Nil(t, err, "Error in Open")
+		require.Equal(t, pt, opened, "Incorrect plaintext")
+	}
+}
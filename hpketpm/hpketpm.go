@@ -0,0 +1,266 @@
+// Package hpketpm provides HPKE receiver keys held in a TPM 2.0.  A Key is an
+// ECC decryption key created in the TPM's owner hierarchy; it implements
+// hpke.DHPrivateKey, so the DHKEM's Diffie-Hellman operation runs in the TPM
+// with TPM2_ECDH_ZGen, and the rest of the KEM and the key schedule run
+// locally.  The private key never leaves the TPM, which binds it to the
+// machine.
+//
+// TPMs implement the NIST curves, so keys for DHKEM(P-256) and, where the TPM
+// supports it, DHKEM(P-521) are supported.
+package hpketpm
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"io"
+	"sync"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+func curveForKEM(kemID hpke.KEMID) (tpm2.EllipticCurve, elliptic.Curve, error) {
+	switch kemID {
+	case hpke.DHKEM_P256:
+		return tpm2.CurveNISTP256, elliptic.P256(), nil
+	case hpke.DHKEM_P521:
+		return tpm2.CurveNISTP521, elliptic.P521(), nil
+	}
+
+	return 0, nil, fmt.Errorf("%w: Unsupported KEM [%s]", hpke.ErrUnsupportedSuite, kemID)
+}
+
+func kemForCurve(curveID tpm2.EllipticCurve) (hpke.KEMID, error) {
+	switch curveID {
+	case tpm2.CurveNISTP256:
+		return hpke.DHKEM_P256, nil
+	case tpm2.CurveNISTP521:
+		return hpke.DHKEM_P521, nil
+	}
+
+	return 0, fmt.Errorf("%w: Unsupported curve [%d]", hpke.ErrUnsupportedSuite, curveID)
+}
+
+// KeyTemplate returns the public area template for a KEM key: an
+// unrestricted, fixed, TPM-generated ECC key that can be used only for
+// decryption, i.e., ECDH.
+func KeyTemplate(kemID hpke.KEMID) (tpm2.Public, error) {
+	curveID, _, err := curveForKEM(kemID)
+	if err != nil {
+		return tpm2.Public{}, err
+	}
+
+	return tpm2.Public{
+		Type:    tpm2.AlgECC,
+		NameAlg: tpm2.AlgSHA256,
+		Attributes: tpm2.FlagDecrypt | tpm2.FlagUserWithAuth | tpm2.FlagFixedTPM |
+			tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin,
+		ECCParameters: &tpm2.ECCParams{
+			CurveID: curveID,
+		},
+	}, nil
+}
+
+// padCoordinate left-pads a coordinate or DH output to the size of a field
+// element, since the TPM strips leading zeros.
+func padCoordinate(x []byte, size int) ([]byte, error) {
+	if len(x) > size {
+		return nil, fmt.Errorf("Coordinate too long [%d > %d]", len(x), size)
+	}
+
+	out := make([]byte, size)
+	copy(out[size-len(x):], x)
+	return out, nil
+}
+
+// Key is a private key in a TPM, for use with the HPKE receiver setup
+// functions or as an Auth mode sender key.
+type Key struct {
+	rw         io.ReadWriter
+	handle     tpmutil.Handle
+	password   string
+	persistent bool
+
+	kem   hpke.KEMScheme
+	pk    hpke.KEMPublicKey
+	curve elliptic.Curve
+
+	// TPM commands on a connection must not be interleaved.
+	mu sync.Mutex
+}
+
+func newKey(rw io.ReadWriter, handle tpmutil.Handle, password string, pub tpm2.Public) (*Key, error) {
+	if pub.Type != tpm2.AlgECC || pub.ECCParameters == nil {
+		return nil, fmt.Errorf("%w: TPM key is not an ECC key", hpke.ErrUnsupportedSuite)
+	}
+
+	if pub.Attributes&tpm2.FlagDecrypt == 0 {
+		return nil, fmt.Errorf("TPM key cannot be used for ECDH")
+	}
+
+	kemID, err := kemForCurve(pub.ECCParameters.CurveID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The KEM ID alone determines the KEM, so any KDF and AEAD will do.
+	suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	_, curve, _ := curveForKEM(kemID)
+	size := (curve.Params().BitSize + 7) / 8
+	x, err := padCoordinate(pub.ECCParameters.Point.XRaw, size)
+	if err != nil {
+		return nil, err
+	}
+	y, err := padCoordinate(pub.ECCParameters.Point.YRaw, size)
+	if err != nil {
+		return nil, err
+	}
+
+	pkXm := append([]byte{0x04}, append(x, y...)...)
+	pk, err := suite.KEM.DeserializePublicKey(pkXm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		rw:       rw,
+		handle:   handle,
+		password: password,
+		kem:      suite.KEM,
+		pk:       pk,
+		curve:    curve,
+	}, nil
+}
+
+// CreateKey creates a transient primary key for the KEM in the owner
+// hierarchy, authorized by ownerPassword, and protects it with password.  A
+// primary key is derived from the hierarchy's seed and the template, so the
+// same key is recreated until the hierarchy is cleared; use Persist to keep
+// it loaded across reboots.
+func CreateKey(rw io.ReadWriter, kemID hpke.KEMID, ownerPassword, password string) (*Key, error) {
+	template, err := KeyTemplate(kemID)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, ownerPassword, password, template)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, _, err := tpm2.ReadPublic(rw, handle)
+	if err != nil {
+		tpm2.FlushContext(rw, handle)
+		return nil, err
+	}
+
+	key, err := newKey(rw, handle, password, pub)
+	if err != nil {
+		tpm2.FlushContext(rw, handle)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// LoadKey returns the persistent key at the given handle, which is protected
+// by password.
+func LoadKey(rw io.ReadWriter, handle tpmutil.Handle, password string) (*Key, error) {
+	pub, _, _, err := tpm2.ReadPublic(rw, handle)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newKey(rw, handle, password, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	key.persistent = true
+	return key, nil
+}
+
+// Persist makes a transient key persistent at the given handle in the owner
+// hierarchy, authorized by ownerPassword, so that it can later be loaded
+// with LoadKey.  The transient copy is flushed.
+func (k *Key) Persist(ownerPassword string, handle tpmutil.Handle) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.persistent {
+		return fmt.Errorf("Key is already persistent")
+	}
+
+	if err := tpm2.EvictControl(k.rw, ownerPassword, tpm2.HandleOwner, k.handle, handle); err != nil {
+		return err
+	}
+
+	tpm2.FlushContext(k.rw, k.handle)
+	k.handle = handle
+	k.persistent = true
+	return nil
+}
+
+// Evict removes a persistent key from the TPM, authorized by ownerPassword.
+// The key cannot be used afterward.
+func (k *Key) Evict(ownerPassword string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.persistent {
+		return fmt.Errorf("Key is not persistent")
+	}
+
+	return tpm2.EvictControl(k.rw, ownerPassword, tpm2.HandleOwner, k.handle, k.handle)
+}
+
+// Close flushes a transient key from the TPM.  It does nothing for a
+// persistent key.
+func (k *Key) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.persistent {
+		return nil
+	}
+
+	return tpm2.FlushContext(k.rw, k.handle)
+}
+
+// Handle returns the key's TPM handle.
+func (k *Key) Handle() tpmutil.Handle {
+	return k.handle
+}
+
+// KEMID returns the identifier of the key's KEM.
+func (k *Key) KEMID() hpke.KEMID {
+	return k.kem.ID()
+}
+
+// PublicKey returns the key's public key.
+func (k *Key) PublicKey() hpke.KEMPublicKey {
+	return k.pk
+}
+
+// DH performs ECDH in the TPM with the peer's serialized public key.
+func (k *Key) DH(pkXm []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(k.curve, pkXm)
+	if x == nil {
+		return nil, fmt.Errorf("%w: Invalid peer public key", hpke.ErrInvalidPublicKey)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	z, err := tpm2.ECDHZGen(k.rw, k.handle, k.password, tpm2.ECPoint{XRaw: x.Bytes(), YRaw: y.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	return padCoordinate(z.XRaw, (k.curve.Params().BitSize+7)/8)
+}
@@ -0,0 +1,111 @@
+package hpketpm
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	template, err := KeyTemplate(hpke.DHKEM_P256)
+	require.Nil(t, err, "Error building template")
+	require.Equal(t, tpm2.AlgECC, template.Type, "Incorrect key type")
+	require.Equal(t, tpm2.CurveNISTP256, template.ECCParameters.CurveID, "Incorrect curve")
+	require.True(t, template.Attributes&tpm2.FlagDecrypt != 0, "Key cannot decrypt")
+	require.True(t, template.Attributes&tpm2.FlagFixedTPM != 0, "Key is not bound to the TPM")
+
+	_, err = KeyTemplate(hpke.DHKEM_X25519)
+	require.True(t, errors.Is(err, hpke.ErrUnsupportedSuite), "Unsupported KEM accepted")
+}
+
+func TestPublicArea(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	_, pk, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+	pkXm := suite.KEM.SerializePublicKey(pk)
+
+	// The TPM strips leading zeros from coordinates
+	pub, err := KeyTemplate(hpke.DHKEM_P256)
+	require.Nil(t, err, "Error building template")
+	pub.ECCParameters.Point = tpm2.ECPoint{XRaw: append([]byte{}, pkXm[1:33]...), YRaw: append([]byte{}, pkXm[33:]...)}
+	for len(pub.ECCParameters.Point.XRaw) > 0 && pub.ECCParameters.Point.XRaw[0] == 0 {
+		pub.ECCParameters.Point.XRaw = pub.ECCParameters.Point.XRaw[1:]
+	}
+
+	key, err := newKey(nil, 0, "", pub)
+	require.Nil(t, err, "Error reading public area")
+	require.Equal(t, hpke.DHKEM_P256, key.KEMID(), "Incorrect KEM")
+	require.Equal(t, pkXm, suite.KEM.SerializePublicKey(key.PublicKey()), "Incorrect public key")
+
+	_, err = key.DH([]byte{0x04, 0x01})
+	require.True(t, errors.Is(err, hpke.ErrInvalidPublicKey), "Invalid peer public key accepted")
+
+	pub.Attributes &^= tpm2.FlagDecrypt
+	_, err = newKey(nil, 0, "", pub)
+	require.NotNil(t, err, "Signing key accepted")
+
+	_, err = padCoordinate(make([]byte, 33), 32)
+	require.NotNil(t, err, "Oversized coordinate accepted")
+}
+
+// TestTPM runs against a real TPM or a simulator such as swtpm, when
+// HPKE_TPM_DEVICE names its device, e.g., /dev/tpmrm0.  The owner hierarchy
+// must have an empty password.
+func TestTPM(t *testing.T) {
+	device := os.Getenv("HPKE_TPM_DEVICE")
+	if device == "" {
+		t.Skip("HPKE_TPM_DEVICE not set")
+	}
+
+	rw, err := tpm2.OpenTPM(device)
+	require.Nil(t, err, "Error opening TPM")
+	defer rw.Close()
+
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_P256, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	skR, err := CreateKey(rw, hpke.DHKEM_P256, "", "password")
+	require.Nil(t, err, "Error creating key")
+	defer skR.Close()
+	pkR := skR.PublicKey()
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+
+	enc, ct, err := hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+	opened, err := hpke.Open(suite, skR, enc, info, aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// The TPM's key can also authenticate a sender
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skOther, pkOther, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	enc, ctxS, err := hpke.SetupAuthS(suite, rand.Reader, pkOther, skR, info)
+	require.Nil(t, err, "Error in SetupAuthS")
+	ct, err = ctxS.Seal(aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err := hpke.SetupAuthR(suite, skOther, pkR, enc, info)
+	require.Nil(t, err, "Error in SetupAuthR")
+	opened, err = ctxR.Open(aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// A wrong password is rejected by the TPM
+	wrong, err := LoadKey(rw, skR.Handle(), "wrong")
+	require.Nil(t, err, "Error loading key")
+	_, err = hpke.Open(suite, wrong, enc, info, aad, ct)
+	require.NotNil(t, err, "Wrong password accepted")
+}
@@ -0,0 +1,163 @@
+// Package hpkevault manages HPKE receiver keys with the HashiCorp Vault
+// transit secrets engine.
+//
+// The transit engine has no key agreement operation, so Vault cannot perform
+// the DHKEM's Diffie-Hellman itself the way the PKCS#11, TPM, and KMS
+// backends do.  Instead, receiver private keys are stored wrapped under a
+// transit encryption key, and a receiver unwraps its key through Vault when
+// it starts.  Every unwrap is subject to Vault policy and appears in Vault's
+// audit log, and the wrapped keys can be rotated and rewrapped centrally,
+// but an unwrapped key is held in the receiver's memory while in use.
+//
+// The client speaks Vault's HTTP API directly:
+//
+//	POST /v1/{mount}/encrypt/{key}  {"plaintext": "..."} -> {"data": {"ciphertext": "vault:v1:..."}}
+//	POST /v1/{mount}/decrypt/{key}  {"ciphertext": "vault:v1:..."} -> {"data": {"plaintext": "..."}}
+//	POST /v1/{mount}/rewrap/{key}   {"ciphertext": "vault:v1:..."} -> {"data": {"ciphertext": "vault:v2:..."}}
+package hpkevault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// DefaultMount is the default mount path of the transit engine.
+const DefaultMount = "transit"
+
+// Client wraps and unwraps private keys with a transit key.
+type Client struct {
+	// HTTPClient is used for requests to Vault.  If nil, http.DefaultClient
+	// is used.
+	HTTPClient *http.Client
+
+	// Address is the base URL of the Vault server, e.g.,
+	// "https://vault.example.com:8200".
+	Address string
+
+	// Token authenticates requests.  Namespace, if set, selects a Vault
+	// Enterprise namespace.
+	Token     string
+	Namespace string
+
+	// Mount is the path of the transit engine; DefaultMount if empty.
+	// KeyName is the name of the transit key.
+	Mount   string
+	KeyName string
+}
+
+type transitRequest struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type transitResponse struct {
+	Data struct {
+		Plaintext  []byte `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (c *Client) call(ctx context.Context, op string, req transitRequest) (*transitResponse, error) {
+	mount := c.Mount
+	if mount == "" {
+		mount = DefaultMount
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(c.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/" + op + "/" + url.PathEscape(c.KeyName)
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Vault-Token", c.Token)
+	if c.Namespace != "" {
+		httpReq.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out transitResponse
+	if resp.StatusCode != http.StatusOK {
+		json.Unmarshal(data, &out)
+		return nil, fmt.Errorf("Vault returned an error [%s]: %s", resp.Status, strings.Join(out.Errors, "; "))
+	}
+
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// WrapPrivateKey encrypts a private key under the transit key, returning the
+// Vault ciphertext to store.  The key is encoded as PKCS #8 before
+// encryption.
+func (c *Client) WrapPrivateKey(ctx context.Context, sk hpke.KEMPrivateKey) (string, error) {
+	der, err := hpke.MarshalPKCS8PrivateKey(sk)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.call(ctx, "encrypt", transitRequest{Plaintext: der})
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(resp.Data.Ciphertext, "vault:") {
+		return "", fmt.Errorf("Vault returned a malformed ciphertext")
+	}
+
+	return resp.Data.Ciphertext, nil
+}
+
+// UnwrapPrivateKey decrypts a private key wrapped with WrapPrivateKey,
+// returning its KEM along with the key.
+func (c *Client) UnwrapPrivateKey(ctx context.Context, wrapped string) (hpke.KEMID, hpke.KEMPrivateKey, error) {
+	resp, err := c.call(ctx, "decrypt", transitRequest{Ciphertext: wrapped})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return hpke.ParsePKCS8PrivateKey(resp.Data.Plaintext)
+}
+
+// RewrapPrivateKey re-encrypts a wrapped key under the latest version of the
+// transit key, without revealing it to the caller, e.g., after the transit
+// key is rotated.
+func (c *Client) RewrapPrivateKey(ctx context.Context, wrapped string) (string, error) {
+	resp, err := c.call(ctx, "rewrap", transitRequest{Ciphertext: wrapped})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Data.Ciphertext, nil
+}
@@ -0,0 +1,100 @@
+package hpkevault
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransit stands in for a transit engine, "encrypting" by reversing the
+// plaintext and counting decryptions as an audit log would.
+type fakeTransit struct {
+	t        *testing.T
+	decrypts int
+}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+
+	var req transitRequest
+	require.Nil(f.t, json.NewDecoder(r.Body).Decode(&req), "Malformed request")
+
+	var resp transitResponse
+	switch r.URL.Path {
+	case "/v1/transit/encrypt/hpke":
+		resp.Data.Ciphertext = "vault:v1:" + base64.StdEncoding.EncodeToString(reverse(req.Plaintext))
+	case "/v1/transit/decrypt/hpke":
+		f.decrypts++
+		ct, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Ciphertext, "vault:v1:"))
+		require.Nil(f.t, err, "Malformed ciphertext")
+		resp.Data.Plaintext = reverse(ct)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestTransitWrap(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	transit := &fakeTransit{t: t}
+	server := httptest.NewServer(transit)
+	defer server.Close()
+
+	client := &Client{HTTPClient: server.Client(), Address: server.URL, Token: "token", KeyName: "hpke"}
+	ctx := context.Background()
+
+	wrapped, err := client.WrapPrivateKey(ctx, skR)
+	require.Nil(t, err, "Error in WrapPrivateKey")
+	require.True(t, strings.HasPrefix(wrapped, "vault:v1:"), "Malformed wrapped key")
+	rawWrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(wrapped, "vault:v1:"))
+	require.Nil(t, err, "Malformed wrapped key")
+	require.False(t, bytes.Contains(rawWrapped, suite.KEM.SerializePrivateKey(skR)), "Private key not wrapped")
+
+	kemID, unwrapped, err := client.UnwrapPrivateKey(ctx, wrapped)
+	require.Nil(t, err, "Error in UnwrapPrivateKey")
+	require.Equal(t, hpke.DHKEM_X25519, kemID, "Incorrect KEM")
+	require.Equal(t, 1, transit.decrypts, "Unwrap not performed by Vault")
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+	enc, ct, err := hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+	opened, err := hpke.Open(suite, unwrapped, enc, info, aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// Errors from Vault are reported
+	client.Token = "wrong"
+	_, _, err = client.UnwrapPrivateKey(ctx, wrapped)
+	require.NotNil(t, err, "Unauthorized unwrap succeeded")
+	require.True(t, strings.Contains(err.Error(), "permission denied"), "Vault error not reported")
+}
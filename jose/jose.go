@@ -0,0 +1,191 @@
+// Package jose implements JSON Web Encryption with HPKE, following the
+// Integrated Encryption mode of draft-ietf-jose-hpke-encrypt, in which HPKE
+// encrypts the plaintext directly to a single recipient.  Objects use the JWE
+// Compact Serialization (RFC 7516):
+//
+//	BASE64URL(protected header) || "." ||
+//	BASE64URL(enc) || "." ||
+//	"" || "." ||
+//	BASE64URL(ciphertext) || "." ||
+//	""
+//
+// The HPKE encapsulated key takes the place of the JWE Encrypted Key, the
+// Initialization Vector and Authentication Tag are empty, and the ASCII
+// encoding of the protected header is the HPKE AAD.  Key Encryption mode, in
+// which HPKE wraps a content encryption key, is not supported.
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// Algorithm is a JOSE "alg" value identifying an HPKE suite.
+type Algorithm string
+
+// Algorithms for Integrated Encryption.  HPKE-1, which uses P-384, is not
+// supported by this package.
+const (
+	HPKE0 Algorithm = "HPKE-0" // P-256, HKDF-SHA256, AES-128-GCM
+	HPKE2 Algorithm = "HPKE-2" // P-521, HKDF-SHA512, AES-256-GCM
+	HPKE3 Algorithm = "HPKE-3" // X25519, HKDF-SHA256, AES-128-GCM
+	HPKE4 Algorithm = "HPKE-4" // X25519, HKDF-SHA256, ChaCha20Poly1305
+	HPKE5 Algorithm = "HPKE-5" // X448, HKDF-SHA512, AES-256-GCM
+	HPKE6 Algorithm = "HPKE-6" // X448, HKDF-SHA512, ChaCha20Poly1305
+)
+
+var algorithms = map[Algorithm][3]uint16{
+	HPKE0: {uint16(hpke.DHKEM_P256), uint16(hpke.KDF_HKDF_SHA256), uint16(hpke.AEAD_AESGCM128)},
+	HPKE2: {uint16(hpke.DHKEM_P521), uint16(hpke.KDF_HKDF_SHA512), uint16(hpke.AEAD_AESGCM256)},
+	HPKE3: {uint16(hpke.DHKEM_X25519), uint16(hpke.KDF_HKDF_SHA256), uint16(hpke.AEAD_AESGCM128)},
+	HPKE4: {uint16(hpke.DHKEM_X25519), uint16(hpke.KDF_HKDF_SHA256), uint16(hpke.AEAD_CHACHA20POLY1305)},
+	HPKE5: {uint16(hpke.DHKEM_X448), uint16(hpke.KDF_HKDF_SHA512), uint16(hpke.AEAD_AESGCM256)},
+	HPKE6: {uint16(hpke.DHKEM_X448), uint16(hpke.KDF_HKDF_SHA512), uint16(hpke.AEAD_CHACHA20POLY1305)},
+}
+
+// Suite returns the HPKE suite identified by the algorithm.
+func (alg Algorithm) Suite() (hpke.CipherSuite, error) {
+	ids, ok := algorithms[alg]
+	if !ok {
+		return hpke.CipherSuite{}, fmt.Errorf("%w: Unknown JOSE algorithm %q", hpke.ErrUnsupportedSuite, string(alg))
+	}
+
+	return hpke.AssembleCipherSuite(hpke.KEMID(ids[0]), hpke.KDFID(ids[1]), hpke.AEADID(ids[2]))
+}
+
+// Header is the JWE protected header.  Only the parameters relevant to HPKE
+// are represented; objects with other critical parameters are rejected.
+type Header struct {
+	Algorithm   Algorithm `json:"alg"`
+	KeyID       string    `json:"kid,omitempty"`
+	Type        string    `json:"typ,omitempty"`
+	ContentType string    `json:"cty,omitempty"`
+
+	// Encryption and Critical must be absent in Integrated Encryption.
+	Encryption string   `json:"enc,omitempty"`
+	Critical   []string `json:"crit,omitempty"`
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(part, s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWE %s: %v", part, err)
+	}
+
+	return b, nil
+}
+
+// Encrypt encrypts the plaintext to the public key pkR, which must be a key
+// for the algorithm's KEM.  The header's Algorithm field selects the suite;
+// the other fields are optional.
+func Encrypt(header Header, rand io.Reader, pkR hpke.KEMPublicKey, pt []byte) (string, error) {
+	if header.Encryption != "" || len(header.Critical) != 0 {
+		return "", fmt.Errorf("Unsupported JWE header parameters for Integrated Encryption")
+	}
+
+	suite, err := header.Algorithm.Suite()
+	if err != nil {
+		return "", err
+	}
+
+	if kemID, err := hpke.KeyKEMID(pkR); err != nil || kemID != suite.KEM.ID() {
+		return "", fmt.Errorf("Public key does not match JOSE algorithm %q", string(header.Algorithm))
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	protected := encode(headerJSON)
+	enc, ct, err := hpke.Seal(suite, rand, pkR, nil, []byte(protected), pt)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{protected, encode(enc), "", encode(ct), ""}, "."), nil
+}
+
+// Decrypt decrypts a JWE in Compact Serialization with the private key skR,
+// returning the plaintext and the protected header.  Callers that hold
+// several keys can use ParseHeader to select one by key ID first.
+func Decrypt(skR hpke.KEMPrivateKey, compact string) ([]byte, Header, error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 5 {
+		return nil, Header{}, fmt.Errorf("Invalid JWE compact serialization")
+	}
+
+	header, err := parseHeader(parts[0])
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	if parts[2] != "" || parts[4] != "" {
+		return nil, Header{}, fmt.Errorf("Non-empty JWE IV or tag in Integrated Encryption")
+	}
+
+	suite, err := header.Algorithm.Suite()
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	enc, err := decode("encrypted key", parts[1])
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	ct, err := decode("ciphertext", parts[3])
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	pt, err := hpke.Open(suite, skR, enc, nil, []byte(parts[0]), ct)
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	return pt, header, nil
+}
+
+// ParseHeader returns the protected header of a JWE in Compact Serialization
+// without decrypting it.  The header is not authenticated until Decrypt
+// succeeds.
+func ParseHeader(compact string) (Header, error) {
+	i := strings.IndexByte(compact, '.')
+	if i < 0 {
+		return Header{}, fmt.Errorf("Invalid JWE compact serialization")
+	}
+
+	return parseHeader(compact[:i])
+}
+
+func parseHeader(protected string) (Header, error) {
+	headerJSON, err := decode("protected header", protected)
+	if err != nil {
+		return Header{}, err
+	}
+
+	var header Header
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return Header{}, err
+	}
+
+	if header.Encryption != "" {
+		return Header{}, fmt.Errorf("JWE \"enc\" must be absent in Integrated Encryption")
+	}
+
+	if len(header.Critical) != 0 {
+		return Header{}, fmt.Errorf("Unsupported critical JWE header parameters %v", header.Critical)
+	}
+
+	return header, nil
+}
@@ -0,0 +1,75 @@
+package jose
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+var plaintext = []byte("You can trust us to stick with you through thick and thin")
+
+func newKeyPair(t *testing.T, suite hpke.CipherSuite) (hpke.KEMPrivateKey, hpke.KEMPublicKey) {
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+	return skR, pkR
+}
+
+func TestRoundTrip(t *testing.T) {
+	for alg := range algorithms {
+		suite, err := alg.Suite()
+		require.Nil(t, err, "Error looking up suite [%s]", alg)
+
+		skR, pkR := newKeyPair(t, suite)
+		header := Header{Algorithm: alg, KeyID: "recipient-1"}
+
+		compact, err := Encrypt(header, rand.Reader, pkR, plaintext)
+		require.Nil(t, err, "Error in Encrypt [%s]", alg)
+
+		parts := strings.Split(compact, ".")
+		require.Equal(t, 5, len(parts), "Incorrect number of parts [%s]", alg)
+		require.Equal(t, "", parts[2], "Non-empty IV [%s]", alg)
+		require.Equal(t, "", parts[4], "Non-empty tag [%s]", alg)
+
+		parsed, err := ParseHeader(compact)
+		require.Nil(t, err, "Error in ParseHeader [%s]", alg)
+		require.Equal(t, header, parsed, "Incorrect header [%s]", alg)
+
+		pt, decHeader, err := Decrypt(skR, compact)
+		require.Nil(t, err, "Error in Decrypt [%s]", alg)
+		require.Equal(t, plaintext, pt, "Incorrect decryption [%s]", alg)
+		require.Equal(t, header, decHeader, "Incorrect header [%s]", alg)
+	}
+}
+
+func TestProtectedHeader(t *testing.T) {
+	suite, err := HPKE3.Suite()
+	require.Nil(t, err, "Error looking up suite")
+
+	skR, pkR := newKeyPair(t, suite)
+	compact, err := Encrypt(Header{Algorithm: HPKE3, KeyID: "a"}, rand.Reader, pkR, plaintext)
+	require.Nil(t, err, "Error in Encrypt")
+
+	// The protected header is bound to the ciphertext as AAD
+	parts := strings.Split(compact, ".")
+	parts[0] = encode([]byte(`{"alg":"HPKE-3","kid":"b"}`))
+	_, _, err = Decrypt(skR, strings.Join(parts, "."))
+	require.NotNil(t, err, "Modified header accepted")
+
+	parts[0] = encode([]byte(`{"alg":"HPKE-3","enc":"A128GCM"}`))
+	_, _, err = Decrypt(skR, strings.Join(parts, "."))
+	require.NotNil(t, err, "Header with \"enc\" accepted")
+
+	_, _, err = Decrypt(skR, compact+".")
+	require.NotNil(t, err, "Malformed compact serialization accepted")
+
+	_, err = Encrypt(Header{Algorithm: HPKE0}, rand.Reader, pkR, plaintext)
+	require.NotNil(t, err, "Encrypt accepted a key for the wrong KEM")
+
+	_, err = Encrypt(Header{Algorithm: "HPKE-1"}, rand.Reader, pkR, plaintext)
+	require.NotNil(t, err, "Encrypt accepted an unsupported algorithm")
+}
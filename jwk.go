@@ -0,0 +1,189 @@
+package hpke
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// jwk holds the members of a JSON Web Key (RFC 7517) used for KEM keys: EC
+// keys (RFC 7518) for the NIST curves, and OKP keys (RFC 8037) for X25519
+// and X448.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+var jwkCurves = map[KEMID]struct {
+	kty string
+	crv string
+}{
+	DHKEM_P256:   {"EC", "P-256"},
+	DHKEM_P521:   {"EC", "P-521"},
+	DHKEM_X25519: {"OKP", "X25519"},
+	DHKEM_X448:   {"OKP", "X448"},
+}
+
+func jwkEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwkDecode(member, s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWK member %q: %v", member, err)
+	}
+
+	if len(b) != size {
+		return nil, fmt.Errorf("Invalid JWK member %q length [%d]", member, len(b))
+	}
+
+	return b, nil
+}
+
+func newJWK(pk KEMPublicKey) (jwk, KEMID, error) {
+	kemID, err := KeyKEMID(pk)
+	if err != nil {
+		return jwk{}, 0, err
+	}
+
+	curve, ok := jwkCurves[kemID]
+	if !ok {
+		return jwk{}, 0, fmt.Errorf("JWK not supported for KEM [%s]", kemID)
+	}
+
+	key := jwk{Kty: curve.kty, Crv: curve.crv}
+	enc := kems[kemID].SerializePublicKey(pk)
+	if curve.kty == "EC" {
+		// Uncompressed point: 0x04 || x || y
+		coordSize := (len(enc) - 1) / 2
+		key.X = jwkEncode(enc[1 : 1+coordSize])
+		key.Y = jwkEncode(enc[1+coordSize:])
+	} else {
+		key.X = jwkEncode(enc)
+	}
+
+	return key, kemID, nil
+}
+
+// MarshalPublicJWK encodes a DHKEM public key as a JSON Web Key.
+func MarshalPublicJWK(pk KEMPublicKey) ([]byte, error) {
+	key, _, err := newJWK(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(key)
+}
+
+// MarshalPrivateJWK encodes a DHKEM private key, including its public key, as
+// a JSON Web Key.
+func MarshalPrivateJWK(sk KEMPrivateKey) ([]byte, error) {
+	key, kemID, err := newJWK(sk.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	key.D = jwkEncode(kems[kemID].SerializePrivateKey(sk))
+	return json.Marshal(key)
+}
+
+func parseJWK(data []byte) (jwk, KEMID, error) {
+	var key jwk
+	if err := json.Unmarshal(data, &key); err != nil {
+		return jwk{}, 0, err
+	}
+
+	for kemID, curve := range jwkCurves {
+		if key.Kty == curve.kty && key.Crv == curve.crv {
+			if _, err := lookupKEM(kemID); err != nil {
+				return jwk{}, 0, err
+			}
+			return key, kemID, nil
+		}
+	}
+
+	return jwk{}, 0, fmt.Errorf("Unsupported JWK key type [%s, %s]", key.Kty, key.Crv)
+}
+
+func (key jwk) publicKey(kemID KEMID) (KEMPublicKey, error) {
+	kem := kems[kemID]
+	if key.Kty != "EC" {
+		x, err := jwkDecode("x", key.X, kem.PublicKeySize())
+		if err != nil {
+			return nil, err
+		}
+
+		return kem.DeserializePublicKey(x)
+	}
+
+	coordSize := (kem.PublicKeySize() - 1) / 2
+	x, err := jwkDecode("x", key.X, coordSize)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := jwkDecode("y", key.Y, coordSize)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := append([]byte{0x04}, x...)
+	return kem.DeserializePublicKey(append(enc, y...))
+}
+
+// ParsePublicJWK decodes a public key from a JSON Web Key, returning the
+// corresponding KEM along with the key.  Any private key material in the JWK
+// is ignored.
+func ParsePublicJWK(data []byte) (KEMID, KEMPublicKey, error) {
+	key, kemID, err := parseJWK(data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pk, err := key.publicKey(kemID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return kemID, pk, nil
+}
+
+// ParsePrivateJWK decodes a private key from a JSON Web Key, returning the
+// corresponding KEM along with the key.  The public key in the JWK must match
+// the private key.
+func ParsePrivateJWK(data []byte) (KEMID, KEMPrivateKey, error) {
+	key, kemID, err := parseJWK(data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if key.D == "" {
+		return 0, nil, fmt.Errorf("JWK does not contain a private key")
+	}
+
+	kem := kems[kemID]
+	d, err := jwkDecode("d", key.D, kem.PrivateKeySize())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sk, err := kem.DeserializePrivateKey(d)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pk, err := key.publicKey(kemID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !pk.(interface{ Equal(KEMPublicKey) bool }).Equal(sk.PublicKey()) {
+		return 0, nil, fmt.Errorf("JWK public key does not match private key")
+	}
+
+	return kemID, sk, nil
+}
@@ -0,0 +1,62 @@
+package hpke
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKVector(t *testing.T) {
+	// RFC 8037, Appendix A.6
+	data := []byte(`{"kty":"OKP","crv":"X25519","d":"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo","x":"hSDwCYkwp1R0i33ctD73Wg2_Og0mOBr066SpjqqbTmo"}`)
+
+	kemID, sk, err := ParsePrivateJWK(data)
+	require.Nil(t, err, "Error in ParsePrivateJWK")
+	require.Equal(t, DHKEM_X25519, kemID, "Incorrect KEM id")
+
+	kem := kems[kemID]
+	require.Equal(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a", hex.EncodeToString(kem.SerializePrivateKey(sk)), "Incorrect private key")
+	require.Equal(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a", hex.EncodeToString(kem.SerializePublicKey(sk.PublicKey())), "Incorrect public key")
+
+	var mismatched map[string]string
+	require.Nil(t, json.Unmarshal(data, &mismatched), "Error decoding JSON")
+	mismatched["x"] = "3p7bfXt9wbTTW2HC7OQ1Nz-DQ8hbeGdNrfx-FG-IK08"
+	data, err = json.Marshal(mismatched)
+	require.Nil(t, err, "Error encoding JSON")
+
+	_, _, err = ParsePrivateJWK(data)
+	require.NotNil(t, err, "ParsePrivateJWK accepted a mismatched public key")
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	for kemID := range jwkCurves {
+		kem, ok := kems[kemID]
+		if !ok && minimalProfile {
+			continue
+		}
+
+		sk, pk, err := kem.DeriveKeyPair(randomBytes(kem.PrivateKeySize()))
+		require.Nil(t, err, "Error deriving key pair")
+
+		pubJWK, err := MarshalPublicJWK(pk)
+		require.Nil(t, err, "Error in MarshalPublicJWK")
+
+		id, decodedPK, err := ParsePublicJWK(pubJWK)
+		require.Nil(t, err, "Error in ParsePublicJWK")
+		require.Equal(t, kemID, id, "Incorrect KEM id for public key")
+		require.True(t, decodedPK.(interface{ Equal(KEMPublicKey) bool }).Equal(pk), "Public key mismatch")
+
+		_, _, err = ParsePrivateJWK(pubJWK)
+		require.NotNil(t, err, "ParsePrivateJWK accepted a public key")
+
+		privJWK, err := MarshalPrivateJWK(sk)
+		require.Nil(t, err, "Error in MarshalPrivateJWK")
+
+		id, decodedSK, err := ParsePrivateJWK(privJWK)
+		require.Nil(t, err, "Error in ParsePrivateJWK")
+		require.Equal(t, kemID, id, "Incorrect KEM id for private key")
+		require.True(t, decodedSK.(interface{ Equal(KEMPrivateKey) bool }).Equal(sk), "Private key mismatch")
+	}
+}
@@ -0,0 +1,108 @@
+package hpke
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// kemKey is implemented by the public and private keys of the built-in KEMs.
+type kemKey interface {
+	kemID() KEMID
+}
+
+// KeyKEMID returns the identifier of the KEM that a public or private key
+// belongs to.  It fails for keys that were not produced by one of the
+// built-in KEMs.
+func KeyKEMID(key interface{}) (KEMID, error) {
+	k, ok := key.(kemKey)
+	if !ok {
+		return 0, fmt.Errorf("Unknown key type [%T]", key)
+	}
+
+	return k.kemID(), nil
+}
+
+// The binary encoding of a key is the KEM ID followed by the serialized key:
+//
+//	struct {
+//	  uint16 kem_id;
+//	  opaque key[Npk or Nsk];
+//	} BinaryKey;
+func marshalKey(kemID KEMID, key []byte) []byte {
+	out := make([]byte, 2+len(key))
+	binary.BigEndian.PutUint16(out, uint16(kemID))
+	copy(out[2:], key)
+	return out
+}
+
+func unmarshalKey(data []byte) (KEMID, KEMScheme, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, fmt.Errorf("Truncated key")
+	}
+
+	kemID := KEMID(binary.BigEndian.Uint16(data))
+	kem, ok := kems[kemID]
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("Unknown KEM id [%s]", kemID)
+	}
+
+	return kemID, kem, data[2:], nil
+}
+
+func marshalPublicKey(pk KEMPublicKey) ([]byte, error) {
+	kemID, err := KeyKEMID(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalKey(kemID, kems[kemID].SerializePublicKey(pk)), nil
+}
+
+func marshalPrivateKey(sk KEMPrivateKey) ([]byte, error) {
+	kemID, err := KeyKEMID(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	if kemID == KEM_SIKE503 || kemID == KEM_SIKE751 {
+		return nil, fmt.Errorf("Private key serialization not supported for SIKE")
+	}
+
+	return marshalKey(kemID, kems[kemID].SerializePrivateKey(sk)), nil
+}
+
+// UnmarshalPublicKey decodes a public key produced by MarshalBinary,
+// returning the KEM it belongs to along with the key.
+func UnmarshalPublicKey(data []byte) (KEMID, KEMPublicKey, error) {
+	kemID, kem, key, err := unmarshalKey(data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pk, err := kem.DeserializePublicKey(key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return kemID, pk, nil
+}
+
+// UnmarshalPrivateKey decodes a private key produced by MarshalBinary,
+// returning the KEM it belongs to along with the key.
+func UnmarshalPrivateKey(data []byte) (KEMID, KEMPrivateKey, error) {
+	kemID, kem, key, err := unmarshalKey(data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if kemID == KEM_SIKE503 || kemID == KEM_SIKE751 {
+		return 0, nil, fmt.Errorf("Private key serialization not supported for SIKE")
+	}
+
+	sk, err := kem.DeserializePrivateKey(key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return kemID, sk, nil
+}
@@ -0,0 +1,67 @@
+package hpke
+
+import (
+	"encoding"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyBinaryMarshaling(t *testing.T) {
+	for kemID, kem := range kems {
+		sk, pk, err := kem.DeriveKeyPair(randomBytes(kem.PrivateKeySize()))
+		require.Nil(t, err, "Error deriving key pair")
+
+		id, err := KeyKEMID(pk)
+		require.Nil(t, err, "Error in KeyKEMID")
+		require.Equal(t, kemID, id, "Incorrect KEM id for public key")
+
+		id, err = KeyKEMID(sk)
+		require.Nil(t, err, "Error in KeyKEMID")
+		require.Equal(t, kemID, id, "Incorrect KEM id for private key")
+
+		encPK, err := pk.(encoding.BinaryMarshaler).MarshalBinary()
+		require.Nil(t, err, "Error in MarshalBinary")
+
+		id, decodedPK, err := UnmarshalPublicKey(encPK)
+		require.Nil(t, err, "Error in UnmarshalPublicKey")
+		require.Equal(t, kemID, id, "Incorrect KEM id for decoded public key")
+		require.True(t, decodedPK.(interface{ Equal(KEMPublicKey) bool }).Equal(pk), "Public key mismatch")
+
+		// Decoding into a key of a different type must fail.  Keys of the same
+		// type, e.g., for P-256 and P-521, are replaced by the decoded key.
+		for otherID, otherKEM := range kems {
+			_, otherPK, err := otherKEM.DeriveKeyPair(randomBytes(otherKEM.PrivateKeySize()))
+			require.Nil(t, err, "Error deriving key pair")
+
+			err = otherPK.(encoding.BinaryUnmarshaler).UnmarshalBinary(encPK)
+			if kemID == otherID {
+				require.Nil(t, err, "Error in UnmarshalBinary")
+				require.True(t, otherPK.(interface{ Equal(KEMPublicKey) bool }).Equal(pk), "Public key mismatch")
+			} else if reflect.TypeOf(otherPK) != reflect.TypeOf(pk) {
+				require.NotNil(t, err, "UnmarshalBinary accepted a key of a different type")
+			}
+		}
+
+		if kemID == KEM_SIKE503 || kemID == KEM_SIKE751 {
+			_, err = sk.(encoding.BinaryMarshaler).MarshalBinary()
+			require.NotNil(t, err, "MarshalBinary succeeded for a SIKE private key")
+			continue
+		}
+
+		encSK, err := sk.(encoding.BinaryMarshaler).MarshalBinary()
+		require.Nil(t, err, "Error in MarshalBinary")
+
+		id, decodedSK, err := UnmarshalPrivateKey(encSK)
+		require.Nil(t, err, "Error in UnmarshalPrivateKey")
+		require.Equal(t, kemID, id, "Incorrect KEM id for decoded private key")
+		require.True(t, decodedSK.(interface{ Equal(KEMPrivateKey) bool }).Equal(sk), "Private key mismatch")
+	}
+
+	_, _, err := UnmarshalPublicKey([]byte{0xFF, 0xFF, 0x00})
+	require.NotNil(t, err, "UnmarshalPublicKey accepted an unknown KEM")
+
+	_, err = KeyKEMID("not a key")
+	require.NotNil(t, err, "KeyKEMID accepted an unknown key type")
+}
@@ -0,0 +1,94 @@
+package hpke
+
+import (
+	"sync"
+)
+
+// The suite identifiers and label prefixes fed to the labeled KDF depend only
+// on the suite and the label, so they are built once and shared, rather than
+// rebuilt on every key schedule and Export call.  Slices handed out by these
+// caches must never be modified.
+
+// maxCachedPrefixes bounds the number of memoized label prefixes, since
+// LabeledExtract and LabeledExpand accept arbitrary suite IDs, labels, and
+// lengths.  Inputs beyond the bound get a freshly built prefix.
+const maxCachedPrefixes = 1024
+
+// labelKey identifies a label prefix within a suite.  Length is the output
+// length encoded ahead of the prefix for LabeledExpand, or -1 for
+// LabeledExtract, which has none.
+type labelKey struct {
+	label  string
+	length int
+}
+
+var labelCache = struct {
+	sync.RWMutex
+	suites   map[SuiteDescriptor][]byte
+	kems     map[KEMID][]byte
+	prefixes map[string]map[labelKey][]byte
+	size     int
+}{
+	suites:   map[SuiteDescriptor][]byte{},
+	kems:     map[KEMID][]byte{},
+	prefixes: map[string]map[labelKey][]byte{},
+}
+
+// id returns the memoized suite_id.  Unlike SuiteID, it does not copy.
+func (suite CipherSuite) id() []byte {
+	d := suite.Descriptor()
+
+	labelCache.RLock()
+	suiteID, ok := labelCache.suites[d]
+	labelCache.RUnlock()
+	if ok {
+		return suiteID
+	}
+
+	suiteID = make([]byte, 0, 10)
+	suiteID = append(suiteID, "HPKE"...)
+	suiteID = appendUint16(suiteID, uint16(d.KEMID))
+	suiteID = appendUint16(suiteID, uint16(d.KDFID))
+	suiteID = appendUint16(suiteID, uint16(d.AEADID))
+
+	labelCache.Lock()
+	labelCache.suites[d] = suiteID
+	labelCache.Unlock()
+	return suiteID
+}
+
+// cachedLabelPrefix returns the memoized labelPrefix for the given suite ID,
+// label, and output length (-1 for none).
+func cachedLabelPrefix(suiteID []byte, label string, length int) []byte {
+	key := labelKey{label, length}
+
+	labelCache.RLock()
+	prefix, ok := labelCache.prefixes[string(suiteID)][key]
+	labelCache.RUnlock()
+	if ok {
+		return prefix
+	}
+
+	var lengthBytes []byte
+	if length >= 0 {
+		lengthBytes = []byte{byte(length >> 8), byte(length)}
+	}
+	prefix = labelPrefix(lengthBytes, suiteID, label)
+
+	labelCache.Lock()
+	defer labelCache.Unlock()
+	if labelCache.size >= maxCachedPrefixes {
+		return prefix
+	}
+
+	labels, ok := labelCache.prefixes[string(suiteID)]
+	if !ok {
+		labels = map[labelKey][]byte{}
+		labelCache.prefixes[string(suiteID)] = labels
+	}
+	if _, ok := labels[key]; !ok {
+		labels[key] = prefix
+		labelCache.size++
+	}
+	return prefix
+}
@@ -0,0 +1,203 @@
+package hpke
+
+import (
+	"fmt"
+	"math"
+
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// MessageVersion1 is the only message format version defined so far.
+const MessageVersion1 uint8 = 0x01
+
+// Message is a self-describing framing for a single-shot HPKE ciphertext.  It
+// carries everything a receiver needs to select a key and ciphersuite, so
+// that applications exchanging ciphertexts do not need to invent their own
+// framing.  The wire format is:
+//
+//	struct {
+//	  uint8 version;
+//	  uint8 mode;
+//	  uint16 kem_id;
+//	  uint16 kdf_id;
+//	  uint16 aead_id;
+//	  opaque key_id<0..255>;
+//	  opaque enc<0..2^16-1>;
+//	  opaque ciphertext<0..2^32-1>;
+//	} Message;
+type Message struct {
+	Version    uint8
+	Mode       Mode
+	KEMID      KEMID
+	KDFID      KDFID
+	AEADID     AEADID
+	KeyID      []byte `tls:"head=1"`
+	Enc        []byte `tls:"head=2"`
+	Ciphertext []byte `tls:"head=4"`
+}
+
+// NewMessage frames the output of a single-shot encryption under the given
+// suite and mode.
+func NewMessage(suite CipherSuite, mode Mode, keyID, enc, ct []byte) *Message {
+	return &Message{
+		Version:    MessageVersion1,
+		Mode:       mode,
+		KEMID:      suite.KEM.ID(),
+		KDFID:      suite.KDF.ID(),
+		AEADID:     suite.AEAD.ID(),
+		KeyID:      keyID,
+		Enc:        enc,
+		Ciphertext: ct,
+	}
+}
+
+// Suite assembles the ciphersuite named in the message.
+func (m Message) Suite() (CipherSuite, error) {
+	return AssembleCipherSuite(m.KEMID, m.KDFID, m.AEADID)
+}
+
+func (m Message) Marshal() ([]byte, error) {
+	if m.Version != MessageVersion1 {
+		return nil, fmt.Errorf("Unsupported message version [%d]", m.Version)
+	}
+
+	return syntax.Marshal(m)
+}
+
+// ParseMessage decodes a message produced by Marshal.  The ciphersuite is not
+// checked, except that a suite forbidden by the installed Policy is
+// rejected; use Suite to assemble it.
+func ParseMessage(data []byte) (*Message, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Empty message")
+	}
+
+	if data[0] != MessageVersion1 {
+		return nil, fmt.Errorf("Unsupported message version [%d]", data[0])
+	}
+
+	var m Message
+	read, err := syntax.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	if read != len(data) {
+		return nil, fmt.Errorf("Trailing data after message")
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (m Message) validate() error {
+	if m.Version != MessageVersion1 {
+		return fmt.Errorf("Unsupported message version [%d]", m.Version)
+	}
+
+	switch m.Mode {
+	case ModeBase, ModePSK, ModeAuth, ModeAuthPSK:
+	default:
+		return fmt.Errorf("Unknown mode [%s]", m.Mode)
+	}
+
+	return checkPolicy(m.KEMID, m.KDFID, m.AEADID)
+}
+
+// Map keys for the CBOR encoding of a message
+const (
+	messageKeyVersion = iota + 1
+	messageKeyMode
+	messageKeyKEMID
+	messageKeyKDFID
+	messageKeyAEADID
+	messageKeyKeyID
+	messageKeyEnc
+	messageKeyCiphertext
+	messageKeyCount = messageKeyCiphertext
+)
+
+// MarshalCBOR encodes the message as a deterministically-encoded CBOR map
+// with integer keys, for embedding in CBOR-based protocols:
+//
+//	{
+//	  1: version,
+//	  2: mode,
+//	  3: kem_id,
+//	  4: kdf_id,
+//	  5: aead_id,
+//	  6: bstr key_id,
+//	  7: bstr enc,
+//	  8: bstr ciphertext
+//	}
+func (m Message) MarshalCBOR() ([]byte, error) {
+	if m.Version != MessageVersion1 {
+		return nil, fmt.Errorf("Unsupported message version [%d]", m.Version)
+	}
+
+	w := cborWriter{}
+	w.mapHeader(messageKeyCount)
+	w.uint(messageKeyVersion)
+	w.uint(uint64(m.Version))
+	w.uint(messageKeyMode)
+	w.uint(uint64(m.Mode))
+	w.uint(messageKeyKEMID)
+	w.uint(uint64(m.KEMID))
+	w.uint(messageKeyKDFID)
+	w.uint(uint64(m.KDFID))
+	w.uint(messageKeyAEADID)
+	w.uint(uint64(m.AEADID))
+	w.uint(messageKeyKeyID)
+	w.bytes(m.KeyID)
+	w.uint(messageKeyEnc)
+	w.bytes(m.Enc)
+	w.uint(messageKeyCiphertext)
+	w.bytes(m.Ciphertext)
+	return w.buf, nil
+}
+
+// ParseMessageCBOR decodes a message produced by MarshalCBOR.  Encodings
+// that are not deterministic are rejected.
+func ParseMessageCBOR(data []byte) (*Message, error) {
+	var m Message
+	ints := map[uint64]uint64{}
+	r := cborReader{data: data}
+	err := r.fields(messageKeyCount, messageKeyCount, func(key uint64) error {
+		var err error
+		switch key {
+		case messageKeyVersion, messageKeyMode:
+			ints[key], err = r.uint(math.MaxUint8)
+		case messageKeyKEMID, messageKeyKDFID, messageKeyAEADID:
+			ints[key], err = r.uint(math.MaxUint16)
+		case messageKeyKeyID:
+			m.KeyID, err = r.bytes()
+		case messageKeyEnc:
+			m.Enc, err = r.bytes()
+		case messageKeyCiphertext:
+			m.Ciphertext, err = r.bytes()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("Trailing data after message")
+	}
+
+	m.Version = uint8(ints[messageKeyVersion])
+	m.Mode = Mode(ints[messageKeyMode])
+	m.KEMID = KEMID(ints[messageKeyKEMID])
+	m.KDFID = KDFID(ints[messageKeyKDFID])
+	m.AEADID = AEADID(ints[messageKeyAEADID])
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
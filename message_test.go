@@ -0,0 +1,83 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	keyID := []byte("receiver-key")
+
+	enc, ct, err := Seal(suite, rand.Reader, pkR, info, aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	encoded, err := NewMessage(suite, ModeBase, keyID, enc, ct).Marshal()
+	assertNotError(t, suite, "Error in Message.Marshal", err)
+
+	msg, err := ParseMessage(encoded)
+	assertNotError(t, suite, "Error in ParseMessage", err)
+	assert(t, suite, "Incorrect mode", msg.Mode == ModeBase)
+	assertBytesEqual(t, suite, "Incorrect key ID", msg.KeyID, keyID)
+
+	parsedSuite, err := msg.Suite()
+	assertNotError(t, suite, "Error in Message.Suite", err)
+
+	decrypted, err := Open(parsedSuite, skR, msg.Enc, info, aad, msg.Ciphertext)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	_, err = ParseMessage(append(encoded, 0x00))
+	assert(t, suite, "ParseMessage accepted trailing data", err != nil)
+
+	badVersion := append([]byte{}, encoded...)
+	badVersion[0] = 0x02
+	_, err = ParseMessage(badVersion)
+	assert(t, suite, "ParseMessage accepted an unknown version", err != nil)
+
+	badMode := append([]byte{}, encoded...)
+	badMode[1] = 0x04
+	_, err = ParseMessage(badMode)
+	assert(t, suite, "ParseMessage accepted an unknown mode", err != nil)
+}
+
+func TestMessageCBOR(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ct, err := Seal(suite, rand.Reader, pkR, info, aad, original)
+	assertNotError(t, suite, "Error in Seal", err)
+
+	encoded, err := NewMessage(suite, ModeBase, []byte("key"), enc, ct).MarshalCBOR()
+	assertNotError(t, suite, "Error in Message.MarshalCBOR", err)
+
+	// map(8), 1: 1, 2: 0, 3: 0x20, 4: 1, 5: 3, 6: h'6b6579'
+	expectedPrefix := []byte{0xa8, 0x01, 0x01, 0x02, 0x00, 0x03, 0x18, 0x20, 0x04, 0x01, 0x05, 0x03, 0x06, 0x43, 'k', 'e', 'y'}
+	assertBytesEqual(t, suite, "Incorrect CBOR encoding", encoded[:len(expectedPrefix)], expectedPrefix)
+
+	msg, err := ParseMessageCBOR(encoded)
+	assertNotError(t, suite, "Error in ParseMessageCBOR", err)
+
+	decrypted, err := Open(suite, skR, msg.Enc, info, aad, msg.Ciphertext)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+
+	_, err = ParseMessageCBOR(append(encoded, 0x00))
+	assert(t, suite, "ParseMessageCBOR accepted trailing data", err != nil)
+
+	// The mode encoded with a one-byte argument instead of inline
+	nonMinimal := append([]byte{0xa8, 0x01, 0x01, 0x02, 0x18, 0x00}, encoded[5:]...)
+	_, err = ParseMessageCBOR(nonMinimal)
+	assert(t, suite, "ParseMessageCBOR accepted a non-deterministic encoding", err != nil)
+
+	// Fields out of order
+	reordered := append([]byte{0xa8, 0x02, 0x00, 0x01, 0x01}, encoded[5:]...)
+	_, err = ParseMessageCBOR(reordered)
+	assert(t, suite, "ParseMessageCBOR accepted unsorted map keys", err != nil)
+
+	_, err = ParseMessageCBOR(encoded[:len(encoded)-1])
+	assert(t, suite, "ParseMessageCBOR accepted truncated data", err != nil)
+}
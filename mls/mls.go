@@ -0,0 +1,82 @@
+// Package mls provides the HPKE helpers defined by the Messaging Layer
+// Security protocol (RFC 9420, Section 5.1.3), which bind each encryption to
+// a label and context so that ciphertexts produced for one purpose cannot be
+// used for another.
+package mls
+
+import (
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// labelPrefix is prepended to every label.
+const labelPrefix = "MLS 1.0 "
+
+// maxVarint is the largest length that can be encoded as an MLS
+// variable-length integer.
+const maxVarint = 1<<30 - 1
+
+// appendVarint appends n in the variable-length integer encoding of RFC 9420,
+// Section 2.1.2, using the shortest form.
+func appendVarint(out []byte, n int) []byte {
+	switch {
+	case n < 1<<6:
+		return append(out, byte(n))
+	case n < 1<<14:
+		return append(out, 0x40|byte(n>>8), byte(n))
+	default:
+		return append(out, 0x80|byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func appendOpaque(out, data []byte) ([]byte, error) {
+	if len(data) > maxVarint {
+		return nil, fmt.Errorf("Vector too long [%d]", len(data))
+	}
+
+	out = appendVarint(out, len(data))
+	return append(out, data...), nil
+}
+
+// EncryptContext returns the serialized EncryptContext used as the HPKE info
+// string:
+//
+//	struct {
+//	  opaque label<V>;
+//	  opaque context<V>;
+//	} EncryptContext;
+//
+// where label is "MLS 1.0 " followed by the given label.
+func EncryptContext(label string, context []byte) ([]byte, error) {
+	out, err := appendOpaque(nil, []byte(labelPrefix+label))
+	if err != nil {
+		return nil, err
+	}
+
+	return appendOpaque(out, context)
+}
+
+// EncryptWithLabel encrypts the plaintext to pkR in the Base mode, with the
+// EncryptContext for the label and context as the info string and an empty
+// AAD.  It returns the KEM output and the ciphertext.
+func EncryptWithLabel(suite hpke.CipherSuite, rand io.Reader, pkR hpke.KEMPublicKey, label string, context, pt []byte) ([]byte, []byte, error) {
+	info, err := EncryptContext(label, context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hpke.Seal(suite, rand, pkR, info, nil, pt)
+}
+
+// DecryptWithLabel decrypts a ciphertext produced by EncryptWithLabel with the
+// same label and context.
+func DecryptWithLabel(suite hpke.CipherSuite, skR hpke.KEMPrivateKey, label string, context, kemOutput, ct []byte) ([]byte, error) {
+	info, err := EncryptContext(label, context)
+	if err != nil {
+		return nil, err
+	}
+
+	return hpke.Open(suite, skR, kemOutput, info, nil, ct)
+}
@@ -0,0 +1,50 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptContext(t *testing.T) {
+	info, err := EncryptContext("test", []byte{0xAA})
+	require.Nil(t, err, "Error in EncryptContext")
+	require.Equal(t, append(append([]byte{0x0c}, "MLS 1.0 test"...), 0x01, 0xAA), info, "Incorrect EncryptContext")
+
+	// Longer contexts use the two-byte varint encoding
+	context := bytes.Repeat([]byte{0x55}, 300)
+	info, err = EncryptContext("", context)
+	require.Nil(t, err, "Error in EncryptContext")
+	require.Equal(t, []byte{0x08}, info[:1], "Incorrect label length")
+	require.Equal(t, []byte{0x41, 0x2c}, info[9:11], "Incorrect context length")
+	require.Equal(t, context, info[11:], "Incorrect context")
+}
+
+func TestEncryptWithLabel(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	pt := []byte("path secret")
+	context := []byte("group context")
+
+	kemOutput, ct, err := EncryptWithLabel(suite, rand.Reader, pkR, "UpdatePathNode", context, pt)
+	require.Nil(t, err, "Error in EncryptWithLabel")
+
+	decrypted, err := DecryptWithLabel(suite, skR, "UpdatePathNode", context, kemOutput, ct)
+	require.Nil(t, err, "Error in DecryptWithLabel")
+	require.Equal(t, pt, decrypted, "Incorrect decryption")
+
+	_, err = DecryptWithLabel(suite, skR, "Welcome", context, kemOutput, ct)
+	require.NotNil(t, err, "Decryption succeeded with a different label")
+
+	_, err = DecryptWithLabel(suite, skR, "UpdatePathNode", nil, kemOutput, ct)
+	require.NotNil(t, err, "Decryption succeeded with a different context")
+}
@@ -0,0 +1,322 @@
+package hpke
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var kemNames = map[KEMID]string{
+	DHKEM_P256:   "DHKEM(P-256, HKDF-SHA256)",
+	DHKEM_P521:   "DHKEM(P-521, HKDF-SHA512)",
+	DHKEM_X25519: "DHKEM(X25519, HKDF-SHA256)",
+	DHKEM_X448:   "DHKEM(X448, HKDF-SHA512)",
+	KEM_SIKE503:  "SIKE503",
+	KEM_SIKE751:  "SIKE751",
+}
+
+var kdfNames = map[KDFID]string{
+	KDF_HKDF_SHA256: "HKDF-SHA256",
+	KDF_HKDF_SHA384: "HKDF-SHA384",
+	KDF_HKDF_SHA512: "HKDF-SHA512",
+}
+
+var aeadNames = map[AEADID]string{
+	AEAD_AESGCM128:               "AES-128-GCM",
+	AEAD_AESGCM256:               "AES-256-GCM",
+	AEAD_CHACHA20POLY1305:        "ChaCha20Poly1305",
+	AEAD_EXPORT_ONLY:             "Export-only",
+	AEAD_AESGCM128_COMMIT:        "AES-128-GCM-Commit",
+	AEAD_AESGCM256_COMMIT:        "AES-256-GCM-Commit",
+	AEAD_CHACHA20POLY1305_COMMIT: "ChaCha20Poly1305-Commit",
+}
+
+// parseID parses the text form of an identifier: either one of the names in
+// the table, compared case-insensitively, or a number in Go syntax (e.g.,
+// "32" or "0x0020").
+func parseID(kind string, text []byte, bits int, names func(name string) (uint64, bool)) (uint64, error) {
+	s := strings.TrimSpace(string(text))
+	if n, ok := names(s); ok {
+		return n, nil
+	}
+
+	n, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("Unknown %s %q", kind, s)
+	}
+
+	return n, nil
+}
+
+// unmarshalIDJSON decodes an identifier from JSON.  Identifiers are encoded
+// as names by MarshalText, but numeric encodings are accepted as well, for
+// compatibility with JSON written before the names were introduced.
+func unmarshalIDJSON(data []byte, bits int, text func([]byte) error) (uint64, bool, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return 0, false, err
+		}
+		return 0, false, text([]byte(s))
+	}
+
+	n, err := strconv.ParseUint(string(data), 10, bits)
+	if err != nil {
+		return 0, false, fmt.Errorf("Invalid identifier %s", data)
+	}
+
+	return n, true, nil
+}
+
+func (id KEMID) String() string {
+	if name, ok := kemNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("KEMID(0x%04x)", uint16(id))
+}
+
+func (id KEMID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *KEMID) UnmarshalText(text []byte) error {
+	n, err := parseID("KEM", text, 16, func(name string) (uint64, bool) {
+		for candidate, candidateName := range kemNames {
+			if strings.EqualFold(name, candidateName) {
+				return uint64(candidate), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return err
+	}
+
+	*id = KEMID(n)
+	return nil
+}
+
+func (id *KEMID) UnmarshalJSON(data []byte) error {
+	n, numeric, err := unmarshalIDJSON(data, 16, id.UnmarshalText)
+	if numeric {
+		*id = KEMID(n)
+	}
+	return err
+}
+
+func (id KDFID) String() string {
+	if name, ok := kdfNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("KDFID(0x%04x)", uint16(id))
+}
+
+func (id KDFID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *KDFID) UnmarshalText(text []byte) error {
+	n, err := parseID("KDF", text, 16, func(name string) (uint64, bool) {
+		for candidate, candidateName := range kdfNames {
+			if strings.EqualFold(name, candidateName) {
+				return uint64(candidate), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return err
+	}
+
+	*id = KDFID(n)
+	return nil
+}
+
+func (id *KDFID) UnmarshalJSON(data []byte) error {
+	n, numeric, err := unmarshalIDJSON(data, 16, id.UnmarshalText)
+	if numeric {
+		*id = KDFID(n)
+	}
+	return err
+}
+
+func (id AEADID) String() string {
+	if name, ok := aeadNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("AEADID(0x%04x)", uint16(id))
+}
+
+func (id AEADID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *AEADID) UnmarshalText(text []byte) error {
+	n, err := parseID("AEAD", text, 16, func(name string) (uint64, bool) {
+		for candidate, candidateName := range aeadNames {
+			if strings.EqualFold(name, candidateName) {
+				return uint64(candidate), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return err
+	}
+
+	*id = AEADID(n)
+	return nil
+}
+
+func (id *AEADID) UnmarshalJSON(data []byte) error {
+	n, numeric, err := unmarshalIDJSON(data, 16, id.UnmarshalText)
+	if numeric {
+		*id = AEADID(n)
+	}
+	return err
+}
+
+func (mode Mode) MarshalText() ([]byte, error) {
+	return []byte(mode.String()), nil
+}
+
+func (mode *Mode) UnmarshalText(text []byte) error {
+	n, err := parseID("mode", text, 8, func(name string) (uint64, bool) {
+		for _, candidate := range []Mode{ModeBase, ModePSK, ModeAuth, ModeAuthPSK} {
+			if strings.EqualFold(name, candidate.String()) {
+				return uint64(candidate), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return err
+	}
+
+	*mode = Mode(n)
+	return nil
+}
+
+func (mode *Mode) UnmarshalJSON(data []byte) error {
+	n, numeric, err := unmarshalIDJSON(data, 8, mode.UnmarshalText)
+	if numeric {
+		*mode = Mode(n)
+	}
+	return err
+}
+
+// Short names used in cipher suite strings
+var kemShortNames = map[KEMID]string{
+	DHKEM_P256:   "P256",
+	DHKEM_P521:   "P521",
+	DHKEM_X25519: "X25519",
+	DHKEM_X448:   "X448",
+	KEM_SIKE503:  "SIKE503",
+	KEM_SIKE751:  "SIKE751",
+}
+
+var aeadShortNames = map[AEADID]string{
+	AEAD_AESGCM128:               "AES128GCM",
+	AEAD_AESGCM256:               "AES256GCM",
+	AEAD_CHACHA20POLY1305:        "CHACHA20POLY1305",
+	AEAD_EXPORT_ONLY:             "EXPORTONLY",
+	AEAD_AESGCM128_COMMIT:        "AES128GCMCOMMIT",
+	AEAD_AESGCM256_COMMIT:        "AES256GCMCOMMIT",
+	AEAD_CHACHA20POLY1305_COMMIT: "CHACHA20POLY1305COMMIT",
+}
+
+// FormatCipherSuite returns the canonical name of the cipher suite with the
+// given algorithms, e.g., "X25519-HKDF-SHA256-AES128GCM".  Algorithms without
+// a short name are named by their identifiers in hex, e.g., "KDFFF01".  The
+// name can be parsed with ParseCipherSuite.
+func FormatCipherSuite(kemID KEMID, kdfID KDFID, aeadID AEADID) string {
+	kem, ok := kemShortNames[kemID]
+	if !ok {
+		kem = fmt.Sprintf("KEM%04X", uint16(kemID))
+	}
+
+	kdf, ok := kdfNames[kdfID]
+	if !ok {
+		kdf = fmt.Sprintf("KDF%04X", uint16(kdfID))
+	}
+
+	aead, ok := aeadShortNames[aeadID]
+	if !ok {
+		aead = fmt.Sprintf("AEAD%04X", uint16(aeadID))
+	}
+
+	return kem + "-" + kdf + "-" + aead
+}
+
+// ParseCipherSuite assembles the cipher suite named by a string in the format
+// produced by FormatCipherSuite.  Names are matched case-insensitively.
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	s := strings.ToUpper(strings.TrimSpace(name))
+
+	var kemID KEMID
+	var kdfID KDFID
+	var aeadID AEADID
+	found := false
+	for candidate, kemName := range kemShortNames {
+		if strings.HasPrefix(s, kemName+"-") {
+			kemID, s, found = candidate, s[len(kemName)+1:], true
+			break
+		}
+	}
+	if n, rest, ok := parseNumericName(s, "KEM"); !found && ok && strings.HasPrefix(rest, "-") {
+		kemID, s, found = KEMID(n), rest[1:], true
+	}
+	if !found {
+		return CipherSuite{}, fmt.Errorf("Unknown KEM in cipher suite %q", name)
+	}
+
+	found = false
+	for candidate, kdfName := range kdfNames {
+		if strings.HasPrefix(s, kdfName+"-") {
+			kdfID, s, found = candidate, s[len(kdfName)+1:], true
+			break
+		}
+	}
+	if n, rest, ok := parseNumericName(s, "KDF"); !found && ok && strings.HasPrefix(rest, "-") {
+		kdfID, s, found = KDFID(n), rest[1:], true
+	}
+	if !found {
+		return CipherSuite{}, fmt.Errorf("Unknown KDF in cipher suite %q", name)
+	}
+
+	found = false
+	for candidate, aeadName := range aeadShortNames {
+		if s == aeadName {
+			aeadID, found = candidate, true
+			break
+		}
+	}
+	if n, rest, ok := parseNumericName(s, "AEAD"); !found && ok && rest == "" {
+		aeadID, found = AEADID(n), true
+	}
+	if !found {
+		return CipherSuite{}, fmt.Errorf("Unknown AEAD in cipher suite %q", name)
+	}
+
+	return AssembleCipherSuite(kemID, kdfID, aeadID)
+}
+
+// parseNumericName parses a name that FormatCipherSuite gives an algorithm
+// without a short name, i.e., the prefix followed by four hex digits, from
+// the start of s.  It returns the identifier and the rest of s.
+func parseNumericName(s, prefix string) (uint16, string, bool) {
+	end := len(prefix) + 4
+	if len(s) < end || !strings.HasPrefix(s, prefix) {
+		return 0, s, false
+	}
+
+	n, err := strconv.ParseUint(s[len(prefix):end], 16, 16)
+	if err != nil {
+		return 0, s, false
+	}
+
+	return uint16(n), s[end:], true
+}
@@ -0,0 +1,129 @@
+package hpke
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDNames(t *testing.T) {
+	require.Equal(t, "DHKEM(X25519, HKDF-SHA256)", DHKEM_X25519.String(), "Incorrect KEM name")
+	require.Equal(t, "HKDF-SHA384", KDF_HKDF_SHA384.String(), "Incorrect KDF name")
+	require.Equal(t, "ChaCha20Poly1305", AEAD_CHACHA20POLY1305.String(), "Incorrect AEAD name")
+	require.Equal(t, "KEMID(0x1234)", KEMID(0x1234).String(), "Incorrect name for unknown KEM")
+
+	for kemID := range kems {
+		text, err := kemID.MarshalText()
+		require.Nil(t, err, "Error in MarshalText")
+
+		var decoded KEMID
+		require.Nil(t, decoded.UnmarshalText(text), "Error in UnmarshalText")
+		require.Equal(t, kemID, decoded, "KEM name round trip failed")
+	}
+
+	for kdfID := range kdfs {
+		text, err := kdfID.MarshalText()
+		require.Nil(t, err, "Error in MarshalText")
+
+		var decoded KDFID
+		require.Nil(t, decoded.UnmarshalText(text), "Error in UnmarshalText")
+		require.Equal(t, kdfID, decoded, "KDF name round trip failed")
+	}
+
+	for aeadID := range aeads {
+		text, err := aeadID.MarshalText()
+		require.Nil(t, err, "Error in MarshalText")
+
+		var decoded AEADID
+		require.Nil(t, decoded.UnmarshalText(text), "Error in UnmarshalText")
+		require.Equal(t, aeadID, decoded, "AEAD name round trip failed")
+	}
+
+	var kemID KEMID
+	require.Nil(t, kemID.UnmarshalText([]byte("dhkem(p-256, hkdf-sha256)")), "Error parsing lower-case name")
+	require.Equal(t, DHKEM_P256, kemID, "Incorrect KEM for lower-case name")
+
+	require.Nil(t, kemID.UnmarshalText([]byte("0x0021")), "Error parsing numeric KEM id")
+	require.Equal(t, DHKEM_X448, kemID, "Incorrect KEM for numeric id")
+
+	require.NotNil(t, kemID.UnmarshalText([]byte("DHKEM(P-384, HKDF-SHA384)")), "Unknown KEM name accepted")
+	require.NotNil(t, kemID.UnmarshalText([]byte("0x10000")), "Out of range KEM id accepted")
+
+	var mode Mode
+	require.Nil(t, mode.UnmarshalText([]byte("authpsk")), "Error parsing mode name")
+	require.Equal(t, ModeAuthPSK, mode, "Incorrect mode")
+}
+
+func TestIDJSON(t *testing.T) {
+	type config struct {
+		Mode Mode   `json:"mode"`
+		KEM  KEMID  `json:"kem"`
+		KDF  KDFID  `json:"kdf"`
+		AEAD AEADID `json:"aead"`
+	}
+
+	in := config{ModeAuth, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128}
+	data, err := json.Marshal(in)
+	require.Nil(t, err, "Error in json.Marshal")
+	require.Equal(t, `{"mode":"Auth","kem":"DHKEM(X25519, HKDF-SHA256)","kdf":"HKDF-SHA256","aead":"AES-128-GCM"}`, string(data), "Incorrect JSON encoding")
+
+	var out config
+	require.Nil(t, json.Unmarshal(data, &out), "Error in json.Unmarshal")
+	require.Equal(t, in, out, "JSON round trip failed")
+
+	// Numeric identifiers are still accepted
+	out = config{}
+	require.Nil(t, json.Unmarshal([]byte(`{"mode":2,"kem":32,"kdf":1,"aead":1}`), &out), "Error decoding numeric identifiers")
+	require.Equal(t, in, out, "Incorrect numeric decoding")
+}
+
+func TestParseCipherSuite(t *testing.T) {
+	suite, err := ParseCipherSuite("X25519-HKDF-SHA256-CHACHA20POLY1305")
+	require.Nil(t, err, "Error in ParseCipherSuite")
+	require.Equal(t, DHKEM_X25519, suite.KEM.ID(), "Incorrect KEM")
+	require.Equal(t, KDF_HKDF_SHA256, suite.KDF.ID(), "Incorrect KDF")
+	require.Equal(t, AEAD_CHACHA20POLY1305, suite.AEAD.ID(), "Incorrect AEAD")
+
+	suite, err = ParseCipherSuite("x25519-hkdf-sha256-chacha20poly1305")
+	require.Nil(t, err, "Error parsing lower-case name")
+	require.Equal(t, DHKEM_X25519, suite.KEM.ID(), "Incorrect KEM")
+
+	for kemID := range kems {
+		for kdfID := range kdfs {
+			for aeadID := range aeads {
+				name := FormatCipherSuite(kemID, kdfID, aeadID)
+				suite, err := ParseCipherSuite(name)
+				require.Nil(t, err, "Error parsing %s", name)
+				require.Equal(t, kemID, suite.KEM.ID(), "Incorrect KEM for %s", name)
+				require.Equal(t, kdfID, suite.KDF.ID(), "Incorrect KDF for %s", name)
+				require.Equal(t, aeadID, suite.AEAD.ID(), "Incorrect AEAD for %s", name)
+			}
+		}
+	}
+
+	// Algorithms without short names are named by their identifiers
+	suite, err = ParseCipherSuite("kem0020-kdf0001-aead0003")
+	require.Nil(t, err, "Error parsing numeric names")
+	require.Equal(t, "X25519-HKDF-SHA256-CHACHA20POLY1305", suite.String(), "Incorrect suite")
+
+	_, err = ParseCipherSuite(FormatCipherSuite(0x9999, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305))
+	require.True(t, errors.Is(err, ErrUnsupportedSuite), "Unknown KEM accepted")
+
+	for _, name := range []string{"", "X25519", "X25519-HKDF-SHA256", "X25519-HKDF-SHA256-AES128GCM-", "P384-HKDF-SHA384-AES256GCM", "X25519-HKDF-MD5-AES128GCM", "KEM20-HKDF-SHA256-AES128GCM", "X25519-HKDF-SHA256-AEAD00001"} {
+		_, err := ParseCipherSuite(name)
+		require.NotNil(t, err, "ParseCipherSuite accepted %q", name)
+	}
+}
+
+func TestCipherSuiteString(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	require.Equal(t, "P256-HKDF-SHA256-AES128GCM", suite.String(), "Incorrect suite name")
+	require.Equal(t, []byte{'H', 'P', 'K', 'E', 0x00, 0x10, 0x00, 0x01, 0x00, 0x01}, suite.SuiteID(), "Incorrect suite_id")
+
+	parsed, err := ParseCipherSuite(suite.String())
+	require.Nil(t, err, "Error in ParseCipherSuite")
+	require.Equal(t, suite.SuiteID(), parsed.SuiteID(), "Suite name round trip failed")
+}
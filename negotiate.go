@@ -0,0 +1,97 @@
+package hpke
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SuiteDescriptor names a ciphersuite by its algorithm identifiers, as a
+// peer might advertise it, e.g., in an ECHConfig or OHTTP key configuration.
+type SuiteDescriptor struct {
+	KEMID  KEMID
+	KDFID  KDFID
+	AEADID AEADID
+}
+
+// Descriptor returns the identifiers of the suite's algorithms.
+func (suite CipherSuite) Descriptor() SuiteDescriptor {
+	return SuiteDescriptor{
+		KEMID:  suite.KEM.ID(),
+		KDFID:  suite.KDF.ID(),
+		AEADID: suite.AEAD.ID(),
+	}
+}
+
+func (d SuiteDescriptor) String() string {
+	return FormatCipherSuite(d.KEMID, d.KDFID, d.AEADID)
+}
+
+// securityLevel returns the suite's security level, the lowest level of its
+// algorithms.  The export-only AEAD does not lower it.
+func (d SuiteDescriptor) securityLevel() int {
+	level := kemSecurityLevels[d.KEMID]
+	if kdf := kdfSecurityLevels[d.KDFID]; kdf < level {
+		level = kdf
+	}
+	if aead, ok := aeadSecurityLevels[d.AEADID]; ok && aead < level {
+		level = aead
+	}
+	return level
+}
+
+// stronger reports whether d is preferred to other by Negotiate.
+func (d SuiteDescriptor) stronger(other SuiteDescriptor) bool {
+	switch {
+	case d.securityLevel() != other.securityLevel():
+		return d.securityLevel() > other.securityLevel()
+	case kemSecurityLevels[d.KEMID] != kemSecurityLevels[other.KEMID]:
+		return kemSecurityLevels[d.KEMID] > kemSecurityLevels[other.KEMID]
+	case kdfSecurityLevels[d.KDFID] != kdfSecurityLevels[other.KDFID]:
+		return kdfSecurityLevels[d.KDFID] > kdfSecurityLevels[other.KDFID]
+	}
+	return aeadSecurityLevels[d.AEADID] > aeadSecurityLevels[other.AEADID]
+}
+
+// Negotiate picks the strongest suite that appears in both the local and
+// remote lists and that AssembleCipherSuite accepts, i.e., that this build
+// supports and that FIPS mode and the installed Policy allow.
+//
+// Suites are preferred in this order:
+//
+//  1. Higher security level, the lowest level of the suite's KEM, KDF, and
+//     AEAD (e.g., 128 bits for DHKEM(X25519) or AES-128-GCM, 256 bits for
+//     DHKEM(P-521), HKDF-SHA512, or AES-256-GCM).
+//  2. Higher KEM security level, then higher KDF security level, then higher
+//     AEAD security level.
+//  3. Earlier position in the local list.
+//
+// The export-only AEAD has no level of its own; it is chosen only if both
+// sides list it.  SIKE is rated at level zero, since it is broken, so a SIKE
+// suite is chosen only if no suite with a classical KEM is common to both
+// lists.
+func Negotiate(local, remote []SuiteDescriptor) (CipherSuite, error) {
+	remoteSet := make(map[SuiteDescriptor]bool, len(remote))
+	for _, d := range remote {
+		remoteSet[d] = true
+	}
+
+	candidates := make([]SuiteDescriptor, 0, len(local))
+	for _, d := range local {
+		if remoteSet[d] {
+			candidates = append(candidates, d)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].stronger(candidates[j])
+	})
+
+	for _, d := range candidates {
+		suite, err := AssembleCipherSuite(d.KEMID, d.KDFID, d.AEADID)
+		if err == nil {
+			return suite, nil
+		}
+	}
+
+	return CipherSuite{}, fmt.Errorf("%w: No mutually supported suite", ErrUnsupportedSuite)
+}
@@ -0,0 +1,77 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
+package hpke
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	x25519AES128 := SuiteDescriptor{DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128}
+	x25519ChaCha := SuiteDescriptor{DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305}
+	p256AES128 := SuiteDescriptor{DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128}
+	p521AES256 := SuiteDescriptor{DHKEM_P521, KDF_HKDF_SHA512, AEAD_AESGCM256}
+	x448AES256 := SuiteDescriptor{DHKEM_X448, KDF_HKDF_SHA512, AEAD_AESGCM256}
+	sike751AES256 := SuiteDescriptor{KEM_SIKE751, KDF_HKDF_SHA512, AEAD_AESGCM256}
+
+	// The strongest common suite wins, regardless of list order
+	suite, err := Negotiate(
+		[]SuiteDescriptor{x25519AES128, p521AES256, x448AES256},
+		[]SuiteDescriptor{x448AES256, x25519AES128, p521AES256},
+	)
+	require.Nil(t, err, "Error in Negotiate")
+	require.Equal(t, p521AES256, suite.Descriptor(), "Incorrect suite")
+
+	// At equal suite level, the stronger AEAD wins
+	suite, err = Negotiate(
+		[]SuiteDescriptor{x25519AES128, x25519ChaCha},
+		[]SuiteDescriptor{x25519AES128, x25519ChaCha},
+	)
+	require.Nil(t, err, "Error in Negotiate")
+	require.Equal(t, x25519ChaCha, suite.Descriptor(), "Incorrect suite")
+
+	// Ties go to the local preference
+	suite, err = Negotiate(
+		[]SuiteDescriptor{p256AES128, x25519AES128},
+		[]SuiteDescriptor{x25519AES128, p256AES128},
+	)
+	require.Nil(t, err, "Error in Negotiate")
+	require.Equal(t, p256AES128, suite.Descriptor(), "Incorrect suite")
+
+	// SIKE ranks below every classical KEM, even when listed first
+	suite, err = Negotiate(
+		[]SuiteDescriptor{sike751AES256, x25519AES128},
+		[]SuiteDescriptor{sike751AES256, x25519AES128},
+	)
+	require.Nil(t, err, "Error in Negotiate")
+	require.Equal(t, x25519AES128, suite.Descriptor(), "Incorrect suite")
+
+	// ... but is still chosen if nothing else is common
+	suite, err = Negotiate(
+		[]SuiteDescriptor{sike751AES256, x25519AES128},
+		[]SuiteDescriptor{sike751AES256, p256AES128},
+	)
+	require.Nil(t, err, "Error in Negotiate")
+	require.Equal(t, sike751AES256, suite.Descriptor(), "Incorrect suite")
+
+	// Suites that cannot be assembled are skipped
+	defer SetPolicy(CurrentPolicy())
+	SetPolicy(&Policy{KEMs: []KEMID{DHKEM_X25519}})
+	suite, err = Negotiate(
+		[]SuiteDescriptor{p521AES256, x25519AES128},
+		[]SuiteDescriptor{p521AES256, x25519AES128},
+	)
+	require.Nil(t, err, "Error in Negotiate")
+	require.Equal(t, x25519AES128, suite.Descriptor(), "Incorrect suite")
+	SetPolicy(nil)
+
+	_, err = Negotiate([]SuiteDescriptor{x25519AES128}, []SuiteDescriptor{p256AES128})
+	require.True(t, errors.Is(err, ErrUnsupportedSuite), "Negotiated without a common suite")
+
+	_, err = Negotiate([]SuiteDescriptor{{0x9999, KDF_HKDF_SHA256, AEAD_AESGCM128}}, []SuiteDescriptor{{0x9999, KDF_HKDF_SHA256, AEAD_AESGCM128}})
+	require.True(t, errors.Is(err, ErrUnsupportedSuite), "Negotiated an unknown suite")
+}
@@ -0,0 +1,477 @@
+// Package odoh implements the encryption of queries and responses for
+// Oblivious DNS over HTTPS (RFC 9230) on top of HPKE.
+package odoh
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Version is the ObliviousDoHConfig version implemented by this package.
+const Version uint16 = 0x0001
+
+// Message types
+const (
+	MessageTypeQuery    uint8 = 0x01
+	MessageTypeResponse uint8 = 0x02
+)
+
+// Labels used in the key schedule
+const (
+	labelKeyID    = "odoh key id"
+	labelQuery    = "odoh query"
+	labelResponse = "odoh response"
+	labelKey      = "odoh key"
+	labelNonce    = "odoh nonce"
+)
+
+// Config is the contents of an ObliviousDoHConfig with version Version,
+// describing a target's public key and the suite to use with it.
+type Config struct {
+	KEMID     hpke.KEMID
+	KDFID     hpke.KDFID
+	AEADID    hpke.AEADID
+	PublicKey []byte `tls:"head=2"`
+}
+
+// wireConfig is the versioned framing of an ObliviousDoHConfig, which allows
+// configurations with unknown versions to be skipped.
+type wireConfig struct {
+	Version  uint16
+	Contents []byte `tls:"head=2"`
+}
+
+// wireConfigs represents ObliviousDoHConfigs encoded on the wire.
+type wireConfigs struct {
+	Configs []wireConfig `tls:"head=2"`
+}
+
+// NewConfig constructs a configuration for the public key pkR.
+func NewConfig(suite hpke.CipherSuite, pkR hpke.KEMPublicKey) (Config, error) {
+	config := Config{
+		KEMID:     suite.KEM.ID(),
+		KDFID:     suite.KDF.ID(),
+		AEADID:    suite.AEAD.ID(),
+		PublicKey: suite.KEM.SerializePublicKey(pkR),
+	}
+
+	if _, err := config.Suite(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// Suite returns the HPKE suite for the configuration.  The DH-based KEMs are
+// the only ones supported, since the encapsulated key in a query is not
+// length-prefixed, and for those KEMs it has the same size as a public key.
+func (config Config) Suite() (hpke.CipherSuite, error) {
+	switch config.KEMID {
+	case hpke.DHKEM_P256, hpke.DHKEM_P521, hpke.DHKEM_X25519, hpke.DHKEM_X448:
+	default:
+		return hpke.CipherSuite{}, fmt.Errorf("%w: Unsupported KEM id [%s]", hpke.ErrUnsupportedSuite, config.KEMID)
+	}
+
+	if config.AEADID == hpke.AEAD_EXPORT_ONLY {
+		return hpke.CipherSuite{}, fmt.Errorf("%w: Export-only AEAD", hpke.ErrUnsupportedSuite)
+	}
+
+	return hpke.AssembleCipherSuite(config.KEMID, config.KDFID, config.AEADID)
+}
+
+// Marshal returns the serialized ObliviousDoHConfig, including its version and
+// length.
+func (config Config) Marshal() ([]byte, error) {
+	contents, err := config.marshalContents()
+	if err != nil {
+		return nil, err
+	}
+
+	return syntax.Marshal(wireConfig{Version: Version, Contents: contents})
+}
+
+func (config Config) marshalContents() ([]byte, error) {
+	if len(config.PublicKey) == 0 {
+		return nil, fmt.Errorf("Empty ODoH public key")
+	}
+
+	return syntax.Marshal(config)
+}
+
+// KeyID computes the identifier of the configuration that clients send with
+// queries, Expand(Extract("", config), "odoh key id", Nh).
+func (config Config) KeyID() ([]byte, error) {
+	suite, err := config.Suite()
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := config.marshalContents()
+	if err != nil {
+		return nil, err
+	}
+
+	prk := suite.KDF.Extract(nil, contents)
+	return suite.KDF.Expand(prk, []byte(labelKeyID), suite.KDF.OutputSize()), nil
+}
+
+func parseContents(contents []byte) (Config, error) {
+	var config Config
+	read, err := syntax.Unmarshal(contents, &config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if read != len(contents) {
+		return Config{}, fmt.Errorf("Trailing data after ObliviousDoHConfigContents")
+	}
+
+	if len(config.PublicKey) == 0 {
+		return Config{}, fmt.Errorf("Empty ODoH public key")
+	}
+
+	return config, nil
+}
+
+// ParseConfigs parses ObliviousDoHConfigs, as published by a target.
+// Configurations with an unknown version or an unsupported suite are
+// skipped; it is an error if none remain.
+func ParseConfigs(data []byte) ([]Config, error) {
+	var list wireConfigs
+	read, err := syntax.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	if read != len(data) {
+		return nil, fmt.Errorf("Trailing data after ObliviousDoHConfigs")
+	}
+
+	var configs []Config
+	for _, wire := range list.Configs {
+		if wire.Version != Version {
+			continue
+		}
+
+		config, err := parseContents(wire.Contents)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := config.Suite(); err == nil {
+			configs = append(configs, config)
+		}
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("No supported ObliviousDoHConfig")
+	}
+
+	return configs, nil
+}
+
+// MarshalConfigs serializes a list of configurations as ObliviousDoHConfigs.
+func MarshalConfigs(configs []Config) ([]byte, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("Empty ObliviousDoHConfigs")
+	}
+
+	list := wireConfigs{Configs: make([]wireConfig, len(configs))}
+	for i, config := range configs {
+		contents, err := config.marshalContents()
+		if err != nil {
+			return nil, err
+		}
+
+		list.Configs[i] = wireConfig{Version: Version, Contents: contents}
+	}
+
+	return syntax.Marshal(list)
+}
+
+// Message is an ObliviousDoHMessage.  For queries, KeyID identifies the
+// target's configuration; for responses, it carries the response nonce.
+type Message struct {
+	MessageType      uint8
+	KeyID            []byte `tls:"head=2"`
+	EncryptedMessage []byte `tls:"head=2"`
+}
+
+// Marshal serializes the message.
+func (msg Message) Marshal() ([]byte, error) {
+	return syntax.Marshal(msg)
+}
+
+// ParseMessage parses a serialized ObliviousDoHMessage.
+func ParseMessage(data []byte) (Message, error) {
+	var msg Message
+	read, err := syntax.Unmarshal(data, &msg)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if read != len(data) {
+		return Message{}, fmt.Errorf("Trailing data after ObliviousDoHMessage")
+	}
+
+	if len(msg.EncryptedMessage) == 0 {
+		return Message{}, fmt.Errorf("Empty ODoH encrypted message")
+	}
+
+	return msg, nil
+}
+
+// messagePlaintext represents an ObliviousDoHMessagePlaintext.
+type messagePlaintext struct {
+	DNSMessage []byte `tls:"head=2"`
+	Padding    []byte `tls:"head=2"`
+}
+
+func marshalPlaintext(dnsMessage []byte, padding int) ([]byte, error) {
+	if len(dnsMessage) == 0 {
+		return nil, fmt.Errorf("Empty DNS message")
+	}
+
+	if padding < 0 {
+		return nil, fmt.Errorf("Invalid padding length [%d]", padding)
+	}
+
+	return syntax.Marshal(messagePlaintext{DNSMessage: dnsMessage, Padding: make([]byte, padding)})
+}
+
+func parsePlaintext(data []byte) ([]byte, error) {
+	var pt messagePlaintext
+	read, err := syntax.Unmarshal(data, &pt)
+	if err != nil {
+		return nil, err
+	}
+
+	if read != len(data) {
+		return nil, fmt.Errorf("Trailing data after ObliviousDoHMessagePlaintext")
+	}
+
+	if !bytes.Equal(pt.Padding, make([]byte, len(pt.Padding))) {
+		return nil, fmt.Errorf("Non-zero ODoH padding")
+	}
+
+	return pt.DNSMessage, nil
+}
+
+// messageAAD computes message_type || len(key_id) || key_id.
+func messageAAD(messageType uint8, keyID []byte) []byte {
+	aad := []byte{messageType, byte(len(keyID) >> 8), byte(len(keyID))}
+	return append(aad, keyID...)
+}
+
+// QueryContext holds the state a client needs to decrypt the response to a
+// query.
+type QueryContext struct {
+	suite  hpke.CipherSuite
+	ctx    *hpke.SenderContext
+	qPlain []byte
+}
+
+// EncryptQuery encrypts a DNS query to the target with the given
+// configuration, adding padding zero bytes of padding.  It returns the
+// serialized ObliviousDoHMessage and the context for decrypting the response.
+func EncryptQuery(config Config, rand io.Reader, query []byte, padding int) ([]byte, *QueryContext, error) {
+	suite, err := config.Suite()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkR, err := suite.KEM.DeserializePublicKey(config.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyID, err := config.KeyID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	qPlain, err := marshalPlaintext(query, padding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc, ctx, err := hpke.SetupBaseS(suite, rand, pkR, []byte(labelQuery))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := ctx.Seal(messageAAD(MessageTypeQuery, keyID), qPlain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg := Message{
+		MessageType:      MessageTypeQuery,
+		KeyID:            keyID,
+		EncryptedMessage: append(enc, ct...),
+	}
+
+	data, err := msg.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, &QueryContext{suite: suite, ctx: ctx, qPlain: qPlain}, nil
+}
+
+// DecryptResponse decrypts the target's response to the query.
+func (q *QueryContext) DecryptResponse(data []byte) ([]byte, error) {
+	msg, err := ParseMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.MessageType != MessageTypeResponse {
+		return nil, fmt.Errorf("Unexpected ODoH message type [%d]", msg.MessageType)
+	}
+
+	if len(msg.KeyID) != responseNonceSize(q.suite) {
+		return nil, fmt.Errorf("Invalid ODoH response nonce length [%d]", len(msg.KeyID))
+	}
+
+	aead, nonce, err := responseAEAD(q.suite, q.ctx, q.qPlain, msg.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rPlain, err := aead.Open(nil, nonce, msg.EncryptedMessage, messageAAD(MessageTypeResponse, msg.KeyID))
+	if err != nil {
+		return nil, hpke.ErrOpenFailed
+	}
+
+	return parsePlaintext(rPlain)
+}
+
+// ResponseContext holds the state a target needs to encrypt the response to
+// a query.
+type ResponseContext struct {
+	suite  hpke.CipherSuite
+	ctx    *hpke.ReceiverContext
+	qPlain []byte
+}
+
+// DecryptQuery decrypts a query addressed to the configuration config, whose
+// private key is skR.  It returns the DNS query and the context for
+// encrypting the response.
+func DecryptQuery(config Config, skR hpke.KEMPrivateKey, data []byte) ([]byte, *ResponseContext, error) {
+	suite, err := config.Suite()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := ParseMessage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if msg.MessageType != MessageTypeQuery {
+		return nil, nil, fmt.Errorf("Unexpected ODoH message type [%d]", msg.MessageType)
+	}
+
+	keyID, err := config.KeyID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(msg.KeyID, keyID) {
+		return nil, nil, fmt.Errorf("Unknown ODoH key ID [%x]", msg.KeyID)
+	}
+
+	// For the supported KEMs, Nenc = Npk
+	Nenc := len(config.PublicKey)
+	if len(msg.EncryptedMessage) < Nenc {
+		return nil, nil, fmt.Errorf("Truncated ODoH query")
+	}
+
+	enc, ct := msg.EncryptedMessage[:Nenc], msg.EncryptedMessage[Nenc:]
+	ctx, err := hpke.SetupBaseR(suite, skR, enc, []byte(labelQuery))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	qPlain, err := ctx.Open(messageAAD(MessageTypeQuery, keyID), ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query, err := parsePlaintext(qPlain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return query, &ResponseContext{suite: suite, ctx: ctx, qPlain: qPlain}, nil
+}
+
+// EncryptResponse encrypts a DNS response to the client that sent the query,
+// adding padding zero bytes of padding, and returns the serialized
+// ObliviousDoHMessage.
+func (r *ResponseContext) EncryptResponse(rand io.Reader, response []byte, padding int) ([]byte, error) {
+	rPlain, err := marshalPlaintext(response, padding)
+	if err != nil {
+		return nil, err
+	}
+
+	responseNonce := make([]byte, responseNonceSize(r.suite))
+	if _, err := io.ReadFull(rand, responseNonce); err != nil {
+		return nil, err
+	}
+
+	aead, nonce, err := responseAEAD(r.suite, r.ctx, r.qPlain, responseNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := Message{
+		MessageType:      MessageTypeResponse,
+		KeyID:            responseNonce,
+		EncryptedMessage: aead.Seal(nil, nonce, rPlain, messageAAD(MessageTypeResponse, responseNonce)),
+	}
+
+	return msg.Marshal()
+}
+
+// exporter is implemented by both sender and receiver contexts.
+type exporter interface {
+	ExportSecret(context []byte, L int) ([]byte, error)
+}
+
+// responseNonceSize returns max(Nn, Nk).
+func responseNonceSize(suite hpke.CipherSuite) int {
+	if suite.AEAD.NonceSize() > suite.AEAD.KeySize() {
+		return suite.AEAD.NonceSize()
+	}
+	return suite.AEAD.KeySize()
+}
+
+// responseAEAD derives the response key and nonce (RFC 9230, Section 6.4).
+func responseAEAD(suite hpke.CipherSuite, ctx exporter, qPlain, responseNonce []byte) (cipher.AEAD, []byte, error) {
+	secret, err := ctx.ExportSecret([]byte(labelResponse), suite.AEAD.KeySize())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	salt := append([]byte{}, qPlain...)
+	salt = append(salt, byte(len(responseNonce)>>8), byte(len(responseNonce)))
+	salt = append(salt, responseNonce...)
+
+	prk := suite.KDF.Extract(salt, secret)
+	key := suite.KDF.Expand(prk, []byte(labelKey), suite.AEAD.KeySize())
+	nonce := suite.KDF.Expand(prk, []byte(labelNonce), suite.AEAD.NonceSize())
+
+	aead, err := suite.AEAD.New(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, nonce, nil
+}
@@ -0,0 +1,116 @@
+package odoh
+
+import (
+	"crypto/rand"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	query    = []byte("\xab\xcd\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x07example\x03com\x00\x00\x01\x00\x01")
+	response = []byte("\xab\xcd\x81\x80\x00\x01\x00\x01\x00\x00\x00\x00")
+)
+
+func newTestConfig(t *testing.T, kemID hpke.KEMID) (Config, hpke.KEMPrivateKey) {
+	suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	config, err := NewConfig(suite, pkR)
+	require.Nil(t, err, "Error in NewConfig")
+	return config, skR
+}
+
+func TestConfigs(t *testing.T) {
+	config, _ := newTestConfig(t, hpke.DHKEM_X25519)
+
+	data, err := MarshalConfigs([]Config{config})
+	require.Nil(t, err, "Error in MarshalConfigs")
+
+	// length, version 1, length, kem_id, kdf_id, aead_id, public key length
+	expectedPrefix := []byte{0x00, 0x2c, 0x00, 0x01, 0x00, 0x28, 0x00, 0x20, 0x00, 0x01, 0x00, 0x01, 0x00, 0x20}
+	require.Equal(t, expectedPrefix, data[:len(expectedPrefix)], "Incorrect ObliviousDoHConfigs encoding")
+
+	single, err := config.Marshal()
+	require.Nil(t, err, "Error in Marshal")
+	require.Equal(t, data[2:], single, "Incorrect ObliviousDoHConfig encoding")
+
+	// Configurations with an unknown version are skipped
+	unknown := []byte{0x00, 0x02, 0x00, 0x02, 0xab, 0xcd}
+	listLen := len(data) - 2 + len(unknown)
+	withUnknown := append([]byte{byte(listLen >> 8), byte(listLen)}, append(unknown, data[2:]...)...)
+
+	configs, err := ParseConfigs(withUnknown)
+	require.Nil(t, err, "Error in ParseConfigs")
+	require.Equal(t, []Config{config}, configs, "Incorrect configs")
+
+	_, err = ParseConfigs(data[:len(data)-1])
+	require.NotNil(t, err, "Truncated ObliviousDoHConfigs accepted")
+
+	_, err = ParseConfigs(append(data, 0x00))
+	require.NotNil(t, err, "ObliviousDoHConfigs with trailing data accepted")
+
+	keyID, err := config.KeyID()
+	require.Nil(t, err, "Error in KeyID")
+	require.Equal(t, 32, len(keyID), "Incorrect key ID length")
+}
+
+func TestQueryResponse(t *testing.T) {
+	for _, kemID := range []hpke.KEMID{hpke.DHKEM_X25519, hpke.DHKEM_P256} {
+		config, skR := newTestConfig(t, kemID)
+
+		encQuery, client, err := EncryptQuery(config, rand.Reader, query, 16)
+		require.Nil(t, err, "Error in EncryptQuery")
+
+		msg, err := ParseMessage(encQuery)
+		require.Nil(t, err, "Error in ParseMessage")
+		require.Equal(t, MessageTypeQuery, msg.MessageType, "Incorrect message type")
+
+		decQuery, target, err := DecryptQuery(config, skR, encQuery)
+		require.Nil(t, err, "Error in DecryptQuery")
+		require.Equal(t, query, decQuery, "Incorrect query")
+
+		encResponse, err := target.EncryptResponse(rand.Reader, response, 0)
+		require.Nil(t, err, "Error in EncryptResponse")
+
+		decResponse, err := client.DecryptResponse(encResponse)
+		require.Nil(t, err, "Error in DecryptResponse")
+		require.Equal(t, response, decResponse, "Incorrect response")
+
+		// Tampering is detected in both directions
+		encQuery[len(encQuery)-1] ^= 0x01
+		_, _, err = DecryptQuery(config, skR, encQuery)
+		require.NotNil(t, err, "Modified query accepted")
+
+		encResponse[len(encResponse)-1] ^= 0x01
+		_, err = client.DecryptResponse(encResponse)
+		require.NotNil(t, err, "Modified response accepted")
+
+		// Queries are bound to the target's configuration
+		other, _ := newTestConfig(t, kemID)
+		encQuery, _, err = EncryptQuery(other, rand.Reader, query, 0)
+		require.Nil(t, err, "Error in EncryptQuery")
+
+		_, _, err = DecryptQuery(config, skR, encQuery)
+		require.NotNil(t, err, "Query for a different config accepted")
+	}
+}
+
+func TestUnsupportedSuite(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	_, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	_, err = NewConfig(suite, pkR)
+	require.NotNil(t, err, "Config accepted with export-only AEAD")
+}
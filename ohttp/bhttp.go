@@ -0,0 +1,290 @@
+package ohttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Known-length Binary HTTP (RFC 9292) encoding of the requests and responses
+// carried by Transport.  Indeterminate-length messages are not supported.
+const (
+	framingKnownLengthRequest  = 0
+	framingKnownLengthResponse = 1
+)
+
+// appendVarint appends a QUIC variable-length integer (RFC 9000, Section 16).
+func appendVarint(out []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(out, byte(v))
+	case v < 1<<14:
+		return append(out, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(out, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(out, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+func appendVarintBytes(out, data []byte) []byte {
+	out = appendVarint(out, uint64(len(data)))
+	return append(out, data...)
+}
+
+// bhttpReader reads the fields of a Binary HTTP message.  A message may be
+// truncated after any section, in which case the remaining sections are
+// empty.
+type bhttpReader struct {
+	data []byte
+}
+
+func (r *bhttpReader) done() bool {
+	return len(r.data) == 0
+}
+
+// padding reports whether the rest of the message is zero padding.
+func (r *bhttpReader) padding() bool {
+	for _, b := range r.data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *bhttpReader) varint() (uint64, error) {
+	if len(r.data) == 0 {
+		return 0, fmt.Errorf("Truncated Binary HTTP message")
+	}
+
+	n := 1 << (r.data[0] >> 6)
+	if len(r.data) < n {
+		return 0, fmt.Errorf("Truncated Binary HTTP message")
+	}
+
+	v := uint64(r.data[0] & 0x3f)
+	for _, b := range r.data[1:n] {
+		v = v<<8 | uint64(b)
+	}
+
+	r.data = r.data[n:]
+	return v, nil
+}
+
+func (r *bhttpReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(r.data)) < n {
+		return nil, fmt.Errorf("Truncated Binary HTTP message")
+	}
+
+	out := r.data[:n]
+	r.data = r.data[n:]
+	return out, nil
+}
+
+// fields reads a known-length field section.  An absent section is empty.
+func (r *bhttpReader) fields() (http.Header, error) {
+	header := http.Header{}
+	if r.done() {
+		return header, nil
+	}
+
+	section, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	fr := &bhttpReader{data: section}
+	for !fr.done() {
+		name, err := fr.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := fr.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		header.Add(string(name), string(value))
+	}
+
+	return header, nil
+}
+
+// content reads a known-length content section.  An absent section is empty.
+func (r *bhttpReader) content() ([]byte, error) {
+	if r.done() {
+		return nil, nil
+	}
+	return r.bytes()
+}
+
+// appendFields appends a known-length field section, with lowercase names as
+// in HTTP/2.
+func appendFields(out []byte, header http.Header) []byte {
+	var section []byte
+	for name, values := range header {
+		for _, value := range values {
+			section = appendVarintBytes(section, []byte(strings.ToLower(name)))
+			section = appendVarintBytes(section, []byte(value))
+		}
+	}
+
+	return appendVarintBytes(out, section)
+}
+
+// encodeRequest serializes a request and its content, which the caller has
+// read from the request body.
+func encodeRequest(req *http.Request, content []byte) []byte {
+	authority := req.Host
+	if len(authority) == 0 {
+		authority = req.URL.Host
+	}
+
+	out := appendVarint(nil, framingKnownLengthRequest)
+	out = appendVarintBytes(out, []byte(req.Method))
+	out = appendVarintBytes(out, []byte(req.URL.Scheme))
+	out = appendVarintBytes(out, []byte(authority))
+	out = appendVarintBytes(out, []byte(req.URL.RequestURI()))
+	out = appendFields(out, req.Header)
+	out = appendVarintBytes(out, content)
+	return appendFields(out, req.Trailer)
+}
+
+// decodeRequest parses a known-length request.
+func decodeRequest(data []byte) (*http.Request, error) {
+	r := &bhttpReader{data: data}
+	framing, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	if framing != framingKnownLengthRequest {
+		return nil, fmt.Errorf("Unsupported Binary HTTP framing indicator [%d]", framing)
+	}
+
+	var control [4][]byte
+	for i := range control {
+		if control[i], err = r.bytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	method, scheme, authority, path := string(control[0]), string(control[1]), string(control[2]), string(control[3])
+	u, err := url.Parse(scheme + "://" + authority + path)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := r.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := r.content()
+	if err != nil {
+		return nil, err
+	}
+
+	trailer, err := r.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.padding() {
+		return nil, fmt.Errorf("Trailing data after Binary HTTP message")
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = header
+	req.Trailer = trailer
+	return req, nil
+}
+
+// encodeResponse serializes a final response.
+func encodeResponse(status int, header http.Header, content []byte) []byte {
+	out := appendVarint(nil, framingKnownLengthResponse)
+	out = appendVarint(out, uint64(status))
+	out = appendFields(out, header)
+	out = appendVarintBytes(out, content)
+	return appendFields(out, nil)
+}
+
+// decodeResponse parses a known-length response, skipping any informational
+// (1xx) responses that precede the final one.
+func decodeResponse(data []byte, req *http.Request) (*http.Response, error) {
+	r := &bhttpReader{data: data}
+	framing, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	if framing != framingKnownLengthResponse {
+		return nil, fmt.Errorf("Unsupported Binary HTTP framing indicator [%d]", framing)
+	}
+
+	var status uint64
+	for {
+		if status, err = r.varint(); err != nil {
+			return nil, err
+		}
+
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("Invalid status code [%d]", status)
+		}
+
+		if status >= 200 {
+			break
+		}
+
+		if _, err := r.fields(); err != nil {
+			return nil, err
+		}
+	}
+
+	header, err := r.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := r.content()
+	if err != nil {
+		return nil, err
+	}
+
+	trailer, err := r.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.padding() {
+		return nil, fmt.Errorf("Trailing data after Binary HTTP message")
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(int(status))),
+		StatusCode:    int(status),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Trailer:       trailer,
+		Body:          ioutil.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,273 @@
+package ohttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// SymmetricAlgorithm is a KDF and AEAD pair that a gateway supports for a
+// given key.
+type SymmetricAlgorithm struct {
+	KDFID  hpke.KDFID
+	AEADID hpke.AEADID
+}
+
+// KeyConfig is a gateway's key configuration, which clients use to
+// encapsulate requests.
+type KeyConfig struct {
+	KeyID      uint8
+	KEMID      hpke.KEMID
+	PublicKey  []byte
+	Algorithms []SymmetricAlgorithm
+}
+
+// NewKeyConfig constructs a key configuration for the public key pkR, usable
+// with each of the given symmetric algorithms.
+func NewKeyConfig(keyID uint8, kem hpke.KEMScheme, pkR hpke.KEMPublicKey, algs ...SymmetricAlgorithm) (KeyConfig, error) {
+	config := KeyConfig{
+		KeyID:      keyID,
+		KEMID:      kem.ID(),
+		PublicKey:  kem.SerializePublicKey(pkR),
+		Algorithms: algs,
+	}
+
+	if err := config.validate(); err != nil {
+		return KeyConfig{}, err
+	}
+
+	return config, nil
+}
+
+// publicKeySize returns the size of a serialized public key for the KEM.
+func publicKeySize(kemID hpke.KEMID) (int, error) {
+	for _, kem := range hpke.SupportedKEMs() {
+		if kem.ID == kemID {
+			return kem.PublicKeySize, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: Unknown KEM id [%s]", hpke.ErrUnsupportedSuite, kemID)
+}
+
+func (config KeyConfig) validate() error {
+	Npk, err := publicKeySize(config.KEMID)
+	if err != nil {
+		return err
+	}
+
+	if len(config.PublicKey) != Npk {
+		return fmt.Errorf("%w: got %d bytes, expected %d", hpke.ErrInvalidPublicKey, len(config.PublicKey), Npk)
+	}
+
+	if len(config.Algorithms) == 0 || len(config.Algorithms) > 0xFFFF/4 {
+		return fmt.Errorf("Invalid number of symmetric algorithms [%d]", len(config.Algorithms))
+	}
+
+	return nil
+}
+
+// Marshal serializes the key configuration:
+//
+//	Key Config {
+//	  Key Identifier (8),
+//	  HPKE KEM ID (16),
+//	  HPKE Public Key (Npk * 8),
+//	  HPKE Symmetric Algorithms Length (16) = 4..65532,
+//	  HPKE Symmetric Algorithms (32) = 4..65532,
+//	}
+func (config KeyConfig) Marshal() ([]byte, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	out := []byte{config.KeyID, 0, 0}
+	binary.BigEndian.PutUint16(out[1:], uint16(config.KEMID))
+	out = append(out, config.PublicKey...)
+
+	algs := make([]byte, 2+4*len(config.Algorithms))
+	binary.BigEndian.PutUint16(algs, uint16(4*len(config.Algorithms)))
+	for i, alg := range config.Algorithms {
+		binary.BigEndian.PutUint16(algs[2+4*i:], uint16(alg.KDFID))
+		binary.BigEndian.PutUint16(algs[4+4*i:], uint16(alg.AEADID))
+	}
+
+	return append(out, algs...), nil
+}
+
+// parseKeyConfig reads a key configuration from the start of data, returning
+// the number of bytes read.
+func parseKeyConfig(data []byte) (KeyConfig, int, error) {
+	if len(data) < 3 {
+		return KeyConfig{}, 0, fmt.Errorf("Truncated key config")
+	}
+
+	config := KeyConfig{
+		KeyID: data[0],
+		KEMID: hpke.KEMID(binary.BigEndian.Uint16(data[1:])),
+	}
+
+	Npk, err := publicKeySize(config.KEMID)
+	if err != nil {
+		return KeyConfig{}, 0, err
+	}
+
+	read := 3
+	if len(data) < read+Npk+2 {
+		return KeyConfig{}, 0, fmt.Errorf("Truncated key config")
+	}
+
+	config.PublicKey = append([]byte{}, data[read:read+Npk]...)
+	read += Npk
+
+	algsLen := int(binary.BigEndian.Uint16(data[read:]))
+	read += 2
+	if algsLen%4 != 0 || len(data) < read+algsLen {
+		return KeyConfig{}, 0, fmt.Errorf("Invalid symmetric algorithms length [%d]", algsLen)
+	}
+
+	for i := 0; i < algsLen; i += 4 {
+		config.Algorithms = append(config.Algorithms, SymmetricAlgorithm{
+			KDFID:  hpke.KDFID(binary.BigEndian.Uint16(data[read+i:])),
+			AEADID: hpke.AEADID(binary.BigEndian.Uint16(data[read+i+2:])),
+		})
+	}
+	read += algsLen
+
+	if err := config.validate(); err != nil {
+		return KeyConfig{}, 0, err
+	}
+
+	return config, read, nil
+}
+
+// ParseKeyConfig parses a single serialized key configuration.
+func ParseKeyConfig(data []byte) (KeyConfig, error) {
+	config, read, err := parseKeyConfig(data)
+	if err != nil {
+		return KeyConfig{}, err
+	}
+
+	if read != len(data) {
+		return KeyConfig{}, fmt.Errorf("Trailing data after key config")
+	}
+
+	return config, nil
+}
+
+// ParseKeyConfigs parses a list of key configurations in the
+// "application/ohttp-keys" format, in which each configuration is prefixed
+// with its 16-bit length.  Configurations that use a KEM not supported by
+// this package are skipped; it is an error if none remain.
+func ParseKeyConfigs(data []byte) ([]KeyConfig, error) {
+	var configs []KeyConfig
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("Truncated key config length")
+		}
+
+		configLen := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+configLen {
+			return nil, fmt.Errorf("Truncated key config")
+		}
+
+		config, err := ParseKeyConfig(data[2 : 2+configLen])
+		data = data[2+configLen:]
+		if errors.Is(err, hpke.ErrUnsupportedSuite) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, config)
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("No supported key configs")
+	}
+
+	return configs, nil
+}
+
+// MarshalKeyConfigs serializes a list of key configurations in the
+// "application/ohttp-keys" format.
+func MarshalKeyConfigs(configs []KeyConfig) ([]byte, error) {
+	var out []byte
+	for _, config := range configs {
+		data, err := config.Marshal()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) > 0xFFFF {
+			return nil, fmt.Errorf("Key config too long [%d]", len(data))
+		}
+
+		out = append(out, byte(len(data)>>8), byte(len(data)))
+		out = append(out, data...)
+	}
+
+	return out, nil
+}
+
+// Suite selects the first of the configuration's symmetric algorithms that
+// this package supports, in the gateway's order of preference.
+func (config KeyConfig) Suite() (hpke.CipherSuite, error) {
+	for _, alg := range config.Algorithms {
+		if alg.AEADID == hpke.AEAD_EXPORT_ONLY {
+			continue
+		}
+
+		suite, err := hpke.AssembleCipherSuite(config.KEMID, alg.KDFID, alg.AEADID)
+		if err == nil {
+			return suite, nil
+		}
+	}
+
+	return hpke.CipherSuite{}, fmt.Errorf("%w: No supported symmetric algorithm [%d]", hpke.ErrUnsupportedSuite, config.KeyID)
+}
+
+// hasAlgorithm reports whether the configuration lists the given algorithms.
+func (config KeyConfig) hasAlgorithm(kdfID hpke.KDFID, aeadID hpke.AEADID) bool {
+	for _, alg := range config.Algorithms {
+		if alg.KDFID == kdfID && alg.AEADID == aeadID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SelectKeyConfig returns the first key configuration in the list that has a
+// symmetric algorithm supported by this package, along with the suite that
+// EncapsulateRequest would use with it.
+func SelectKeyConfig(configs []KeyConfig) (KeyConfig, hpke.CipherSuite, error) {
+	for _, config := range configs {
+		suite, err := config.Suite()
+		if err == nil {
+			return config, suite, nil
+		}
+	}
+
+	return KeyConfig{}, hpke.CipherSuite{}, fmt.Errorf("%w: No usable key config", hpke.ErrUnsupportedSuite)
+}
+
+// KeyConfigForRequest returns the key configuration, among those a gateway
+// publishes, that an Encapsulated Request was sent to, as identified by the
+// key ID in its header.  Gateways with several keys use this to select the
+// configuration and private key to pass to DecapsulateRequest.
+func KeyConfigForRequest(configs []KeyConfig, encRequest []byte) (KeyConfig, error) {
+	if len(encRequest) < headerSize {
+		return KeyConfig{}, fmt.Errorf("Truncated encapsulated request")
+	}
+
+	for _, config := range configs {
+		if config.KeyID == encRequest[0] {
+			return config, nil
+		}
+	}
+
+	return KeyConfig{}, fmt.Errorf("Unknown key ID [%d]", encRequest[0])
+}
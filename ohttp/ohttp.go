@@ -0,0 +1,237 @@
+// Package ohttp implements the encapsulation of requests and responses for
+// Oblivious HTTP (RFC 9458) on top of HPKE.  The messages being encapsulated
+// are opaque to this package; for standard OHTTP they are Binary HTTP
+// messages (RFC 9292).
+//
+// The key configurations that gateways publish, in the
+// "application/ohttp-keys" format, are handled separately from the
+// encapsulation: gateways serialize theirs with MarshalKeyConfigs, and
+// clients parse them with ParseKeyConfigs and choose one with
+// SelectKeyConfig.
+package ohttp
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+const (
+	// RequestLabel and ResponseLabel are the media types bound into the
+	// encapsulation of Binary HTTP requests and responses.
+	RequestLabel  = "message/bhttp request"
+	ResponseLabel = "message/bhttp response"
+
+	// headerSize is the size of the request header: key_id, kem_id, kdf_id,
+	// and aead_id.
+	headerSize = 7
+)
+
+func requestHeader(keyID uint8, suite hpke.CipherSuite) []byte {
+	hdr := make([]byte, headerSize)
+	hdr[0] = keyID
+	binary.BigEndian.PutUint16(hdr[1:], uint16(suite.KEM.ID()))
+	binary.BigEndian.PutUint16(hdr[3:], uint16(suite.KDF.ID()))
+	binary.BigEndian.PutUint16(hdr[5:], uint16(suite.AEAD.ID()))
+	return hdr
+}
+
+// requestInfo computes the HPKE info string, label || 0x00 || hdr.
+func requestInfo(label string, hdr []byte) []byte {
+	info := append([]byte(label), 0x00)
+	return append(info, hdr...)
+}
+
+// ClientContext holds the state a client needs to decapsulate the response
+// to an encapsulated request.
+type ClientContext struct {
+	suite hpke.CipherSuite
+	enc   []byte
+	ctx   *hpke.SenderContext
+}
+
+// EncapsulateRequest encrypts a Binary HTTP request to the gateway whose key
+// configuration is given, returning the Encapsulated Request and the context
+// for decapsulating the response.
+func EncapsulateRequest(config KeyConfig, rand io.Reader, request []byte) ([]byte, *ClientContext, error) {
+	return EncapsulateRequestWithLabel(config, rand, RequestLabel, request)
+}
+
+// EncapsulateRequestWithLabel is like EncapsulateRequest, but for a request
+// with a media type other than Binary HTTP.  The label must be the media type
+// followed by " request".
+func EncapsulateRequestWithLabel(config KeyConfig, rand io.Reader, label string, request []byte) ([]byte, *ClientContext, error) {
+	suite, err := config.Suite()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkR, err := suite.KEM.DeserializePublicKey(config.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hdr := requestHeader(config.KeyID, suite)
+	enc, ctx, err := hpke.SetupBaseS(suite, rand, pkR, requestInfo(label, hdr))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := ctx.Seal(nil, request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encRequest := append(hdr, enc...)
+	encRequest = append(encRequest, ct...)
+	return encRequest, &ClientContext{suite: suite, enc: enc, ctx: ctx}, nil
+}
+
+// DecapsulateResponse decrypts the gateway's Encapsulated Response.
+func (c *ClientContext) DecapsulateResponse(encResponse []byte) ([]byte, error) {
+	return c.DecapsulateResponseWithLabel(ResponseLabel, encResponse)
+}
+
+// DecapsulateResponseWithLabel is like DecapsulateResponse, but for a response
+// with a media type other than Binary HTTP.
+func (c *ClientContext) DecapsulateResponseWithLabel(label string, encResponse []byte) ([]byte, error) {
+	nonceLen := responseNonceSize(c.suite)
+	if len(encResponse) < nonceLen {
+		return nil, fmt.Errorf("Truncated encapsulated response")
+	}
+
+	secret, err := c.ctx.ExportSecret([]byte(label), nonceLen)
+	if err != nil {
+		return nil, err
+	}
+
+	responseNonce, ct := encResponse[:nonceLen], encResponse[nonceLen:]
+	aead, nonce, err := responseAEAD(c.suite, secret, c.enc, responseNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	pt, err := aead.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return nil, hpke.ErrOpenFailed
+	}
+
+	return pt, nil
+}
+
+// GatewayContext holds the state a gateway needs to encapsulate the response
+// to a decapsulated request.
+type GatewayContext struct {
+	suite hpke.CipherSuite
+	enc   []byte
+	ctx   *hpke.ReceiverContext
+}
+
+// DecapsulateRequest decrypts an Encapsulated Request addressed to the key
+// configuration config, whose private key is skR.  It returns the request
+// and the context for encapsulating the response.
+func DecapsulateRequest(config KeyConfig, skR hpke.KEMPrivateKey, encRequest []byte) ([]byte, *GatewayContext, error) {
+	return DecapsulateRequestWithLabel(config, skR, RequestLabel, encRequest)
+}
+
+// DecapsulateRequestWithLabel is like DecapsulateRequest, but for a request
+// with a media type other than Binary HTTP.
+func DecapsulateRequestWithLabel(config KeyConfig, skR hpke.KEMPrivateKey, label string, encRequest []byte) ([]byte, *GatewayContext, error) {
+	if len(encRequest) < headerSize {
+		return nil, nil, fmt.Errorf("Truncated encapsulated request")
+	}
+
+	hdr := encRequest[:headerSize]
+	if hdr[0] != config.KeyID {
+		return nil, nil, fmt.Errorf("Unknown key ID [%d]", hdr[0])
+	}
+
+	kemID := hpke.KEMID(binary.BigEndian.Uint16(hdr[1:]))
+	kdfID := hpke.KDFID(binary.BigEndian.Uint16(hdr[3:]))
+	aeadID := hpke.AEADID(binary.BigEndian.Uint16(hdr[5:]))
+	if kemID != config.KEMID || !config.hasAlgorithm(kdfID, aeadID) || aeadID == hpke.AEAD_EXPORT_ONLY {
+		return nil, nil, fmt.Errorf("%w: Suite not offered in key config [%s, %s, %s]", hpke.ErrUnsupportedSuite, kemID, kdfID, aeadID)
+	}
+
+	suite, err := hpke.AssembleCipherSuite(kemID, kdfID, aeadID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	Nenc := hpke.EncapsulatedKeySize(suite.KEM)
+	if len(encRequest) < headerSize+Nenc {
+		return nil, nil, fmt.Errorf("Truncated encapsulated request")
+	}
+
+	enc := encRequest[headerSize : headerSize+Nenc]
+	ct := encRequest[headerSize+Nenc:]
+
+	ctx, err := hpke.SetupBaseR(suite, skR, enc, requestInfo(label, hdr))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request, err := ctx.Open(nil, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return request, &GatewayContext{suite: suite, enc: append([]byte{}, enc...), ctx: ctx}, nil
+}
+
+// EncapsulateResponse encrypts a Binary HTTP response to the client that sent
+// the request.
+func (g *GatewayContext) EncapsulateResponse(rand io.Reader, response []byte) ([]byte, error) {
+	return g.EncapsulateResponseWithLabel(rand, ResponseLabel, response)
+}
+
+// EncapsulateResponseWithLabel is like EncapsulateResponse, but for a
+// response with a media type other than Binary HTTP.  The label must be the
+// media type followed by " response".
+func (g *GatewayContext) EncapsulateResponseWithLabel(rand io.Reader, label string, response []byte) ([]byte, error) {
+	nonceLen := responseNonceSize(g.suite)
+	responseNonce := make([]byte, nonceLen)
+	if _, err := io.ReadFull(rand, responseNonce); err != nil {
+		return nil, err
+	}
+
+	secret, err := g.ctx.ExportSecret([]byte(label), nonceLen)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, nonce, err := responseAEAD(g.suite, secret, g.enc, responseNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(responseNonce, nonce, response, nil), nil
+}
+
+// responseNonceSize returns max(Nn, Nk), the length of both the response
+// nonce and the exported secret.
+func responseNonceSize(suite hpke.CipherSuite) int {
+	if suite.AEAD.NonceSize() > suite.AEAD.KeySize() {
+		return suite.AEAD.NonceSize()
+	}
+	return suite.AEAD.KeySize()
+}
+
+// responseAEAD derives the response key and nonce from the exported secret
+// (RFC 9458, Section 4.4).
+func responseAEAD(suite hpke.CipherSuite, secret, enc, responseNonce []byte) (cipher.AEAD, []byte, error) {
+	salt := append(append([]byte{}, enc...), responseNonce...)
+	prk := suite.KDF.Extract(salt, secret)
+	key := suite.KDF.Expand(prk, []byte("key"), suite.AEAD.KeySize())
+	nonce := suite.KDF.Expand(prk, []byte("nonce"), suite.AEAD.NonceSize())
+
+	aead, err := suite.AEAD.New(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, nonce, nil
+}
@@ -0,0 +1,186 @@
+package ohttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// Key configuration and private key from RFC 9458, Appendix A
+	rfcKeyConfig  = "01002031e1f05a740102115220e9af918f738674aec95f54db6e04eb705aae8e79815500080001000100010003"
+	rfcPrivateKey = "3c168975674b2fa8e465970b79c8dcf09f1c741626480bd4c6162fc5b6a98e1a"
+
+	request  = []byte("\x00\x03GET\x05https\x0bexample.com\x01/")
+	response = []byte("\x01\x40\xc8")
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	require.Nil(t, err, "Error decoding hex")
+	return data
+}
+
+func rfcKeys(t *testing.T) (KeyConfig, hpke.KEMPrivateKey) {
+	config, err := ParseKeyConfig(mustHex(t, rfcKeyConfig))
+	require.Nil(t, err, "Error in ParseKeyConfig")
+
+	suite, err := config.Suite()
+	require.Nil(t, err, "Error selecting suite")
+
+	skR, err := suite.KEM.DeserializePrivateKey(mustHex(t, rfcPrivateKey))
+	require.Nil(t, err, "Error deserializing private key")
+	return config, skR
+}
+
+func TestKeyConfig(t *testing.T) {
+	data := mustHex(t, rfcKeyConfig)
+	config, skR := rfcKeys(t)
+
+	require.Equal(t, uint8(1), config.KeyID, "Incorrect key ID")
+	require.Equal(t, hpke.DHKEM_X25519, config.KEMID, "Incorrect KEM ID")
+	require.Equal(t, []SymmetricAlgorithm{
+		{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128},
+		{hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305},
+	}, config.Algorithms, "Incorrect symmetric algorithms")
+
+	suite, err := config.Suite()
+	require.Nil(t, err, "Error selecting suite")
+	require.Equal(t, config.PublicKey, suite.KEM.SerializePublicKey(skR.PublicKey()), "Public key does not match private key")
+
+	encoded, err := config.Marshal()
+	require.Nil(t, err, "Error in Marshal")
+	require.Equal(t, data, encoded, "Key config did not round-trip")
+
+	_, err = ParseKeyConfig(data[:len(data)-1])
+	require.NotNil(t, err, "Truncated key config accepted")
+
+	_, err = ParseKeyConfig(append(data, 0x00))
+	require.NotNil(t, err, "Key config with trailing data accepted")
+}
+
+func TestKeyConfigs(t *testing.T) {
+	config, _ := rfcKeys(t)
+
+	list, err := MarshalKeyConfigs([]KeyConfig{config})
+	require.Nil(t, err, "Error in MarshalKeyConfigs")
+
+	// Prepend a config with an unsupported KEM, which must be skipped
+	unknown := []byte{0x00, 0x03, 0x02, 0xab, 0xcd}
+	configs, err := ParseKeyConfigs(append(unknown, list...))
+	require.Nil(t, err, "Error in ParseKeyConfigs")
+	require.Equal(t, []KeyConfig{config}, configs, "Incorrect key configs")
+
+	_, err = ParseKeyConfigs(unknown)
+	require.NotNil(t, err, "Key config list without supported configs accepted")
+
+	_, err = ParseKeyConfigs(list[:len(list)-1])
+	require.NotNil(t, err, "Truncated key config list accepted")
+}
+
+func TestSelectKeyConfig(t *testing.T) {
+	config, _ := rfcKeys(t)
+
+	// Configurations without a supported symmetric algorithm are skipped
+	exportOnly := config
+	exportOnly.KeyID = 2
+	exportOnly.Algorithms = []SymmetricAlgorithm{{hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY}}
+
+	selected, suite, err := SelectKeyConfig([]KeyConfig{exportOnly, config})
+	require.Nil(t, err, "Error in SelectKeyConfig")
+	require.Equal(t, config, selected, "Incorrect key config selected")
+	require.Equal(t, hpke.AEAD_AESGCM128, suite.AEAD.ID(), "Incorrect suite selected")
+
+	_, _, err = SelectKeyConfig([]KeyConfig{exportOnly})
+	require.NotNil(t, err, "Unusable key config selected")
+
+	// Gateways find the configuration a request was sent to
+	encRequest, _, err := EncapsulateRequest(config, rand.Reader, request)
+	require.Nil(t, err, "Error in EncapsulateRequest")
+
+	found, err := KeyConfigForRequest([]KeyConfig{exportOnly, config}, encRequest)
+	require.Nil(t, err, "Error in KeyConfigForRequest")
+	require.Equal(t, config, found, "Incorrect key config found")
+
+	_, err = KeyConfigForRequest([]KeyConfig{exportOnly}, encRequest)
+	require.NotNil(t, err, "Request matched to the wrong key config")
+
+	_, err = KeyConfigForRequest([]KeyConfig{config}, encRequest[:headerSize-1])
+	require.NotNil(t, err, "Truncated request accepted")
+}
+
+func TestRoundTrip(t *testing.T) {
+	config, skR := rfcKeys(t)
+
+	encRequest, client, err := EncapsulateRequest(config, rand.Reader, request)
+	require.Nil(t, err, "Error in EncapsulateRequest")
+	require.Equal(t, mustHex(t, "01002000010001"), encRequest[:headerSize], "Incorrect request header")
+
+	decRequest, gateway, err := DecapsulateRequest(config, skR, encRequest)
+	require.Nil(t, err, "Error in DecapsulateRequest")
+	require.Equal(t, request, decRequest, "Incorrect request")
+
+	encResponse, err := gateway.EncapsulateResponse(rand.Reader, response)
+	require.Nil(t, err, "Error in EncapsulateResponse")
+
+	decResponse, err := client.DecapsulateResponse(encResponse)
+	require.Nil(t, err, "Error in DecapsulateResponse")
+	require.Equal(t, response, decResponse, "Incorrect response")
+
+	// Tampering is detected in both directions
+	encRequest[len(encRequest)-1] ^= 0x01
+	_, _, err = DecapsulateRequest(config, skR, encRequest)
+	require.NotNil(t, err, "Modified request accepted")
+
+	encResponse[len(encResponse)-1] ^= 0x01
+	_, err = client.DecapsulateResponse(encResponse)
+	require.NotNil(t, err, "Modified response accepted")
+
+	// Requests for a different key ID or suite are rejected
+	other := config
+	other.KeyID = 2
+	encRequest, _, err = EncapsulateRequest(other, rand.Reader, request)
+	require.Nil(t, err, "Error in EncapsulateRequest")
+
+	_, _, err = DecapsulateRequest(config, skR, encRequest)
+	require.NotNil(t, err, "Request for a different key ID accepted")
+
+	other = config
+	other.Algorithms = []SymmetricAlgorithm{{hpke.KDF_HKDF_SHA512, hpke.AEAD_AESGCM256}}
+	encRequest, _, err = EncapsulateRequest(other, rand.Reader, request)
+	require.Nil(t, err, "Error in EncapsulateRequest")
+
+	_, _, err = DecapsulateRequest(config, skR, encRequest)
+	require.NotNil(t, err, "Request with an unoffered suite accepted")
+}
+
+func TestRoundTripSIKE(t *testing.T) {
+	// The encapsulated key in a request is not length-prefixed, and for SIKE
+	// it differs in size from the public key
+	suite, err := hpke.AssembleCipherSuite(hpke.KEM_SIKE503, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+	require.NotEqual(t, suite.KEM.PublicKeySize(), hpke.EncapsulatedKeySize(suite.KEM), "Nenc equals Npk")
+
+	skR, pkR, err := suite.KEM.DeriveKeyPair(mustHex(t, rfcPrivateKey))
+	require.Nil(t, err, "Error in DeriveKeyPair")
+
+	config, err := NewKeyConfig(1, suite.KEM, pkR, SymmetricAlgorithm{hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128})
+	require.Nil(t, err, "Error in NewKeyConfig")
+
+	encRequest, client, err := EncapsulateRequest(config, rand.Reader, request)
+	require.Nil(t, err, "Error in EncapsulateRequest")
+
+	decRequest, gateway, err := DecapsulateRequest(config, skR, encRequest)
+	require.Nil(t, err, "Error in DecapsulateRequest")
+	require.Equal(t, request, decRequest, "Incorrect request")
+
+	encResponse, err := gateway.EncapsulateResponse(rand.Reader, response)
+	require.Nil(t, err, "Error in EncapsulateResponse")
+
+	decResponse, err := client.DecapsulateResponse(encResponse)
+	require.Nil(t, err, "Error in DecapsulateResponse")
+	require.Equal(t, response, decResponse, "Incorrect response")
+}
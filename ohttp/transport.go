@@ -0,0 +1,112 @@
+package ohttp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// RequestContentType and ResponseContentType are the media types of
+	// Encapsulated Requests and Responses.
+	RequestContentType  = "message/ohttp-req"
+	ResponseContentType = "message/ohttp-res"
+)
+
+// Transport is an http.RoundTripper that sends each request through an
+// Oblivious HTTP relay.  The request, including its headers and body, is
+// encoded as a Binary HTTP message, encapsulated to the gateway's key
+// configuration, and POSTed to the relay; the gateway's response is
+// decapsulated and returned as if it came directly from the target.
+//
+// To send requests obliviously, set Transport as the transport of an
+// http.Client:
+//
+//	client := &http.Client{Transport: &ohttp.Transport{Config: config, RelayURL: relay}}
+//
+// Requests are buffered in memory, so Transport is not suitable for large
+// uploads or downloads.
+type Transport struct {
+	// Config is the gateway's key configuration.
+	Config KeyConfig
+
+	// RelayURL is the URL of the relay resource.
+	RelayURL string
+
+	// Base sends the encapsulated requests to the relay.  If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Rand is the source of randomness for encapsulation.  If nil,
+	// crypto/rand.Reader is used.
+	Rand io.Reader
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) rand() io.Reader {
+	if t.Rand != nil {
+		return t.Rand
+	}
+	return rand.Reader
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var content []byte
+	if req.Body != nil {
+		var err error
+		content, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encRequest, client, err := EncapsulateRequest(t.Config, t.rand(), encodeRequest(req, content))
+	if err != nil {
+		return nil, err
+	}
+
+	relayReq, err := http.NewRequest(http.MethodPost, t.RelayURL, bytes.NewReader(encRequest))
+	if err != nil {
+		return nil, err
+	}
+
+	relayReq = relayReq.WithContext(req.Context())
+	relayReq.Header.Set("Content-Type", RequestContentType)
+
+	relayResp, err := t.base().RoundTrip(relayReq)
+	if err != nil {
+		return nil, err
+	}
+	defer relayResp.Body.Close()
+
+	if relayResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Relay returned an error [%s]", relayResp.Status)
+	}
+
+	if contentType := relayResp.Header.Get("Content-Type"); contentType != ResponseContentType {
+		return nil, fmt.Errorf("Unexpected response content type [%s]", contentType)
+	}
+
+	encResponse, err := ioutil.ReadAll(relayResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.DecapsulateResponse(encResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeResponse(response, req)
+}
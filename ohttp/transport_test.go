@@ -0,0 +1,135 @@
+package ohttp
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryHTTP(t *testing.T) {
+	// The truncated messages from RFC 9458, Appendix A
+	req, err := decodeRequest(request)
+	require.Nil(t, err, "Error in decodeRequest")
+	require.Equal(t, "GET", req.Method, "Incorrect method")
+	require.Equal(t, "https://example.com/", req.URL.String(), "Incorrect URL")
+
+	resp, err := decodeResponse(response, req)
+	require.Nil(t, err, "Error in decodeResponse")
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Incorrect status")
+
+	// Informational responses and padding are skipped
+	data := []byte{framingKnownLengthResponse}
+	data = appendVarint(data, http.StatusContinue)
+	data = appendFields(data, http.Header{"Link": {"</style.css>"}})
+	data = append(data, encodeResponse(http.StatusNotFound, http.Header{"X-Test": {"1"}}, []byte("missing"))[1:]...)
+	data = append(data, 0, 0, 0)
+
+	resp, err = decodeResponse(data, req)
+	require.Nil(t, err, "Error in decodeResponse")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, "Incorrect status")
+	require.Equal(t, "1", resp.Header.Get("X-Test"), "Incorrect header")
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Equal(t, "missing", string(body), "Incorrect content")
+
+	_, err = decodeResponse(append(data, 1), req)
+	require.NotNil(t, err, "Trailing data accepted")
+
+	_, err = decodeResponse(data[:len(data)-10], req)
+	require.NotNil(t, err, "Truncated response accepted")
+
+	_, err = decodeRequest(data)
+	require.NotNil(t, err, "Response accepted as request")
+
+	// Large values use longer varints
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30} {
+		r := &bhttpReader{data: appendVarint(nil, v)}
+		decoded, err := r.varint()
+		require.Nil(t, err, "Error decoding varint")
+		require.Equal(t, v, decoded, "Incorrect varint")
+		require.True(t, r.done(), "Varint not fully read")
+	}
+}
+
+// newGateway returns a server that acts as both relay and gateway, serving
+// decapsulated requests with the handler.
+func newGateway(t *testing.T, handler http.Handler) *httptest.Server {
+	config, skR := rfcKeys(t)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != RequestContentType {
+			http.Error(w, "Not an encapsulated request", http.StatusBadRequest)
+			return
+		}
+
+		encRequest, _ := ioutil.ReadAll(r.Body)
+		msg, gateway, err := DecapsulateRequest(config, skR, encRequest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req, err := decodeRequest(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		encResponse, err := gateway.EncapsulateResponse(rand.Reader, encodeResponse(rec.Code, rec.Header(), rec.Body.Bytes()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ResponseContentType)
+		w.Write(encResponse)
+	}))
+}
+
+func TestTransport(t *testing.T) {
+	config, _ := rfcKeys(t)
+
+	target := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Token", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.URL.RequestURI() + " " + string(body)))
+	})
+
+	server := newGateway(t, target)
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Config: config, RelayURL: server.URL}}
+
+	req, err := http.NewRequest(http.MethodPut, "https://target.example/items/1?v=2", strings.NewReader("payload"))
+	require.Nil(t, err, "Error creating request")
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := client.Do(req)
+	require.Nil(t, err, "Error in round trip")
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err, "Error reading response")
+	require.Equal(t, http.StatusCreated, resp.StatusCode, "Incorrect status")
+	require.Equal(t, "/items/1?v=2 payload", string(body), "Incorrect response content")
+	require.Equal(t, http.MethodPut, resp.Header.Get("X-Method"), "Incorrect method")
+	require.Equal(t, "target.example", resp.Header.Get("X-Host"), "Incorrect authority")
+	require.Equal(t, "Bearer secret", resp.Header.Get("X-Token"), "Incorrect header")
+
+	// Errors from the relay are reported
+	other := config
+	other.KeyID = 7
+	client = &http.Client{Transport: &Transport{Config: other, RelayURL: server.URL}}
+	_, err = client.Get("https://target.example/")
+	require.NotNil(t, err, "Relay error not reported")
+}
@@ -0,0 +1,85 @@
+package hpke
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// OnionHop describes one relay in a multi-hop path.  Each hop may use its own
+// ciphersuite and info string.
+type OnionHop struct {
+	Suite     CipherSuite
+	PublicKey KEMPublicKey
+	Info      []byte
+}
+
+// onionLayer represents one layer of an onion encoded on the wire.
+type onionLayer struct {
+	Enc        []byte `tls:"head=2"`
+	Ciphertext []byte `tls:"head=4"`
+}
+
+// onionLayerInfo binds the position of a hop in the path into the info
+// string for its layer, so that layers cannot be reordered or spliced into
+// a different position.
+func onionLayerInfo(index int, info []byte) []byte {
+	out := make([]byte, 2+len(info))
+	binary.BigEndian.PutUint16(out, uint16(index))
+	copy(out[2:], info)
+	return out
+}
+
+// SealOnion encrypts the plaintext in nested layers for a chain of relays.
+// The plaintext is first encrypted to the last hop, that ciphertext to the
+// second-to-last hop, and so on, so that the first hop can remove the
+// outermost layer.  Each hop removes its layer with OpenOnionLayer and
+// forwards the result to the next hop.
+func SealOnion(rand io.Reader, hops []OnionHop, pt []byte) ([]byte, error) {
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("No hops")
+	}
+
+	if len(hops) > 0xFFFF {
+		return nil, fmt.Errorf("Too many hops [%d]", len(hops))
+	}
+
+	payload := pt
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		enc, ct, err := Seal(hop.Suite, rand, hop.PublicKey, onionLayerInfo(i, hop.Info), nil, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err = syntax.Marshal(onionLayer{Enc: enc, Ciphertext: ct})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+// OpenOnionLayer removes the layer addressed to the hop at the given index
+// in the path, returning the payload to forward to the next hop.  For the
+// last hop, the payload is the original plaintext.
+func OpenOnionLayer(suite CipherSuite, skR KEMPrivateKey, index int, info, opaque []byte) ([]byte, error) {
+	if index < 0 || index > 0xFFFF {
+		return nil, fmt.Errorf("Invalid hop index [%d]", index)
+	}
+
+	var layer onionLayer
+	read, err := syntax.Unmarshal(opaque, &layer)
+	if err != nil {
+		return nil, err
+	}
+
+	if read != len(opaque) {
+		return nil, fmt.Errorf("Trailing data after onion layer")
+	}
+
+	return Open(suite, skR, layer.Enc, onionLayerInfo(index, info), nil, layer.Ciphertext)
+}
@@ -0,0 +1,50 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+)
+
+func TestOnionRoundTrip(t *testing.T) {
+	suiteIDs := [][3]uint16{
+		{uint16(DHKEM_X25519), uint16(KDF_HKDF_SHA256), uint16(AEAD_CHACHA20POLY1305)},
+		{uint16(DHKEM_P256), uint16(KDF_HKDF_SHA256), uint16(AEAD_AESGCM128)},
+		{uint16(DHKEM_X448), uint16(KDF_HKDF_SHA512), uint16(AEAD_AESGCM256)},
+	}
+
+	hops := make([]OnionHop, len(suiteIDs))
+	privateKeys := make([]KEMPrivateKey, len(suiteIDs))
+	for i, ids := range suiteIDs {
+		suite := mustAssembleSuite(t, KEMID(ids[0]), KDFID(ids[1]), AEADID(ids[2]))
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		privateKeys[i] = skR
+		hops[i] = OnionHop{
+			Suite:     suite,
+			PublicKey: pkR,
+			Info:      []byte(fmt.Sprintf("relay-%d", i)),
+		}
+	}
+
+	onion, err := SealOnion(rand.Reader, hops, original)
+	fatalOnError(t, err, "Error in SealOnion")
+
+	// Layers must be removed in order, at the correct position
+	_, err = OpenOnionLayer(hops[1].Suite, privateKeys[1], 1, hops[1].Info, onion)
+	assert(t, hops[1].Suite, "Inner layer opened before outer layer", err != nil)
+
+	inner, err := OpenOnionLayer(hops[0].Suite, privateKeys[0], 0, hops[0].Info, onion)
+	assertNotError(t, hops[0].Suite, "Error in OpenOnionLayer", err)
+
+	_, err = OpenOnionLayer(hops[1].Suite, privateKeys[1], 2, hops[1].Info, inner)
+	assert(t, hops[1].Suite, "Layer opened at incorrect position", err != nil)
+
+	payload := onion
+	for i, hop := range hops {
+		payload, err = OpenOnionLayer(hop.Suite, privateKeys[i], i, hop.Info, payload)
+		assertNotError(t, hop.Suite, "Error in OpenOnionLayer", err)
+	}
+
+	assertBytesEqual(t, hops[0].Suite, "Incorrect decryption", payload, original)
+}
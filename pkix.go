@@ -0,0 +1,316 @@
+package hpke
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// Object identifiers for the DHKEM key types
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidX25519         = asn1.ObjectIdentifier{1, 3, 101, 110}
+	oidX448           = asn1.ObjectIdentifier{1, 3, 101, 111}
+)
+
+const (
+	pemTypePublicKey  = "PUBLIC KEY"
+	pemTypePrivateKey = "PRIVATE KEY"
+)
+
+// pkcs8 is the PKCS#8 PrivateKeyInfo structure (RFC 5208), with the optional
+// attributes omitted.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// subjectPublicKeyInfo is the SubjectPublicKeyInfo structure (RFC 5280).
+type subjectPublicKeyInfo struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// montgomeryOID returns the RFC 8410 algorithm identifier for the X25519 and
+// X448 KEMs.
+func montgomeryOID(kemID KEMID) (asn1.ObjectIdentifier, bool) {
+	switch kemID {
+	case DHKEM_X25519:
+		return oidX25519, true
+	case DHKEM_X448:
+		return oidX448, true
+	}
+	return nil, false
+}
+
+func montgomeryKEMID(oid asn1.ObjectIdentifier) (KEMID, bool) {
+	switch {
+	case oid.Equal(oidX25519):
+		return DHKEM_X25519, true
+	case oid.Equal(oidX448):
+		return DHKEM_X448, true
+	}
+	return 0, false
+}
+
+func ecdhKEMID(curve elliptic.Curve) (KEMID, error) {
+	switch curve {
+	case elliptic.P256():
+		return DHKEM_P256, nil
+	case elliptic.P521():
+		return DHKEM_P521, nil
+	}
+	return 0, fmt.Errorf("Unsupported curve [%s]", curve.Params().Name)
+}
+
+// ecdsaPublicKey converts a NIST curve public key to the form crypto/x509
+// uses.
+func ecdsaPublicKey(pub *ecdhPublicKey) *ecdsa.PublicKey {
+	x, y := elliptic.Unmarshal(pub.curve, pub.point)
+	return &ecdsa.PublicKey{Curve: pub.curve, X: x, Y: y}
+}
+
+// MarshalPKIXPublicKey encodes a DHKEM public key as a DER
+// SubjectPublicKeyInfo.  P-256 and P-521 keys use the id-ecPublicKey
+// algorithm with a named curve (RFC 5480); X25519 and X448 keys use the
+// algorithms from RFC 8410.
+func MarshalPKIXPublicKey(pk KEMPublicKey) ([]byte, error) {
+	switch raw := pk.(type) {
+	case *ecdhPublicKey:
+		return x509.MarshalPKIXPublicKey(ecdsaPublicKey(raw))
+
+	case *x25519PublicKey, *x448PublicKey:
+		kemID, _ := KeyKEMID(pk)
+		oid, _ := montgomeryOID(kemID)
+		key := kems[kemID].SerializePublicKey(pk)
+		return asn1.Marshal(subjectPublicKeyInfo{
+			Algo:      pkix.AlgorithmIdentifier{Algorithm: oid},
+			PublicKey: asn1.BitString{Bytes: key, BitLength: 8 * len(key)},
+		})
+	}
+
+	return nil, fmt.Errorf("Unsupported public key type [%T]", pk)
+}
+
+// ParsePKIXPublicKey decodes a DER SubjectPublicKeyInfo holding a P-256,
+// P-521, X25519, or X448 public key, returning the corresponding KEM along
+// with the key.
+func ParsePKIXPublicKey(der []byte) (KEMID, KEMPublicKey, error) {
+	var spki subjectPublicKeyInfo
+	rest, err := asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rest) != 0 {
+		return 0, nil, fmt.Errorf("Trailing data after public key")
+	}
+
+	if spki.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		parsed, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		ecPub, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return 0, nil, fmt.Errorf("Unexpected public key type [%T]", parsed)
+		}
+
+		kemID, err := ecdhKEMID(ecPub.Curve)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		kem, err := lookupKEM(kemID)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		pk, err := kem.DeserializePublicKey(elliptic.Marshal(ecPub.Curve, ecPub.X, ecPub.Y))
+		return kemID, pk, err
+	}
+
+	kemID, ok := montgomeryKEMID(spki.Algo.Algorithm)
+	if !ok {
+		return 0, nil, fmt.Errorf("Unsupported public key algorithm [%v]", spki.Algo.Algorithm)
+	}
+
+	if len(spki.Algo.Parameters.FullBytes) != 0 {
+		return 0, nil, fmt.Errorf("Unexpected algorithm parameters")
+	}
+
+	if spki.PublicKey.BitLength%8 != 0 {
+		return 0, nil, fmt.Errorf("Public key is not a whole number of bytes")
+	}
+
+	kem, err := lookupKEM(kemID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pk, err := kem.DeserializePublicKey(spki.PublicKey.Bytes)
+	return kemID, pk, err
+}
+
+// MarshalPKCS8PrivateKey encodes a DHKEM private key as a DER PKCS#8
+// PrivateKeyInfo, using the same algorithms as MarshalPKIXPublicKey.
+func MarshalPKCS8PrivateKey(sk KEMPrivateKey) ([]byte, error) {
+	switch raw := sk.(type) {
+	case *ecdhPrivateKey:
+		ecPriv := &ecdsa.PrivateKey{
+			PublicKey: *ecdsaPublicKey(raw.pub),
+			D:         new(big.Int).SetBytes(raw.d),
+		}
+		return x509.MarshalPKCS8PrivateKey(ecPriv)
+
+	case *x25519PrivateKey, *x448PrivateKey:
+		kemID, _ := KeyKEMID(sk)
+		oid, _ := montgomeryOID(kemID)
+
+		// CurvePrivateKey ::= OCTET STRING
+		key, err := asn1.Marshal(kems[kemID].SerializePrivateKey(sk))
+		if err != nil {
+			return nil, err
+		}
+
+		return asn1.Marshal(pkcs8{
+			Algo:       pkix.AlgorithmIdentifier{Algorithm: oid},
+			PrivateKey: key,
+		})
+	}
+
+	return nil, fmt.Errorf("Unsupported private key type [%T]", sk)
+}
+
+// ParsePKCS8PrivateKey decodes a DER PKCS#8 PrivateKeyInfo holding a P-256,
+// P-521, X25519, or X448 private key, returning the corresponding KEM along
+// with the key.
+func ParsePKCS8PrivateKey(der []byte) (KEMID, KEMPrivateKey, error) {
+	var info pkcs8
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rest) != 0 {
+		return 0, nil, fmt.Errorf("Trailing data after private key")
+	}
+
+	if info.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		parsed, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		ecPriv, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return 0, nil, fmt.Errorf("Unexpected private key type [%T]", parsed)
+		}
+
+		kemID, err := ecdhKEMID(ecPriv.Curve)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		kem, err := lookupKEM(kemID)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		d := ecPriv.D.Bytes()
+		if len(d) > kem.PrivateKeySize() {
+			return 0, nil, fmt.Errorf("Private key too large")
+		}
+
+		padded := make([]byte, kem.PrivateKeySize())
+		copy(padded[len(padded)-len(d):], d)
+		sk, err := kem.DeserializePrivateKey(padded)
+		return kemID, sk, err
+	}
+
+	kemID, ok := montgomeryKEMID(info.Algo.Algorithm)
+	if !ok {
+		return 0, nil, fmt.Errorf("Unsupported private key algorithm [%v]", info.Algo.Algorithm)
+	}
+
+	var key []byte
+	rest, err = asn1.Unmarshal(info.PrivateKey, &key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rest) != 0 {
+		return 0, nil, fmt.Errorf("Trailing data after private key")
+	}
+
+	kem, err := lookupKEM(kemID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sk, err := kem.DeserializePrivateKey(key)
+	return kemID, sk, err
+}
+
+// MarshalPublicKeyPEM encodes a DHKEM public key as a PEM "PUBLIC KEY" block.
+func MarshalPublicKeyPEM(pk KEMPublicKey) ([]byte, error) {
+	der, err := MarshalPKIXPublicKey(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: der}), nil
+}
+
+// MarshalPrivateKeyPEM encodes a DHKEM private key as a PEM "PRIVATE KEY"
+// block.
+func MarshalPrivateKeyPEM(sk KEMPrivateKey) ([]byte, error) {
+	der, err := MarshalPKCS8PrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+}
+
+func decodePEM(data []byte, pemType string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM block found")
+	}
+
+	if block.Type != pemType {
+		return nil, fmt.Errorf("Unexpected PEM block type [%s]", block.Type)
+	}
+
+	return block.Bytes, nil
+}
+
+// ParsePublicKeyPEM decodes the first PEM "PUBLIC KEY" block in data, as
+// with ParsePKIXPublicKey.
+func ParsePublicKeyPEM(data []byte) (KEMID, KEMPublicKey, error) {
+	der, err := decodePEM(data, pemTypePublicKey)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return ParsePKIXPublicKey(der)
+}
+
+// ParsePrivateKeyPEM decodes the first PEM "PRIVATE KEY" block in data, as
+// with ParsePKCS8PrivateKey.
+func ParsePrivateKeyPEM(data []byte) (KEMID, KEMPrivateKey, error) {
+	der, err := decodePEM(data, pemTypePrivateKey)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return ParsePKCS8PrivateKey(der)
+}
@@ -0,0 +1,152 @@
+package hpke
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Policy is a crypto policy: a set of allowed algorithms and a minimum
+// security level.  Once installed with SetPolicy, it is enforced by
+// AssembleCipherSuite, and hence also wherever a suite is reconstructed from
+// identifiers on the wire or in storage, e.g., by UnmarshalReceiverContext.
+// ParseMessage and ParseMessageCBOR also reject messages for forbidden
+// suites.
+type Policy struct {
+	// KEMs, KDFs, and AEADs list the allowed algorithms.  An empty list
+	// allows all algorithms of that kind.
+	KEMs  []KEMID
+	KDFs  []KDFID
+	AEADs []AEADID
+
+	// MinSecurityLevel is the minimum classical security level, in bits, of
+	// each algorithm in a suite.  The export-only AEAD has no level of its
+	// own and is not checked against it.
+	MinSecurityLevel int
+}
+
+// Approximate classical security levels of the algorithms, in bits.  SIKE is
+// rated at zero, since the key recovery attack on SIDH by Castryck and Decru
+// breaks it in practice, so any minimum security level excludes it.
+var (
+	kemSecurityLevels = map[KEMID]int{
+		DHKEM_P256:   128,
+		DHKEM_P521:   256,
+		DHKEM_X25519: 128,
+		DHKEM_X448:   224,
+		KEM_SIKE503:  0,
+		KEM_SIKE751:  0,
+	}
+
+	kdfSecurityLevels = map[KDFID]int{
+		KDF_HKDF_SHA256: 128,
+		KDF_HKDF_SHA384: 192,
+		KDF_HKDF_SHA512: 256,
+	}
+
+	aeadSecurityLevels = map[AEADID]int{
+		AEAD_AESGCM128:               128,
+		AEAD_AESGCM256:               256,
+		AEAD_CHACHA20POLY1305:        256,
+		AEAD_AESGCM128_COMMIT:        128,
+		AEAD_AESGCM256_COMMIT:        256,
+		AEAD_CHACHA20POLY1305_COMMIT: 256,
+	}
+)
+
+func containsKEM(ids []KEMID, id KEMID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKDF(ids []KDFID, id KDFID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAEAD(ids []AEADID, id AEADID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error wrapping ErrUnsupportedSuite if the policy forbids
+// the suite with the given algorithms.
+func (p *Policy) Check(kemID KEMID, kdfID KDFID, aeadID AEADID) error {
+	switch {
+	case len(p.KEMs) > 0 && !containsKEM(p.KEMs, kemID):
+		return fmt.Errorf("%w: KEM not allowed by policy [%s]", ErrUnsupportedSuite, kemID)
+	case len(p.KDFs) > 0 && !containsKDF(p.KDFs, kdfID):
+		return fmt.Errorf("%w: KDF not allowed by policy [%s]", ErrUnsupportedSuite, kdfID)
+	case len(p.AEADs) > 0 && !containsAEAD(p.AEADs, aeadID):
+		return fmt.Errorf("%w: AEAD not allowed by policy [%s]", ErrUnsupportedSuite, aeadID)
+	}
+
+	if p.MinSecurityLevel > 0 {
+		if kemSecurityLevels[kemID] < p.MinSecurityLevel {
+			return fmt.Errorf("%w: KEM below minimum security level [%s]", ErrUnsupportedSuite, kemID)
+		}
+		if kdfSecurityLevels[kdfID] < p.MinSecurityLevel {
+			return fmt.Errorf("%w: KDF below minimum security level [%s]", ErrUnsupportedSuite, kdfID)
+		}
+		if aeadID != AEAD_EXPORT_ONLY && aeadSecurityLevels[aeadID] < p.MinSecurityLevel {
+			return fmt.Errorf("%w: AEAD below minimum security level [%s]", ErrUnsupportedSuite, aeadID)
+		}
+	}
+
+	return nil
+}
+
+// allowsAEAD reports whether the policy allows the AEAD, regardless of the
+// rest of the suite.
+func (p *Policy) allowsAEAD(aeadID AEADID) bool {
+	if len(p.AEADs) > 0 && !containsAEAD(p.AEADs, aeadID) {
+		return false
+	}
+
+	return aeadID == AEAD_EXPORT_ONLY || aeadSecurityLevels[aeadID] >= p.MinSecurityLevel
+}
+
+// policy holds the installed *Policy, or a nil *Policy if there is none.
+var policy atomic.Value
+
+// SetPolicy installs a package-wide crypto policy, replacing any previous
+// one.  The policy is copied, so later changes to p have no effect.  A nil
+// policy removes the restriction.
+func SetPolicy(p *Policy) {
+	if p == nil {
+		policy.Store((*Policy)(nil))
+		return
+	}
+
+	copied := &Policy{
+		KEMs:             append([]KEMID(nil), p.KEMs...),
+		KDFs:             append([]KDFID(nil), p.KDFs...),
+		AEADs:            append([]AEADID(nil), p.AEADs...),
+		MinSecurityLevel: p.MinSecurityLevel,
+	}
+	policy.Store(copied)
+}
+
+// CurrentPolicy returns the installed policy, or nil if there is none.
+func CurrentPolicy() *Policy {
+	p, _ := policy.Load().(*Policy)
+	return p
+}
+
+func checkPolicy(kemID KEMID, kdfID KDFID, aeadID AEADID) error {
+	if p := CurrentPolicy(); p != nil {
+		return p.Check(kemID, kdfID, aeadID)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+//go:build !hpke_minimal
+// +build !hpke_minimal
+
+package hpke
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	defer SetPolicy(CurrentPolicy())
+
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	// Artifacts produced before the policy is installed
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	fatalOnError(t, err, "Error in SetupBaseS")
+	ct, err := ctxS.Seal(nil, original)
+	fatalOnError(t, err, "Error in Seal")
+	encoded, err := NewMessage(suite, ModeBase, nil, enc, ct).Marshal()
+	fatalOnError(t, err, "Error in Message.Marshal")
+	opaque, err := ctxS.Marshal()
+	fatalOnError(t, err, "Error in Marshal")
+
+	cases := []struct {
+		policy Policy
+		kem    KEMID
+		kdf    KDFID
+		aead   AEADID
+		ok     bool
+	}{
+		{Policy{AEADs: []AEADID{AEAD_AESGCM128, AEAD_AESGCM256}}, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128, true},
+		{Policy{AEADs: []AEADID{AEAD_AESGCM128, AEAD_AESGCM256}}, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_EXPORT_ONLY, false},
+		{Policy{KEMs: []KEMID{DHKEM_P256}}, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128, false},
+		{Policy{KDFs: []KDFID{KDF_HKDF_SHA512}}, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128, false},
+		{Policy{MinSecurityLevel: 192}, DHKEM_P521, KDF_HKDF_SHA512, AEAD_AESGCM256, true},
+		{Policy{MinSecurityLevel: 192}, DHKEM_P521, KDF_HKDF_SHA512, AEAD_EXPORT_ONLY, true},
+		{Policy{MinSecurityLevel: 192}, DHKEM_P256, KDF_HKDF_SHA512, AEAD_AESGCM256, false},
+		{Policy{MinSecurityLevel: 192}, DHKEM_P521, KDF_HKDF_SHA256, AEAD_AESGCM256, false},
+		{Policy{MinSecurityLevel: 192}, DHKEM_P521, KDF_HKDF_SHA512, AEAD_AESGCM128, false},
+		{Policy{MinSecurityLevel: 128}, KEM_SIKE751, KDF_HKDF_SHA512, AEAD_AESGCM256, false},
+		{Policy{MinSecurityLevel: 128}, KEM_SIKE503, KDF_HKDF_SHA512, AEAD_AESGCM256, false},
+	}
+
+	for i, c := range cases {
+		SetPolicy(&c.policy)
+		_, err := AssembleCipherSuite(c.kem, c.kdf, c.aead)
+		if c.ok && err != nil {
+			t.Fatalf("Case %d: Allowed suite refused: %v", i, err)
+		}
+		if !c.ok && !errors.Is(err, ErrUnsupportedSuite) {
+			t.Fatalf("Case %d: Forbidden suite assembled", i)
+		}
+	}
+
+	// The policy is copied when installed
+	p := &Policy{KEMs: []KEMID{DHKEM_P256}}
+	SetPolicy(p)
+	p.KEMs[0] = DHKEM_X25519
+	_, err = AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	assert(t, suite, "Policy not copied", err != nil)
+
+	// Forbidden suites are refused when decoding
+	_, err = ParseMessage(encoded)
+	assert(t, suite, "Message for forbidden suite parsed", errors.Is(err, ErrUnsupportedSuite))
+	_, err = UnmarshalSenderContext(opaque)
+	assert(t, suite, "Context for forbidden suite unmarshaled", errors.Is(err, ErrUnsupportedSuite))
+
+	SetPolicy(nil)
+	msg, err := ParseMessage(encoded)
+	assertNotError(t, suite, "Error in ParseMessage without policy", err)
+	pt, err := Open(suite, skR, msg.Enc, info, nil, msg.Ciphertext)
+	assertNotError(t, suite, "Error in Open", err)
+	assertBytesEqual(t, suite, "Incorrect plaintext", original, pt)
+}
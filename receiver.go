@@ -0,0 +1,78 @@
+package hpke
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type receiverKey struct {
+	keyID []byte
+	skR   KEMPrivateKey
+}
+
+// Receiver holds a set of private keys, each tagged with a key ID, and opens
+// envelopes addressed to any of them.  Holding the old and new keys at the
+// same time allows receiver keys to be rotated without rejecting envelopes
+// that are still in flight.
+//
+// A Receiver is not safe for concurrent modification.
+type Receiver struct {
+	keys []receiverKey
+}
+
+// AddKey registers a private key under the given key ID.
+func (r *Receiver) AddKey(keyID []byte, skR KEMPrivateKey) error {
+	if _, ok := r.Key(keyID); ok {
+		return fmt.Errorf("Duplicate key ID [%x]", keyID)
+	}
+
+	r.keys = append(r.keys, receiverKey{keyID: keyID, skR: skR})
+	return nil
+}
+
+// RemoveKey removes the private key registered under the given key ID, if
+// any.
+func (r *Receiver) RemoveKey(keyID []byte) {
+	for i, key := range r.keys {
+		if bytes.Equal(key.keyID, keyID) {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+// Key returns the private key registered under the given key ID.
+func (r *Receiver) Key(keyID []byte) (KEMPrivateKey, bool) {
+	for _, key := range r.keys {
+		if bytes.Equal(key.keyID, keyID) {
+			return key.skR, true
+		}
+	}
+	return nil, false
+}
+
+// OpenEnvelope opens an envelope produced by SealEnvelope using whichever of
+// the registered keys it is addressed to.  If the envelope has slots for
+// several registered keys, each is tried in turn until one decrypts.
+func (r *Receiver) OpenEnvelope(info, aad, opaque []byte) ([]byte, error) {
+	env, suite, err := parseEnvelope(opaque)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("No recipient slot for any registered key ID")
+	for _, slot := range env.Slots {
+		skR, ok := r.Key(slot.KeyID)
+		if !ok {
+			continue
+		}
+
+		var pt []byte
+		pt, err = openEnvelopeSlot(suite, env, slot, skR, info, aad)
+		if err == nil {
+			return pt, nil
+		}
+	}
+
+	return nil, err
+}
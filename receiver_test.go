@@ -0,0 +1,48 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestReceiverKeyRotation(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+
+	skOld, pkOld, _ := mustGenerateKeyPair(t, suite)
+	skNew, pkNew, _ := mustGenerateKeyPair(t, suite)
+	oldID, newID := []byte("key-1"), []byte("key-2")
+
+	receiver := &Receiver{}
+	assertNotError(t, suite, "Error in AddKey", receiver.AddKey(oldID, skOld))
+	assertNotError(t, suite, "Error in AddKey", receiver.AddKey(newID, skNew))
+	assert(t, suite, "AddKey accepted a duplicate key ID", receiver.AddKey(oldID, skNew) != nil)
+
+	oldEnv, err := SealEnvelope(suite, rand.Reader, []Recipient{{KeyID: oldID, PublicKey: pkOld}}, info, aad, original)
+	assertNotError(t, suite, "Error in SealEnvelope", err)
+
+	newEnv, err := SealEnvelope(suite, rand.Reader, []Recipient{{KeyID: newID, PublicKey: pkNew}}, info, aad, original)
+	assertNotError(t, suite, "Error in SealEnvelope", err)
+
+	for _, env := range [][]byte{oldEnv, newEnv} {
+		decrypted, err := receiver.OpenEnvelope(info, aad, env)
+		assertNotError(t, suite, "Error in Receiver.OpenEnvelope", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	receiver.RemoveKey(oldID)
+	_, err = receiver.OpenEnvelope(info, aad, oldEnv)
+	assert(t, suite, "Envelope opened with a removed key", err != nil)
+
+	// A slot whose key ID collides with a registered key but was wrapped to a
+	// different key must not prevent a later matching slot from opening.
+	mixedEnv, err := SealEnvelope(suite, rand.Reader, []Recipient{
+		{KeyID: []byte("other"), PublicKey: pkOld},
+		{KeyID: newID, PublicKey: pkNew},
+	}, info, aad, original)
+	assertNotError(t, suite, "Error in SealEnvelope", err)
+
+	receiver.AddKey([]byte("other"), skNew)
+	decrypted, err := receiver.OpenEnvelope(info, aad, mixedEnv)
+	assertNotError(t, suite, "Error in Receiver.OpenEnvelope", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+}
@@ -0,0 +1,237 @@
+// Package remotekem lets a receiver's private key live in a separate,
+// hardened decapsulation service.  The service holds the private keys and
+// performs KEM decapsulation on request; the client implements
+// hpke.AuthKEMDecapsulator, so it can be passed to hpke.SetupBaseR and the
+// other receiver setup functions in place of a private key.
+//
+// The protocol runs over HTTP with JSON bodies, in which byte strings are
+// base64-encoded:
+//
+//	GET  /keys/{key_id}        -> {"kem_id": 32, "public_key": "..."}
+//	POST /keys/{key_id}/decap  {"enc": "...", "pk_s": "..."} -> {"shared_secret": "..."}
+//
+// pk_s is present only for decapsulation in the authenticated modes.  Errors
+// are reported with an HTTP error status and a plain-text message.
+//
+// The service returns the KEM shared secret for each encapsulated key, which
+// is as sensitive as the messages encrypted under it, so the connection must
+// be confidential and the service must authenticate its clients, e.g., with
+// mutual TLS.  The private keys themselves never leave the service.
+package remotekem
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// maxRequestSize bounds the size of decapsulation requests that the service
+// reads.
+const maxRequestSize = 64 * 1024
+
+// PublicKeyResponse is the response to a public key request.
+type PublicKeyResponse struct {
+	KEMID     hpke.KEMID `json:"kem_id"`
+	PublicKey []byte     `json:"public_key"`
+}
+
+// DecapRequest is a decapsulation request.  SenderPublicKey is set only for
+// the authenticated modes.
+type DecapRequest struct {
+	Enc             []byte `json:"enc"`
+	SenderPublicKey []byte `json:"pk_s,omitempty"`
+}
+
+// DecapResponse is the response to a decapsulation request.
+type DecapResponse struct {
+	SharedSecret []byte `json:"shared_secret"`
+}
+
+// Decapsulator is a client for a key held by a decapsulation service.  It
+// implements hpke.AuthKEMDecapsulator.
+type Decapsulator struct {
+	client *http.Client
+	keyURL string
+	kem    hpke.KEMScheme
+	pkR    hpke.KEMPublicKey
+}
+
+// NewDecapsulator connects to the key with the given ID at the decapsulation
+// service at baseURL, fetching its public key.  If client is nil,
+// http.DefaultClient is used.
+func NewDecapsulator(client *http.Client, baseURL, keyID string) (*Decapsulator, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	d := &Decapsulator{
+		client: client,
+		keyURL: strings.TrimSuffix(baseURL, "/") + "/keys/" + url.PathEscape(keyID),
+	}
+
+	resp, err := client.Get(d.keyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub PublicKeyResponse
+	if err := readResponse(resp, &pub); err != nil {
+		return nil, err
+	}
+
+	// The KEM ID alone determines the KEM, so any KDF and AEAD will do.
+	suite, err := hpke.AssembleCipherSuite(pub.KEMID, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	d.kem = suite.KEM
+	d.pkR, err = d.kem.DeserializePublicKey(pub.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func readResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Decapsulation service returned an error [%s]: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// KEMID returns the identifier of the key's KEM.
+func (d *Decapsulator) KEMID() hpke.KEMID {
+	return d.kem.ID()
+}
+
+// PublicKey returns the key's public key.
+func (d *Decapsulator) PublicKey() hpke.KEMPublicKey {
+	return d.pkR
+}
+
+// Decap asks the service to decapsulate enc.
+func (d *Decapsulator) Decap(enc []byte) ([]byte, error) {
+	return d.decap(DecapRequest{Enc: enc})
+}
+
+// AuthDecap asks the service to decapsulate enc from the sender pkS.
+func (d *Decapsulator) AuthDecap(enc []byte, pkS hpke.KEMPublicKey) ([]byte, error) {
+	return d.decap(DecapRequest{Enc: enc, SenderPublicKey: d.kem.SerializePublicKey(pkS)})
+}
+
+func (d *Decapsulator) decap(req DecapRequest) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.Post(d.keyURL+"/decap", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var decap DecapResponse
+	if err := readResponse(resp, &decap); err != nil {
+		return nil, err
+	}
+
+	return decap.SharedSecret, nil
+}
+
+// Key is a private key held by the decapsulation service.
+type Key struct {
+	KEM        hpke.KEMScheme
+	PrivateKey hpke.KEMPrivateKey
+}
+
+type handler struct {
+	keys map[string]Key
+}
+
+// NewHandler returns an http.Handler that serves the decapsulation protocol
+// for the given keys, indexed by key ID.  The handler does not authenticate
+// clients; it must be deployed behind something that does.
+func NewHandler(keys map[string]Key) http.Handler {
+	return handler{keys: keys}
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/keys/")
+	if path == r.URL.EscapedPath() {
+		http.NotFound(w, r)
+		return
+	}
+
+	decap := strings.HasSuffix(path, "/decap")
+	keyID, err := url.PathUnescape(strings.TrimSuffix(path, "/decap"))
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return
+	}
+
+	key, ok := h.keys[keyID]
+	if !ok {
+		http.Error(w, "Unknown key ID", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case !decap && r.Method == http.MethodGet:
+		writeJSON(w, PublicKeyResponse{
+			KEMID:     key.KEM.ID(),
+			PublicKey: key.KEM.SerializePublicKey(key.PrivateKey.PublicKey()),
+		})
+
+	case decap && r.Method == http.MethodPost:
+		h.serveDecap(w, r, key)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h handler) serveDecap(w http.ResponseWriter, r *http.Request, key Key) {
+	var req DecapRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "Malformed request", http.StatusBadRequest)
+		return
+	}
+
+	d := hpke.NewKEMDecapsulator(key.KEM, key.PrivateKey)
+
+	var sharedSecret []byte
+	var err error
+	if req.SenderPublicKey != nil {
+		var pkS hpke.KEMPublicKey
+		pkS, err = key.KEM.DeserializePublicKey(req.SenderPublicKey)
+		if err == nil {
+			sharedSecret, err = d.AuthDecap(req.Enc, pkS)
+		}
+	} else {
+		sharedSecret, err = d.Decap(req.Enc)
+	}
+
+	if err != nil {
+		http.Error(w, "Decapsulation failed", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, DecapResponse{SharedSecret: sharedSecret})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
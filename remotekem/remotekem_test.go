@@ -0,0 +1,77 @@
+package remotekem
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKeyPair(t *testing.T, suite hpke.CipherSuite) (hpke.KEMPrivateKey, hpke.KEMPublicKey) {
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	sk, pk, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+	return sk, pk
+}
+
+func TestRemoteDecapsulation(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_CHACHA20POLY1305)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	skR, pkR := generateKeyPair(t, suite)
+	server := httptest.NewServer(NewHandler(map[string]Key{
+		"receiver/1": {KEM: suite.KEM, PrivateKey: skR},
+	}))
+	defer server.Close()
+
+	d, err := NewDecapsulator(server.Client(), server.URL+"/", "receiver/1")
+	require.Nil(t, err, "Error in NewDecapsulator")
+	require.Equal(t, hpke.DHKEM_X25519, d.KEMID(), "Incorrect KEM")
+	require.Equal(t, suite.KEM.SerializePublicKey(pkR), suite.KEM.SerializePublicKey(d.PublicKey()), "Incorrect public key")
+
+	info, aad, pt := []byte("info"), []byte("aad"), []byte("plaintext")
+
+	// Base mode
+	enc, ct, err := hpke.Seal(suite, rand.Reader, pkR, info, aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err := hpke.SetupBaseR(suite, d, enc, info)
+	require.Nil(t, err, "Error in SetupBaseR")
+	opened, err := ctxR.Open(aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// Auth mode
+	skS, pkS := generateKeyPair(t, suite)
+	enc, ctxS, err := hpke.SetupAuthS(suite, rand.Reader, pkR, skS, info)
+	require.Nil(t, err, "Error in SetupAuthS")
+	ct, err = ctxS.Seal(aad, pt)
+	require.Nil(t, err, "Error in Seal")
+
+	ctxR, err = hpke.SetupAuthR(suite, d, pkS, enc, info)
+	require.Nil(t, err, "Error in SetupAuthR")
+	opened, err = ctxR.Open(aad, ct)
+	require.Nil(t, err, "Error in Open")
+	require.Equal(t, pt, opened, "Incorrect plaintext")
+
+	// Errors from the service are reported
+	_, err = d.Decap([]byte{0x01})
+	require.NotNil(t, err, "Malformed encapsulated key accepted")
+
+	_, err = NewDecapsulator(server.Client(), server.URL, "unknown")
+	require.NotNil(t, err, "Unknown key ID accepted")
+
+	resp, err := server.Client().Post(server.URL+"/keys/receiver%2F1", "application/json", nil)
+	require.Nil(t, err, "Error sending request")
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "Incorrect status for POST to key")
+
+	resp, err = server.Client().Post(server.URL+"/keys/receiver%2F1/decap", "application/json", nil)
+	require.Nil(t, err, "Error sending request")
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "Incorrect status for empty request")
+}
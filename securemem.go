@@ -0,0 +1,115 @@
+package hpke
+
+import (
+	"runtime"
+)
+
+// secureBuffer is a region of memory for a context's secrets that is locked
+// into RAM, so that it is never swapped, excluded from core dumps where the
+// platform allows, and surrounded by inaccessible guard pages.  The region is
+// allocated outside the Go heap, so the garbage collector never copies it.
+type secureBuffer struct {
+	mapping []byte
+	data    []byte
+}
+
+func newSecureBuffer(size int) (*secureBuffer, error) {
+	mapping, data, err := secureAlloc(size)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &secureBuffer{mapping: mapping, data: data}
+	runtime.SetFinalizer(buf, (*secureBuffer).free)
+	return buf, nil
+}
+
+// take returns the next len(src) bytes of the buffer holding a copy of src,
+// and wipes src.
+func (b *secureBuffer) take(src []byte) []byte {
+	if len(src) == 0 {
+		return nil
+	}
+
+	n := copy(b.data, src)
+	out := b.data[:n:n]
+	b.data = b.data[n:]
+	wipe(src)
+	return out
+}
+
+// free wipes and releases the buffer.  It is safe to call more than once.
+func (b *secureBuffer) free() {
+	if b == nil || b.mapping == nil {
+		return
+	}
+
+	wipe(b.mapping[secureGuardSize() : len(b.mapping)-secureGuardSize()])
+	secureFree(b.mapping)
+	b.mapping = nil
+	b.data = nil
+	runtime.SetFinalizer(b, nil)
+}
+
+// EnableSecureMemory moves the context's key, base nonce, and exporter secret
+// into memory that is locked into RAM, excluded from core dumps where the
+// platform supports it (currently Linux), and bounded by guard pages.  Secrets
+// installed by KeyUpdate are kept there too, and Zeroize wipes and releases
+// the memory.  Secure memory is supported on Linux and macOS; elsewhere, or
+// if the process's locked-memory limit is exhausted, EnableSecureMemory
+// fails.
+//
+// Secure memory narrows, but does not close, the exposure of secrets to swap
+// and core dumps: the AEAD's expanded key, values returned by Export, key
+// schedule intermediates retained for inspection, and the short-lived results
+// of key derivation all remain on the Go heap.  Response contexts, shards, and
+// contexts restored by Unmarshal do not inherit the setting.
+//
+// Since the memory is released by Zeroize, or when the context is garbage
+// collected, the slices in the context's exported fields must not be retained
+// beyond the life of the context.
+func (ctx *context) EnableSecureMemory() error {
+	defer ctx.lock()()
+
+	if ctx.closed {
+		return ErrContextClosed
+	}
+
+	if ctx.secure != nil {
+		return nil
+	}
+
+	size := len(ctx.ExporterSecret) + len(ctx.Key) + 2*len(ctx.BaseNonce)
+	buf, err := newSecureBuffer(size)
+	if err != nil {
+		return err
+	}
+
+	ctx.secure = buf
+	ctx.ExporterSecret = buf.take(ctx.ExporterSecret)
+	ctx.Key = buf.take(ctx.Key)
+	ctx.BaseNonce = buf.take(ctx.BaseNonce)
+	ctx.nonce = buf.take(make([]byte, len(ctx.BaseNonce)))
+	return nil
+}
+
+// SecureMemory reports whether the context's secrets are held in secure
+// memory.
+func (ctx *context) SecureMemory() bool {
+	defer ctx.lock()()
+
+	return ctx.secure != nil
+}
+
+// replaceSecret installs fresh as the new value of the secret held in old,
+// copying it into secure memory if the context uses it.  Secrets keep their
+// length across key updates, so the new value always fits in place.
+func (ctx *context) replaceSecret(old, fresh []byte) []byte {
+	if ctx.secure == nil || len(old) != len(fresh) {
+		return fresh
+	}
+
+	copy(old, fresh)
+	wipe(fresh)
+	return old
+}
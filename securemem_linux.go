@@ -0,0 +1,12 @@
+package hpke
+
+import (
+	"syscall"
+)
+
+// madvDontDump is MADV_DONTDUMP, which the syscall package does not define.
+const madvDontDump = 0x10
+
+func excludeFromCoreDump(b []byte) error {
+	return syscall.Madvise(b, madvDontDump)
+}
@@ -0,0 +1,19 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package hpke
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func secureGuardSize() int {
+	return 0
+}
+
+func secureAlloc(size int) (mapping, data []byte, err error) {
+	return nil, nil, fmt.Errorf("Secure memory not supported on %s", runtime.GOOS)
+}
+
+func secureFree(mapping []byte) {}
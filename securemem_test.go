@@ -0,0 +1,62 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package hpke
+
+import (
+	"crypto/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSecureMemory(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ctxS, err := NewSender(suite, pkR, WithInfo(info), WithSecureMemory())
+	if err != nil {
+		t.Skipf("Secure memory unavailable: %v", err)
+	}
+	assert(t, suite, "Secure memory not enabled", ctxS.SecureMemory())
+
+	ctxR, err := NewReceiver(suite, skR, enc, WithInfo(info))
+	assertNotError(t, suite, "Error in NewReceiver", err)
+	assert(t, suite, "Secure memory enabled without being requested", !ctxR.SecureMemory())
+
+	// Secrets live in the locked region, and survive key updates there
+	inRegion := func(b []byte) bool {
+		start := reflect.ValueOf(ctxS.secure.mapping).Pointer()
+		end := start + uintptr(len(ctxS.secure.mapping))
+		p := reflect.ValueOf(b).Pointer()
+		return p >= start && p+uintptr(len(b)) <= end
+	}
+	for i := 0; i < 2; i++ {
+		assert(t, suite, "Exporter secret not in secure memory", inRegion(ctxS.ExporterSecret))
+		assert(t, suite, "Key not in secure memory", inRegion(ctxS.Key))
+		assert(t, suite, "Base nonce not in secure memory", inRegion(ctxS.BaseNonce))
+
+		ct, err := ctxS.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+		pt, err := ctxR.Open(aad, ct)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", original, pt)
+		assertBytesEqual(t, suite, "Export mismatch", ctxS.Export(exportContext, exportLength), ctxR.Export(exportContext, exportLength))
+
+		fatalOnError(t, ctxS.KeyUpdate(), "Error in KeyUpdate")
+		fatalOnError(t, ctxR.KeyUpdate(), "Error in KeyUpdate")
+	}
+
+	// Reset releases the old region without carrying its nonce buffer over
+	_, err = ctxS.Reset(suite, pkR, WithInfo(info))
+	assertNotError(t, suite, "Error in Reset", err)
+	assert(t, suite, "Secure memory kept after Reset", !ctxS.SecureMemory())
+	_, err = ctxS.Seal(aad, original)
+	assertNotError(t, suite, "Error in Seal after Reset", err)
+
+	_, ctxS, err = SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+	fatalOnError(t, ctxS.EnableSecureMemory(), "Error in EnableSecureMemory")
+	secure := ctxS.secure
+	ctxS.Zeroize()
+	assert(t, suite, "Secure memory not released by Zeroize", secure.mapping == nil && !ctxS.SecureMemory())
+}
@@ -0,0 +1,58 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package hpke
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func secureGuardSize() int {
+	return os.Getpagesize()
+}
+
+// secureAlloc maps size bytes of locked memory between two guard pages.  The
+// usable region ends at the upper guard page, so that an overrun faults
+// immediately.
+func secureAlloc(size int) (mapping, data []byte, err error) {
+	page := os.Getpagesize()
+	length := (size + page - 1) / page * page
+	if length == 0 {
+		length = page
+	}
+
+	mapping, err = syscall.Mmap(-1, 0, length+2*page, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error mapping secure memory: %w", err)
+	}
+
+	region := mapping[page : page+length]
+	if err = syscall.Mprotect(mapping[:page], syscall.PROT_NONE); err == nil {
+		err = syscall.Mprotect(mapping[page+length:], syscall.PROT_NONE)
+	}
+	if err != nil {
+		syscall.Munmap(mapping)
+		return nil, nil, fmt.Errorf("Error protecting secure memory guard pages: %w", err)
+	}
+
+	if err = syscall.Mlock(region); err != nil {
+		syscall.Munmap(mapping)
+		return nil, nil, fmt.Errorf("Error locking secure memory: %w", err)
+	}
+
+	if err = excludeFromCoreDump(region); err != nil {
+		syscall.Munlock(region)
+		syscall.Munmap(mapping)
+		return nil, nil, fmt.Errorf("Error excluding secure memory from core dumps: %w", err)
+	}
+
+	return mapping, region[length-size:], nil
+}
+
+func secureFree(mapping []byte) {
+	page := os.Getpagesize()
+	syscall.Munlock(mapping[page : len(mapping)-page])
+	syscall.Munmap(mapping)
+}
@@ -0,0 +1,113 @@
+// Package sframe derives SFrame (RFC 9605) keys from an HPKE context, for
+// applications that establish media encryption keys with HPKE.  Both ends of
+// an HPKE context export the same SFrame base key, from which the key and
+// salt for each SFrame key ID are derived as in RFC 9605, Section 4.4.2.
+package sframe
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// CipherSuite is an SFrame cipher suite identifier.
+type CipherSuite uint16
+
+// SFrame cipher suites from RFC 9605, Section 4.5
+const (
+	AES_128_CTR_HMAC_SHA256_80 CipherSuite = 0x0001
+	AES_128_CTR_HMAC_SHA256_64 CipherSuite = 0x0002
+	AES_128_CTR_HMAC_SHA256_32 CipherSuite = 0x0003
+	AES_128_GCM_SHA256_128     CipherSuite = 0x0004
+	AES_256_GCM_SHA512_128     CipherSuite = 0x0005
+)
+
+// exportLabel is the HPKE exporter context for the base key.  It matches the
+// label that RFC 9605, Section 5.2 uses with the MLS exporter.
+const exportLabel = "SFrame 1.0"
+
+const (
+	keyLabel  = "SFrame 1.0 Secret key "
+	saltLabel = "SFrame 1.0 Secret salt "
+)
+
+// params returns the hash function, key size (Nk), and nonce size (Nn) of the
+// cipher suite.  For the AES-CTR suites, the key includes the HMAC key.
+func (cs CipherSuite) params() (func() hash.Hash, int, int, error) {
+	switch cs {
+	case AES_128_CTR_HMAC_SHA256_80, AES_128_CTR_HMAC_SHA256_64, AES_128_CTR_HMAC_SHA256_32:
+		return sha256.New, 48, 12, nil
+	case AES_128_GCM_SHA256_128:
+		return sha256.New, 16, 12, nil
+	case AES_256_GCM_SHA512_128:
+		return sha512.New, 32, 12, nil
+	}
+
+	return nil, 0, 0, fmt.Errorf("Unknown SFrame cipher suite [%04x]", uint16(cs))
+}
+
+// KeySize returns the size of the cipher suite's keys, Nk.
+func (cs CipherSuite) KeySize() int {
+	_, Nk, _, _ := cs.params()
+	return Nk
+}
+
+// Exporter is implemented by hpke.SenderContext and hpke.ReceiverContext.
+type Exporter interface {
+	ExportSecret(context []byte, L int) ([]byte, error)
+}
+
+// BaseKey exports the SFrame base key for the cipher suite from an HPKE
+// context.
+func BaseKey(ctx Exporter, cs CipherSuite) ([]byte, error) {
+	_, Nk, _, err := cs.params()
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.ExportSecret([]byte(exportLabel), Nk)
+}
+
+// DeriveKeySalt derives the SFrame key and salt for a key ID from a base key.
+func DeriveKeySalt(cs CipherSuite, kid uint64, baseKey []byte) ([]byte, []byte, error) {
+	h, Nk, Nn, err := cs.params()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret := hkdf.Extract(h, baseKey, nil)
+
+	// The labels end with the key ID and cipher suite, as 8-byte and 2-byte
+	// big-endian integers.
+	suffix := make([]byte, 10)
+	binary.BigEndian.PutUint64(suffix, kid)
+	binary.BigEndian.PutUint16(suffix[8:], uint16(cs))
+
+	key := make([]byte, Nk)
+	if _, err := io.ReadFull(hkdf.Expand(h, secret, append([]byte(keyLabel), suffix...)), key); err != nil {
+		return nil, nil, err
+	}
+
+	salt := make([]byte, Nn)
+	if _, err := io.ReadFull(hkdf.Expand(h, secret, append([]byte(saltLabel), suffix...)), salt); err != nil {
+		return nil, nil, err
+	}
+
+	return key, salt, nil
+}
+
+// DeriveKeySaltFromContext exports the base key from an HPKE context and
+// derives the SFrame key and salt for a key ID from it.
+func DeriveKeySaltFromContext(ctx Exporter, cs CipherSuite, kid uint64) ([]byte, []byte, error) {
+	baseKey, err := BaseKey(ctx, cs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return DeriveKeySalt(cs, kid, baseKey)
+}
@@ -0,0 +1,77 @@
+package sframe
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+// hkdfSHA256 computes HKDF-Extract and a single block of HKDF-Expand
+// directly with HMAC, as an independent check of the labels.
+func hkdfSHA256(ikm, info []byte, L int) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:L]
+}
+
+func TestDeriveKeySalt(t *testing.T) {
+	baseKey := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+	suffix := []byte{0, 0, 0, 0, 0, 0, 0x01, 0x23, 0x00, 0x04}
+
+	key, salt, err := DeriveKeySalt(AES_128_GCM_SHA256_128, 0x123, baseKey)
+	require.Nil(t, err, "Error in DeriveKeySalt")
+	require.Equal(t, hkdfSHA256(baseKey, append([]byte("SFrame 1.0 Secret key "), suffix...), 16), key, "Incorrect key")
+	require.Equal(t, hkdfSHA256(baseKey, append([]byte("SFrame 1.0 Secret salt "), suffix...), 12), salt, "Incorrect salt")
+
+	for _, cs := range []CipherSuite{AES_128_CTR_HMAC_SHA256_80, AES_128_CTR_HMAC_SHA256_64, AES_128_CTR_HMAC_SHA256_32, AES_256_GCM_SHA512_128} {
+		key, salt, err := DeriveKeySalt(cs, 0x123, baseKey)
+		require.Nil(t, err, "Error in DeriveKeySalt")
+		require.Equal(t, cs.KeySize(), len(key), "Incorrect key size")
+		require.Equal(t, 12, len(salt), "Incorrect salt size")
+	}
+
+	// Keys differ per key ID and cipher suite
+	other, _, err := DeriveKeySalt(AES_128_GCM_SHA256_128, 0x124, baseKey)
+	require.Nil(t, err, "Error in DeriveKeySalt")
+	require.NotEqual(t, key, other, "Key ID not bound to key")
+
+	_, _, err = DeriveKeySalt(CipherSuite(0x0006), 0, baseKey)
+	require.NotNil(t, err, "Unknown cipher suite accepted")
+}
+
+func TestDeriveKeySaltFromContext(t *testing.T) {
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X25519, hpke.KDF_HKDF_SHA256, hpke.AEAD_EXPORT_ONLY)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	enc, ctxS, err := hpke.SetupBaseS(suite, rand.Reader, pkR, []byte("conference"))
+	require.Nil(t, err, "Error in SetupBaseS")
+	ctxR, err := hpke.SetupBaseR(suite, skR, enc, []byte("conference"))
+	require.Nil(t, err, "Error in SetupBaseR")
+
+	keyS, saltS, err := DeriveKeySaltFromContext(ctxS, AES_128_CTR_HMAC_SHA256_80, 7)
+	require.Nil(t, err, "Error deriving sender keys")
+	keyR, saltR, err := DeriveKeySaltFromContext(ctxR, AES_128_CTR_HMAC_SHA256_80, 7)
+	require.Nil(t, err, "Error deriving receiver keys")
+
+	require.Equal(t, keyS, keyR, "Keys differ")
+	require.Equal(t, saltS, saltR, "Salts differ")
+	require.Equal(t, 48, len(keyS), "Incorrect key size")
+
+	baseKey, err := BaseKey(ctxS, AES_128_CTR_HMAC_SHA256_80)
+	require.Nil(t, err, "Error in BaseKey")
+	require.Equal(t, ctxS.Export([]byte("SFrame 1.0"), 48), baseKey, "Incorrect base key")
+}
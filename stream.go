@@ -0,0 +1,264 @@
+package hpke
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultStreamChunkSize is the plaintext chunk size used by the streaming
+// mode when no chunk size is specified.
+const DefaultStreamChunkSize = 64 * 1024
+
+// StreamChunkSize recommends a chunk size for streams encrypted with the
+// given AEAD.  AES-GCM is fastest with 16 KiB chunks, which stay in the CPU's
+// caches between buffering the plaintext and sealing it; with AES-NI, both
+// smaller and larger chunks measurably lose throughput, as
+// BenchmarkStreamWriter shows.  ChaCha20Poly1305 is slower per byte, and
+// gains from chunks up to 64 KiB, beyond which it levels off.
+//
+// The chunk size is part of the stream format, so the reader must use the
+// same size as the writer.  StreamChunkSize therefore depends only on the
+// AEAD, never on the host, so that both sides can call it independently.
+// Passing zero to NewStreamWriter and NewStreamReader still selects
+// DefaultStreamChunkSize, so existing streams remain readable.
+func StreamChunkSize(aead AEADID) int {
+	switch aead {
+	case AEAD_AESGCM128, AEAD_AESGCM256, AEAD_AESGCM128_COMMIT, AEAD_AESGCM256_COMMIT:
+		return 16 * 1024
+	default:
+		return DefaultStreamChunkSize
+	}
+}
+
+const (
+	streamChunkMiddle = 0x00
+	streamChunkFinal  = 0x01
+)
+
+// streamAAD binds the chunk counter and the last-block flag into the
+// associated data for each chunk, following the STREAM construction.  The
+// result is appended to dst.
+func streamAAD(dst, aad []byte, seq uint64, final bool) []byte {
+	var suffix [9]byte
+	binary.BigEndian.PutUint64(suffix[:8], seq)
+	suffix[8] = streamChunkMiddle
+	if final {
+		suffix[8] = streamChunkFinal
+	}
+	return append(append(dst, aad...), suffix[:]...)
+}
+
+func streamChunkSize(chunkSize int) int {
+	if chunkSize <= 0 {
+		return DefaultStreamChunkSize
+	}
+	return chunkSize
+}
+
+// StreamWriter encrypts a large plaintext as a sequence of fixed-size chunks,
+// each sealed under the context.  The final chunk is marked as such, so that
+// truncation, extension, and reordering of chunks are detected by the
+// StreamReader.  Close must be called to emit the final chunk.
+//
+// Plaintext is buffered in a single buffer with room for a chunk and the AEAD
+// overhead, and each chunk is sealed in place, so the writer allocates
+// nothing per chunk.  The writer implements io.ReaderFrom, so io.Copy reads
+// plaintext directly into that buffer.
+type StreamWriter struct {
+	ctx       *SenderContext
+	w         io.Writer
+	aad       []byte
+	chunkSize int
+	buf       []byte
+	aadBuf    []byte
+	closed    bool
+}
+
+func NewStreamWriter(ctx *SenderContext, w io.Writer, aad []byte, chunkSize int) *StreamWriter {
+	chunkSize = streamChunkSize(chunkSize)
+
+	// Export-only and closed contexts have no AEAD; sealing will fail
+	overhead := 0
+	if ctx.aead != nil {
+		overhead = ctx.aead.Overhead()
+	}
+
+	return &StreamWriter{
+		ctx:       ctx,
+		w:         w,
+		aad:       aad,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize+overhead),
+		aadBuf:    make([]byte, 0, len(aad)+9),
+	}
+}
+
+// sealChunk seals the buffered plaintext in place and writes it out.
+func (sw *StreamWriter) sealChunk(final bool) error {
+	sw.aadBuf = streamAAD(sw.aadBuf[:0], sw.aad, sw.ctx.Seq(), final)
+	ct, err := sw.ctx.SealTo(sw.buf[:0], sw.aadBuf, sw.buf)
+	if err != nil {
+		return err
+	}
+
+	sw.buf = sw.buf[:0]
+	_, err = sw.w.Write(ct)
+	return err
+}
+
+func (sw *StreamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, fmt.Errorf("Write to closed stream")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only emitted once more data arrives, since the
+		// last chunk in the stream must be sealed as final.
+		if len(sw.buf) == sw.chunkSize {
+			if err := sw.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(sw.buf[len(sw.buf):sw.chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// ReadFrom encrypts the data read from r until EOF, reading it directly into
+// the chunk buffer.  Like Write, it leaves the last chunk buffered until
+// Close, so the stream is the same as if the data had been written.
+func (sw *StreamWriter) ReadFrom(r io.Reader) (int64, error) {
+	if sw.closed {
+		return 0, fmt.Errorf("Write to closed stream")
+	}
+
+	var read int64
+	var lookahead [1]byte
+	for {
+		// A full chunk is only emitted once a byte beyond it has been read,
+		// since the last chunk in the stream must be sealed as final.
+		if len(sw.buf) == sw.chunkSize {
+			n, err := r.Read(lookahead[:])
+			if n > 0 {
+				if err := sw.sealChunk(false); err != nil {
+					return read, err
+				}
+
+				sw.buf = append(sw.buf, lookahead[0])
+				read++
+			}
+
+			if err == io.EOF {
+				return read, nil
+			} else if err != nil {
+				return read, err
+			}
+			continue
+		}
+
+		n, err := r.Read(sw.buf[len(sw.buf):sw.chunkSize])
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		read += int64(n)
+		if err == io.EOF {
+			return read, nil
+		} else if err != nil {
+			return read, err
+		}
+	}
+}
+
+// Close seals any buffered data as the final chunk.  It does not close the
+// underlying writer.
+func (sw *StreamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+
+	sw.closed = true
+	return sw.sealChunk(true)
+}
+
+// StreamReader decrypts a stream produced by a StreamWriter with the same
+// associated data and chunk size.
+type StreamReader struct {
+	ctx      *ReceiverContext
+	r        io.Reader
+	aad      []byte
+	ctSize   int
+	buf      []byte
+	pending  []byte
+	finished bool
+	err      error
+}
+
+func NewStreamReader(ctx *ReceiverContext, r io.Reader, aad []byte, chunkSize int) *StreamReader {
+	// Export-only and closed contexts have no AEAD; opening will fail
+	ctSize := streamChunkSize(chunkSize)
+	if ctx.aead != nil {
+		ctSize += ctx.aead.Overhead()
+	}
+
+	return &StreamReader{
+		ctx:    ctx,
+		r:      r,
+		aad:    aad,
+		ctSize: ctSize,
+		buf:    make([]byte, 0, ctSize+1),
+	}
+}
+
+// nextChunk reads and decrypts the next chunk.  One byte beyond the chunk is
+// read ahead in order to determine whether the chunk is the final one.
+func (sr *StreamReader) nextChunk() error {
+	n, err := io.ReadFull(sr.r, sr.buf[len(sr.buf):cap(sr.buf)])
+	sr.buf = sr.buf[:len(sr.buf)+n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	final := len(sr.buf) <= sr.ctSize
+	chunk := sr.buf
+	if !final {
+		chunk = sr.buf[:sr.ctSize]
+	}
+
+	pt, err := sr.ctx.Open(streamAAD(nil, sr.aad, sr.ctx.Seq(), final), chunk)
+	if err != nil {
+		return fmt.Errorf("Stream chunk authentication failed: %w", err)
+	}
+
+	if final {
+		sr.buf = sr.buf[:0]
+		sr.finished = true
+	} else {
+		sr.buf = append(sr.buf[:0], sr.buf[sr.ctSize:]...)
+	}
+
+	sr.pending = pt
+	return nil
+}
+
+func (sr *StreamReader) Read(p []byte) (int, error) {
+	for len(sr.pending) == 0 {
+		if sr.finished {
+			return 0, io.EOF
+		}
+
+		if sr.err != nil {
+			return 0, sr.err
+		}
+
+		sr.err = sr.nextChunk()
+	}
+
+	n := copy(p, sr.pending)
+	sr.pending = sr.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,201 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+const testStreamChunkSize = 64
+
+// streamAEADs are the AEADs that TestStreamRoundTrip covers.  The committing
+// AEADs prepend the commitment to the ciphertext, which in-place sealing has
+// to make room for.
+var streamAEADs = []AEADID{
+	AEAD_CHACHA20POLY1305,
+	AEAD_AESGCM128,
+	AEAD_AESGCM256,
+	AEAD_CHACHA20POLY1305_COMMIT,
+	AEAD_AESGCM128_COMMIT,
+	AEAD_AESGCM256_COMMIT,
+}
+
+func setupStreamContexts(t *testing.T) (CipherSuite, *SenderContext, *ReceiverContext) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+
+	ctxS, ctxR := setupStreamSuiteContexts(t, suite)
+	return suite, ctxS, ctxR
+}
+
+func setupStreamSuiteContexts(t *testing.T, suite CipherSuite) (*SenderContext, *ReceiverContext) {
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+
+	enc, ctxS, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	ctxR, err := SetupBaseR(suite, skR, enc, info)
+	assertNotError(t, suite, "Error in SetupBaseR", err)
+
+	return ctxS, ctxR
+}
+
+func sealStream(t *testing.T, suite CipherSuite, ctxS *SenderContext, pt []byte) []byte {
+	var ct bytes.Buffer
+	sw := NewStreamWriter(ctxS, &ct, aad, testStreamChunkSize)
+
+	// Write in uneven pieces to exercise buffering across chunk boundaries
+	for len(pt) > 0 {
+		n := 7
+		if n > len(pt) {
+			n = len(pt)
+		}
+
+		_, err := sw.Write(pt[:n])
+		assertNotError(t, suite, "Error in Write", err)
+		pt = pt[n:]
+	}
+
+	assertNotError(t, suite, "Error in Close", sw.Close())
+	return ct.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, aeadID := range streamAEADs {
+		suite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, aeadID)
+		if err != nil {
+			// Not available in this build
+			continue
+		}
+
+		for _, size := range []int{0, 1, testStreamChunkSize, 3 * testStreamChunkSize, 3*testStreamChunkSize + 5} {
+			ctxS, ctxR := setupStreamSuiteContexts(t, suite)
+			pt := randomBytes(size)
+
+			ct := sealStream(t, suite, ctxS, pt)
+
+			sr := NewStreamReader(ctxR, bytes.NewReader(ct), aad, testStreamChunkSize)
+			decrypted, err := ioutil.ReadAll(sr)
+			assertNotError(t, suite, "Error reading stream", err)
+			assertBytesEqual(t, suite, "Incorrect decryption", decrypted, pt)
+		}
+	}
+}
+
+func TestStreamReadFrom(t *testing.T) {
+	var _ io.ReaderFrom = &StreamWriter{}
+
+	for _, size := range []int{0, 1, testStreamChunkSize, 3 * testStreamChunkSize, 3*testStreamChunkSize + 5} {
+		suite, ctxS, ctxR := setupStreamContexts(t)
+		pt := randomBytes(size)
+
+		// A copy of the sender context seals the same stream with Write
+		opaque, err := ctxS.Marshal()
+		assertNotError(t, suite, "Error serializing context", err)
+		ctxCopy, err := UnmarshalSenderContext(opaque)
+		assertNotError(t, suite, "Error deserializing context", err)
+		expected := sealStream(t, suite, ctxCopy, pt)
+
+		// Hide bytes.Reader's WriteTo, which io.Copy would otherwise prefer
+		var ct bytes.Buffer
+		sw := NewStreamWriter(ctxS, &ct, aad, testStreamChunkSize)
+		src := struct{ io.Reader }{iotest.HalfReader(bytes.NewReader(pt))}
+		n, err := io.Copy(sw, src)
+		assertNotError(t, suite, "Error in ReadFrom", err)
+		assert(t, suite, "Incorrect length copied", n == int64(size))
+		assertNotError(t, suite, "Error in Close", sw.Close())
+		assertBytesEqual(t, suite, "ReadFrom and Write streams differ", ct.Bytes(), expected)
+
+		sr := NewStreamReader(ctxR, &ct, aad, testStreamChunkSize)
+		decrypted, err := ioutil.ReadAll(sr)
+		assertNotError(t, suite, "Error reading stream", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, pt)
+	}
+}
+
+func TestStreamChunkSize(t *testing.T) {
+	suite, ctxS, ctxR := setupStreamContexts(t)
+	assert(t, suite, "Incorrect AES-GCM chunk size", StreamChunkSize(AEAD_AESGCM128) == 16*1024)
+
+	chunkSize := StreamChunkSize(ctxS.AEADID)
+	assert(t, suite, "Incorrect ChaCha20Poly1305 chunk size", chunkSize == DefaultStreamChunkSize)
+
+	var ct bytes.Buffer
+	pt := randomBytes(2*chunkSize + 1)
+	sw := NewStreamWriter(ctxS, &ct, aad, chunkSize)
+	_, err := sw.Write(pt)
+	assertNotError(t, suite, "Error in Write", err)
+	assertNotError(t, suite, "Error in Close", sw.Close())
+
+	sr := NewStreamReader(ctxR, &ct, aad, StreamChunkSize(ctxR.AEADID))
+	decrypted, err := ioutil.ReadAll(sr)
+	assertNotError(t, suite, "Error reading stream", err)
+	assertBytesEqual(t, suite, "Incorrect decryption", decrypted, pt)
+}
+
+func TestStreamTampering(t *testing.T) {
+	suite, ctxS, ctxR := setupStreamContexts(t)
+	opaqueR, err := ctxR.Marshal()
+	assertNotError(t, suite, "Error serializing receiver context", err)
+
+	pt := randomBytes(3 * testStreamChunkSize)
+	ct := sealStream(t, suite, ctxS, pt)
+	ctSize := testStreamChunkSize + ctxS.aead.Overhead()
+
+	reordered := append([]byte{}, ct[ctSize:2*ctSize]...)
+	reordered = append(reordered, ct[:ctSize]...)
+	reordered = append(reordered, ct[2*ctSize:]...)
+
+	tampered := map[string][]byte{
+		"truncated": ct[:2*ctSize],
+		"reordered": reordered,
+		"extended":  append(append([]byte{}, ct...), ct[:ctSize]...),
+	}
+
+	for label, stream := range tampered {
+		ctxR, err := UnmarshalReceiverContext(opaqueR)
+		assertNotError(t, suite, "Error deserializing receiver context", err)
+
+		sr := NewStreamReader(ctxR, bytes.NewReader(stream), aad, testStreamChunkSize)
+		_, err = ioutil.ReadAll(sr)
+		assert(t, suite, "Tampered stream accepted: "+label, err != nil)
+	}
+}
+
+func TestStreamWriterAllocs(t *testing.T) {
+	suite, ctxS, _ := setupStreamContexts(t)
+	sw := NewStreamWriter(ctxS, ioutil.Discard, aad, testStreamChunkSize)
+	chunk := randomBytes(testStreamChunkSize)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := sw.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert(t, suite, "StreamWriter allocates per chunk", allocs == 0)
+}
+
+func TestStreamExportOnly(t *testing.T) {
+	suite := mustAssembleSuite(t, DHKEM_X25519, KDF_HKDF_SHA256, AEAD_EXPORT_ONLY)
+	ctxS, ctxR := setupStreamSuiteContexts(t, suite)
+
+	sw := NewStreamWriter(ctxS, ioutil.Discard, aad, testStreamChunkSize)
+	_, err := sw.Write(randomBytes(testStreamChunkSize))
+	assertNotError(t, suite, "Error in Write", err)
+	err = sw.Close()
+	assert(t, suite, "Stream sealed with an export-only context", err != nil)
+
+	sr := NewStreamReader(ctxR, bytes.NewReader(randomBytes(testStreamChunkSize)), aad, testStreamChunkSize)
+	_, err = ioutil.ReadAll(sr)
+	assert(t, suite, "Stream opened with an export-only context", err != nil)
+
+	// Closed contexts have no AEAD either
+	_, _, ctxR = setupStreamContexts(t)
+	ctxR.Close()
+	sr = NewStreamReader(ctxR, bytes.NewReader(randomBytes(testStreamChunkSize)), aad, testStreamChunkSize)
+	_, err = ioutil.ReadAll(sr)
+	assert(t, suite, "Stream opened with a closed context", errors.Is(err, ErrContextClosed))
+}
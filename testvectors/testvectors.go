@@ -0,0 +1,385 @@
+// Package testvectors loads HPKE test vectors published by other
+// implementations and normalizes them into the JSON format that this
+// package's test vector generator emits and TestVectorVerify consumes, so
+// that cross-implementation interop can be checked programmatically.
+//
+// rust-hpke and hpke-js publish vectors in the CFRG JSON format, but leave
+// fields that do not apply to a mode null or absent.  BoringSSL translates
+// the CFRG vectors into its FileTest text format, keeping only the inputs it
+// tests against.  In both cases, fields that can be recomputed from the
+// others (e.g., enc from pkEm for the DH-based KEMs) are filled in.
+//
+// This package does not depend on the hpke package, so that its tests can use
+// it.
+package testvectors
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// kemX25519 is the KEM assumed for BoringSSL vectors without a kem_id, which
+// predate BoringSSL's support for other KEMs.
+const kemX25519 = 0x0020
+
+// Encryption is a single encryption within a test vector.  Nonce is nil if
+// the source does not record it, in which case encryptions are sequential.
+type Encryption struct {
+	Plaintext  []byte
+	AAD        []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// Export is a single exporter output within a test vector.
+type Export struct {
+	Context []byte
+	Length  int
+	Value   []byte
+}
+
+// Vector is a test vector for one mode and ciphersuite.  Fields that the
+// source does not provide are nil.
+type Vector struct {
+	Mode   uint8
+	KEMID  uint16
+	KDFID  uint16
+	AEADID uint16
+	Info   []byte
+
+	IKMR  []byte
+	IKMS  []byte
+	IKME  []byte
+	SKR   []byte
+	SKS   []byte
+	SKE   []byte
+	PSK   []byte
+	PSKID []byte
+
+	PKR []byte
+	PKS []byte
+	PKE []byte
+
+	Enc                []byte
+	SharedSecret       []byte
+	KeyScheduleContext []byte
+	Secret             []byte
+	Key                []byte
+	BaseNonce          []byte
+	ExporterSecret     []byte
+
+	Encryptions []Encryption
+	Exports     []Export
+}
+
+// normalize fills in fields that can be derived from the others.
+func (v *Vector) normalize() {
+	// For the DH-based KEMs, the encapsulated key is the serialized
+	// ephemeral public key.
+	if v.Enc == nil && v.PKE != nil {
+		v.Enc = v.PKE
+	}
+}
+
+// hexBytes is a hex-encoded byte string that may be null in JSON.
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *hexBytes) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	if s == nil {
+		*h = nil
+		return nil
+	}
+
+	decoded, err := hex.DecodeString(*s)
+	if err != nil {
+		return err
+	}
+
+	*h = decoded
+	return nil
+}
+
+// Raw JSON structures, following the field names of the CFRG vectors
+type rawEncryption struct {
+	Plaintext  hexBytes `json:"pt"`
+	AAD        hexBytes `json:"aad"`
+	Nonce      hexBytes `json:"nonce"`
+	Ciphertext hexBytes `json:"ct"`
+}
+
+type rawExport struct {
+	Context hexBytes `json:"exporter_context"`
+	Length  int      `json:"L"`
+	Value   hexBytes `json:"exported_value"`
+}
+
+type rawVector struct {
+	Mode   uint8    `json:"mode"`
+	KEMID  uint16   `json:"kem_id"`
+	KDFID  uint16   `json:"kdf_id"`
+	AEADID uint16   `json:"aead_id"`
+	Info   hexBytes `json:"info"`
+
+	IKMR  hexBytes `json:"ikmR"`
+	IKMS  hexBytes `json:"ikmS,omitempty"`
+	IKME  hexBytes `json:"ikmE"`
+	SKR   hexBytes `json:"skRm"`
+	SKS   hexBytes `json:"skSm,omitempty"`
+	SKE   hexBytes `json:"skEm"`
+	PSK   hexBytes `json:"psk,omitempty"`
+	PSKID hexBytes `json:"psk_id,omitempty"`
+
+	PKR hexBytes `json:"pkRm"`
+	PKS hexBytes `json:"pkSm,omitempty"`
+	PKE hexBytes `json:"pkEm"`
+
+	Enc                hexBytes `json:"enc"`
+	SharedSecret       hexBytes `json:"shared_secret"`
+	KeyScheduleContext hexBytes `json:"key_schedule_context"`
+	Secret             hexBytes `json:"secret"`
+	Key                hexBytes `json:"key"`
+	BaseNonce          hexBytes `json:"base_nonce"`
+	ExporterSecret     hexBytes `json:"exporter_secret"`
+
+	Encryptions []rawEncryption `json:"encryptions"`
+	Exports     []rawExport     `json:"exports"`
+}
+
+func (raw rawVector) vector() Vector {
+	v := Vector{
+		Mode: raw.Mode, KEMID: raw.KEMID, KDFID: raw.KDFID, AEADID: raw.AEADID, Info: raw.Info,
+		IKMR: raw.IKMR, IKMS: raw.IKMS, IKME: raw.IKME,
+		SKR: raw.SKR, SKS: raw.SKS, SKE: raw.SKE,
+		PSK: raw.PSK, PSKID: raw.PSKID,
+		PKR: raw.PKR, PKS: raw.PKS, PKE: raw.PKE,
+		Enc:                raw.Enc,
+		SharedSecret:       raw.SharedSecret,
+		KeyScheduleContext: raw.KeyScheduleContext,
+		Secret:             raw.Secret,
+		Key:                raw.Key,
+		BaseNonce:          raw.BaseNonce,
+		ExporterSecret:     raw.ExporterSecret,
+	}
+
+	for _, e := range raw.Encryptions {
+		v.Encryptions = append(v.Encryptions, Encryption{e.Plaintext, e.AAD, e.Nonce, e.Ciphertext})
+	}
+	for _, e := range raw.Exports {
+		v.Exports = append(v.Exports, Export{e.Context, e.Length, e.Value})
+	}
+
+	v.normalize()
+	return v
+}
+
+func (v Vector) raw() rawVector {
+	raw := rawVector{
+		Mode: v.Mode, KEMID: v.KEMID, KDFID: v.KDFID, AEADID: v.AEADID, Info: v.Info,
+		IKMR: v.IKMR, IKMS: v.IKMS, IKME: v.IKME,
+		SKR: v.SKR, SKS: v.SKS, SKE: v.SKE,
+		PSK: v.PSK, PSKID: v.PSKID,
+		PKR: v.PKR, PKS: v.PKS, PKE: v.PKE,
+		Enc:                v.Enc,
+		SharedSecret:       v.SharedSecret,
+		KeyScheduleContext: v.KeyScheduleContext,
+		Secret:             v.Secret,
+		Key:                v.Key,
+		BaseNonce:          v.BaseNonce,
+		ExporterSecret:     v.ExporterSecret,
+		Encryptions:        []rawEncryption{},
+		Exports:            []rawExport{},
+	}
+
+	for _, e := range v.Encryptions {
+		raw.Encryptions = append(raw.Encryptions, rawEncryption{e.Plaintext, e.AAD, e.Nonce, e.Ciphertext})
+	}
+	for _, e := range v.Exports {
+		raw.Exports = append(raw.Exports, rawExport{e.Context, e.Length, e.Value})
+	}
+
+	return raw
+}
+
+// Marshal encodes test vectors in the JSON format of this package's test
+// vectors.
+func Marshal(vectors []Vector) ([]byte, error) {
+	raw := make([]rawVector, len(vectors))
+	for i, v := range vectors {
+		raw[i] = v.raw()
+	}
+	return json.Marshal(raw)
+}
+
+func loadJSON(r io.Reader) ([]Vector, error) {
+	var raw []rawVector
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	vectors := make([]Vector, len(raw))
+	for i := range raw {
+		vectors[i] = raw[i].vector()
+	}
+	return vectors, nil
+}
+
+// LoadRustHPKE loads the JSON test vectors used by rust-hpke's known-answer
+// tests.
+func LoadRustHPKE(r io.Reader) ([]Vector, error) {
+	return loadJSON(r)
+}
+
+// LoadHPKEJS loads the JSON test vectors used by hpke-js.
+func LoadHPKEJS(r io.Reader) ([]Vector, error) {
+	return loadJSON(r)
+}
+
+// LoadBoringSSL loads test vectors in the FileTest format of BoringSSL's
+// hpke_test_vectors.txt.  Each vector is a block of "key = value" lines
+// terminated by a blank line; the encryption and export fields repeat once
+// per entry.
+func LoadBoringSSL(r io.Reader) ([]Vector, error) {
+	var vectors []Vector
+	var current *Vector
+	haveKEM := false
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+
+		if !haveKEM {
+			current.KEMID = kemX25519
+		}
+
+		current.normalize()
+		vectors = append(vectors, *current)
+		current, haveKEM = nil, false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			finish()
+			continue
+		}
+
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Malformed line [%d]", line)
+		}
+
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if current == nil {
+			current = &Vector{}
+		}
+
+		if key == "kem_id" {
+			haveKEM = true
+		}
+
+		if err := current.setBoringSSLField(key, value); err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	finish()
+	return vectors, nil
+}
+
+// setBoringSSLField sets one field of a vector from a FileTest attribute.  An
+// encryption starts with "aad" and an export with "exporter_context", as in
+// the files that BoringSSL generates.
+func (v *Vector) setBoringSSLField(key, value string) error {
+	switch key {
+	case "mode", "kem_id", "kdf_id", "aead_id", "L":
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid %s [%s]", key, value)
+		}
+
+		switch key {
+		case "mode":
+			v.Mode = uint8(n)
+		case "kem_id":
+			v.KEMID = uint16(n)
+		case "kdf_id":
+			v.KDFID = uint16(n)
+		case "aead_id":
+			v.AEADID = uint16(n)
+		case "L":
+			if len(v.Exports) == 0 {
+				return fmt.Errorf("L outside of export")
+			}
+			v.Exports[len(v.Exports)-1].Length = int(n)
+		}
+		return nil
+	}
+
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("Invalid %s: %v", key, err)
+	}
+
+	fields := map[string]*[]byte{
+		"info": &v.Info, "ikmR": &v.IKMR, "ikmS": &v.IKMS, "ikmE": &v.IKME,
+		"skRm": &v.SKR, "skSm": &v.SKS, "skEm": &v.SKE, "psk": &v.PSK, "psk_id": &v.PSKID,
+		"pkRm": &v.PKR, "pkSm": &v.PKS, "pkEm": &v.PKE, "enc": &v.Enc,
+	}
+	if field, ok := fields[key]; ok {
+		*field = data
+		return nil
+	}
+
+	switch key {
+	case "aad":
+		v.Encryptions = append(v.Encryptions, Encryption{AAD: data})
+		return nil
+	case "exporter_context":
+		v.Exports = append(v.Exports, Export{Context: data})
+		return nil
+	}
+
+	switch {
+	case (key == "pt" || key == "ct" || key == "nonce") && len(v.Encryptions) > 0:
+		e := &v.Encryptions[len(v.Encryptions)-1]
+		switch key {
+		case "pt":
+			e.Plaintext = data
+		case "ct":
+			e.Ciphertext = data
+		case "nonce":
+			e.Nonce = data
+		}
+	case key == "exported_value" && len(v.Exports) > 0:
+		v.Exports[len(v.Exports)-1].Value = data
+	default:
+		return fmt.Errorf("Unexpected attribute %q", key)
+	}
+
+	return nil
+}
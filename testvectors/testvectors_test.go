@@ -0,0 +1,115 @@
+package testvectors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const vectorFile = "../test-vectors.json"
+
+func loadRepoVectors(t *testing.T) []Vector {
+	file, err := os.Open(vectorFile)
+	require.Nil(t, err, "Error opening test vectors")
+	defer file.Close()
+
+	vectors, err := LoadRustHPKE(file)
+	require.Nil(t, err, "Error loading test vectors")
+	require.NotEqual(t, 0, len(vectors), "No test vectors loaded")
+	return vectors
+}
+
+// toBoringSSL writes vectors in the form produced by BoringSSL's
+// translate_test_vectors.py, which omits kem_id, enc, and the derived values.
+func toBoringSSL(vectors []Vector) string {
+	var b strings.Builder
+	for _, v := range vectors {
+		fmt.Fprintf(&b, "mode = %d\nkdf_id = %d\naead_id = %d\n", v.Mode, v.KDFID, v.AEADID)
+		fmt.Fprintf(&b, "info = %x\nskRm = %x\nskEm = %x\npkRm = %x\npkEm = %x\n", v.Info, v.SKR, v.SKE, v.PKR, v.PKE)
+		if v.PSK != nil {
+			fmt.Fprintf(&b, "psk = %x\npsk_id = %x\n", v.PSK, v.PSKID)
+		}
+		if v.PKS != nil {
+			fmt.Fprintf(&b, "pkSm = %x\nskSm = %x\n", v.PKS, v.SKS)
+		}
+		for i, e := range v.Encryptions {
+			fmt.Fprintf(&b, "# encryptions[%d]\naad = %x\nct = %x\npt = %x\n", i, e.AAD, e.Ciphertext, e.Plaintext)
+		}
+		for i, e := range v.Exports {
+			fmt.Fprintf(&b, "# exports[%d]\nexporter_context = %x\nL = %d\nexported_value = %x\n", i, e.Context, e.Length, e.Value)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestLoadBoringSSL(t *testing.T) {
+	var x25519 []Vector
+	for _, v := range loadRepoVectors(t) {
+		if v.KEMID == kemX25519 {
+			x25519 = append(x25519, v)
+		}
+	}
+
+	loaded, err := LoadBoringSSL(strings.NewReader(toBoringSSL(x25519)))
+	require.Nil(t, err, "Error in LoadBoringSSL")
+	require.Equal(t, len(x25519), len(loaded), "Incorrect number of vectors")
+
+	for i, v := range loaded {
+		require.Equal(t, uint16(kemX25519), v.KEMID, "Incorrect default KEM")
+		require.Equal(t, x25519[i].Enc, v.Enc, "enc not derived from pkEm")
+		require.Equal(t, len(x25519[i].Encryptions), len(v.Encryptions), "Incorrect number of encryptions")
+		require.Equal(t, x25519[i].Exports, v.Exports, "Incorrect exports")
+		for _, e := range v.Encryptions {
+			require.Nil(t, e.Nonce, "Nonce present")
+		}
+	}
+
+	_, err = LoadBoringSSL(strings.NewReader("mode = 0\nct = 00\n"))
+	require.NotNil(t, err, "Ciphertext outside of encryption accepted")
+
+	_, err = LoadBoringSSL(strings.NewReader("mode = zero\n"))
+	require.NotNil(t, err, "Malformed mode accepted")
+}
+
+func TestLoadJSON(t *testing.T) {
+	// Fields that do not apply to the mode are null, as in rust-hpke's
+	// vectors, and enc is absent.
+	const input = `[{"mode":0,"kem_id":32,"kdf_id":1,"aead_id":1,"info":"01",` +
+		`"skRm":"02","skSm":null,"skEm":"03","psk":null,"psk_id":null,` +
+		`"pkRm":"04","pkSm":null,"pkEm":"05",` +
+		`"encryptions":[{"aad":"06","ct":"07","nonce":"08","pt":"09"}],` +
+		`"exports":[{"exporter_context":"","L":32,"exported_value":"0a"}]}]`
+
+	vectors, err := LoadHPKEJS(strings.NewReader(input))
+	require.Nil(t, err, "Error in LoadHPKEJS")
+	require.Equal(t, 1, len(vectors), "Incorrect number of vectors")
+	require.Nil(t, vectors[0].PSK, "Null PSK not decoded as nil")
+	require.Equal(t, []byte{0x05}, vectors[0].Enc, "enc not derived from pkEm")
+	require.Equal(t, Encryption{[]byte{9}, []byte{6}, []byte{8}, []byte{7}}, vectors[0].Encryptions[0], "Incorrect encryption")
+
+	encoded, err := Marshal(vectors)
+	require.Nil(t, err, "Error in Marshal")
+	require.False(t, bytes.Contains(encoded, []byte(`"psk"`)), "Absent PSK marshaled")
+
+	_, err = LoadRustHPKE(strings.NewReader(`[{"info":"zz"}]`))
+	require.NotNil(t, err, "Invalid hex accepted")
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	vectors := loadRepoVectors(t)
+
+	encoded, err := Marshal(vectors)
+	require.Nil(t, err, "Error in Marshal")
+
+	reloaded, err := LoadRustHPKE(bytes.NewReader(encoded))
+	require.Nil(t, err, "Error reloading vectors")
+	require.Equal(t, len(vectors), len(reloaded), "Incorrect number of vectors")
+	require.Equal(t, hex.EncodeToString(vectors[0].SharedSecret), hex.EncodeToString(reloaded[0].SharedSecret), "Incorrect shared secret")
+	require.Equal(t, vectors[len(vectors)-1].Encryptions, reloaded[len(reloaded)-1].Encryptions, "Incorrect encryptions")
+}
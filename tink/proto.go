@@ -0,0 +1,95 @@
+package tink
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendUvarint(out []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(out, buf[:n]...)
+}
+
+// protoWriter encodes the subset of the protocol buffer wire format needed
+// for Tink keysets.  Fields with default values are omitted, as in proto3.
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) tag(field, wireType int) {
+	w.buf = appendUvarint(w.buf, uint64(field<<3|wireType))
+}
+
+func (w *protoWriter) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	w.tag(field, wireVarint)
+	w.buf = appendUvarint(w.buf, v)
+}
+
+func (w *protoWriter) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	w.tag(field, wireBytes)
+	w.buf = appendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// protoField is a single decoded field.  Only one of varint and bytes is set,
+// according to the wire type.
+type protoField struct {
+	num      int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// parseProto decodes the fields of a message.  Fields of wire types other
+// than varint and length-delimited are rejected, since Tink keysets do not
+// use them.
+func parseProto(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("Invalid protobuf field key")
+		}
+		data = data[n:]
+
+		field := protoField{num: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("Invalid protobuf varint [%d]", field.num)
+			}
+			data = data[n:]
+
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, fmt.Errorf("Invalid protobuf length [%d]", field.num)
+			}
+			field.bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+
+		default:
+			return nil, fmt.Errorf("Unsupported protobuf wire type [%d]", field.wireType)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,485 @@
+// Package tink provides HybridEncrypt and HybridDecrypt primitives that are
+// compatible with Tink's HPKE key type, and imports and exports binary Tink
+// keysets for the supported KEMs, so that Tink users can exchange ciphertexts
+// with HPKE peers built on this package.
+//
+// As in Tink, a ciphertext is the key's output prefix followed by the HPKE
+// encapsulated key and the Base mode ciphertext, with the context info as
+// the HPKE info string and an empty AAD.
+package tink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	hpke "github.com/cisco/go-hpke"
+)
+
+// Type URLs of the Tink HPKE key types
+const (
+	PrivateKeyTypeURL = "type.googleapis.com/google.crypto.tink.HpkePrivateKey"
+	PublicKeyTypeURL  = "type.googleapis.com/google.crypto.tink.HpkePublicKey"
+)
+
+// OutputPrefixType determines the prefix that Tink adds to ciphertexts.
+type OutputPrefixType uint64
+
+// Supported output prefix types.  TINK prefixes ciphertexts with 0x01 and the
+// 4-byte key ID; RAW adds no prefix.
+const (
+	OutputPrefixTink OutputPrefixType = 1
+	OutputPrefixRaw  OutputPrefixType = 3
+)
+
+// Tink key status and key material types
+const (
+	keyStatusEnabled      = 1
+	keyMaterialPrivate    = 3
+	keyMaterialPublic     = 4
+	tinkPrefixStartByte   = 0x01
+	tinkPrefixSize        = 5
+	hpkeKeyFormatVersion0 = 0
+)
+
+// Tink's enums for the HPKE algorithms, which differ from the HPKE IDs.
+var (
+	tinkKEMs = map[hpke.KEMID]uint64{
+		hpke.DHKEM_X25519: 1,
+		hpke.DHKEM_P256:   2,
+		hpke.DHKEM_P521:   4,
+	}
+	tinkKDFs = map[hpke.KDFID]uint64{
+		hpke.KDF_HKDF_SHA256: 1,
+		hpke.KDF_HKDF_SHA384: 2,
+		hpke.KDF_HKDF_SHA512: 3,
+	}
+	tinkAEADs = map[hpke.AEADID]uint64{
+		hpke.AEAD_AESGCM128:        1,
+		hpke.AEAD_AESGCM256:        2,
+		hpke.AEAD_CHACHA20POLY1305: 3,
+	}
+)
+
+// Key is one key in a keyset.
+type Key struct {
+	KeyID      uint32
+	Prefix     OutputPrefixType
+	Suite      hpke.CipherSuite
+	PublicKey  hpke.KEMPublicKey
+	PrivateKey hpke.KEMPrivateKey
+}
+
+// prefix returns the output prefix for ciphertexts under the key.
+func (key Key) prefix() ([]byte, error) {
+	switch key.Prefix {
+	case OutputPrefixTink:
+		prefix := make([]byte, tinkPrefixSize)
+		prefix[0] = tinkPrefixStartByte
+		binary.BigEndian.PutUint32(prefix[1:], key.KeyID)
+		return prefix, nil
+	case OutputPrefixRaw:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported Tink output prefix type [%d]", key.Prefix)
+}
+
+func marshalParams(suite hpke.CipherSuite) ([]byte, error) {
+	kem, okKEM := tinkKEMs[suite.KEM.ID()]
+	kdf, okKDF := tinkKDFs[suite.KDF.ID()]
+	aead, okAEAD := tinkAEADs[suite.AEAD.ID()]
+	if !okKEM || !okKDF || !okAEAD {
+		return nil, fmt.Errorf("%w: Suite not supported by Tink [%s]", hpke.ErrUnsupportedSuite, suite)
+	}
+
+	w := protoWriter{}
+	w.varint(1, kem)
+	w.varint(2, kdf)
+	w.varint(3, aead)
+	return w.buf, nil
+}
+
+func parseParams(data []byte) (hpke.CipherSuite, error) {
+	fields, err := parseProto(data)
+	if err != nil {
+		return hpke.CipherSuite{}, err
+	}
+
+	var kem, kdf, aead uint64
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			kem = f.varint
+		case 2:
+			kdf = f.varint
+		case 3:
+			aead = f.varint
+		}
+	}
+
+	var kemID hpke.KEMID
+	var kdfID hpke.KDFID
+	var aeadID hpke.AEADID
+	var okKEM, okKDF, okAEAD bool
+	for id, v := range tinkKEMs {
+		if v == kem {
+			kemID, okKEM = id, true
+		}
+	}
+	for id, v := range tinkKDFs {
+		if v == kdf {
+			kdfID, okKDF = id, true
+		}
+	}
+	for id, v := range tinkAEADs {
+		if v == aead {
+			aeadID, okAEAD = id, true
+		}
+	}
+
+	if !okKEM || !okKDF || !okAEAD {
+		return hpke.CipherSuite{}, fmt.Errorf("%w: Unknown Tink HPKE parameters [%d, %d, %d]", hpke.ErrUnsupportedSuite, kem, kdf, aead)
+	}
+
+	return hpke.AssembleCipherSuite(kemID, kdfID, aeadID)
+}
+
+// marshalPublicKey encodes an HpkePublicKey message.
+func marshalPublicKey(suite hpke.CipherSuite, pk hpke.KEMPublicKey) ([]byte, error) {
+	params, err := marshalParams(suite)
+	if err != nil {
+		return nil, err
+	}
+
+	w := protoWriter{}
+	w.varint(1, hpkeKeyFormatVersion0)
+	w.bytes(2, params)
+	w.bytes(3, suite.KEM.SerializePublicKey(pk))
+	return w.buf, nil
+}
+
+func parsePublicKey(data []byte) (hpke.CipherSuite, hpke.KEMPublicKey, error) {
+	fields, err := parseProto(data)
+	if err != nil {
+		return hpke.CipherSuite{}, nil, err
+	}
+
+	var version uint64
+	var params, pkm []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			version = f.varint
+		case 2:
+			params = f.bytes
+		case 3:
+			pkm = f.bytes
+		}
+	}
+
+	if version != hpkeKeyFormatVersion0 {
+		return hpke.CipherSuite{}, nil, fmt.Errorf("Unsupported Tink HPKE key version [%d]", version)
+	}
+
+	suite, err := parseParams(params)
+	if err != nil {
+		return hpke.CipherSuite{}, nil, err
+	}
+
+	pk, err := suite.KEM.DeserializePublicKey(pkm)
+	if err != nil {
+		return hpke.CipherSuite{}, nil, err
+	}
+
+	return suite, pk, nil
+}
+
+// marshalPrivateKey encodes an HpkePrivateKey message.
+func marshalPrivateKey(suite hpke.CipherSuite, sk hpke.KEMPrivateKey) ([]byte, error) {
+	pub, err := marshalPublicKey(suite, sk.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	w := protoWriter{}
+	w.varint(1, hpkeKeyFormatVersion0)
+	w.bytes(2, pub)
+	w.bytes(3, suite.KEM.SerializePrivateKey(sk))
+	return w.buf, nil
+}
+
+func parsePrivateKey(data []byte) (hpke.CipherSuite, hpke.KEMPrivateKey, error) {
+	fields, err := parseProto(data)
+	if err != nil {
+		return hpke.CipherSuite{}, nil, err
+	}
+
+	var version uint64
+	var pub, skm []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			version = f.varint
+		case 2:
+			pub = f.bytes
+		case 3:
+			skm = f.bytes
+		}
+	}
+
+	if version != hpkeKeyFormatVersion0 {
+		return hpke.CipherSuite{}, nil, fmt.Errorf("Unsupported Tink HPKE key version [%d]", version)
+	}
+
+	suite, pk, err := parsePublicKey(pub)
+	if err != nil {
+		return hpke.CipherSuite{}, nil, err
+	}
+
+	sk, err := suite.KEM.DeserializePrivateKey(skm)
+	if err != nil {
+		return hpke.CipherSuite{}, nil, err
+	}
+
+	if !bytes.Equal(suite.KEM.SerializePublicKey(sk.PublicKey()), suite.KEM.SerializePublicKey(pk)) {
+		return hpke.CipherSuite{}, nil, fmt.Errorf("Tink HPKE private key does not match public key")
+	}
+
+	return suite, sk, nil
+}
+
+// marshalKeyset encodes a Keyset message whose first key is primary.
+func marshalKeyset(keys []Key, private bool) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Empty keyset")
+	}
+
+	w := protoWriter{}
+	w.varint(1, uint64(keys[0].KeyID))
+	for _, key := range keys {
+		if _, err := key.prefix(); err != nil {
+			return nil, err
+		}
+
+		var value []byte
+		var err error
+		keyData := protoWriter{}
+		if private {
+			value, err = marshalPrivateKey(key.Suite, key.PrivateKey)
+			keyData.bytes(1, []byte(PrivateKeyTypeURL))
+			keyData.bytes(2, value)
+			keyData.varint(3, keyMaterialPrivate)
+		} else {
+			value, err = marshalPublicKey(key.Suite, key.PublicKey)
+			keyData.bytes(1, []byte(PublicKeyTypeURL))
+			keyData.bytes(2, value)
+			keyData.varint(3, keyMaterialPublic)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := protoWriter{}
+		entry.bytes(1, keyData.buf)
+		entry.varint(2, keyStatusEnabled)
+		entry.varint(3, uint64(key.KeyID))
+		entry.varint(4, uint64(key.Prefix))
+		w.bytes(2, entry.buf)
+	}
+
+	return w.buf, nil
+}
+
+// MarshalPublicKeyset encodes the public keys as a binary Tink Keyset.  The
+// first key is the primary key.
+func MarshalPublicKeyset(keys []Key) ([]byte, error) {
+	return marshalKeyset(keys, false)
+}
+
+// MarshalPrivateKeyset encodes the private keys as a binary Tink Keyset.
+// The first key is the primary key.
+func MarshalPrivateKeyset(keys []Key) ([]byte, error) {
+	return marshalKeyset(keys, true)
+}
+
+// ParseKeyset decodes a binary Tink Keyset containing HPKE public or private
+// keys.  The primary key is returned first; disabled keys are skipped.
+// Keysets are not authenticated, so they must come from a trusted source.
+func ParseKeyset(data []byte) ([]Key, error) {
+	fields, err := parseProto(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var primaryID uint32
+	var keys []Key
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			primaryID = uint32(f.varint)
+		case 2:
+			key, enabled, err := parseKey(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			if !enabled {
+				continue
+			}
+
+			if key.KeyID == primaryID && len(keys) > 0 {
+				keys = append([]Key{key}, keys...)
+			} else {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if len(keys) == 0 || keys[0].KeyID != primaryID {
+		return nil, fmt.Errorf("Keyset has no enabled primary key")
+	}
+
+	return keys, nil
+}
+
+func parseKey(data []byte) (Key, bool, error) {
+	fields, err := parseProto(data)
+	if err != nil {
+		return Key{}, false, err
+	}
+
+	var keyData []byte
+	var status uint64
+	key := Key{}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			keyData = f.bytes
+		case 2:
+			status = f.varint
+		case 3:
+			key.KeyID = uint32(f.varint)
+		case 4:
+			key.Prefix = OutputPrefixType(f.varint)
+		}
+	}
+
+	if _, err := key.prefix(); err != nil {
+		return Key{}, false, err
+	}
+
+	fields, err = parseProto(keyData)
+	if err != nil {
+		return Key{}, false, err
+	}
+
+	var typeURL string
+	var value []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			typeURL = string(f.bytes)
+		case 2:
+			value = f.bytes
+		}
+	}
+
+	switch typeURL {
+	case PrivateKeyTypeURL:
+		key.Suite, key.PrivateKey, err = parsePrivateKey(value)
+		if err == nil {
+			key.PublicKey = key.PrivateKey.PublicKey()
+		}
+	case PublicKeyTypeURL:
+		key.Suite, key.PublicKey, err = parsePublicKey(value)
+	default:
+		err = fmt.Errorf("Unsupported Tink key type %q", typeURL)
+	}
+
+	return key, status == keyStatusEnabled, err
+}
+
+// HybridEncrypt encrypts to the primary key of a keyset.  It implements
+// Tink's tink.HybridEncrypt interface.
+type HybridEncrypt struct {
+	rand   io.Reader
+	key    Key
+	prefix []byte
+}
+
+// NewHybridEncrypt returns a HybridEncrypt primitive for the primary key of
+// the keyset, using rand as the source of randomness.
+func NewHybridEncrypt(keys []Key, rand io.Reader) (*HybridEncrypt, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Empty keyset")
+	}
+
+	prefix, err := keys[0].prefix()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HybridEncrypt{rand: rand, key: keys[0], prefix: prefix}, nil
+}
+
+// Encrypt encrypts the plaintext, binding it to contextInfo.
+func (e *HybridEncrypt) Encrypt(plaintext, contextInfo []byte) ([]byte, error) {
+	enc, ct, err := hpke.Seal(e.key.Suite, e.rand, e.key.PublicKey, contextInfo, nil, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, e.prefix...)
+	out = append(out, enc...)
+	return append(out, ct...), nil
+}
+
+// HybridDecrypt decrypts with any of the private keys of a keyset.  It
+// implements Tink's tink.HybridDecrypt interface.
+type HybridDecrypt struct {
+	keys []Key
+}
+
+// NewHybridDecrypt returns a HybridDecrypt primitive for the private keys of
+// the keyset.
+func NewHybridDecrypt(keys []Key) (*HybridDecrypt, error) {
+	for _, key := range keys {
+		if key.PrivateKey == nil {
+			return nil, fmt.Errorf("Keyset does not contain private keys")
+		}
+	}
+
+	return &HybridDecrypt{keys: keys}, nil
+}
+
+// Decrypt decrypts the ciphertext, which must have been bound to the same
+// contextInfo.  Keys with the TINK prefix type are selected by their prefix;
+// keys with the RAW prefix type are tried in turn.
+func (d *HybridDecrypt) Decrypt(ciphertext, contextInfo []byte) ([]byte, error) {
+	for _, key := range d.keys {
+		prefix, err := key.prefix()
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.HasPrefix(ciphertext, prefix) {
+			continue
+		}
+
+		// For the supported KEMs, Nenc = Npk
+		body := ciphertext[len(prefix):]
+		Nenc := len(key.Suite.KEM.SerializePublicKey(key.PublicKey))
+		if len(body) < Nenc {
+			continue
+		}
+
+		pt, err := hpke.Open(key.Suite, key.PrivateKey, body[:Nenc], contextInfo, nil, body[Nenc:])
+		if err == nil {
+			return pt, nil
+		}
+	}
+
+	return nil, hpke.ErrOpenFailed
+}
@@ -0,0 +1,95 @@
+package tink
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	hpke "github.com/cisco/go-hpke"
+	"github.com/stretchr/testify/require"
+)
+
+var contextInfo = []byte("context info")
+
+func newTestKey(t *testing.T, kemID hpke.KEMID, keyID uint32, prefix OutputPrefixType) Key {
+	suite, err := hpke.AssembleCipherSuite(kemID, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	skR, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	return Key{KeyID: keyID, Prefix: prefix, Suite: suite, PublicKey: pkR, PrivateKey: skR}
+}
+
+func TestKeysetRoundTrip(t *testing.T) {
+	for _, kemID := range []hpke.KEMID{hpke.DHKEM_X25519, hpke.DHKEM_P256, hpke.DHKEM_P521} {
+		keys := []Key{
+			newTestKey(t, kemID, 0x01020304, OutputPrefixTink),
+			newTestKey(t, kemID, 0x05060708, OutputPrefixRaw),
+		}
+
+		privData, err := MarshalPrivateKeyset(keys)
+		require.Nil(t, err, "Error marshaling private keyset")
+		pubData, err := MarshalPublicKeyset(keys)
+		require.Nil(t, err, "Error marshaling public keyset")
+
+		privKeys, err := ParseKeyset(privData)
+		require.Nil(t, err, "Error parsing private keyset")
+		pubKeys, err := ParseKeyset(pubData)
+		require.Nil(t, err, "Error parsing public keyset")
+		require.Equal(t, 2, len(pubKeys), "Incorrect number of keys")
+		require.Equal(t, keys[0].KeyID, pubKeys[0].KeyID, "Incorrect primary key")
+		require.Nil(t, pubKeys[0].PrivateKey, "Private key in public keyset")
+
+		_, err = NewHybridDecrypt(pubKeys)
+		require.NotNil(t, err, "HybridDecrypt accepted public keyset")
+
+		enc, err := NewHybridEncrypt(pubKeys, rand.Reader)
+		require.Nil(t, err, "Error in NewHybridEncrypt")
+		dec, err := NewHybridDecrypt(privKeys)
+		require.Nil(t, err, "Error in NewHybridDecrypt")
+
+		pt := []byte("plaintext")
+		ct, err := enc.Encrypt(pt, contextInfo)
+		require.Nil(t, err, "Error in Encrypt")
+		require.Equal(t, []byte{0x01, 0x01, 0x02, 0x03, 0x04}, ct[:5], "Incorrect output prefix")
+
+		decrypted, err := dec.Decrypt(ct, contextInfo)
+		require.Nil(t, err, "Error in Decrypt")
+		require.Equal(t, pt, decrypted, "Incorrect plaintext")
+
+		_, err = dec.Decrypt(ct, []byte("other context info"))
+		require.NotNil(t, err, "Decrypt succeeded with wrong context info")
+
+		// A RAW key produces ciphertexts without a prefix
+		enc, err = NewHybridEncrypt(pubKeys[1:], rand.Reader)
+		require.Nil(t, err, "Error in NewHybridEncrypt")
+		ct, err = enc.Encrypt(pt, contextInfo)
+		require.Nil(t, err, "Error in Encrypt")
+
+		decrypted, err = dec.Decrypt(ct, contextInfo)
+		require.Nil(t, err, "Error in Decrypt")
+		require.Equal(t, pt, decrypted, "Incorrect plaintext")
+	}
+}
+
+func TestKeysetErrors(t *testing.T) {
+	_, err := MarshalPublicKeyset(nil)
+	require.NotNil(t, err, "Empty keyset accepted")
+
+	suite, err := hpke.AssembleCipherSuite(hpke.DHKEM_X448, hpke.KDF_HKDF_SHA256, hpke.AEAD_AESGCM128)
+	require.Nil(t, err, "Error looking up ciphersuite")
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Read(ikm)
+	_, pkR, err := suite.KEM.DeriveKeyPair(ikm)
+	require.Nil(t, err, "Error deriving key pair")
+
+	_, err = MarshalPublicKeyset([]Key{{KeyID: 1, Prefix: OutputPrefixTink, Suite: suite, PublicKey: pkR}})
+	require.True(t, errors.Is(err, hpke.ErrUnsupportedSuite), "Suite unsupported by Tink accepted")
+
+	_, err = ParseKeyset([]byte{0x08, 0x01, 0x12, 0xff})
+	require.NotNil(t, err, "Truncated keyset accepted")
+}